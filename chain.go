@@ -0,0 +1,208 @@
+package kfs
+
+import (
+	"io/fs"
+	"time"
+)
+
+type (
+	// Middleware wraps an [FS] to produce a new [FS]
+	Middleware = func(fsys FS) FS
+)
+
+// Chain wraps fsys with middleware
+//
+// Middleware is listed from outermost to innermost, in the style of
+// net/http middleware. That is, Chain(fsys, a, b) is equivalent to
+// a(b(AsFS(fsys))).
+func Chain(fsys fs.FS, mws ...Middleware) FS {
+	f := AsFS(fsys)
+	for i := len(mws) - 1; i >= 0; i-- {
+		f = mws[i](f)
+	}
+	return f
+}
+
+// WithReadOnly returns a [Middleware] that wraps an fs with [NewReadOnlyFS]
+func WithReadOnly() Middleware {
+	return func(fsys FS) FS {
+		return NewReadOnlyFS(fsys)
+	}
+}
+
+// WithMask returns a [Middleware] that wraps an fs with [NewMaskFS]
+func WithMask(filter FileFilter) Middleware {
+	return func(fsys FS) FS {
+		return NewMaskFS(fsys, filter)
+	}
+}
+
+// WithProtect returns a [Middleware] that wraps an fs with [NewProtectFS]
+func WithProtect(patterns ...string) Middleware {
+	return func(fsys FS) FS {
+		return NewProtectFS(fsys, patterns...)
+	}
+}
+
+// WithInspect returns a [Middleware] that wraps an fs with [NewInspectFS]
+func WithInspect(inspector Inspector) Middleware {
+	return func(fsys FS) FS {
+		return NewInspectFS(fsys, inspector)
+	}
+}
+
+// WithRedact returns a [Middleware] that wraps an fs with [NewRedactFS]
+func WithRedact(redactor Redactor) Middleware {
+	return func(fsys FS) FS {
+		return NewRedactFS(fsys, redactor)
+	}
+}
+
+// WithWORM returns a [Middleware] that wraps an fs with [NewWORMFS]
+func WithWORM() Middleware {
+	return func(fsys FS) FS {
+		return NewWORMFS(fsys)
+	}
+}
+
+// WithShard returns a [Middleware] that wraps an fs with [NewShardFS]
+func WithShard(opts ...ShardFSOpt) Middleware {
+	return func(fsys FS) FS {
+		return NewShardFS(fsys, opts...)
+	}
+}
+
+// WithSymlinkPolicy returns a [Middleware] that wraps an fs with
+// [NewSymlinkPolicyFS]
+func WithSymlinkPolicy(policy SymlinkPolicy) Middleware {
+	return func(fsys FS) FS {
+		return NewSymlinkPolicyFS(fsys, policy)
+	}
+}
+
+type (
+	wrapFS struct {
+		fsys fs.FS
+	}
+)
+
+// AsFS returns fsys as an [FS]
+//
+// If fsys does not implement [FS], it is wrapped such that operations it
+// does not support return [ErrNotImplemented].
+func AsFS(fsys fs.FS) FS {
+	if f, ok := fsys.(FS); ok {
+		return f
+	}
+	return &wrapFS{
+		fsys: fsys,
+	}
+}
+
+func (f *wrapFS) Open(name string) (fs.File, error) {
+	return f.fsys.Open(name)
+}
+
+func (f *wrapFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.fsys, name)
+}
+
+func (f *wrapFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(f.fsys, name)
+}
+
+func (f *wrapFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(f.fsys, name)
+}
+
+func (f *wrapFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(f.fsys, pattern)
+}
+
+func (f *wrapFS) Sub(dir string) (fs.FS, error) {
+	fsys, err := fs.Sub(f.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return AsFS(fsys), nil
+}
+
+func (f *wrapFS) FullFilePath(name string) (string, error) {
+	return FullFilePath(f.fsys, name)
+}
+
+func (f *wrapFS) Lstat(name string) (fs.FileInfo, error) {
+	return Lstat(f.fsys, name)
+}
+
+func (f *wrapFS) ReadLink(name string) (string, error) {
+	return ReadLink(f.fsys, name)
+}
+
+func (f *wrapFS) Symlink(oldname, newname string) error {
+	return Symlink(f.fsys, oldname, newname)
+}
+
+func (f *wrapFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	return OpenFile(f.fsys, name, flag, mode)
+}
+
+func (f *wrapFS) Remove(name string) error {
+	return Remove(f.fsys, name)
+}
+
+func (f *wrapFS) RemoveAll(name string) error {
+	return RemoveAll(f.fsys, name)
+}
+
+func (f *wrapFS) Rename(oldname, newname string) error {
+	return Rename(f.fsys, oldname, newname)
+}
+
+func (f *wrapFS) Truncate(name string, size int64) error {
+	return Truncate(f.fsys, name, size)
+}
+
+func (f *wrapFS) Chtimes(name string, atime, mtime time.Time) error {
+	return Chtimes(f.fsys, name, atime, mtime)
+}
+
+func (f *wrapFS) Lchtimes(name string, atime, mtime time.Time) error {
+	return Lchtimes(f.fsys, name, atime, mtime)
+}
+
+func (f *wrapFS) Chmod(name string, mode fs.FileMode) error {
+	return Chmod(f.fsys, name, mode)
+}
+
+func (f *wrapFS) Chown(name string, uid, gid int) error {
+	return Chown(f.fsys, name, uid, gid)
+}
+
+func (f *wrapFS) Lchown(name string, uid, gid int) error {
+	return Lchown(f.fsys, name, uid, gid)
+}
+
+func (f *wrapFS) Mkfifo(name string, mode fs.FileMode) error {
+	return Mkfifo(f.fsys, name, mode)
+}
+
+func (f *wrapFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	return WriteFileIfMatch(f.fsys, name, data, perm, token)
+}
+
+func (f *wrapFS) MkdirTemp(dir, pattern string) (string, error) {
+	return MkdirTemp(f.fsys, dir, pattern)
+}
+
+func (f *wrapFS) CreateTemp(dir, pattern string) (File, string, error) {
+	return CreateTemp(f.fsys, dir, pattern)
+}
+
+func (f *wrapFS) SyncDir(name string) error {
+	return SyncDir(f.fsys, name)
+}
+
+func (f *wrapFS) Statfs(name string) (*FSStats, error) {
+	return Statfs(f.fsys, name)
+}
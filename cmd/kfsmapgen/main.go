@@ -0,0 +1,49 @@
+// Command kfsmapgen generates Go source that builds a [kfstest.MapFS] from a
+// directory
+//
+// It is intended for use with go:generate, for example:
+//
+//	//go:generate go run xorkevin.dev/kfs/cmd/kfsmapgen -dir testdata -func testdataFS -o testdata_gen_test.go
+//
+// The package name defaults to $GOPACKAGE, which go generate sets. The
+// generated source imports kfstest, which imports package testing, so it
+// should be written to a _test.go file.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"xorkevin.dev/kfs/kfstest"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory to load")
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "package name of the generated source")
+	fn := flag.String("func", "generatedFS", "name of the generated function")
+	out := flag.String("o", "", "output _test.go file, or stdout if empty")
+	flag.Parse()
+
+	if err := run(*dir, *pkg, *fn, *out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, pkg, fn, out string) error {
+	m, err := kfstest.MapFSFromDir(dir, nil)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := kfstest.WriteMapFSSource(&b, m, pkg, fn); err != nil {
+		return err
+	}
+	if out == "" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+	return os.WriteFile(out, b.Bytes(), 0o644)
+}
@@ -0,0 +1,146 @@
+package kfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+
+	"xorkevin.dev/kerrors"
+)
+
+const (
+	countEntriesBatchSize = 256
+)
+
+// CountEntries counts the entries of a directory up to limit
+//
+// CountEntries reads the directory in batches with [fs.ReadDirFile] and stops
+// once limit entries have been counted, so that it does not read the full
+// listing of a large directory. If limit is not positive, all entries are
+// counted.
+func CountEntries(fsys fs.FS, name string, limit int) (_ int, retErr error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			retErr = errors.Join(retErr, &fs.PathError{
+				Op:   "countentries",
+				Path: name,
+				Err:  kerrors.WithMsg(err, "Failed closing dir"),
+			})
+		}
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, &fs.PathError{
+			Op:   "countentries",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to stat dir"),
+		}
+	}
+	d, ok := f.(fs.ReadDirFile)
+	if !ok || !info.IsDir() {
+		return 0, &fs.PathError{
+			Op:   "countentries",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, ErrNotDir, "File is not a directory"),
+		}
+	}
+	count := 0
+	for limit <= 0 || count < limit {
+		n := countEntriesBatchSize
+		if limit > 0 {
+			n = min(n, limit-count)
+		}
+		entries, err := d.ReadDir(n)
+		count += len(entries)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, &fs.PathError{
+				Op:   "countentries",
+				Path: name,
+				Err:  kerrors.WithMsg(err, "Failed to read dir"),
+			}
+		}
+		if len(entries) == 0 {
+			break
+		}
+	}
+	return count, nil
+}
+
+// IsEmptyDir returns whether a directory has no entries
+//
+// IsEmptyDir reads at most one entry of the directory.
+func IsEmptyDir(fsys fs.FS, name string) (bool, error) {
+	count, err := CountEntries(fsys, name, 1)
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// RemoveIfEmpty removes a directory if it has no entries, and returns whether
+// it was removed
+func RemoveIfEmpty(fsys fs.FS, name string) (bool, error) {
+	empty, err := IsEmptyDir(fsys, name)
+	if err != nil {
+		return false, err
+	}
+	if !empty {
+		return false, nil
+	}
+	if err := Remove(fsys, name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// FindUp searches start and its ancestors for a file with any of names, and
+// returns the nearest directory that contains one
+//
+// If start is a file, the search starts from its directory. The search stops at
+// the root of fsys, so it never leaves the fs. Symbolic links named by names
+// count as found whether or not they resolve. If no directory contains any of
+// names, FindUp returns an error matching [fs.ErrNotExist].
+func FindUp(fsys fs.FS, start string, names ...string) (string, error) {
+	if !fs.ValidPath(start) {
+		return "", &fs.PathError{
+			Op:   "findup",
+			Path: start,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	dir := start
+	if info, err := fs.Stat(fsys, start); err == nil && !info.IsDir() {
+		dir = path.Dir(start)
+	}
+	for {
+		for _, i := range names {
+			if _, err := lstatOrStat(fsys, path.Join(dir, i)); err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				return "", &fs.PathError{
+					Op:   "findup",
+					Path: path.Join(dir, i),
+					Err:  kerrors.WithMsg(err, "Failed to stat file"),
+				}
+			}
+			return dir, nil
+		}
+		if dir == "." {
+			return "", &fs.PathError{
+				Op:   "findup",
+				Path: start,
+				Err:  kerrors.WithMsg(fs.ErrNotExist, "No marker file found"),
+			}
+		}
+		dir = path.Dir(dir)
+	}
+}
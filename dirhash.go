@@ -0,0 +1,64 @@
+package kfs
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"xorkevin.dev/kerrors"
+)
+
+// HashDirH1 computes the "h1:" hash of the files in dir as used by go.sum and
+// golang.org/x/mod/sumdb/dirhash
+//
+// Every non-directory file in dir is named prefix + "/" + its path relative
+// to dir, and the hash is then identical to that of dirhash.HashDir(dir,
+// prefix, dirhash.Hash1) for the same tree on disk. Symbolic links are
+// followed when opened, as they are by dirhash. Unlike dirhash, fsys may be
+// any [fs.FS].
+func HashDirH1(fsys fs.FS, dir, prefix string) (string, error) {
+	var files []string
+	if err := fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel := strings.TrimPrefix(p, dir+"/")
+		if dir == "." {
+			rel = p
+		}
+		files = append(files, prefix+"/"+rel)
+		return nil
+	}); err != nil {
+		return "", &fs.PathError{
+			Op:   "hashdirh1",
+			Path: dir,
+			Err:  kerrors.WithMsg(err, "Failed to walk dir"),
+		}
+	}
+	slices.Sort(files)
+
+	h := sha256.New()
+	for _, i := range files {
+		if strings.Contains(i, "\n") {
+			return "", &fs.PathError{
+				Op:   "hashdirh1",
+				Path: i,
+				Err:  kerrors.WithMsg(fs.ErrInvalid, "File name contains a newline"),
+			}
+		}
+		name := path.Join(dir, strings.TrimPrefix(i, prefix+"/"))
+		sum, err := hashFile(fsys, name, sha256.New(), "hashdirh1")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%x  %s\n", sum, i)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,224 @@
+package kfs
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+
+	"xorkevin.dev/kerrors"
+)
+
+type (
+	// DuplicateGroup is a group of files with identical contents
+	DuplicateGroup struct {
+		Size   int64
+		Digest []byte
+		// Names are the sorted names of the files
+		Names []string
+	}
+
+	// DuplicateReport is the result of [FindDuplicates]
+	DuplicateReport struct {
+		// Groups are sorted by the space they waste, with the largest first
+		Groups []DuplicateGroup
+		// Files is the number of regular files found
+		Files int
+		// Hashed is the number of files hashed, which excludes those with a
+		// unique size
+		Hashed int
+		// WastedBytes is the space that would be freed by keeping only one file
+		// of each group
+		WastedBytes int64
+	}
+
+	// FindDuplicatesOpt is an option for [FindDuplicates]
+	FindDuplicatesOpt = func(o *findDuplicatesOpts)
+
+	findDuplicatesOpts struct {
+		workers int
+		alg     *HashAlg
+		minSize int64
+	}
+)
+
+// FindDuplicatesWorkers sets the max number of files that [FindDuplicates]
+// may hash concurrently
+//
+// Values less than 2 hash files sequentially, which is the default.
+func FindDuplicatesWorkers(n int) FindDuplicatesOpt {
+	return func(o *findDuplicatesOpts) {
+		o.workers = n
+	}
+}
+
+// FindDuplicatesAlg sets the hash algorithm used by [FindDuplicates] in place
+// of sha256
+func FindDuplicatesAlg(alg HashAlg) FindDuplicatesOpt {
+	return func(o *findDuplicatesOpts) {
+		o.alg = &alg
+	}
+}
+
+// FindDuplicatesMinSize sets the min size of files considered by
+// [FindDuplicates]
+//
+// The default is 1, which skips empty files.
+func FindDuplicatesMinSize(n int64) FindDuplicatesOpt {
+	return func(o *findDuplicatesOpts) {
+		o.minSize = n
+	}
+}
+
+// FindDuplicates finds groups of regular files with identical contents in the
+// tree at root
+//
+// Files are first grouped by size, and only files that share a size with
+// another file are hashed, with their contents streamed through the hash.
+// Symlinks are not followed. If ctx is canceled, FindDuplicates stops and
+// returns an error matching ctx.Err().
+func FindDuplicates(ctx context.Context, fsys fs.FS, root string, opts ...FindDuplicatesOpt) (*DuplicateReport, error) {
+	o := findDuplicatesOpts{
+		workers: 1,
+		minSize: 1,
+	}
+	for _, i := range opts {
+		i(&o)
+	}
+	o.workers = max(o.workers, 1)
+	newHash := sha256.New
+	if o.alg != nil {
+		newHash = o.alg.New
+	}
+
+	report := &DuplicateReport{}
+	bySize := map[int64][]string{}
+	if err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return kerrors.WithMsg(err, "Find canceled")
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return &fs.PathError{
+				Op:   "findduplicates",
+				Path: p,
+				Err:  kerrors.WithMsg(err, "Failed to stat file"),
+			}
+		}
+		report.Files++
+		if info.Size() < o.minSize {
+			return nil
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], p)
+		return nil
+	}); err != nil {
+		return nil, &fs.PathError{
+			Op:   "findduplicates",
+			Path: root,
+			Err:  kerrors.WithMsg(err, "Failed to walk dir"),
+		}
+	}
+
+	type hashJob struct {
+		size   int64
+		name   string
+		digest []byte
+		err    error
+	}
+	var jobs []*hashJob
+	for size, names := range bySize {
+		if len(names) < 2 {
+			continue
+		}
+		for _, i := range names {
+			jobs = append(jobs, &hashJob{
+				size: size,
+				name: i,
+			})
+		}
+	}
+	report.Hashed = len(jobs)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobCh := make(chan *hashJob)
+	var wg sync.WaitGroup
+	for range o.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobCh {
+				if err := ctx.Err(); err != nil {
+					j.err = &fs.PathError{
+						Op:   "findduplicates",
+						Path: j.name,
+						Err:  kerrors.WithMsg(err, "Find canceled"),
+					}
+					continue
+				}
+				j.digest, j.err = hashFile(fsys, j.name, newHash(), "findduplicates")
+				if j.err != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+	for _, i := range jobs {
+		jobCh <- i
+	}
+	close(jobCh)
+	wg.Wait()
+
+	errs := make([]error, 0, len(jobs))
+	for _, i := range jobs {
+		errs = append(errs, i.err)
+	}
+	if err := firstHashTreeErr(errs); err != nil {
+		return nil, err
+	}
+
+	type groupKey struct {
+		size   int64
+		digest string
+	}
+	groups := map[groupKey]*DuplicateGroup{}
+	for _, i := range jobs {
+		key := groupKey{
+			size:   i.size,
+			digest: string(i.digest),
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &DuplicateGroup{
+				Size:   i.size,
+				Digest: i.digest,
+			}
+			groups[key] = g
+		}
+		g.Names = append(g.Names, i.name)
+	}
+	for _, g := range groups {
+		if len(g.Names) < 2 {
+			continue
+		}
+		slices.Sort(g.Names)
+		report.Groups = append(report.Groups, *g)
+		report.WastedBytes += g.Size * int64(len(g.Names)-1)
+	}
+	slices.SortFunc(report.Groups, func(a, b DuplicateGroup) int {
+		if c := cmp.Compare(b.Size*int64(len(b.Names)-1), a.Size*int64(len(a.Names)-1)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Names[0], b.Names[0])
+	})
+	return report, nil
+}
@@ -0,0 +1,51 @@
+package kfs
+
+import (
+	"errors"
+	"io/fs"
+
+	"xorkevin.dev/kerrors"
+)
+
+// ErrNoOSPath is returned when a file has no path on the os
+var ErrNoOSPath errNoOSPath
+
+type (
+	errNoOSPath struct{}
+)
+
+func (e errNoOSPath) Error() string {
+	return "File has no os path"
+}
+
+// WriteExecutable writes an executable file with mode 0o755 and returns its
+// full file path for passing to os/exec
+//
+// An existing file is removed first, since [WriteFile] keeps the mode of
+// existing files. If fsys cannot provide a full file path, the file is still
+// written, and WriteExecutable returns an error matching [ErrNoOSPath].
+func WriteExecutable(fsys fs.FS, name string, data []byte) (string, error) {
+	if err := Remove(fsys, name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", &fs.PathError{
+			Op:   "writeexecutable",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to remove existing file"),
+		}
+	}
+	if err := WriteFile(fsys, name, data, 0o755); err != nil {
+		return "", &fs.PathError{
+			Op:   "writeexecutable",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to write file"),
+		}
+	}
+	p, err := FullFilePath(fsys, name)
+	if err != nil {
+		return "", &fs.PathError{
+			Op:   "writeexecutable",
+			Path: name,
+			Err:  kerrors.WithKind(err, ErrNoOSPath, "Failed to get full file path"),
+		}
+	}
+	return p, nil
+}
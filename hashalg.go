@@ -0,0 +1,171 @@
+package kfs
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io/fs"
+	"sync"
+
+	"xorkevin.dev/kerrors"
+)
+
+// ErrUnknownHashAlg is returned when a hash algorithm is not registered
+var ErrUnknownHashAlg errUnknownHashAlg
+
+type (
+	errUnknownHashAlg struct{}
+)
+
+func (e errUnknownHashAlg) Error() string {
+	return "Unknown hash algorithm"
+}
+
+type (
+	// HashAlg is a hash algorithm that may be registered with
+	// [RegisterHashAlg]
+	HashAlg struct {
+		// Name is the multicodec name of the algorithm, e.g. sha2-256
+		Name string
+		// Code is the multihash code of the algorithm, e.g. 0x12 for sha2-256
+		Code uint64
+		// New returns a new hash. Algorithms outside the standard library, such
+		// as blake3, may be registered by any implementation of [hash.Hash].
+		New func() hash.Hash
+	}
+
+	hashAlgRegistry struct {
+		mu     sync.RWMutex
+		byName map[string]HashAlg
+		byCode map[uint64]HashAlg
+	}
+)
+
+// Multihash codes of common hash algorithms
+const (
+	HashCodeSHA1   = 0x11
+	HashCodeSHA256 = 0x12
+	HashCodeSHA512 = 0x13
+	HashCodeBLAKE3 = 0x1e
+)
+
+var defaultHashAlgRegistry = newHashAlgRegistry(
+	HashAlg{Name: "sha1", Code: HashCodeSHA1, New: sha1.New},
+	HashAlg{Name: "sha2-256", Code: HashCodeSHA256, New: sha256.New},
+	HashAlg{Name: "sha2-512", Code: HashCodeSHA512, New: sha512.New},
+)
+
+func newHashAlgRegistry(algs ...HashAlg) *hashAlgRegistry {
+	r := &hashAlgRegistry{
+		byName: map[string]HashAlg{},
+		byCode: map[uint64]HashAlg{},
+	}
+	for _, i := range algs {
+		r.register(i)
+	}
+	return r
+}
+
+func (r *hashAlgRegistry) register(alg HashAlg) {
+	if alg.Name == "" {
+		panic("kfs: empty hash algorithm name")
+	}
+	if alg.New == nil {
+		panic("kfs: nil hash constructor for algorithm " + alg.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byName[alg.Name]; ok {
+		panic("kfs: hash algorithm already registered " + alg.Name)
+	}
+	if _, ok := r.byCode[alg.Code]; ok {
+		panic(fmt.Sprintf("kfs: hash algorithm code already registered 0x%x", alg.Code))
+	}
+	r.byName[alg.Name] = alg
+	r.byCode[alg.Code] = alg
+}
+
+func (r *hashAlgRegistry) getByName(name string) (HashAlg, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	alg, ok := r.byName[name]
+	return alg, ok
+}
+
+func (r *hashAlgRegistry) getByCode(code uint64) (HashAlg, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	alg, ok := r.byCode[code]
+	return alg, ok
+}
+
+// RegisterHashAlg registers a hash algorithm that may then be selected by
+// name or multihash code
+//
+// RegisterHashAlg panics if the algorithm has no name or constructor, or if
+// its name or code is already registered. The sha1, sha2-256, and sha2-512
+// algorithms are registered by default. blake3 is not, since it is not in the
+// standard library, but it may be registered with [HashCodeBLAKE3].
+func RegisterHashAlg(alg HashAlg) {
+	defaultHashAlgRegistry.register(alg)
+}
+
+// LookupHashAlg returns the registered hash algorithm with a name
+func LookupHashAlg(name string) (HashAlg, error) {
+	alg, ok := defaultHashAlgRegistry.getByName(name)
+	if !ok {
+		return HashAlg{}, kerrors.WithKind(nil, ErrUnknownHashAlg, fmt.Sprintf("No hash algorithm registered with name %s", name))
+	}
+	return alg, nil
+}
+
+// LookupHashAlgCode returns the registered hash algorithm with a multihash
+// code
+func LookupHashAlgCode(code uint64) (HashAlg, error) {
+	alg, ok := defaultHashAlgRegistry.getByCode(code)
+	if !ok {
+		return HashAlg{}, kerrors.WithKind(nil, ErrUnknownHashAlg, fmt.Sprintf("No hash algorithm registered with code 0x%x", code))
+	}
+	return alg, nil
+}
+
+// Multihash returns digest in the self-describing multihash format, which is
+// uvarint(code) || uvarint(len(digest)) || digest
+func (a HashAlg) Multihash(digest []byte) []byte {
+	b := make([]byte, 0, 2*binary.MaxVarintLen64+len(digest))
+	b = binary.AppendUvarint(b, a.Code)
+	b = binary.AppendUvarint(b, uint64(len(digest)))
+	return append(b, digest...)
+}
+
+// ParseMultihash parses a multihash, returning its registered hash algorithm
+// and digest
+//
+// The digest may be shorter than the output of the algorithm, as multihash
+// allows truncated digests, but not longer.
+func ParseMultihash(mh []byte) (HashAlg, []byte, error) {
+	code, n := binary.Uvarint(mh)
+	if n <= 0 {
+		return HashAlg{}, nil, kerrors.WithKind(nil, fs.ErrInvalid, "Malformed multihash code")
+	}
+	mh = mh[n:]
+	size, n := binary.Uvarint(mh)
+	if n <= 0 {
+		return HashAlg{}, nil, kerrors.WithKind(nil, fs.ErrInvalid, "Malformed multihash length")
+	}
+	mh = mh[n:]
+	if uint64(len(mh)) != size {
+		return HashAlg{}, nil, kerrors.WithKind(nil, fs.ErrInvalid, "Multihash length does not match digest")
+	}
+	alg, err := LookupHashAlgCode(code)
+	if err != nil {
+		return HashAlg{}, nil, err
+	}
+	if len(mh) > alg.New().Size() {
+		return HashAlg{}, nil, kerrors.WithKind(nil, fs.ErrInvalid, fmt.Sprintf("Multihash digest too long for %s", alg.Name))
+	}
+	return alg, mh, nil
+}
@@ -0,0 +1,408 @@
+package kfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"xorkevin.dev/kerrors"
+)
+
+type (
+	// HashTreeOpt is an option for [HashTree]
+	HashTreeOpt = func(o *hashTreeOpts)
+
+	hashTreeOpts struct {
+		workers int
+		alg     *HashAlg
+		gitHash func() hash.Hash
+	}
+)
+
+// HashTreeWorkers sets the max number of files that [HashTree] may hash
+// concurrently
+//
+// Values less than 2 hash files sequentially, which is the default.
+func HashTreeWorkers(n int) HashTreeOpt {
+	return func(o *hashTreeOpts) {
+		o.workers = n
+	}
+}
+
+// HashTreeAlg sets the hash algorithm used by [HashTree] in place of sha256
+//
+// The algorithm may be one returned by [LookupHashAlg], which allows it to be
+// selected by name. It is ignored with [HashTreeGit].
+func HashTreeAlg(alg HashAlg) HashTreeOpt {
+	return func(o *hashTreeOpts) {
+		o.alg = &alg
+	}
+}
+
+// HashTreeGit makes [HashTree] compute Git object ids with newHash instead of
+// its own encoding
+//
+// newHash should be sha1.New for repositories with the default object format,
+// or sha256.New for those with the sha256 object format. Regular files and
+// symlinks are hashed as blobs, and directories as trees, so the digest of a
+// file or directory matches the id Git would compute for it. As with Git,
+// files are given mode 100755 if the owner executable bit is set and 100644
+// otherwise, and directories without files are omitted from their parent.
+func HashTreeGit(newHash func() hash.Hash) HashTreeOpt {
+	return func(o *hashTreeOpts) {
+		o.gitHash = newHash
+	}
+}
+
+const (
+	hashTreeTagFile    = 'f'
+	hashTreeTagSymlink = 'l'
+	hashTreeTagDir     = 'd'
+)
+
+// HashTree computes a Merkle digest of the tree at root
+//
+// The digest covers the names, permission bits, and contents of all files,
+// and does not depend on mod times or on the order in which entries are
+// listed. Each node is hashed with sha256, or the algorithm set with
+// [HashTreeAlg], using the following canonical encoding, where u32 is a
+// big-endian uint32:
+//
+//   - regular file: 'f' || u32(perm) || content
+//   - symlink: 'l' || target, where target is as returned by [ReadLink]
+//   - directory: 'd' || u32(perm) || entries, where each entry, in order of
+//     name, is u32(len(name)) || name || digest(entry)
+//
+// The digest of the tree is the digest of root. Symlinks are never followed.
+// Other file types, such as devices, result in an error. If ctx is canceled,
+// HashTree stops and returns an error matching ctx.Err().
+//
+// With [HashTreeGit], HashTree instead computes Git object ids.
+func HashTree(ctx context.Context, fsys fs.FS, root string, opts ...HashTreeOpt) ([]byte, error) {
+	o := hashTreeOpts{}
+	for _, i := range opts {
+		i(&o)
+	}
+
+	info, err := lstatOrStat(fsys, root)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: root,
+			Err:  kerrors.WithMsg(err, "Failed to stat file"),
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s := &hashTreeState{
+		ctx:     ctx,
+		cancel:  cancel,
+		fsys:    fsys,
+		root:    root,
+		newHash: sha256.New,
+	}
+	if o.alg != nil {
+		s.newHash = o.alg.New
+	}
+	if o.gitHash != nil {
+		s.git = true
+		s.newHash = o.gitHash
+	}
+	if o.workers > 1 {
+		// the calling goroutine is also a worker
+		s.sem = make(chan struct{}, o.workers-1)
+	}
+	digest, err := s.hashNode(root, info.Mode().Type())
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+type (
+	hashTreeState struct {
+		ctx     context.Context
+		cancel  context.CancelFunc
+		fsys    fs.FS
+		root    string
+		newHash func() hash.Hash
+		git     bool
+		sem     chan struct{}
+	}
+)
+
+const (
+	gitModeFile       = "100644"
+	gitModeExecutable = "100755"
+	gitModeSymlink    = "120000"
+	gitModeDir        = "40000"
+)
+
+func writeGitHeader(h hash.Hash, kind string, size int64) {
+	fmt.Fprintf(h, "%s %d\x00", kind, size)
+}
+
+func writeHashU32(h hash.Hash, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	h.Write(b[:])
+}
+
+func (s *hashTreeState) hashNode(name string, typ fs.FileMode) ([]byte, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Hash canceled"),
+		}
+	}
+	switch typ {
+	case 0:
+		return s.hashFile(name)
+	case fs.ModeSymlink:
+		return s.hashSymlink(name)
+	case fs.ModeDir:
+		return s.hashDir(name)
+	default:
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Unsupported file type %s", typ)),
+		}
+	}
+}
+
+func (s *hashTreeState) hashFile(name string) (_ []byte, retErr error) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to open file"),
+		}
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			retErr = errors.Join(retErr, &fs.PathError{
+				Op:   "hashtree",
+				Path: name,
+				Err:  kerrors.WithMsg(err, "Failed closing file"),
+			})
+		}
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to stat file"),
+		}
+	}
+	h := s.newHash()
+	if s.git {
+		writeGitHeader(h, "blob", info.Size())
+	} else {
+		h.Write([]byte{hashTreeTagFile})
+		writeHashU32(h, uint32(info.Mode().Perm()))
+	}
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to read file"),
+		}
+	}
+	if s.git && n != info.Size() {
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "File changed size while hashing"),
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+func (s *hashTreeState) hashSymlink(name string) ([]byte, error) {
+	target, err := ReadLink(s.fsys, name)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to read link"),
+		}
+	}
+	h := s.newHash()
+	if s.git {
+		writeGitHeader(h, "blob", int64(len(target)))
+	} else {
+		h.Write([]byte{hashTreeTagSymlink})
+	}
+	h.Write([]byte(target))
+	return h.Sum(nil), nil
+}
+
+func (s *hashTreeState) hashDir(name string) ([]byte, error) {
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to stat dir"),
+		}
+	}
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "hashtree",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to read dir"),
+		}
+	}
+	// fs.ReadDir returns entries sorted by name
+
+	digests := make([][]byte, len(entries))
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for n, i := range entries {
+		child := path.Join(name, i.Name())
+		hashChild := func() {
+			digests[n], errs[n] = s.hashNode(child, i.Type())
+			if errs[n] != nil {
+				s.cancel()
+			}
+		}
+		if i.Type() == 0 {
+			select {
+			case s.sem <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() {
+						<-s.sem
+					}()
+					hashChild()
+				}()
+				continue
+			default:
+			}
+		}
+		// directories and symlinks, and files when there is no free worker, are
+		// hashed inline
+		hashChild()
+	}
+	wg.Wait()
+	if err := firstHashTreeErr(errs); err != nil {
+		return nil, err
+	}
+
+	if s.git {
+		return s.hashGitTree(name, entries, digests)
+	}
+
+	h := s.newHash()
+	h.Write([]byte{hashTreeTagDir})
+	writeHashU32(h, uint32(info.Mode().Perm()))
+	for n, i := range entries {
+		writeHashU32(h, uint32(len(i.Name())))
+		h.Write([]byte(i.Name()))
+		h.Write(digests[n])
+	}
+	return h.Sum(nil), nil
+}
+
+// hashGitTree hashes a directory as a Git tree, returning a nil digest for a
+// directory other than root without files
+func (s *hashTreeState) hashGitTree(name string, entries []fs.DirEntry, digests [][]byte) ([]byte, error) {
+	type gitTreeEntry struct {
+		mode   string
+		name   string
+		digest []byte
+	}
+	treeEntries := make([]gitTreeEntry, 0, len(entries))
+	for n, i := range entries {
+		if digests[n] == nil {
+			continue
+		}
+		var mode string
+		switch i.Type() {
+		case fs.ModeDir:
+			mode = gitModeDir
+		case fs.ModeSymlink:
+			mode = gitModeSymlink
+		default:
+			info, err := i.Info()
+			if err != nil {
+				return nil, &fs.PathError{
+					Op:   "hashtree",
+					Path: path.Join(name, i.Name()),
+					Err:  kerrors.WithMsg(err, "Failed to stat file"),
+				}
+			}
+			mode = gitModeFile
+			if info.Mode().Perm()&0o100 != 0 {
+				mode = gitModeExecutable
+			}
+		}
+		treeEntries = append(treeEntries, gitTreeEntry{
+			mode:   mode,
+			name:   i.Name(),
+			digest: digests[n],
+		})
+	}
+	if len(treeEntries) == 0 && name != s.root {
+		return nil, nil
+	}
+	// git sorts tree entries as if directory names end with a slash
+	gitSortName := func(e gitTreeEntry) string {
+		if e.mode == gitModeDir {
+			return e.name + "/"
+		}
+		return e.name
+	}
+	slices.SortFunc(treeEntries, func(a, b gitTreeEntry) int {
+		return strings.Compare(gitSortName(a), gitSortName(b))
+	})
+
+	var b bytes.Buffer
+	for _, i := range treeEntries {
+		b.WriteString(i.mode)
+		b.WriteByte(' ')
+		b.WriteString(i.name)
+		b.WriteByte(0)
+		b.Write(i.digest)
+	}
+	h := s.newHash()
+	writeGitHeader(h, "tree", int64(b.Len()))
+	h.Write(b.Bytes())
+	return h.Sum(nil), nil
+}
+
+// firstHashTreeErr returns the first error that did not result from
+// cancellation, falling back to the first error
+func firstHashTreeErr(errs []error) error {
+	var first error
+	for _, i := range errs {
+		if i == nil {
+			continue
+		}
+		if !errors.Is(i, context.Canceled) {
+			return i
+		}
+		if first == nil {
+			first = i
+		}
+	}
+	return first
+}
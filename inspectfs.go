@@ -0,0 +1,495 @@
+package kfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"xorkevin.dev/kerrors"
+)
+
+// ErrRejected is returned when an [Inspector] rejects the contents of a file
+var ErrRejected errRejected
+
+type (
+	errRejected struct{}
+)
+
+func (e errRejected) Error() string {
+	return "File rejected"
+}
+
+type (
+	// Inspector inspects the contents of written files
+	Inspector interface {
+		// Inspect is called with the full contents of a file before it is
+		// written. Returning an error rejects the file.
+		Inspect(name string, r io.Reader) error
+	}
+
+	// InspectorFunc is a function that implements [Inspector]
+	InspectorFunc func(name string, r io.Reader) error
+)
+
+// Inspect implements [Inspector]
+func (f InspectorFunc) Inspect(name string, r io.Reader) error {
+	return f(name, r)
+}
+
+type (
+	inspectFS struct {
+		fsys      fs.FS
+		dir       string
+		inspector Inspector
+	}
+)
+
+func (f *inspectFS) Open(name string) (fs.File, error) {
+	return f.fsys.Open(name)
+}
+
+func (f *inspectFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.fsys, name)
+}
+
+func (f *inspectFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(f.fsys, name)
+}
+
+func (f *inspectFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(f.fsys, name)
+}
+
+func (f *inspectFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(f.fsys, pattern)
+}
+
+func (f *inspectFS) Sub(dir string) (fs.FS, error) {
+	fsys, err := fs.Sub(f.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &inspectFS{
+		fsys:      fsys,
+		dir:       joinValidPath(f.dir, dir),
+		inspector: f.inspector,
+	}, nil
+}
+
+func (f *inspectFS) FullFilePath(name string) (string, error) {
+	return FullFilePath(f.fsys, name)
+}
+
+func (f *inspectFS) Lstat(name string) (fs.FileInfo, error) {
+	return Lstat(f.fsys, name)
+}
+
+func (f *inspectFS) ReadLink(name string) (string, error) {
+	return ReadLink(f.fsys, name)
+}
+
+func (f *inspectFS) Symlink(oldname, newname string) error {
+	return Symlink(f.fsys, oldname, newname)
+}
+
+// OpenFile implements [WriteFS]
+//
+// Files opened for writing are spooled in memory, and are only written to
+// the underlying fs on Close once the inspector accepts their contents.
+func (f *inspectFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return OpenFile(f.fsys, name, flag, mode)
+	}
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "openfile", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	var data []byte
+	exists := true
+	if existing, err := fs.ReadFile(f.fsys, name); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) || flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "openfile", Path: name, Err: kerrors.WithMsg(err, "Failed to read file")}
+		}
+		exists = false
+	} else if flag&os.O_TRUNC == 0 {
+		data = existing
+	}
+	symlink := false
+	if exists {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &fs.PathError{Op: "openfile", Path: name, Err: kerrors.WithMsg(fs.ErrExist, "File already exists")}
+		}
+		info, err := fs.Stat(f.fsys, name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "openfile", Path: name, Err: kerrors.WithMsg(err, "Failed to stat file")}
+		}
+		mode = info.Mode()
+		if info, err := Lstat(f.fsys, name); err == nil && info.Mode().Type() == fs.ModeSymlink {
+			symlink = true
+		}
+	}
+	return &inspectFile{
+		fsys:      f.fsys,
+		inspector: f.inspector,
+		name:      name,
+		fullName:  joinValidPath(f.dir, name),
+		flag:      flag,
+		mode:      mode,
+		symlink:   symlink,
+		data:      data,
+		modTime:   time.Now(),
+	}, nil
+}
+
+func (f *inspectFS) Remove(name string) error {
+	return Remove(f.fsys, name)
+}
+
+func (f *inspectFS) RemoveAll(name string) error {
+	return RemoveAll(f.fsys, name)
+}
+
+func (f *inspectFS) Rename(oldname, newname string) error {
+	return Rename(f.fsys, oldname, newname)
+}
+
+// Truncate implements [TruncateFS]
+//
+// The inspector is called with the truncated contents before the file is
+// truncated.
+func (f *inspectFS) Truncate(name string, size int64) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "truncate", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Negative size")}
+	}
+	data, err := fs.ReadFile(f.fsys, name)
+	if err != nil {
+		return &fs.PathError{Op: "truncate", Path: name, Err: kerrors.WithMsg(err, "Failed to read file")}
+	}
+	if err := f.inspector.Inspect(joinValidPath(f.dir, name), io.MultiReader(
+		bytes.NewReader(data[:min(size, int64(len(data)))]),
+		io.LimitReader(zeroReader{}, max(size-int64(len(data)), 0)),
+	)); err != nil {
+		return &fs.PathError{Op: "truncate", Path: name, Err: kerrors.WithKind(err, ErrRejected, "File rejected by inspector")}
+	}
+	return Truncate(f.fsys, name, size)
+}
+
+func (f *inspectFS) Chtimes(name string, atime, mtime time.Time) error {
+	return Chtimes(f.fsys, name, atime, mtime)
+}
+
+func (f *inspectFS) Lchtimes(name string, atime, mtime time.Time) error {
+	return Lchtimes(f.fsys, name, atime, mtime)
+}
+
+func (f *inspectFS) Chmod(name string, mode fs.FileMode) error {
+	return Chmod(f.fsys, name, mode)
+}
+
+func (f *inspectFS) Chown(name string, uid, gid int) error {
+	return Chown(f.fsys, name, uid, gid)
+}
+
+func (f *inspectFS) Lchown(name string, uid, gid int) error {
+	return Lchown(f.fsys, name, uid, gid)
+}
+
+func (f *inspectFS) Mkfifo(name string, mode fs.FileMode) error {
+	return Mkfifo(f.fsys, name, mode)
+}
+
+// WriteFileIfMatch implements [ConditionalWriteFS]
+//
+// The inspector is called with data before the file is written.
+func (f *inspectFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if err := f.inspector.Inspect(joinValidPath(f.dir, name), bytes.NewReader(data)); err != nil {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: kerrors.WithKind(err, ErrRejected, "File rejected by inspector")}
+	}
+	return WriteFileIfMatch(f.fsys, name, data, perm, token)
+}
+
+func (f *inspectFS) MkdirTemp(dir, pattern string) (string, error) {
+	return MkdirTemp(f.fsys, dir, pattern)
+}
+
+// CreateTemp implements [TempFS]
+//
+// The file is inspected on Close as with [inspectFS.OpenFile].
+func (f *inspectFS) CreateTemp(dir, pattern string) (File, string, error) {
+	return createTemp(f, dir, pattern)
+}
+
+func (f *inspectFS) SyncDir(name string) error {
+	return SyncDir(f.fsys, name)
+}
+
+func (f *inspectFS) Statfs(name string) (*FSStats, error) {
+	return Statfs(f.fsys, name)
+}
+
+// NewInspectFS creates a new [FS] that passes the contents of written files
+// to an [Inspector] before writing them
+//
+// Files opened for writing with [OpenFile] are spooled in memory. On Close,
+// the inspector is called with the name of the file relative to the root of
+// the returned fs, including in sub fs, and its full contents, including any
+// existing contents that were not truncated. If the inspector returns an
+// error, Close fails with [ErrRejected] and the underlying file is left
+// untouched. Otherwise the spooled contents replace those of the underlying
+// file. This allows services that accept uploads to plug in scanners and
+// validators at the fs layer.
+func NewInspectFS(fsys fs.FS, inspector Inspector) FS {
+	return &inspectFS{
+		fsys:      fsys,
+		dir:       "",
+		inspector: inspector,
+	}
+}
+
+type (
+	// zeroReader reads an endless stream of zeros
+	zeroReader struct{}
+)
+
+func (r zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+type (
+	// inspectFile is a file spooled in memory until it is inspected on Close
+	inspectFile struct {
+		fsys      fs.FS
+		inspector Inspector
+		name      string
+		fullName  string
+		flag      int
+		mode      fs.FileMode
+		symlink   bool
+		data      []byte
+		offset    int64
+		modTime   time.Time
+		closed    bool
+	}
+
+	inspectFileInfo struct {
+		name    string
+		size    int64
+		mode    fs.FileMode
+		modTime time.Time
+	}
+)
+
+func (f *inspectFile) Stat() (fs.FileInfo, error) {
+	return &inspectFileInfo{
+		name:    path.Base(f.name),
+		size:    int64(len(f.data)),
+		mode:    f.mode,
+		modTime: f.modTime,
+	}, nil
+}
+
+func (f *inspectFile) assertOpen(op string) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	return nil
+}
+
+func (f *inspectFile) Read(p []byte) (int, error) {
+	if err := f.assertOpen("read"); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_RDWR == 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: kerrors.WithMsg(fs.ErrInvalid, "File not open for reading")}
+	}
+	if f.offset >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *inspectFile) Write(p []byte) (int, error) {
+	if err := f.assertOpen("write"); err != nil {
+		return 0, err
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: kerrors.WithMsg(fs.ErrInvalid, "File not open for writing")}
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.data))
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	n := copy(f.data[f.offset:], p)
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *inspectFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.assertOpen("seek"); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid whence")}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: kerrors.WithMsg(fs.ErrInvalid, "Negative offset")}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Truncate implements [TruncatableFile]
+func (f *inspectFile) Truncate(size int64) error {
+	if err := f.assertOpen("truncate"); err != nil {
+		return err
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: kerrors.WithMsg(fs.ErrInvalid, "File not open for writing")}
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: kerrors.WithMsg(fs.ErrInvalid, "Negative size")}
+	}
+	if size <= int64(len(f.data)) {
+		f.data = f.data[:size]
+	} else {
+		f.data = append(f.data, make([]byte, size-int64(len(f.data)))...)
+	}
+	return nil
+}
+
+// Close inspects the spooled contents of the file and writes them to the
+// underlying fs if accepted
+//
+// The contents are written to a temp file in the same directory, which is
+// renamed over the file, so that readers never see a partially written file.
+// If the underlying fs cannot rename or chmod files, or the file was opened
+// with O_EXCL, or is a symlink that should be written through, then the file
+// is instead opened with O_TRUNC and written in place.
+func (f *inspectFile) Close() error {
+	if err := f.assertOpen("close"); err != nil {
+		return err
+	}
+	f.closed = true
+	if err := f.inspector.Inspect(f.fullName, bytes.NewReader(f.data)); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: kerrors.WithKind(err, ErrRejected, "File rejected by inspector")}
+	}
+	if f.flag&os.O_EXCL == 0 && !f.symlink {
+		if err := f.replace(); !errors.Is(err, ErrNotImplemented) {
+			return err
+		}
+	}
+	return f.writeInPlace()
+}
+
+// replace writes the contents of the file to a temp file and renames it over
+// the file
+func (f *inspectFile) replace() (retErr error) {
+	if !fsSupportsReplace(f.fsys) {
+		return kerrors.WithMsg(ErrNotImplemented, "Failed to replace file")
+	}
+	file, tmp, err := CreateTemp(f.fsys, path.Dir(f.name), "."+path.Base(f.name)+".*.tmp")
+	if err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: kerrors.WithMsg(err, "Failed to create temp file")}
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			if err := Remove(f.fsys, tmp); err != nil {
+				retErr = errors.Join(retErr, &fs.PathError{Op: "close", Path: f.name, Err: kerrors.WithMsg(err, "Failed to remove temp file")})
+			}
+		}
+	}()
+	_, err = file.Write(f.data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: kerrors.WithMsg(err, "Failed writing to temp file")}
+	}
+	if err := Chmod(f.fsys, tmp, f.mode.Perm()); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: kerrors.WithMsg(err, "Failed to chmod temp file")}
+	}
+	if err := Rename(f.fsys, tmp, f.name); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: kerrors.WithMsg(err, "Failed to rename temp file")}
+	}
+	renamed = true
+	return nil
+}
+
+// fsSupportsReplace returns whether fsys may remove, chmod, and rename a temp
+// file, as needed by [inspectFile.replace]
+func fsSupportsReplace(fsys fs.FS) bool {
+	if _, ok := fsys.(RemoveFS); !ok {
+		return false
+	}
+	if _, ok := fsys.(ChmodFS); !ok {
+		return false
+	}
+	_, ok := fsys.(RenameFS)
+	return ok
+}
+
+// writeInPlace truncates the file and writes its contents
+func (f *inspectFile) writeInPlace() (retErr error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if f.flag&os.O_EXCL != 0 {
+		flag |= os.O_EXCL
+	}
+	file, err := OpenFile(f.fsys, f.name, flag, f.mode)
+	if err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: kerrors.WithMsg(err, "Failed to open file")}
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			retErr = errors.Join(retErr, &fs.PathError{Op: "close", Path: f.name, Err: kerrors.WithMsg(err, "Failed closing file")})
+		}
+	}()
+	if _, err := file.Write(f.data); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: kerrors.WithMsg(err, "Failed writing to file")}
+	}
+	return nil
+}
+
+func (i *inspectFileInfo) Name() string {
+	return i.name
+}
+
+func (i *inspectFileInfo) Size() int64 {
+	return i.size
+}
+
+func (i *inspectFileInfo) Mode() fs.FileMode {
+	return i.mode
+}
+
+func (i *inspectFileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i *inspectFileInfo) IsDir() bool {
+	return false
+}
+
+func (i *inspectFileInfo) Sys() any {
+	return nil
+}
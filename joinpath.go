@@ -0,0 +1,51 @@
+package kfs
+
+import (
+	"io/fs"
+	"strings"
+)
+
+// joinValidPath joins a dir and a name that are valid by [fs.ValidPath]
+//
+// It is equivalent to [path.Join] for valid paths, but avoids cleaning the
+// result, which allocates on every call. Wrappers join their dir with names on
+// every operation, so this matters for deep stacks of wrappers. dir may also
+// be empty for the root of a wrapper.
+func joinValidPath(dir, name string) string {
+	if dir == "" || dir == "." {
+		return name
+	}
+	if name == "." {
+		return dir
+	}
+	return dir + "/" + name
+}
+
+// joinEntryPaths joins a valid dir with the names of its entries
+//
+// The paths share a single backing buffer, so that filtering a dir allocates
+// a constant number of times rather than once per entry.
+func joinEntryPaths(dir string, entries []fs.DirEntry) []string {
+	prefix := ""
+	if dir != "" && dir != "." {
+		prefix = dir + "/"
+	}
+	size := 0
+	for _, i := range entries {
+		size += len(prefix) + len(i.Name())
+	}
+	var b strings.Builder
+	b.Grow(size)
+	for _, i := range entries {
+		b.WriteString(prefix)
+		b.WriteString(i.Name())
+	}
+	buf := b.String()
+	res := make([]string, 0, len(entries))
+	for _, i := range entries {
+		n := len(prefix) + len(i.Name())
+		res = append(res, buf[:n])
+		buf = buf[n:]
+	}
+	return res
+}
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math/rand/v2"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"xorkevin.dev/kerrors"
@@ -18,11 +21,14 @@ var (
 	ErrNotImplemented errNotImplemented
 	// ErrTargetOutsideFS is returned when the symlink target is outside the file system
 	ErrTargetOutsideFS errTargetOutsideFS
+	// ErrPreconditionFailed is returned when a conditional write finds that the file has changed
+	ErrPreconditionFailed errPreconditionFailed
 )
 
 type (
-	errNotImplemented  struct{}
-	errTargetOutsideFS struct{}
+	errNotImplemented     struct{}
+	errTargetOutsideFS    struct{}
+	errPreconditionFailed struct{}
 )
 
 func (e errNotImplemented) Error() string {
@@ -33,6 +39,10 @@ func (e errTargetOutsideFS) Error() string {
 	return "Target outside fs"
 }
 
+func (e errPreconditionFailed) Error() string {
+	return "Precondition failed"
+}
+
 type (
 	// LstatFS is a file system that can run lstat
 	FullFilePathFS interface {
@@ -113,6 +123,111 @@ func ReadLink(fsys fs.FS, name string) (string, error) {
 	return f.ReadLink(name)
 }
 
+// maxLinkDepth is the max number of symlinks followed in resolving a path
+const maxLinkDepth = 40
+
+// EvalSymlinks returns the path of name after resolving any symlinks in it
+//
+// Symlinks are resolved with [Lstat] and [ReadLink], so the result is always a
+// path within fsys. If more than 40 symlinks are followed, as for a cycle of
+// symlinks, EvalSymlinks returns an error matching [ErrLinkLoop].
+func EvalSymlinks(fsys fs.FS, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{
+			Op:   "evalsymlinks",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	var rest []string
+	if name != "." {
+		rest = strings.Split(name, "/")
+	}
+	cur := "."
+	links := 0
+	for len(rest) > 0 {
+		next := joinValidPath(cur, rest[0])
+		rest = rest[1:]
+		info, err := Lstat(fsys, next)
+		if err != nil {
+			return "", &fs.PathError{
+				Op:   "evalsymlinks",
+				Path: name,
+				Err:  kerrors.WithMsg(err, "Failed to lstat file"),
+			}
+		}
+		if info.Mode().Type() != fs.ModeSymlink {
+			if len(rest) > 0 && !info.IsDir() {
+				return "", &fs.PathError{
+					Op:   "evalsymlinks",
+					Path: name,
+					Err:  kerrors.WithKind(fs.ErrInvalid, ErrNotDir, fmt.Sprintf("Parent %s is not a directory", next)),
+				}
+			}
+			cur = next
+			continue
+		}
+		links++
+		if links > maxLinkDepth {
+			return "", &fs.PathError{
+				Op:   "evalsymlinks",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrInvalid, ErrLinkLoop, fmt.Sprintf("Too many links resolving %s", name)),
+			}
+		}
+		target, err := ReadLink(fsys, next)
+		if err != nil {
+			return "", &fs.PathError{
+				Op:   "evalsymlinks",
+				Path: name,
+				Err:  kerrors.WithMsg(err, "Failed to read link"),
+			}
+		}
+		// resolve the target from the root
+		if target = path.Join(path.Dir(next), target); target != "." {
+			rest = append(strings.Split(target, "/"), rest...)
+		}
+		cur = "."
+	}
+	return cur, nil
+}
+
+type (
+	// MakeSymlinkFS is a file system that may create symlinks
+	MakeSymlinkFS interface {
+		fs.FS
+		// Symlink creates newname as a symbolic link to oldname. Like the
+		// destinations returned by ReadLink, oldname is a slash-separated path
+		// relative to the directory of newname, and must be a path inside FS.
+		Symlink(oldname, newname string) error
+	}
+)
+
+// Symlink creates newname as a symbolic link to oldname
+//
+// oldname is relative to the directory of newname. An oldname that is
+// absolute or that refers outside of fsys is rejected with
+// [ErrTargetOutsideFS].
+func Symlink(fsys fs.FS, oldname, newname string) error {
+	f, ok := fsys.(MakeSymlinkFS)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to create symlink")}
+	}
+	return f.Symlink(oldname, newname)
+}
+
+// checkLinkTarget returns an error if the target of the symlink at the valid
+// path name is not a path inside the fs
+func checkLinkTarget(name, target string) error {
+	if path.IsAbs(target) {
+		return kerrors.WithMsg(ErrTargetOutsideFS, fmt.Sprintf("Target %s is absolute", target))
+	}
+	if !fs.ValidPath(path.Join(path.Dir(name), target)) {
+		return kerrors.WithMsg(ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the FS", target))
+	}
+	return nil
+}
+
 type (
 	// File is an [fs.File] that allows writing
 	File interface {
@@ -120,6 +235,20 @@ type (
 		io.Writer
 	}
 
+	// TruncatableFile is a [File] that may be truncated
+	TruncatableFile interface {
+		File
+		// Truncate changes the size of the file
+		Truncate(size int64) error
+	}
+
+	// SyncFile is a [File] whose contents may be committed to stable storage
+	SyncFile interface {
+		File
+		// Sync commits the contents of the file to stable storage
+		Sync() error
+	}
+
 	// WriteFS is a file system that may be read from and written to
 	WriteFS interface {
 		fs.FS
@@ -193,16 +322,83 @@ func RemoveAll(fsys fs.FS, name string) error {
 	return f.RemoveAll(name)
 }
 
+type (
+	// RenameFS is a file system that may rename files
+	RenameFS interface {
+		fs.FS
+		// Rename renames a file, replacing newname if it exists and is not a
+		// directory
+		Rename(oldname, newname string) error
+	}
+)
+
+// Rename renames a file
+//
+// If newname exists and is not a directory, it is replaced. Renaming a file
+// within the same directory is atomic for an fs backed by the os, which allows
+// files to be written to a temporary name and then renamed into place so that
+// readers never see a partially written file.
+func Rename(fsys fs.FS, oldname, newname string) error {
+	f, ok := fsys.(RenameFS)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to rename file")}
+	}
+	return f.Rename(oldname, newname)
+}
+
+type (
+	// TruncateFS is a file system that may truncate files
+	TruncateFS interface {
+		fs.FS
+		// Truncate changes the size of a file
+		Truncate(name string, size int64) error
+	}
+)
+
+// Truncate changes the size of a file
+//
+// Symlinks are followed. A file that is extended is filled with zeros. If
+// fsys does not implement TruncateFS, a file may still be truncated to size 0
+// by opening it with O_TRUNC.
+func Truncate(fsys fs.FS, name string, size int64) error {
+	if f, ok := fsys.(TruncateFS); ok {
+		return f.Truncate(name, size)
+	}
+	if size != 0 {
+		return &fs.PathError{Op: "truncate", Path: name, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to truncate file")}
+	}
+	f, err := OpenFile(fsys, name, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return kerrors.WithMsg(err, "Failed opening file")
+	}
+	if err := f.Close(); err != nil {
+		return &fs.PathError{Op: "truncate", Path: name, Err: kerrors.WithMsg(err, "Failed closing file")}
+	}
+	return nil
+}
+
 type (
 	// ChtimesFS is a file system that may change file time metadata
 	ChtimesFS interface {
 		fs.FS
-		// Remove removes a file
+		// Chtimes changes the access and mod times of a file, following
+		// symlinks. A zero time leaves that time unchanged.
 		Chtimes(name string, atime, mtime time.Time) error
 	}
+
+	// LchtimesFS is a file system that may change the time metadata of
+	// symlinks
+	LchtimesFS interface {
+		fs.FS
+		// Lchtimes changes the access and mod times of a file without following
+		// symlinks. A zero time leaves that time unchanged.
+		Lchtimes(name string, atime, mtime time.Time) error
+	}
 )
 
-// Chtimes changes the time metadata of a file
+// Chtimes changes the access and mod times of a file
+//
+// Symlinks are followed. A zero atime or mtime leaves that time unchanged.
 func Chtimes(fsys fs.FS, name string, atime, mtime time.Time) error {
 	f, ok := fsys.(ChtimesFS)
 	if !ok {
@@ -211,27 +407,322 @@ func Chtimes(fsys fs.FS, name string, atime, mtime time.Time) error {
 	return f.Chtimes(name, atime, mtime)
 }
 
+// Lchtimes changes the access and mod times of a file without following
+// symlinks
+//
+// A zero atime or mtime leaves that time unchanged. If fsys does not implement
+// [LchtimesFS], then Lchtimes returns an error.
+func Lchtimes(fsys fs.FS, name string, atime, mtime time.Time) error {
+	f, ok := fsys.(LchtimesFS)
+	if !ok {
+		return &fs.PathError{Op: "lchtimes", Path: name, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to change link time metadata")}
+	}
+	return f.Lchtimes(name, atime, mtime)
+}
+
+type (
+	// ChmodFS is a file system that may change file modes
+	ChmodFS interface {
+		fs.FS
+		// Chmod changes the mode of a file
+		Chmod(name string, mode fs.FileMode) error
+	}
+)
+
+// Chmod changes the mode of a file
+//
+// Symlinks are followed. This allows tooling to fix up the modes of files
+// after they are written.
+func Chmod(fsys fs.FS, name string, mode fs.FileMode) error {
+	f, ok := fsys.(ChmodFS)
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to change file mode")}
+	}
+	return f.Chmod(name, mode)
+}
+
+type (
+	// ChownFS is a file system that may change file owners
+	ChownFS interface {
+		fs.FS
+		// Chown changes the owner of a file, following symlinks
+		Chown(name string, uid, gid int) error
+	}
+
+	// LchownFS is a file system that may change the owners of symlinks
+	LchownFS interface {
+		fs.FS
+		// Lchown changes the owner of a file without following symlinks
+		Lchown(name string, uid, gid int) error
+	}
+)
+
+// Chown changes the owner of a file
+//
+// Symlinks are followed. A uid or gid of -1 leaves it unchanged.
+func Chown(fsys fs.FS, name string, uid, gid int) error {
+	f, ok := fsys.(ChownFS)
+	if !ok {
+		return &fs.PathError{Op: "chown", Path: name, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to change file owner")}
+	}
+	return f.Chown(name, uid, gid)
+}
+
+// Lchown changes the owner of a file without following symlinks
+//
+// A uid or gid of -1 leaves it unchanged.
+func Lchown(fsys fs.FS, name string, uid, gid int) error {
+	f, ok := fsys.(LchownFS)
+	if !ok {
+		return &fs.PathError{Op: "lchown", Path: name, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to change file owner")}
+	}
+	return f.Lchown(name, uid, gid)
+}
+
+type (
+	// MkfifoFS is a file system that may create named pipes
+	MkfifoFS interface {
+		fs.FS
+		// Mkfifo creates a named pipe
+		Mkfifo(name string, mode fs.FileMode) error
+	}
+)
+
+// Mkfifo creates a named pipe
+func Mkfifo(fsys fs.FS, name string, mode fs.FileMode) error {
+	f, ok := fsys.(MkfifoFS)
+	if !ok {
+		return &fs.PathError{Op: "mkfifo", Path: name, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to create named pipe")}
+	}
+	return f.Mkfifo(name, mode)
+}
+
+type (
+	// ConditionalWriteFS is a file system that may write files only if they
+	// are unchanged
+	ConditionalWriteFS interface {
+		fs.FS
+		// WriteFileIfMatch writes a file if its [FileToken] matches token, or if
+		// token is empty, only if the file does not exist
+		WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error
+	}
+)
+
+// WriteFileIfMatch writes a file if its [FileToken] matches token
+//
+// If token is empty, the file is only written if it does not exist. If the
+// file has changed, WriteFileIfMatch returns an error matching
+// [ErrPreconditionFailed]. This allows optimistic concurrency for shared
+// state files, where a file is read along with its token, and then written
+// only if no other writer has changed it since.
+func WriteFileIfMatch(fsys fs.FS, name string, data []byte, perm fs.FileMode, token string) error {
+	f, ok := fsys.(ConditionalWriteFS)
+	if !ok {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to conditionally write file")}
+	}
+	return f.WriteFileIfMatch(name, data, perm, token)
+}
+
+// FileToken returns a token that changes when a file is written, for use with
+// [WriteFileIfMatch]
+//
+// The token is derived from the mod time and size of the file.
+func FileToken(info fs.FileInfo) string {
+	return strconv.FormatInt(info.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(info.Size(), 16)
+}
+
+type (
+	// TempFS is a file system that may create temporary files and directories
+	TempFS interface {
+		fs.FS
+		// MkdirTemp creates a new directory in dir, and returns its path. The
+		// directory name is made of pattern, with a random string replacing the
+		// last "*" in pattern, or appended to pattern if it has none.
+		MkdirTemp(dir, pattern string) (string, error)
+		// CreateTemp creates a new file in dir, opened for writing, and returns
+		// the file and its path. The file name is made of pattern as with
+		// MkdirTemp.
+		CreateTemp(dir, pattern string) (File, string, error)
+	}
+)
+
+// maxTempAttempts is the max number of names tried for a temporary file
+const maxTempAttempts = 10000
+
+// TempName returns a random file name made of pattern
+//
+// A random string replaces the last "*" in pattern, or is appended to pattern
+// if it has none. It is used by [MkdirTemp] and [CreateTemp], and may be used
+// by implementations of [TempFS]. Each name has 64 random bits, so callers
+// only retry on the rare collision.
+func TempName(pattern string) (string, error) {
+	if strings.ContainsRune(pattern, '/') {
+		return "", kerrors.WithMsg(fs.ErrInvalid, "Pattern contains a path separator")
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndexByte(pattern, '*'); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	return prefix + strconv.FormatUint(rand.Uint64(), 36) + suffix, nil
+}
+
+// MkdirTemp creates a new directory in dir, and returns its path
+//
+// The name is made of pattern as with [TempName]. If fsys does not implement
+// [TempFS], then MkdirTemp returns an error, as [WriteFS] has no way to create
+// an empty directory.
+func MkdirTemp(fsys fs.FS, dir, pattern string) (string, error) {
+	f, ok := fsys.(TempFS)
+	if !ok {
+		return "", &fs.PathError{Op: "mkdirtemp", Path: dir, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to create temp dir")}
+	}
+	return f.MkdirTemp(dir, pattern)
+}
+
+// CreateTemp creates a new file in dir, opened for writing, and returns the
+// file and its path
+//
+// The name is made of pattern as with [TempName]. If fsys does not implement
+// [TempFS], then the file is created by [OpenFile] with O_EXCL, which is
+// retried with a new name if it already exists.
+func CreateTemp(fsys fs.FS, dir, pattern string) (File, string, error) {
+	if f, ok := fsys.(TempFS); ok {
+		return f.CreateTemp(dir, pattern)
+	}
+	return createTemp(fsys, dir, pattern)
+}
+
+// createTemp creates a new file in dir with [OpenFile] and O_EXCL
+func createTemp(fsys fs.FS, dir, pattern string) (File, string, error) {
+	if !fs.ValidPath(dir) {
+		return nil, "", &fs.PathError{Op: "createtemp", Path: dir, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	for range maxTempAttempts {
+		name, err := TempName(pattern)
+		if err != nil {
+			return nil, "", &fs.PathError{Op: "createtemp", Path: dir, Err: err}
+		}
+		name = joinValidPath(dir, name)
+		f, err := OpenFile(fsys, name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			return f, name, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, "", err
+		}
+	}
+	return nil, "", &fs.PathError{Op: "createtemp", Path: dir, Err: kerrors.WithMsg(fs.ErrExist, "Failed to find an unused temp name")}
+}
+
+// Sync commits the contents of a file to stable storage
+//
+// If f does not implement [SyncFile], then Sync returns an error. Together
+// with [SyncDir], it allows durable write patterns such as writing a temp
+// file, syncing it, renaming it into place, and syncing its directory.
+func Sync(f fs.File) error {
+	s, ok := f.(SyncFile)
+	if !ok {
+		return kerrors.WithMsg(ErrNotImplemented, "Failed to sync file")
+	}
+	return s.Sync()
+}
+
+type (
+	// SyncFS is a file system that may commit directories to stable storage
+	SyncFS interface {
+		fs.FS
+		// SyncDir commits the entries of a directory to stable storage
+		SyncDir(name string) error
+	}
+)
+
+// SyncDir commits the entries of a directory to stable storage
+//
+// If fsys does not implement [SyncFS], then SyncDir returns an error.
+func SyncDir(fsys fs.FS, name string) error {
+	f, ok := fsys.(SyncFS)
+	if !ok {
+		return &fs.PathError{Op: "syncdir", Path: name, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to sync dir")}
+	}
+	return f.SyncDir(name)
+}
+
+type (
+	// FSStats is the capacity and usage of a file system
+	FSStats struct {
+		// TotalBytes is the size of the file system
+		TotalBytes uint64
+		// FreeBytes is the number of free bytes
+		FreeBytes uint64
+		// AvailBytes is the number of free bytes available to the process,
+		// which may exclude space reserved for privileged users
+		AvailBytes uint64
+		// TotalInodes is the number of inodes, or 0 if unknown
+		TotalInodes uint64
+		// FreeInodes is the number of free inodes, or 0 if unknown
+		FreeInodes uint64
+	}
+
+	// StatfsFS is a file system that may report its capacity and usage
+	StatfsFS interface {
+		fs.FS
+		// Statfs returns the stats of the file system containing a file
+		Statfs(name string) (*FSStats, error)
+	}
+)
+
+// Statfs returns the stats of the file system containing a file
+//
+// If fsys does not implement [StatfsFS], then Statfs returns an error.
+func Statfs(fsys fs.FS, name string) (*FSStats, error) {
+	f, ok := fsys.(StatfsFS)
+	if !ok {
+		return nil, &fs.PathError{Op: "statfs", Path: name, Err: kerrors.WithMsg(ErrNotImplemented, "Failed to stat file system")}
+	}
+	return f.Statfs(name)
+}
+
 type (
 	osFS struct {
 		fsys fs.FS
 		dir  string
+		// osDir is dir cleaned and in os form, or empty if paths in dir may not
+		// be joined by concatenation
+		osDir        string
+		linkFallback LinkFallback
 	}
 )
 
 func (f *osFS) Open(name string) (fs.File, error) {
-	return f.fsys.Open(name)
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, NormalizeError(err)
+	}
+	return file, nil
 }
 
 func (f *osFS) Stat(name string) (fs.FileInfo, error) {
-	return fs.Stat(f.fsys, name)
+	info, err := fs.Stat(f.fsys, name)
+	if err != nil {
+		return nil, NormalizeError(err)
+	}
+	return info, nil
 }
 
 func (f *osFS) ReadDir(name string) ([]fs.DirEntry, error) {
-	return fs.ReadDir(f.fsys, name)
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, NormalizeError(err)
+	}
+	return entries, nil
 }
 
 func (f *osFS) ReadFile(name string) ([]byte, error) {
-	return fs.ReadFile(f.fsys, name)
+	data, err := fs.ReadFile(f.fsys, name)
+	if err != nil {
+		return nil, NormalizeError(err)
+	}
+	return data, nil
 }
 
 func (f *osFS) Glob(pattern string) ([]string, error) {
@@ -243,11 +734,23 @@ func (f *osFS) Sub(dir string) (fs.FS, error) {
 	if err != nil {
 		return nil, err
 	}
-	return New(fsys, path.Join(f.dir, dir)), nil
+	return New(fsys, path.Join(f.dir, dir), OSFSLinkFallback(f.linkFallback)), nil
 }
 
+// fullFilePath returns the os path of a valid name
 func (f *osFS) fullFilePath(name string) string {
-	return filepath.Join(filepath.FromSlash(f.dir), filepath.FromSlash(name))
+	if f.osDir == "" {
+		return filepath.Join(filepath.FromSlash(f.dir), filepath.FromSlash(name))
+	}
+	if name == "." {
+		return f.osDir
+	}
+	// a valid name is already clean, so joining it to a clean dir only needs
+	// a separator, unless dir is a root that ends with one
+	if os.IsPathSeparator(f.osDir[len(f.osDir)-1]) {
+		return f.osDir + filepath.FromSlash(name)
+	}
+	return f.osDir + string(filepath.Separator) + filepath.FromSlash(name)
 }
 
 func (f *osFS) FullFilePath(name string) (string, error) {
@@ -274,7 +777,7 @@ func (f *osFS) Lstat(name string) (fs.FileInfo, error) {
 		return nil, &fs.PathError{
 			Op:   "lstat",
 			Path: name,
-			Err:  kerrors.WithMsg(err, "Failed to lstat file"),
+			Err:  wrapOSErr(err, "Failed to lstat file"),
 		}
 	}
 	return info, nil
@@ -293,31 +796,48 @@ func (f *osFS) ReadLink(name string) (string, error) {
 		return "", &fs.PathError{
 			Op:   "readlink",
 			Path: name,
-			Err:  kerrors.WithMsg(err, "Failed to read link"),
+			Err:  wrapOSErr(err, "Failed to read link"),
 		}
 	}
 	target = filepath.ToSlash(target)
-	if path.IsAbs(target) {
+	if err := checkLinkTarget(name, target); err != nil {
 		return "", &fs.PathError{
 			Op:   "readlink",
 			Path: name,
-			Err:  kerrors.WithMsg(ErrTargetOutsideFS, fmt.Sprintf("Target %s is absolute", target)),
-		}
-	}
-	if !fs.ValidPath(path.Join(path.Dir(name), target)) {
-		return "", &fs.PathError{
-			Op:   "readlink",
-			Path: name,
-			Err:  kerrors.WithMsg(ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the FS", target)),
+			Err:  err,
 		}
 	}
 	return target, nil
 }
 
+// Symlink implements [MakeSymlinkFS]
+//
+// It will create any directories in the path of newname with 0o777 (before
+// umask). If the os does not permit creating symlinks, the link is created
+// according to the [LinkFallback] of the fs.
+func (f *osFS) Symlink(oldname, newname string) error {
+	if !fs.ValidPath(newname) || oldname == "" {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if err := checkLinkTarget(newname, oldname); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	fullPath := f.fullFilePath(newname)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o777); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: wrapOSErr(err, "Failed to mkdir")}
+	}
+	target := filepath.FromSlash(oldname)
+	if err := os.Symlink(target, fullPath); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: symlinkFallback(f.linkFallback, filepath.Join(filepath.Dir(fullPath), target), fullPath, err)}
+	}
+	return nil
+}
+
 // OpenFile implements [WriteFS]
 //
 // When O_CREATE is set, it will create any directories in the path of the file
-// with 0o777 (before umask)
+// with 0o777 (before umask). The returned file implements [TruncatableFile]
+// and [SyncFile].
 func (f *osFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
 	if !fs.ValidPath(name) {
 		return nil, &fs.PathError{Op: "openfile", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
@@ -325,12 +845,12 @@ func (f *osFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
 	fullPath := f.fullFilePath(name)
 	if flag&os.O_CREATE != 0 {
 		if err := os.MkdirAll(filepath.Dir(fullPath), 0o777); err != nil {
-			return nil, &fs.PathError{Op: "openfile", Path: name, Err: kerrors.WithMsg(err, "Failed to mkdir")}
+			return nil, &fs.PathError{Op: "openfile", Path: name, Err: wrapOSErr(err, "Failed to mkdir")}
 		}
 	}
 	fi, err := os.OpenFile(fullPath, flag, mode)
 	if err != nil {
-		return nil, &fs.PathError{Op: "openfile", Path: name, Err: kerrors.WithMsg(err, "Failed to open file")}
+		return nil, &fs.PathError{Op: "openfile", Path: name, Err: wrapOSErr(err, "Failed to open file")}
 	}
 	return fi, nil
 }
@@ -341,7 +861,7 @@ func (f *osFS) Remove(name string) error {
 		return &fs.PathError{Op: "remove", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
 	}
 	if err := os.Remove(f.fullFilePath(name)); err != nil {
-		return &fs.PathError{Op: "remove", Path: name, Err: kerrors.WithMsg(err, "Failed to remove file")}
+		return &fs.PathError{Op: "remove", Path: name, Err: wrapOSErr(err, "Failed to remove file")}
 	}
 	return nil
 }
@@ -352,7 +872,36 @@ func (f *osFS) RemoveAll(name string) error {
 		return &fs.PathError{Op: "removeall", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
 	}
 	if err := os.RemoveAll(f.fullFilePath(name)); err != nil {
-		return &fs.PathError{Op: "removeall", Path: name, Err: kerrors.WithMsg(err, "Failed to remove file")}
+		return &fs.PathError{Op: "removeall", Path: name, Err: wrapOSErr(err, "Failed to remove file")}
+	}
+	return nil
+}
+
+// Rename implements [RenameFS]
+//
+// It will create any directories in the path of newname with 0o777 (before
+// umask).
+func (f *osFS) Rename(oldname, newname string) error {
+	if !fs.ValidPath(oldname) || !fs.ValidPath(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	newPath := f.fullFilePath(newname)
+	if err := os.MkdirAll(filepath.Dir(newPath), 0o777); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: wrapOSErr(err, "Failed to mkdir")}
+	}
+	if err := os.Rename(f.fullFilePath(oldname), newPath); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: wrapOSErr(err, "Failed to rename file")}
+	}
+	return nil
+}
+
+// Truncate implements [TruncateFS]
+func (f *osFS) Truncate(name string, size int64) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "truncate", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if err := os.Truncate(f.fullFilePath(name), size); err != nil {
+		return &fs.PathError{Op: "truncate", Path: name, Err: wrapOSErr(err, "Failed to truncate file")}
 	}
 	return nil
 }
@@ -363,11 +912,227 @@ func (f *osFS) Chtimes(name string, atime, mtime time.Time) error {
 		return &fs.PathError{Op: "chtimes", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
 	}
 	if err := os.Chtimes(f.fullFilePath(name), atime, mtime); err != nil {
-		return &fs.PathError{Op: "chtimes", Path: name, Err: kerrors.WithMsg(err, "Failed to change file time metadata")}
+		return &fs.PathError{Op: "chtimes", Path: name, Err: wrapOSErr(err, "Failed to change file time metadata")}
+	}
+	return nil
+}
+
+// Lchtimes implements [LchtimesFS]
+//
+// It is only supported on linux and windows.
+func (f *osFS) Lchtimes(name string, atime, mtime time.Time) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "lchtimes", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if err := lchtimes(f.fullFilePath(name), atime, mtime); err != nil {
+		return &fs.PathError{Op: "lchtimes", Path: name, Err: err}
 	}
 	return nil
 }
 
+// Chmod implements [ChmodFS]
+func (f *osFS) Chmod(name string, mode fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "chmod", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if err := os.Chmod(f.fullFilePath(name), mode); err != nil {
+		return &fs.PathError{Op: "chmod", Path: name, Err: wrapOSErr(err, "Failed to change file mode")}
+	}
+	return nil
+}
+
+// Chown implements [ChownFS]
+//
+// Changing owners is not supported on windows.
+func (f *osFS) Chown(name string, uid, gid int) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "chown", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if err := os.Chown(f.fullFilePath(name), uid, gid); err != nil {
+		return &fs.PathError{Op: "chown", Path: name, Err: wrapOSErr(err, "Failed to change file owner")}
+	}
+	return nil
+}
+
+// Lchown implements [LchownFS]
+//
+// Changing owners is not supported on windows.
+func (f *osFS) Lchown(name string, uid, gid int) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "lchown", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if err := os.Lchown(f.fullFilePath(name), uid, gid); err != nil {
+		return &fs.PathError{Op: "lchown", Path: name, Err: wrapOSErr(err, "Failed to change file owner")}
+	}
+	return nil
+}
+
+// Mkfifo implements [MkfifoFS]
+//
+// It will create any directories in the path of the pipe with 0o777 (before
+// umask). Named pipes are not supported on windows.
+func (f *osFS) Mkfifo(name string, mode fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkfifo", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	fullPath := f.fullFilePath(name)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o777); err != nil {
+		return &fs.PathError{Op: "mkfifo", Path: name, Err: wrapOSErr(err, "Failed to mkdir")}
+	}
+	if err := mkfifo(fullPath, mode); err != nil {
+		return &fs.PathError{Op: "mkfifo", Path: name, Err: err}
+	}
+	return nil
+}
+
+// WriteFileIfMatch implements [ConditionalWriteFS]
+//
+// An empty token creates the file with O_EXCL. Otherwise the file is written
+// to a temporary file in the same directory, which then replaces it with a
+// rename if its token still matches, keeping its mode. The check and the
+// rename are not atomic with respect to other writers, but readers never see
+// a partially written file.
+func (f *osFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) (retErr error) {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	fullPath := f.fullFilePath(name)
+	if token == "" {
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0o777); err != nil {
+			return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed to mkdir")}
+		}
+		file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return &fs.PathError{Op: "writefileifmatch", Path: name, Err: kerrors.WithKind(err, ErrPreconditionFailed, "File already exists")}
+			}
+			return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed to open file")}
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				retErr = errors.Join(retErr, &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed closing file")})
+			}
+		}()
+		if _, err := file.Write(data); err != nil {
+			return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed writing to file")}
+		}
+		return nil
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed to stat file")}
+	}
+	if info.IsDir() {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: kerrors.WithKind(fs.ErrInvalid, ErrIsDir, "File is a directory")}
+	}
+	if FileToken(info) != token {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: kerrors.WithKind(nil, ErrPreconditionFailed, "File token does not match")}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".*.tmp")
+	if err != nil {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed to create temp file")}
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if retErr != nil {
+			if err := os.Remove(tmpName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				retErr = errors.Join(retErr, &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed to remove temp file")})
+			}
+		}
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed writing to temp file")}
+	}
+	if err := tmp.Close(); err != nil {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed closing temp file")}
+	}
+	if err := os.Chmod(tmpName, info.Mode().Perm()); err != nil {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed to chmod temp file")}
+	}
+	// check again just before the rename to narrow the window for a
+	// concurrent write
+	if info, err := os.Stat(fullPath); err != nil {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed to stat file")}
+	} else if FileToken(info) != token {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: kerrors.WithKind(nil, ErrPreconditionFailed, "File token does not match")}
+	}
+	if err := os.Rename(tmpName, fullPath); err != nil {
+		return &fs.PathError{Op: "writefileifmatch", Path: name, Err: wrapOSErr(err, "Failed to rename temp file")}
+	}
+	return nil
+}
+
+// MkdirTemp implements [TempFS]
+//
+// It will create dir and any directories in its path with 0o777 (before
+// umask).
+func (f *osFS) MkdirTemp(dir, pattern string) (string, error) {
+	if !fs.ValidPath(dir) {
+		return "", &fs.PathError{Op: "mkdirtemp", Path: dir, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if strings.ContainsRune(pattern, '/') {
+		return "", &fs.PathError{Op: "mkdirtemp", Path: dir, Err: kerrors.WithMsg(fs.ErrInvalid, "Pattern contains a path separator")}
+	}
+	fullPath := f.fullFilePath(dir)
+	if err := os.MkdirAll(fullPath, 0o777); err != nil {
+		return "", &fs.PathError{Op: "mkdirtemp", Path: dir, Err: wrapOSErr(err, "Failed to mkdir")}
+	}
+	name, err := os.MkdirTemp(fullPath, pattern)
+	if err != nil {
+		return "", &fs.PathError{Op: "mkdirtemp", Path: dir, Err: wrapOSErr(err, "Failed to create temp dir")}
+	}
+	return joinValidPath(dir, filepath.Base(name)), nil
+}
+
+// CreateTemp implements [TempFS]
+//
+// The file is opened for reading and writing. It will create dir and any
+// directories in its path with 0o777 (before umask).
+func (f *osFS) CreateTemp(dir, pattern string) (File, string, error) {
+	if !fs.ValidPath(dir) {
+		return nil, "", &fs.PathError{Op: "createtemp", Path: dir, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if strings.ContainsRune(pattern, '/') {
+		return nil, "", &fs.PathError{Op: "createtemp", Path: dir, Err: kerrors.WithMsg(fs.ErrInvalid, "Pattern contains a path separator")}
+	}
+	fullPath := f.fullFilePath(dir)
+	if err := os.MkdirAll(fullPath, 0o777); err != nil {
+		return nil, "", &fs.PathError{Op: "createtemp", Path: dir, Err: wrapOSErr(err, "Failed to mkdir")}
+	}
+	file, err := os.CreateTemp(fullPath, pattern)
+	if err != nil {
+		return nil, "", &fs.PathError{Op: "createtemp", Path: dir, Err: wrapOSErr(err, "Failed to create temp file")}
+	}
+	return file, joinValidPath(dir, filepath.Base(file.Name())), nil
+}
+
+// SyncDir implements [SyncFS]
+func (f *osFS) SyncDir(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "syncdir", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	if err := syncOSDir(f.fullFilePath(name)); err != nil {
+		return &fs.PathError{Op: "syncdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Statfs implements [StatfsFS]
+//
+// Inode counts are 0 on windows, which does not report them.
+func (f *osFS) Statfs(name string) (*FSStats, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "statfs", Path: name, Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path")}
+	}
+	stats, err := statfs(f.fullFilePath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	return stats, nil
+}
+
 type (
 	// FS implements all the file system operations
 	FS interface {
@@ -380,22 +1145,47 @@ type (
 		FullFilePathFS
 		LstatFS
 		ReadLinkFS
+		MakeSymlinkFS
 		WriteFS
 		RemoveFS
 		RemoveAllFS
+		RenameFS
+		TruncateFS
 		ChtimesFS
+		LchtimesFS
+		ChmodFS
+		ChownFS
+		LchownFS
+		MkfifoFS
+		ConditionalWriteFS
+		TempFS
+		SyncFS
+		StatfsFS
 	}
 )
 
 // New creates a new [FS]
-func New(fsys fs.FS, dir string) FS {
+func New(fsys fs.FS, dir string, opts ...OSFSOpt) FS {
+	o := osFSOpts{
+		linkFallback: LinkFallbackError,
+	}
+	for _, i := range opts {
+		i(&o)
+	}
+	osDir := filepath.Clean(filepath.FromSlash(dir))
+	if dir == "" || osDir == "." || osDir == filepath.VolumeName(osDir) {
+		// names are not joined to these dirs with a separator
+		osDir = ""
+	}
 	return &osFS{
-		fsys: fsys,
-		dir:  dir,
+		fsys:         fsys,
+		dir:          dir,
+		osDir:        osDir,
+		linkFallback: o.linkFallback,
 	}
 }
 
 // DirFS returns an [os.DirFS] wrapped by [FS]
-func DirFS(dir string) FS {
-	return New(os.DirFS(filepath.FromSlash(dir)), dir)
+func DirFS(dir string, opts ...OSFSOpt) FS {
+	return New(os.DirFS(filepath.FromSlash(dir)), dir, opts...)
 }
@@ -1,12 +1,28 @@
 package kfs_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/stretchr/testify/require"
@@ -58,11 +74,18 @@ func Test_FS(t *testing.T) {
 	assert.NoError(kfstest.TestFileWrite(subFsys, "subother/subother.txt", []byte("subother")))
 
 	assert.NoError(kfstest.TestFS(fsys, testFiles...))
+	assert.NoError(kfstest.TestFSErrors(fsys, kfstest.TestFSErrorsOpts{
+		Existing: "foo.txt",
+	}))
 
 	{
 		// test read-only fs
 		roFS := kfs.NewReadOnlyFS(fsys)
 		assert.NoError(kfstest.TestFS(roFS, testFiles...))
+		assert.NoError(kfstest.TestDirOpenFile(roFS, "bar"))
+		assert.NoError(kfstest.TestFSErrors(roFS, kfstest.TestFSErrorsOpts{
+			ReadOnly: true,
+		}))
 		assert.ErrorIs(
 			kfs.WriteFile(roFS, "shouldfailwriting", []byte("should fail writing"), 0o644),
 			kfs.ErrReadOnly,
@@ -100,6 +123,42 @@ func Test_FS(t *testing.T) {
 		}
 		_, err = fs.ReadFile(fsys, ".git")
 		assert.ErrorIs(err, kfs.ErrFileMasked)
+		assert.NoError(kfstest.TestReadDirFile(fsys, "."))
+		assert.NoError(kfstest.TestDirOpenFile(fsys, "bar"))
+		dirFile, err := kfs.OpenFile(fsys, ".", os.O_RDONLY, 0)
+		assert.NoError(err)
+		entries, err = dirFile.(fs.ReadDirFile).ReadDir(-1)
+		assert.NoError(err)
+		for _, i := range entries {
+			assert.NotEqual(".git", i.Name())
+		}
+		assert.NoError(dirFile.Close())
+		dir, err := fsys.Open(".")
+		assert.NoError(err)
+		entries, err = dir.(fs.ReadDirFile).ReadDir(-1)
+		assert.NoError(err)
+		for _, i := range entries {
+			assert.NotEqual(".git", i.Name())
+		}
+		assert.NoError(dir.Close())
+	}
+
+	{
+		// test positional writes, truncation, and exclusive creation
+		assert.NoError(kfstest.TestFileWrite(subFsys, "writeat.txt", []byte("hello, world")))
+		assert.NoError(kfstest.TestFileWriteAt(subFsys, "writeat.txt", 7, []byte("there")))
+		assert.NoError(kfstest.TestFileWriteAt(subFsys, "writeat.txt", 16, []byte("past end")))
+		assert.NoError(kfstest.TestFileTruncate(subFsys, "writeat.txt"))
+		assert.NoError(kfstest.TestFileCreateExcl(subFsys, "excl.txt", []byte("excl")))
+		assert.NoError(kfs.Remove(subFsys, "writeat.txt"))
+		assert.NoError(kfs.Remove(subFsys, "excl.txt"))
+	}
+
+	{
+		// test dir errors
+		assert.ErrorIs(kfs.WriteFile(subFsys, "subother/subother.txt/child.txt", []byte("child"), 0o644), kfs.ErrNotDir)
+		_, err := kfs.OpenFile(subFsys, "subother", os.O_WRONLY, 0)
+		assert.ErrorIs(err, kfs.ErrIsDir)
 	}
 
 	{
@@ -128,3 +187,1884 @@ func Test_FS(t *testing.T) {
 		assert.NoError(kfstest.TestFileOpen(subFsys, "yetanother.txt", []byte("yetanother")))
 	}
 }
+
+// registerTestScheme registers the url scheme of [Test_OpenURL] once, as the
+// registry is global and may not register a scheme twice
+var registerTestScheme = sync.OnceFunc(func() {
+	kfs.RegisterScheme("KFSTest", func(ctx context.Context, u *url.URL) (kfs.FS, error) {
+		return kfs.NewReadOnlyFS(kfs.DirFS(u.Path)), nil
+	})
+})
+
+func Test_OpenURL(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	tempDir := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(tempDir, "foo.txt"), []byte("hello, world"), 0o644))
+
+	testFiles := []kfstest.TestFSFile{
+		{
+			Name: "foo.txt",
+			Data: []byte("hello, world"),
+		},
+	}
+
+	{
+		fsys, err := kfs.OpenURL(context.Background(), tempDir)
+		assert.NoError(err)
+		assert.NoError(kfstest.TestFS(fsys, testFiles...))
+	}
+
+	{
+		fsys, err := kfs.OpenURL(context.Background(), (&url.URL{Scheme: "file", Path: filepath.ToSlash(tempDir)}).String())
+		assert.NoError(err)
+		assert.NoError(kfstest.TestFS(fsys, testFiles...))
+	}
+
+	{
+		_, err := kfs.OpenURL(context.Background(), "kfstestunknown://bucket/prefix")
+		assert.ErrorIs(err, kfs.ErrUnknownScheme)
+	}
+
+	{
+		registerTestScheme()
+		assert.Panics(func() {
+			kfs.RegisterScheme("kfstest", func(ctx context.Context, u *url.URL) (kfs.FS, error) {
+				return nil, nil
+			})
+		})
+		fsys, err := kfs.OpenURL(context.Background(), (&url.URL{Scheme: "KFSTEST", Path: filepath.ToSlash(tempDir)}).String())
+		assert.NoError(err)
+		assert.NoError(kfstest.TestFS(fsys, testFiles...))
+		assert.ErrorIs(kfs.WriteFile(fsys, "bar.txt", []byte("bar"), 0o644), kfs.ErrReadOnly)
+	}
+}
+
+func Test_Chain(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	tempDir := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(tempDir, "foo.txt"), []byte("hello, world"), 0o644))
+	assert.NoError(os.WriteFile(filepath.Join(tempDir, "secret.txt"), []byte("secret"), 0o644))
+
+	fsys := kfs.Chain(
+		kfs.DirFS(tempDir),
+		kfs.WithReadOnly(),
+		kfs.WithMask(func(p string) (bool, error) {
+			return p != "secret.txt", nil
+		}),
+	)
+
+	assert.NoError(kfstest.TestFS(fsys, kfstest.TestFSFile{
+		Name: "foo.txt",
+		Data: []byte("hello, world"),
+	}))
+	_, err := fs.ReadFile(fsys, "secret.txt")
+	assert.ErrorIs(err, kfs.ErrFileMasked)
+	assert.ErrorIs(kfs.WriteFile(fsys, "bar.txt", []byte("bar"), 0o644), kfs.ErrReadOnly)
+
+	{
+		// test wrapping a plain fs
+		fsys := kfs.Chain(os.DirFS(tempDir))
+		content, err := fs.ReadFile(fsys, "foo.txt")
+		assert.NoError(err)
+		assert.Equal([]byte("hello, world"), content)
+		assert.ErrorIs(kfs.Remove(fsys, "foo.txt"), kfs.ErrNotImplemented)
+	}
+}
+
+func Test_NormalizeError(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	{
+		err := kfs.NormalizeError(&fs.PathError{Op: "open", Path: "foo.txt", Err: syscall.ENOTDIR})
+		assert.ErrorIs(err, kfs.ErrNotDir)
+		assert.ErrorIs(err, syscall.ENOTDIR)
+		var pathErr *fs.PathError
+		assert.ErrorAs(err, &pathErr)
+		assert.Equal("open", pathErr.Op)
+		assert.Equal("foo.txt", pathErr.Path)
+	}
+
+	assert.ErrorIs(kfs.NormalizeError(syscall.EISDIR), kfs.ErrIsDir)
+	assert.Equal(fs.ErrNotExist, kfs.NormalizeError(fs.ErrNotExist))
+	assert.NoError(kfs.NormalizeError(nil))
+
+	{
+		fsys := kfs.DirFS(t.TempDir())
+		assert.ErrorIs(kfs.WriteFile(fsys, strings.Repeat("a", 1024), []byte("long"), 0o644), kfs.ErrNameTooLong)
+	}
+}
+
+func Test_RemoveAllContext(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	tempDir := t.TempDir()
+	fsys := kfs.DirFS(tempDir)
+	assert.NoError(kfs.WriteFile(fsys, "tree/foo.txt", []byte("foo"), 0o644))
+	assert.NoError(kfs.WriteFile(fsys, "tree/bar/bar.txt", []byte("bar"), 0o644))
+	assert.NoError(kfs.WriteFile(fsys, "keep/keep.txt", []byte("keep"), 0o644))
+	assert.NoError(os.Symlink("../keep", filepath.Join(tempDir, "tree", "link")))
+
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		report, err := kfs.RemoveAllContext(ctx, fsys, "tree")
+		assert.ErrorIs(err, context.Canceled)
+		assert.Empty(report.Removed)
+		_, err = fs.Stat(fsys, "tree/foo.txt")
+		assert.NoError(err)
+	}
+
+	report, err := kfs.RemoveAllContext(context.Background(), fsys, "tree")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"tree/foo.txt", "tree/bar/bar.txt", "tree/bar", "tree/link", "tree"}, report.Removed)
+	assert.Equal("tree", report.Removed[len(report.Removed)-1])
+	_, err = fs.Stat(fsys, "tree")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.NoError(kfstest.TestFileOpen(fsys, "keep/keep.txt", []byte("keep")))
+
+	report, err = kfs.RemoveAllContext(context.Background(), fsys, "tree")
+	assert.NoError(err)
+	assert.Empty(report.Removed)
+}
+
+func Test_RemoveAllContextWorkers(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfs.DirFS(t.TempDir())
+	var expected []string
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
+			name := fmt.Sprintf("tree/%d/%d.txt", i, j)
+			assert.NoError(kfs.WriteFile(fsys, name, []byte(name), 0o644))
+			expected = append(expected, name)
+		}
+		expected = append(expected, fmt.Sprintf("tree/%d", i))
+	}
+	expected = append(expected, "tree")
+
+	report, err := kfs.RemoveAllContext(context.Background(), fsys, "tree", kfs.RemoveAllWorkers(4))
+	assert.NoError(err)
+	assert.ElementsMatch(expected, report.Removed)
+	assert.Equal("tree", report.Removed[len(report.Removed)-1])
+	_, err = fs.Stat(fsys, "tree")
+	assert.ErrorIs(err, fs.ErrNotExist)
+}
+
+func Test_CountEntries(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	tempDir := t.TempDir()
+	fsys := kfs.DirFS(tempDir)
+	for i := 0; i < 300; i++ {
+		assert.NoError(kfs.WriteFile(fsys, fmt.Sprintf("big/%d.txt", i), nil, 0o644))
+	}
+	assert.NoError(os.Mkdir(filepath.Join(tempDir, "empty"), 0o777))
+
+	count, err := kfs.CountEntries(fsys, "big", 0)
+	assert.NoError(err)
+	assert.Equal(300, count)
+	count, err = kfs.CountEntries(fsys, "big", 10)
+	assert.NoError(err)
+	assert.Equal(10, count)
+
+	empty, err := kfs.IsEmptyDir(fsys, "big")
+	assert.NoError(err)
+	assert.False(empty)
+	empty, err = kfs.IsEmptyDir(fsys, "empty")
+	assert.NoError(err)
+	assert.True(empty)
+	_, err = kfs.IsEmptyDir(fsys, "big/0.txt")
+	assert.ErrorIs(err, kfs.ErrNotDir)
+
+	removed, err := kfs.RemoveIfEmpty(fsys, "big")
+	assert.NoError(err)
+	assert.False(removed)
+	removed, err = kfs.RemoveIfEmpty(fsys, "empty")
+	assert.NoError(err)
+	assert.True(removed)
+	_, err = fs.Stat(fsys, "empty")
+	assert.ErrorIs(err, fs.ErrNotExist)
+}
+
+func Test_PruneEmptyDirs(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	tempDir := t.TempDir()
+	fsys := kfs.DirFS(tempDir)
+	for _, i := range []string{"a/b/c", "a/d", "e", "f/g", ".git/objects"} {
+		assert.NoError(os.MkdirAll(filepath.Join(tempDir, filepath.FromSlash(i)), 0o777))
+	}
+	assert.NoError(kfs.WriteFile(fsys, "f/keep.txt", []byte("keep"), 0o644))
+	assert.NoError(kfs.WriteFile(fsys, ".git/objects/obj", []byte("obj"), 0o644))
+
+	maskedFS := kfs.NewMaskFS(fsys, func(p string) (bool, error) {
+		return p != ".git/objects/obj", nil
+	})
+
+	report, err := kfs.PruneEmptyDirs(context.Background(), maskedFS, ".", kfs.PruneDryRun())
+	assert.NoError(err)
+	assert.Equal([]string{".git/objects", ".git", "a/b/c", "a/b", "a/d", "a", "e", "f/g"}, report.Removed)
+	_, err = fs.Stat(fsys, "a/b/c")
+	assert.NoError(err)
+
+	report, err = kfs.PruneEmptyDirs(context.Background(), maskedFS, ".")
+	assert.NoError(err)
+	assert.Equal([]string{"a/b/c", "a/b", "a/d", "a", "e", "f/g"}, report.Removed)
+	entries, err := fs.ReadDir(fsys, ".")
+	assert.NoError(err)
+	names := make([]string, 0, len(entries))
+	for _, i := range entries {
+		names = append(names, i.Name())
+	}
+	assert.Equal([]string{".git", "f"}, names)
+	assert.NoError(kfstest.TestFileOpen(fsys, ".git/objects/obj", []byte("obj")))
+
+	{
+		// implicit parent dirs are removed with their last child
+		mfs := kfstest.NewMapFS().
+			WithDir("a/b/c").
+			WithDir("a/d").
+			WithFile("f/keep.txt", []byte("keep"), 0o644)
+		report, err := kfs.PruneEmptyDirs(context.Background(), mfs, ".")
+		assert.NoError(err)
+		assert.Equal([]string{"a/b/c", "a/b", "a/d", "a"}, report.Removed)
+		_, err = fs.Stat(mfs, "a")
+		assert.ErrorIs(err, fs.ErrNotExist)
+		assert.NoError(kfstest.TestFileOpen(mfs, "f/keep.txt", []byte("keep")))
+	}
+}
+
+func Test_SameModTime(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	a := time.Date(2024, 1, 1, 0, 0, 1, 500_000_000, time.UTC)
+	assert.True(kfs.SameModTime(a, a, 0))
+	assert.False(kfs.SameModTime(a, a.Add(time.Nanosecond), 0))
+	assert.True(kfs.SameModTime(a, a.Truncate(time.Second), time.Second))
+	assert.True(kfs.SameModTime(a, a.Round(2*time.Second), 2*time.Second))
+	assert.True(kfs.SameModTime(a.Round(2*time.Second), a, 2*time.Second))
+	assert.False(kfs.SameModTime(a, a.Add(2*time.Second), 2*time.Second))
+	assert.True(kfs.SameModTime(a.In(time.FixedZone("test", 3600)), a, 0))
+}
+
+func Test_HashTree(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	newFS := func() *kfstest.MapFS {
+		return kfstest.NewMapFS().
+			WithFile("a/b/c.txt", []byte("hello"), 0o644).
+			WithFile("a/d.txt", []byte("world"), 0o644).
+			WithFile("e.sh", []byte("#!/bin/sh"), 0o755).
+			WithDir("f").
+			WithSymlink("g", "a/d.txt")
+	}
+
+	digest, err := kfs.HashTree(context.Background(), newFS(), ".")
+	assert.NoError(err)
+	assert.Len(digest, sha256.Size)
+
+	digest2, err := kfs.HashTree(context.Background(), newFS(), ".", kfs.HashTreeWorkers(4))
+	assert.NoError(err)
+	assert.Equal(digest, digest2)
+
+	fileDigest, err := kfs.HashTree(context.Background(), newFS(), "a/d.txt")
+	assert.NoError(err)
+	h := sha256.New()
+	h.Write([]byte{'f', 0x00, 0x00, 0x01, 0xa4})
+	h.Write([]byte("world"))
+	assert.Equal(h.Sum(nil), fileDigest)
+
+	for _, i := range []*kfstest.MapFS{
+		newFS().WithFile("a/d.txt", []byte("World"), 0o644),
+		newFS().WithFile("a/d.txt", []byte("world"), 0o600),
+		newFS().WithSymlink("g", "a/b/c.txt"),
+		newFS().WithDir("h"),
+	} {
+		other, err := kfs.HashTree(context.Background(), i, ".")
+		assert.NoError(err)
+		assert.NotEqual(digest, other)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = kfs.HashTree(ctx, newFS(), ".")
+	assert.ErrorIs(err, context.Canceled)
+
+	_, err = kfs.HashTree(context.Background(), newFS(), "missing")
+	assert.ErrorIs(err, fs.ErrNotExist)
+}
+
+func Test_HashDirH1(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("mod/go.mod", []byte("module example.com/m\n"), 0o644).
+		WithFile("mod/a/b.go", []byte("package a\n"), 0o644).
+		WithDir("mod/empty")
+
+	summary := strings.Builder{}
+	for _, i := range []struct {
+		name string
+		data string
+	}{
+		{name: "example.com/m@v1.0.0/a/b.go", data: "package a\n"},
+		{name: "example.com/m@v1.0.0/go.mod", data: "module example.com/m\n"},
+	} {
+		fmt.Fprintf(&summary, "%x  %s\n", sha256.Sum256([]byte(i.data)), i.name)
+	}
+	sum := sha256.Sum256([]byte(summary.String()))
+	expected := "h1:" + base64.StdEncoding.EncodeToString(sum[:])
+
+	h1, err := kfs.HashDirH1(fsys, "mod", "example.com/m@v1.0.0")
+	assert.NoError(err)
+	assert.Equal(expected, h1)
+
+	sub, err := fs.Sub(fsys, "mod")
+	assert.NoError(err)
+	h1, err = kfs.HashDirH1(sub, ".", "example.com/m@v1.0.0")
+	assert.NoError(err)
+	assert.Equal(expected, h1)
+
+	_, err = kfs.HashDirH1(fsys, "missing", "example.com/m@v1.0.0")
+	assert.ErrorIs(err, fs.ErrNotExist)
+}
+
+func Test_HashTreeGit(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("a/b.txt", []byte("hello\n"), 0o644).
+		WithFile("a.txt", []byte("x"), 0o644).
+		WithFile("run.sh", []byte("#!/bin/sh\n"), 0o755).
+		WithSymlink("link", "a/b.txt").
+		WithDir("e")
+
+	for _, i := range []struct {
+		name     string
+		expected string
+	}{
+		{name: ".", expected: "c4e04f290f25c184ac3c7c4a60b0b83cb8dd0fc1"},
+		{name: "a", expected: "dd5a3627ad3d4a1eaa9b180972bab37891a5e101"},
+		{name: "a/b.txt", expected: "ce013625030ba8dba906f756967f9e9ca394464a"},
+		{name: "link", expected: "fb8889aa0e875da9d29cbb51155974586b8a64c5"},
+		{name: "run.sh", expected: "1a2485251c33a70432394c93fb89330ef214bfc9"},
+		{name: "e", expected: "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+	} {
+		digest, err := kfs.HashTree(context.Background(), fsys, i.name, kfs.HashTreeGit(sha1.New), kfs.HashTreeWorkers(4))
+		assert.NoError(err)
+		assert.Equal(i.expected, hex.EncodeToString(digest), i.name)
+	}
+}
+
+func Test_HashAlg(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	alg, err := kfs.LookupHashAlg("sha2-512")
+	assert.NoError(err)
+	assert.Equal(uint64(kfs.HashCodeSHA512), alg.Code)
+
+	_, err = kfs.LookupHashAlg("blake3")
+	assert.ErrorIs(err, kfs.ErrUnknownHashAlg)
+	_, err = kfs.LookupHashAlgCode(0x99)
+	assert.ErrorIs(err, kfs.ErrUnknownHashAlg)
+
+	assert.Panics(func() {
+		kfs.RegisterHashAlg(kfs.HashAlg{Name: "sha2-256", Code: 0x99, New: sha256.New})
+	})
+
+	fsys := kfstest.NewMapFS().
+		WithFile("a.txt", []byte("hello"), 0o644)
+	digest, err := kfs.HashTree(context.Background(), fsys, ".", kfs.HashTreeAlg(alg))
+	assert.NoError(err)
+	assert.Len(digest, sha512.Size)
+
+	mh := alg.Multihash(digest)
+	assert.Equal([]byte{kfs.HashCodeSHA512, sha512.Size}, mh[:2])
+	parsed, parsedDigest, err := kfs.ParseMultihash(mh)
+	assert.NoError(err)
+	assert.Equal("sha2-512", parsed.Name)
+	assert.Equal(digest, parsedDigest)
+
+	_, _, err = kfs.ParseMultihash(mh[:len(mh)-1])
+	assert.ErrorIs(err, fs.ErrInvalid)
+	_, _, err = kfs.ParseMultihash([]byte{0x99, 0x01, 0x00})
+	assert.ErrorIs(err, kfs.ErrUnknownHashAlg)
+}
+
+func Test_FindUp(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("go.mod", []byte("module example.com/m\n"), 0o644).
+		WithDir(".git").
+		WithFile("sub/package.json", []byte("{}"), 0o644).
+		WithFile("sub/pkg/a/b.txt", []byte("b"), 0o644)
+
+	for _, i := range []struct {
+		start    string
+		names    []string
+		expected string
+	}{
+		{start: "sub/pkg/a", names: []string{"go.mod"}, expected: "."},
+		{start: "sub/pkg/a", names: []string{"go.mod", "package.json"}, expected: "sub"},
+		{start: "sub/pkg/a/b.txt", names: []string{".git"}, expected: "."},
+		{start: ".", names: []string{"go.mod"}, expected: "."},
+		{start: "sub", names: []string{"package.json"}, expected: "sub"},
+	} {
+		dir, err := kfs.FindUp(fsys, i.start, i.names...)
+		assert.NoError(err)
+		assert.Equal(i.expected, dir)
+	}
+
+	_, err := kfs.FindUp(fsys, "sub/pkg", "Cargo.toml")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	_, err = kfs.FindUp(fsys, "../sub", "go.mod")
+	assert.ErrorIs(err, fs.ErrInvalid)
+
+	{
+		// stat of a path under a file fails with ENOTDIR on the os
+		fsys := kfs.DirFS(t.TempDir())
+		assert.NoError(kfs.WriteFile(fsys, "go.mod", []byte("module example.com/m\n"), 0o644))
+		assert.NoError(kfs.WriteFile(fsys, "cmd/main.go", []byte("package main\n"), 0o644))
+		dir, err := kfs.FindUp(fsys, "cmd/main.go", "go.mod")
+		assert.NoError(err)
+		assert.Equal(".", dir)
+	}
+}
+
+func Test_ApplyPatch(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	diff := []byte(`diff --git a/a.txt b/a.txt
+index 2019eda..b8f0e56 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1,7 +1,7 @@
+ one
+ two
+-three
++THREE
+ four
+ five
+ six
+-seven
++seven
+\ No newline at end of file
+diff --git a/a/b.txt b/a/b.txt
+deleted file mode 100644
+index ce01362..0000000
+--- a/a/b.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-hello
+diff --git a/c.txt b/c.txt
+new file mode 100644
+index 0000000..3e75765
+--- /dev/null
++++ b/c.txt
+@@ -0,0 +1 @@
++new
+`)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("a.txt", []byte("zero\nzero\none\ntwo\nthree\nfour\nfive\nsix\nseven\n"), 0o600).
+		WithFile("a/b.txt", []byte("hello\n"), 0o644)
+
+	expectedReport := &kfs.PatchReport{
+		Created:  []string{"c.txt"},
+		Modified: []string{"a.txt"},
+		Removed:  []string{"a/b.txt"},
+	}
+
+	report, err := kfs.ApplyPatch(fsys, diff, kfs.ApplyPatchDryRun())
+	assert.NoError(err)
+	assert.Equal(expectedReport, report)
+	assert.NoError(kfstest.TestFileOpen(fsys, "a/b.txt", []byte("hello\n")))
+
+	report, err = kfs.ApplyPatch(fsys, diff)
+	assert.NoError(err)
+	assert.Equal(expectedReport, report)
+	assert.NoError(kfstest.TestFileOpen(fsys, "a.txt", []byte("zero\nzero\none\ntwo\nTHREE\nfour\nfive\nsix\nseven")))
+	info, err := fs.Stat(fsys, "a.txt")
+	assert.NoError(err)
+	assert.Equal(fs.FileMode(0o600), info.Mode().Perm())
+	assert.NoError(kfstest.TestFileOpen(fsys, "c.txt", []byte("new\n")))
+	_, err = fs.Stat(fsys, "a/b.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	_, err = kfs.ApplyPatch(fsys, diff)
+	assert.ErrorIs(err, kfs.ErrPatchConflict)
+
+	fuzzDiff := []byte(`--- a.txt
++++ a.txt
+@@ -2,5 +2,5 @@
+ changed
+ two
+-THREE
++three
+ four
+ five
+`)
+	_, err = kfs.ApplyPatch(fsys, fuzzDiff, kfs.ApplyPatchStrip(0))
+	assert.ErrorIs(err, kfs.ErrPatchConflict)
+	report, err = kfs.ApplyPatch(fsys, fuzzDiff, kfs.ApplyPatchStrip(0), kfs.ApplyPatchFuzz(1))
+	assert.NoError(err)
+	assert.Equal([]string{"a.txt"}, report.Modified)
+	assert.NoError(kfstest.TestFileOpen(fsys, "a.txt", []byte("zero\nzero\none\ntwo\nthree\nfour\nfive\nsix\nseven")))
+
+	_, err = kfs.ApplyPatch(fsys, []byte("not a patch\n"))
+	assert.ErrorIs(err, fs.ErrInvalid)
+	_, err = kfs.ApplyPatch(fsys, []byte("--- a/../x\n+++ b/../x\n@@ -1 +1 @@\n-a\n+b\n"))
+	assert.ErrorIs(err, fs.ErrInvalid)
+}
+
+func Test_ProtectFS(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("home/user/.ssh/id_ed25519", []byte("key"), 0o600).
+		WithFile("home/user/notes.txt", []byte("notes"), 0o644).
+		WithFile("tmp/scratch.txt", []byte("scratch"), 0o644).
+		WithFile("etc/passwd", []byte("root"), 0o644)
+
+	pfs := kfs.Chain(fsys, kfs.WithProtect("home/*/.ssh", "etc"))
+
+	assert.ErrorIs(kfs.Remove(pfs, "etc"), kfs.ErrProtected)
+	assert.ErrorIs(kfs.RemoveAll(pfs, "etc"), kfs.ErrProtected)
+	assert.ErrorIs(kfs.RemoveAll(pfs, "home"), kfs.ErrProtected)
+	assert.ErrorIs(kfs.RemoveAll(pfs, "."), fs.ErrPermission)
+	assert.ErrorIs(kfs.RemoveAll(pfs, "home/user/.ssh"), kfs.ErrProtected)
+	assert.ErrorIs(kfs.Remove(pfs, "home/user/.ssh/id_ed25519"), kfs.ErrProtected)
+	assert.ErrorIs(kfs.Remove(pfs, "etc/passwd"), kfs.ErrProtected)
+	report, err := kfs.RemoveAllContext(context.Background(), pfs, "home")
+	assert.ErrorIs(err, kfs.ErrProtected)
+	assert.Empty(report.Removed)
+	assert.NoError(kfstest.TestFileOpen(fsys, "home/user/.ssh/id_ed25519", []byte("key")))
+	assert.NoError(kfs.Remove(pfs, "home/user/notes.txt"))
+	assert.NoError(kfs.RemoveAll(pfs, "tmp"))
+	assert.NoError(kfs.WriteFile(pfs, "tmp/new.txt", []byte("new"), 0o644))
+	assert.ErrorIs(kfs.Remove(pfs, "../etc"), fs.ErrInvalid)
+
+	sub, err := fs.Sub(pfs, "home")
+	assert.NoError(err)
+	assert.ErrorIs(kfs.RemoveAll(sub, "user"), kfs.ErrProtected)
+	assert.ErrorIs(kfs.RemoveAll(sub, "."), kfs.ErrProtected)
+
+	assert.Panics(func() {
+		kfs.NewProtectFS(fsys, "[")
+	})
+}
+
+func Test_InspectFS(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("uploads/a.txt", []byte("hello"), 0o644)
+
+	var inspected []string
+	ifs := kfs.Chain(fsys, kfs.WithInspect(kfs.InspectorFunc(func(name string, r io.Reader) error {
+		inspected = append(inspected, name)
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(b, []byte("virus")) {
+			return errors.New("Found virus")
+		}
+		return nil
+	})))
+
+	assert.NoError(kfs.WriteFile(ifs, "uploads/b.txt", []byte("clean"), 0o644))
+	assert.NoError(kfstest.TestFileOpen(fsys, "uploads/b.txt", []byte("clean")))
+
+	assert.ErrorIs(kfs.WriteFile(ifs, "uploads/a.txt", []byte("virus"), 0o644), kfs.ErrRejected)
+	assert.NoError(kfstest.TestFileOpen(fsys, "uploads/a.txt", []byte("hello")))
+	assert.ErrorIs(kfs.WriteFile(ifs, "uploads/c.txt", []byte("virus"), 0o644), kfs.ErrRejected)
+	_, err := fs.Stat(fsys, "uploads/c.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	f, err := kfs.OpenFile(ifs, "uploads/a.txt", os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(err)
+	_, err = f.Write([]byte(" world"))
+	assert.NoError(err)
+	info, err := f.Stat()
+	assert.NoError(err)
+	assert.Equal(fs.FileMode(0o644), info.Mode())
+	assert.NoError(kfstest.TestFileOpen(fsys, "uploads/a.txt", []byte("hello")))
+	assert.NoError(f.Close())
+	assert.NoError(kfstest.TestFileOpen(fsys, "uploads/a.txt", []byte("hello world")))
+	entries, err := fs.ReadDir(fsys, "uploads")
+	assert.NoError(err)
+	assert.Len(entries, 2)
+
+	_, err = kfs.OpenFile(ifs, "uploads/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	assert.ErrorIs(err, fs.ErrExist)
+	_, err = kfs.OpenFile(ifs, "uploads/missing.txt", os.O_WRONLY, 0)
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	sub, err := fs.Sub(ifs, "uploads")
+	assert.NoError(err)
+	assert.NoError(kfs.WriteFile(sub, "d.txt", []byte("d"), 0o644))
+
+	assert.Equal([]string{"uploads/b.txt", "uploads/a.txt", "uploads/c.txt", "uploads/a.txt", "uploads/d.txt"}, inspected)
+
+	fsys.WithSymlink("uploads/link.txt", "d.txt")
+	assert.NoError(kfs.WriteFile(ifs, "uploads/link.txt", []byte("linked"), 0o644))
+	assert.NoError(kfstest.TestFileOpen(fsys, "uploads/d.txt", []byte("linked")))
+	info, err = kfs.Lstat(fsys, "uploads/link.txt")
+	assert.NoError(err)
+	assert.Equal(fs.ModeSymlink, info.Mode().Type())
+
+	plain := kfs.NewInspectFS(plainWriteFS{fsys}, kfs.InspectorFunc(func(name string, r io.Reader) error {
+		return nil
+	}))
+	assert.NoError(kfs.WriteFile(plain, "uploads/b.txt", []byte("plain"), 0o644))
+	assert.NoError(kfstest.TestFileOpen(fsys, "uploads/b.txt", []byte("plain")))
+	entries, err = fs.ReadDir(fsys, "uploads")
+	assert.NoError(err)
+	assert.Len(entries, 4)
+}
+
+func Test_RedactFS(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	tempDir := t.TempDir()
+	fsys := kfs.DirFS(tempDir)
+	assert.NoError(kfs.WriteFile(fsys, "a/b.txt", []byte("b"), 0o644))
+
+	err := kfs.Remove(fsys, "missing.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.Contains(err.Error(), filepath.ToSlash(tempDir))
+
+	rfs := kfs.Chain(fsys, kfs.WithRedact(nil))
+	err = kfs.Remove(rfs, "missing.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.NotContains(filepath.ToSlash(err.Error()), filepath.ToSlash(tempDir))
+	assert.Contains(err.Error(), "missing.txt")
+	var pathErr *fs.PathError
+	assert.ErrorAs(err, &pathErr)
+	assert.Equal("missing.txt", pathErr.Path)
+	assert.False(errors.As(pathErr.Err, &pathErr))
+
+	_, err = kfs.Lstat(rfs, "a/missing.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.NotContains(filepath.ToSlash(err.Error()), filepath.ToSlash(tempDir))
+
+	err = kfs.Rename(rfs, "missing.txt", "a/c.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.NotContains(filepath.ToSlash(err.Error()), filepath.ToSlash(tempDir))
+	var linkErr *os.LinkError
+	assert.ErrorAs(err, &linkErr)
+	assert.Equal("missing.txt", linkErr.Old)
+	assert.Equal("a/c.txt", linkErr.New)
+
+	b, err := fs.ReadFile(rfs, "a/b.txt")
+	assert.NoError(err)
+	assert.Equal([]byte("b"), b)
+
+	{
+		// errors from opened files are redacted
+		f, err := rfs.Open("a")
+		assert.NoError(err)
+		_, err = f.Read(make([]byte, 1))
+		assert.Error(err)
+		assert.Contains(err.Error(), "read a:")
+		assert.NotContains(filepath.ToSlash(err.Error()), filepath.ToSlash(tempDir))
+		entries, err := f.(fs.ReadDirFile).ReadDir(-1)
+		assert.NoError(err)
+		assert.Len(entries, 1)
+		assert.NoError(f.Close())
+		err = f.Close()
+		assert.ErrorIs(err, fs.ErrClosed)
+		assert.NotContains(filepath.ToSlash(err.Error()), filepath.ToSlash(tempDir))
+
+		wf, err := kfs.OpenFile(rfs, "a/b.txt", os.O_RDONLY, 0)
+		assert.NoError(err)
+		_, err = wf.Write([]byte("b"))
+		assert.Error(err)
+		assert.NotContains(filepath.ToSlash(err.Error()), filepath.ToSlash(tempDir))
+		assert.NoError(wf.Close())
+	}
+
+	sub, err := fs.Sub(kfs.NewRedactFS(fsys, kfs.RedactorFunc(func(p string) string {
+		return "<path>"
+	})), "a")
+	assert.NoError(err)
+	err = kfs.Remove(sub, "missing.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.NotContains(filepath.ToSlash(err.Error()), filepath.ToSlash(tempDir))
+	assert.ErrorAs(err, &pathErr)
+	assert.Equal("<path>", pathErr.Path)
+
+	redactor := kfs.RelativeRedactor("/srv/data")
+	assert.Equal("a/b.txt", redactor.Redact("/srv/data/a/b.txt"))
+	assert.Equal(".", redactor.Redact("/srv/data"))
+	assert.Equal("[redacted]", redactor.Redact("/etc/passwd"))
+	assert.Equal("a/b.txt", redactor.Redact("a/b.txt"))
+}
+
+func Test_VerifiedFS(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("plugins/a.so", []byte("plugin a"), 0o644).
+		WithFile("plugins/b.so", []byte("plugin b"), 0o644)
+
+	alg, err := kfs.LookupHashAlg("sha2-256")
+	assert.NoError(err)
+	manifest, err := kfs.NewManifest(fsys, alg)
+	assert.NoError(err)
+	assert.Len(manifest, 2)
+	sum := sha256.Sum256([]byte("plugin a"))
+	assert.Equal(alg.Multihash(sum[:]), manifest["plugins/a.so"])
+
+	vfs := kfs.NewVerifiedFS(fsys, manifest)
+	b, err := fs.ReadFile(vfs, "plugins/a.so")
+	assert.NoError(err)
+	assert.Equal([]byte("plugin a"), b)
+	entries, err := fs.ReadDir(vfs, "plugins")
+	assert.NoError(err)
+	assert.Len(entries, 2)
+
+	fsys.WithFile("plugins/b.so", []byte("tampered"), 0o644)
+	_, err = fs.ReadFile(vfs, "plugins/b.so")
+	assert.ErrorIs(err, kfs.ErrIntegrity)
+	f, err := vfs.Open("plugins/b.so")
+	assert.NoError(err)
+	_, err = io.ReadAll(f)
+	assert.ErrorIs(err, kfs.ErrIntegrity)
+	assert.NoError(f.Close())
+
+	fsys.WithFile("plugins/c.so", []byte("plugin c"), 0o644)
+	_, err = vfs.Open("plugins/c.so")
+	assert.ErrorIs(err, kfs.ErrIntegrity)
+
+	sub, err := fs.Sub(vfs, "plugins")
+	assert.NoError(err)
+	b, err = fs.ReadFile(sub, "a.so")
+	assert.NoError(err)
+	assert.Equal([]byte("plugin a"), b)
+
+	assert.ErrorIs(kfs.WriteFile(vfs, "plugins/a.so", []byte("x"), 0o644), kfs.ErrReadOnly)
+	assert.ErrorIs(kfs.Remove(vfs, "plugins/a.so"), kfs.ErrReadOnly)
+}
+
+func Test_OpenByDigest(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("plugins/a.so", []byte("plugin a"), 0o644).
+		WithFile("plugins/b.so", []byte("plugin b"), 0o644)
+
+	alg, err := kfs.LookupHashAlg("sha2-256")
+	assert.NoError(err)
+	manifest, err := kfs.NewManifest(fsys, alg)
+	assert.NoError(err)
+	vfs := kfs.NewVerifiedFS(fsys, manifest)
+
+	sum := sha256.Sum256([]byte("plugin a"))
+	mh := alg.Multihash(sum[:])
+	// a file whose contents changed since the manifest was computed fails to
+	// verify
+	fsys.WithFile("plugins/a.so", []byte("plugin b"), 0o644)
+	f, err := kfs.OpenByDigest(vfs, mh)
+	assert.NoError(err)
+	_, err = io.ReadAll(f)
+	assert.ErrorIs(err, kfs.ErrIntegrity)
+	assert.NoError(f.Close())
+
+	fsys.WithFile("plugins/a.so", []byte("plugin a"), 0o644)
+	f, err = kfs.OpenByDigest(vfs, mh)
+	assert.NoError(err)
+	b, err := io.ReadAll(f)
+	assert.NoError(err)
+	assert.Equal([]byte("plugin a"), b)
+	assert.NoError(f.Close())
+
+	sub, err := fs.Sub(vfs, "plugins")
+	assert.NoError(err)
+	f, err = kfs.OpenByDigest(sub, mh)
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	other := sha256.Sum256([]byte("plugin c"))
+	_, err = kfs.OpenByDigest(vfs, alg.Multihash(other[:]))
+	assert.ErrorIs(err, fs.ErrNotExist)
+	_, err = kfs.OpenByDigest(fsys, mh)
+	assert.ErrorIs(err, kfs.ErrNotImplemented)
+}
+
+func Test_WORMFS(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("audit/2024.log", []byte("old"), 0o444)
+
+	wfs := kfs.Chain(fsys, kfs.WithWORM())
+
+	f, err := kfs.OpenFile(wfs, "audit/2025.log", os.O_WRONLY|os.O_CREATE, 0o444)
+	assert.NoError(err)
+	_, err = f.Write([]byte("new"))
+	assert.NoError(err)
+	_, err = f.Write([]byte(" entry"))
+	assert.NoError(err)
+	assert.NoError(f.Close())
+	assert.NoError(kfstest.TestFileOpen(fsys, "audit/2025.log", []byte("new entry")))
+
+	assert.ErrorIs(kfs.WriteFile(wfs, "audit/2024.log", []byte("x"), 0o444), kfs.ErrWriteOnce)
+	_, err = kfs.OpenFile(wfs, "audit/2024.log", os.O_WRONLY|os.O_APPEND, 0)
+	assert.ErrorIs(err, kfs.ErrWriteOnce)
+	_, err = kfs.OpenFile(wfs, "audit/2024.log", os.O_RDWR, 0)
+	assert.ErrorIs(err, fs.ErrPermission)
+	assert.ErrorIs(kfs.Remove(wfs, "audit/2024.log"), kfs.ErrWriteOnce)
+	assert.ErrorIs(kfs.RemoveAll(wfs, "audit"), kfs.ErrWriteOnce)
+	assert.ErrorIs(kfs.Chtimes(wfs, "audit/2024.log", time.Time{}, time.Time{}), kfs.ErrWriteOnce)
+	assert.NoError(kfstest.TestFileOpen(fsys, "audit/2024.log", []byte("old")))
+
+	b, err := fs.ReadFile(wfs, "audit/2024.log")
+	assert.NoError(err)
+	assert.Equal([]byte("old"), b)
+
+	sub, err := fs.Sub(wfs, "audit")
+	assert.NoError(err)
+	assert.ErrorIs(kfs.Remove(sub, "2025.log"), kfs.ErrWriteOnce)
+	assert.NoError(kfs.WriteFile(sub, "2026.log", []byte("newer"), 0o444))
+}
+
+// forEachWriteFS runs f in parallel subtests against an empty os fs and an
+// empty [kfstest.MapFS]
+func forEachWriteFS(t *testing.T, f func(t *testing.T, fsys kfs.FS)) {
+	t.Helper()
+
+	for _, tc := range []struct {
+		name string
+		fsys func(t *testing.T) kfs.FS
+	}{
+		{
+			name: "os",
+			fsys: func(t *testing.T) kfs.FS {
+				return kfs.DirFS(t.TempDir())
+			},
+		},
+		{
+			name: "map",
+			fsys: func(t *testing.T) kfs.FS {
+				return kfstest.NewMapFS()
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f(t, tc.fsys(t))
+		})
+	}
+}
+
+func Test_WriteFileIfMatch(t *testing.T) {
+	t.Parallel()
+
+	forEachWriteFS(t, func(t *testing.T, fsys kfs.FS) {
+		assert := require.New(t)
+
+		assert.NoError(kfs.WriteFileIfMatch(fsys, "state/a.json", []byte(`{"v":1}`), 0o600, ""))
+		assert.NoError(kfstest.TestFileOpen(fsys, "state/a.json", []byte(`{"v":1}`)))
+		err := kfs.WriteFileIfMatch(fsys, "state/a.json", []byte(`{"v":2}`), 0o600, "")
+		assert.ErrorIs(err, kfs.ErrPreconditionFailed)
+		assert.ErrorIs(err, fs.ErrExist)
+
+		info, err := fs.Stat(fsys, "state/a.json")
+		assert.NoError(err)
+		token := kfs.FileToken(info)
+		assert.NoError(kfs.WriteFileIfMatch(fsys, "state/a.json", []byte(`{"v":22}`), 0o644, token))
+		assert.NoError(kfstest.TestFileOpen(fsys, "state/a.json", []byte(`{"v":22}`)))
+		info, err = fs.Stat(fsys, "state/a.json")
+		assert.NoError(err)
+		assert.Equal(fs.FileMode(0o600), info.Mode().Perm())
+
+		// the token is stale after the write
+		assert.ErrorIs(kfs.WriteFileIfMatch(fsys, "state/a.json", []byte(`{"v":3}`), 0o600, token), kfs.ErrPreconditionFailed)
+		assert.NoError(kfstest.TestFileOpen(fsys, "state/a.json", []byte(`{"v":22}`)))
+
+		entries, err := fs.ReadDir(fsys, "state")
+		assert.NoError(err)
+		assert.Len(entries, 1)
+
+		assert.ErrorIs(kfs.WriteFileIfMatch(fsys, "state/missing.json", nil, 0o600, token), fs.ErrNotExist)
+		assert.ErrorIs(kfs.WriteFileIfMatch(kfs.NewReadOnlyFS(fsys), "state/a.json", nil, 0o600, token), kfs.ErrReadOnly)
+	})
+}
+
+func Test_FindDuplicates(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS().
+		WithFile("a/1.txt", []byte("hello"), 0o644).
+		WithFile("b/1.txt", []byte("hello"), 0o644).
+		WithFile("c/1.txt", []byte("hello"), 0o644).
+		WithFile("a/2.txt", []byte("world"), 0o644).
+		WithFile("a/big.bin", []byte("0123456789ab"), 0o644).
+		WithFile("b/big.bin", []byte("0123456789ab"), 0o644).
+		WithFile("unique.txt", []byte("unique file"), 0o644).
+		WithFile("empty1", nil, 0o644).
+		WithFile("empty2", nil, 0o644).
+		WithSymlink("link", "a/1.txt")
+
+	report, err := kfs.FindDuplicates(context.Background(), fsys, ".", kfs.FindDuplicatesWorkers(4))
+	assert.NoError(err)
+	assert.Equal(9, report.Files)
+	assert.Equal(6, report.Hashed)
+	assert.Equal(int64(22), report.WastedBytes)
+	assert.Len(report.Groups, 2)
+	sum := sha256.Sum256([]byte("hello"))
+	assert.Equal(kfs.DuplicateGroup{
+		Size:   5,
+		Digest: sum[:],
+		Names:  []string{"a/1.txt", "b/1.txt", "c/1.txt"},
+	}, report.Groups[1])
+	assert.Equal([]string{"a/big.bin", "b/big.bin"}, report.Groups[0].Names)
+
+	report, err = kfs.FindDuplicates(context.Background(), fsys, "a")
+	assert.NoError(err)
+	assert.Empty(report.Groups)
+
+	report, err = kfs.FindDuplicates(context.Background(), fsys, ".", kfs.FindDuplicatesMinSize(0))
+	assert.NoError(err)
+	assert.Len(report.Groups, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = kfs.FindDuplicates(ctx, fsys, ".")
+	assert.ErrorIs(err, context.Canceled)
+}
+
+func Test_ShardFS(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := kfstest.NewMapFS()
+	sfs := kfs.Chain(fsys, kfs.WithShard())
+
+	assert.NoError(kfs.WriteFile(sfs, "abc", []byte("abc"), 0o644))
+	assert.NoError(kfs.WriteFile(sfs, "def", []byte("def"), 0o644))
+	assert.NoError(kfs.WriteFile(sfs, "ghi", []byte("ghi"), 0o644))
+	assert.NoError(kfstest.TestFileOpen(fsys, "ba/78/abc", []byte("abc")))
+	assert.NoError(kfstest.TestFileOpen(sfs, "abc", []byte("abc")))
+
+	entries, err := fs.ReadDir(sfs, ".")
+	assert.NoError(err)
+	var names []string
+	for _, i := range entries {
+		names = append(names, i.Name())
+	}
+	assert.Equal([]string{"abc", "def", "ghi"}, names)
+
+	matches, err := fs.Glob(sfs, "[ad]*")
+	assert.NoError(err)
+	assert.Equal([]string{"abc", "def"}, matches)
+
+	assert.NoError(fstest.TestFS(sfs, "abc", "def", "ghi"))
+
+	assert.NoError(kfs.Remove(sfs, "def"))
+	_, err = fs.Stat(sfs, "def")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	_, err = fs.Stat(sfs, "ba/78")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.ErrorIs(kfs.WriteFile(sfs, "dir/abc", []byte("abc"), 0o644), fs.ErrNotExist)
+
+	deep := kfs.NewShardFS(fsys, kfs.ShardFSLevels(3), kfs.ShardFSWidth(1))
+	assert.NoError(kfs.WriteFile(deep, "abc", []byte("abc"), 0o644))
+	assert.NoError(kfstest.TestFileOpen(fsys, "b/a/7/abc", []byte("abc")))
+
+	for _, i := range []kfs.FS{sfs, deep} {
+		assert.NoError(kfs.Symlink(i, "abc", "link"))
+		target, err := kfs.ReadLink(i, "link")
+		assert.NoError(err)
+		assert.Equal("abc", target)
+		resolved, err := kfs.EvalSymlinks(i, "link")
+		assert.NoError(err)
+		assert.Equal("abc", resolved)
+		assert.NoError(kfstest.TestFileOpen(i, "link", []byte("abc")))
+	}
+	target, err := kfs.ReadLink(fsys, "b1/b1/link")
+	assert.NoError(err)
+	assert.Equal("../../ba/78/abc", target)
+
+	assert.Panics(func() {
+		kfs.NewShardFS(fsys, kfs.ShardFSLevels(0))
+	})
+}
+
+func Test_Rename(t *testing.T) {
+	t.Parallel()
+
+	forEachWriteFS(t, func(t *testing.T, fsys kfs.FS) {
+		assert := require.New(t)
+
+		assert.NoError(kfs.WriteFile(fsys, "data/.a.txt.tmp", []byte("new"), 0o644))
+		assert.NoError(kfs.WriteFile(fsys, "data/a.txt", []byte("old"), 0o644))
+		assert.NoError(kfs.Rename(fsys, "data/.a.txt.tmp", "data/a.txt"))
+		assert.NoError(kfstest.TestFileOpen(fsys, "data/a.txt", []byte("new")))
+		_, err := fs.Stat(fsys, "data/.a.txt.tmp")
+		assert.ErrorIs(err, fs.ErrNotExist)
+
+		assert.NoError(kfs.WriteFile(fsys, "data/sub/b.txt", []byte("b"), 0o644))
+		assert.NoError(kfs.Rename(fsys, "data", "moved/data"))
+		assert.NoError(kfstest.TestFileOpen(fsys, "moved/data/a.txt", []byte("new")))
+		assert.NoError(kfstest.TestFileOpen(fsys, "moved/data/sub/b.txt", []byte("b")))
+		_, err = fs.Stat(fsys, "data")
+		assert.ErrorIs(err, fs.ErrNotExist)
+
+		assert.ErrorIs(kfs.Rename(fsys, "missing.txt", "other.txt"), fs.ErrNotExist)
+
+		sub, err := fs.Sub(fsys, "moved")
+		assert.NoError(err)
+		assert.NoError(kfs.Rename(sub, "data/sub/b.txt", "data/c.txt"))
+		assert.NoError(kfstest.TestFileOpen(fsys, "moved/data/c.txt", []byte("b")))
+
+		assert.ErrorIs(kfs.Rename(kfs.NewReadOnlyFS(fsys), "moved/data/a.txt", "a.txt"), kfs.ErrReadOnly)
+		assert.ErrorIs(kfs.Rename(kfs.NewWORMFS(fsys), "moved/data/a.txt", "a.txt"), kfs.ErrWriteOnce)
+		var linkErr *os.LinkError
+		assert.ErrorAs(kfs.Rename(kfs.NewReadOnlyFS(fsys), "moved/data/a.txt", "a.txt"), &linkErr)
+		assert.Equal("moved/data/a.txt", linkErr.Old)
+		assert.Equal("a.txt", linkErr.New)
+		assert.ErrorAs(kfs.Rename(kfs.NewWORMFS(fsys), "moved/data/a.txt", "../a.txt"), &linkErr)
+		assert.ErrorIs(linkErr, fs.ErrInvalid)
+		assert.ErrorIs(kfs.Rename(kfs.NewProtectFS(fsys, "moved/data/a.txt"), "moved/data", "data"), kfs.ErrProtected)
+		assert.ErrorIs(kfs.Rename(kfs.NewProtectFS(fsys, "moved/data/a.txt"), "moved/data/c.txt", "moved/data/a.txt"), kfs.ErrProtected)
+		masked := kfs.NewMaskFS(fsys, func(p string) (bool, error) {
+			return p != "secret.txt", nil
+		})
+		assert.ErrorIs(kfs.Rename(masked, "moved/data/a.txt", "secret.txt"), kfs.ErrFileMasked)
+		assert.NoError(kfstest.TestFileOpen(fsys, "moved/data/a.txt", []byte("new")))
+	})
+}
+
+func Test_Chmod(t *testing.T) {
+	t.Parallel()
+
+	forEachWriteFS(t, func(t *testing.T, fsys kfs.FS) {
+		assert := require.New(t)
+
+		assert.NoError(kfs.WriteFile(fsys, "bin/run.sh", []byte("#!/bin/sh\n"), 0o644))
+		assert.NoError(kfs.Chmod(fsys, "bin/run.sh", 0o755))
+		if runtime.GOOS != "windows" {
+			info, err := fs.Stat(fsys, "bin/run.sh")
+			assert.NoError(err)
+			assert.Equal(fs.FileMode(0o755), info.Mode())
+		}
+
+		sub, err := fs.Sub(fsys, "bin")
+		assert.NoError(err)
+		assert.NoError(kfs.Chmod(sub, ".", 0o700))
+		info, err := fs.Stat(fsys, "bin")
+		assert.NoError(err)
+		assert.True(info.IsDir())
+		if runtime.GOOS != "windows" {
+			assert.Equal(fs.FileMode(0o700), info.Mode().Perm())
+		}
+
+		assert.ErrorIs(kfs.Chmod(fsys, "missing.sh", 0o755), fs.ErrNotExist)
+		assert.ErrorIs(kfs.Chmod(kfs.NewReadOnlyFS(fsys), "bin/run.sh", 0o600), kfs.ErrReadOnly)
+		assert.ErrorIs(kfs.Chmod(kfs.NewWORMFS(fsys), "bin/run.sh", 0o600), kfs.ErrWriteOnce)
+	})
+}
+
+func Test_Truncate(t *testing.T) {
+	t.Parallel()
+
+	forEachWriteFS(t, func(t *testing.T, fsys kfs.FS) {
+		assert := require.New(t)
+
+		assert.NoError(kfs.WriteFile(fsys, "log/app.log", []byte("hello world"), 0o644))
+		assert.NoError(kfs.Truncate(fsys, "log/app.log", 5))
+		data, err := fs.ReadFile(fsys, "log/app.log")
+		assert.NoError(err)
+		assert.Equal([]byte("hello"), data)
+
+		assert.NoError(kfs.Truncate(fsys, "log/app.log", 8))
+		data, err = fs.ReadFile(fsys, "log/app.log")
+		assert.NoError(err)
+		assert.Equal([]byte("hello\x00\x00\x00"), data)
+
+		f, err := kfs.OpenFile(fsys, "log/app.log", os.O_WRONLY, 0)
+		assert.NoError(err)
+		tf, ok := f.(kfs.TruncatableFile)
+		assert.True(ok)
+		assert.NoError(tf.Truncate(2))
+		assert.NoError(f.Close())
+		data, err = fs.ReadFile(fsys, "log/app.log")
+		assert.NoError(err)
+		assert.Equal([]byte("he"), data)
+
+		assert.NoError(kfs.Truncate(plainWriteFS{fsys}, "log/app.log", 0))
+		data, err = fs.ReadFile(fsys, "log/app.log")
+		assert.NoError(err)
+		assert.Len(data, 0)
+		assert.ErrorIs(kfs.Truncate(plainWriteFS{fsys}, "log/app.log", 1), kfs.ErrNotImplemented)
+
+		assert.ErrorIs(kfs.Truncate(fsys, "missing.log", 0), fs.ErrNotExist)
+		assert.Error(kfs.Truncate(fsys, "log", 0))
+		assert.ErrorIs(kfs.Truncate(kfs.NewReadOnlyFS(fsys), "log/app.log", 0), kfs.ErrReadOnly)
+		assert.ErrorIs(kfs.Truncate(kfs.NewWORMFS(fsys), "log/app.log", 0), kfs.ErrWriteOnce)
+	})
+}
+
+func Test_Lchtimes(t *testing.T) {
+	t.Parallel()
+
+	forEachWriteFS(t, func(t *testing.T, fsys kfs.FS) {
+		assert := require.New(t)
+
+		assert.NoError(kfs.WriteFile(fsys, "data/target.txt", []byte("hello"), 0o644))
+		assert.NoError(kfs.Symlink(fsys, "target.txt", "data/link.txt"))
+		targetInfo, err := fsys.Stat("data/target.txt")
+		assert.NoError(err)
+
+		atime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+		mtime := time.Date(2021, time.February, 3, 4, 5, 6, 0, time.UTC)
+		err = kfs.Lchtimes(fsys, "data/link.txt", atime, mtime)
+		if _, ok := fsys.(*kfstest.MapFS); !ok && runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+			assert.ErrorIs(err, kfs.ErrNotImplemented)
+			return
+		}
+		assert.NoError(err)
+		info, err := fsys.Lstat("data/link.txt")
+		assert.NoError(err)
+		assert.True(mtime.Equal(info.ModTime()))
+		info, err = fsys.Stat("data/target.txt")
+		assert.NoError(err)
+		assert.True(targetInfo.ModTime().Equal(info.ModTime()))
+
+		// zero times are left unchanged
+		assert.NoError(kfs.Lchtimes(fsys, "data/link.txt", time.Time{}, time.Time{}))
+		info, err = fsys.Lstat("data/link.txt")
+		assert.NoError(err)
+		assert.True(mtime.Equal(info.ModTime()))
+
+		// Chtimes follows the link
+		assert.NoError(kfs.Chtimes(fsys, "data/link.txt", atime, mtime.Add(time.Hour)))
+		info, err = fsys.Stat("data/target.txt")
+		assert.NoError(err)
+		assert.True(mtime.Add(time.Hour).Equal(info.ModTime()))
+		info, err = fsys.Lstat("data/link.txt")
+		assert.NoError(err)
+		assert.True(mtime.Equal(info.ModTime()))
+
+		if m, ok := fsys.(*kfstest.MapFS); ok {
+			got, err := m.ATime("data/target.txt")
+			assert.NoError(err)
+			assert.True(atime.Equal(got))
+			assert.NoError(kfs.Chtimes(fsys, "data/target.txt", time.Time{}, mtime))
+			got, err = m.ATime("data/target.txt")
+			assert.NoError(err)
+			assert.True(atime.Equal(got))
+			got, err = m.ATime("data/link.txt")
+			assert.NoError(err)
+			assert.True(atime.Equal(got))
+		}
+
+		assert.ErrorIs(kfs.Lchtimes(fsys, "missing", atime, mtime), fs.ErrNotExist)
+		assert.ErrorIs(kfs.Lchtimes(kfs.NewReadOnlyFS(fsys), "data/link.txt", atime, mtime), kfs.ErrReadOnly)
+		assert.ErrorIs(kfs.Lchtimes(plainWriteFS{fsys}, "data/link.txt", atime, mtime), kfs.ErrNotImplemented)
+	})
+}
+
+func Test_TempFS(t *testing.T) {
+	t.Parallel()
+
+	forEachWriteFS(t, func(t *testing.T, fsys kfs.FS) {
+		assert := require.New(t)
+
+		dir, err := kfs.MkdirTemp(fsys, "tmp", "build-*.d")
+		assert.NoError(err)
+		assert.True(strings.HasPrefix(dir, "tmp/build-"))
+		assert.True(strings.HasSuffix(dir, ".d"))
+		info, err := fs.Stat(fsys, dir)
+		assert.NoError(err)
+		assert.True(info.IsDir())
+		other, err := kfs.MkdirTemp(fsys, "tmp", "build-*.d")
+		assert.NoError(err)
+		assert.NotEqual(dir, other)
+
+		f, name, err := kfs.CreateTemp(fsys, dir, "out")
+		assert.NoError(err)
+		assert.Equal(dir, path.Dir(name))
+		assert.True(strings.HasPrefix(path.Base(name), "out"))
+		_, err = f.Write([]byte("output"))
+		assert.NoError(err)
+		assert.NoError(f.Close())
+		data, err := fs.ReadFile(fsys, name)
+		assert.NoError(err)
+		assert.Equal([]byte("output"), data)
+
+		f, name, err = kfs.CreateTemp(plainWriteFS{fsys}, ".", "*.txt")
+		assert.NoError(err)
+		assert.True(strings.HasSuffix(name, ".txt"))
+		assert.NoError(f.Close())
+		_, err = fs.Stat(fsys, name)
+		assert.NoError(err)
+		_, err = kfs.MkdirTemp(plainWriteFS{fsys}, ".", "dir")
+		assert.ErrorIs(err, kfs.ErrNotImplemented)
+
+		_, err = kfs.MkdirTemp(fsys, ".", "a/*")
+		assert.ErrorIs(err, fs.ErrInvalid)
+		_, _, err = kfs.CreateTemp(fsys, "../outside", "*")
+		assert.ErrorIs(err, fs.ErrInvalid)
+		_, _, err = kfs.CreateTemp(fsys, name, "*")
+		assert.Error(err)
+		_, err = kfs.MkdirTemp(kfs.NewReadOnlyFS(fsys), "tmp", "*")
+		assert.ErrorIs(err, kfs.ErrReadOnly)
+
+		inspected := kfs.NewInspectFS(fsys, kfs.InspectorFunc(func(name string, r io.Reader) error {
+			return errors.New("rejected")
+		}))
+		f, name, err = kfs.CreateTemp(inspected, "tmp", "*")
+		assert.NoError(err)
+		assert.ErrorIs(f.Close(), kfs.ErrRejected)
+		_, err = fs.Stat(fsys, name)
+		assert.ErrorIs(err, fs.ErrNotExist)
+	})
+}
+
+func Test_Sync(t *testing.T) {
+	t.Parallel()
+
+	forEachWriteFS(t, func(t *testing.T, fsys kfs.FS) {
+		assert := require.New(t)
+
+		f, err := kfs.OpenFile(fsys, "data/state.json", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		assert.NoError(err)
+		_, err = f.Write([]byte("{}"))
+		assert.NoError(err)
+		assert.NoError(kfs.Sync(f))
+		data, err := fs.ReadFile(fsys, "data/state.json")
+		assert.NoError(err)
+		assert.Equal([]byte("{}"), data)
+		assert.NoError(f.Close())
+
+		assert.NoError(kfs.SyncDir(fsys, "data"))
+		assert.NoError(kfs.SyncDir(fsys, "."))
+		assert.ErrorIs(kfs.SyncDir(fsys, "data/state.json"), kfs.ErrNotDir)
+		assert.ErrorIs(kfs.SyncDir(fsys, "missing"), fs.ErrNotExist)
+		assert.ErrorIs(kfs.SyncDir(plainWriteFS{fsys}, "data"), kfs.ErrNotImplemented)
+		assert.NoError(kfs.SyncDir(kfs.NewReadOnlyFS(fsys), "data"))
+
+		r, err := fsys.Open("data/state.json")
+		assert.NoError(err)
+		assert.ErrorIs(kfs.Sync(struct{ fs.File }{r}), kfs.ErrNotImplemented)
+		assert.NoError(r.Close())
+	})
+}
+
+func Test_Statfs(t *testing.T) {
+	t.Parallel()
+
+	t.Run("os", func(t *testing.T) {
+		t.Parallel()
+
+		assert := require.New(t)
+
+		fsys := kfs.DirFS(t.TempDir())
+		assert.NoError(kfs.WriteFile(fsys, "cache/entry", []byte("hello"), 0o644))
+
+		stats, err := kfs.Statfs(fsys, "cache/entry")
+		assert.NoError(err)
+		assert.NotZero(stats.TotalBytes)
+		assert.LessOrEqual(stats.FreeBytes, stats.TotalBytes)
+		assert.LessOrEqual(stats.AvailBytes, stats.FreeBytes)
+
+		_, err = kfs.Statfs(fsys, "missing")
+		assert.ErrorIs(err, fs.ErrNotExist)
+		_, err = kfs.Statfs(fsys, "../outside")
+		assert.ErrorIs(err, fs.ErrInvalid)
+	})
+
+	t.Run("map", func(t *testing.T) {
+		t.Parallel()
+
+		assert := require.New(t)
+
+		want := kfs.FSStats{
+			TotalBytes:  1 << 30,
+			FreeBytes:   1 << 20,
+			AvailBytes:  1 << 19,
+			TotalInodes: 1024,
+			FreeInodes:  512,
+		}
+		fsys := kfstest.NewMapFS().
+			WithFile("cache/entry", []byte("hello"), 0o644)
+		_, err := kfs.Statfs(fsys, ".")
+		assert.ErrorIs(err, kfs.ErrNotImplemented)
+
+		fsys.WithStatfs(want)
+		stats, err := kfs.Statfs(fsys, "cache/entry")
+		assert.NoError(err)
+		assert.Equal(want, *stats)
+
+		stats, err = kfs.Statfs(kfs.NewReadOnlyFS(fsys), ".")
+		assert.NoError(err)
+		assert.Equal(want, *stats)
+
+		_, err = kfs.Statfs(fsys, "missing")
+		assert.ErrorIs(err, fs.ErrNotExist)
+		_, err = kfs.Statfs(plainWriteFS{fsys}, ".")
+		assert.ErrorIs(err, kfs.ErrNotImplemented)
+	})
+}
+
+func Test_EvalSymlinks(t *testing.T) {
+	t.Parallel()
+
+	forEachWriteFS(t, func(t *testing.T, fsys kfs.FS) {
+		assert := require.New(t)
+
+		assert.NoError(kfs.WriteFile(fsys, "data/v1/conf.txt", []byte("conf"), 0o644))
+		assert.NoError(kfs.Symlink(fsys, "v1", "data/current"))
+		assert.NoError(kfs.Symlink(fsys, "data/current/conf.txt", "conf.txt"))
+		assert.NoError(kfs.Symlink(fsys, "b", "loop/a"))
+		assert.NoError(kfs.Symlink(fsys, "a", "loop/b"))
+
+		for _, i := range []struct {
+			name string
+			res  string
+		}{
+			{name: ".", res: "."},
+			{name: "data/v1/conf.txt", res: "data/v1/conf.txt"},
+			{name: "data/current", res: "data/v1"},
+			{name: "data/current/conf.txt", res: "data/v1/conf.txt"},
+			{name: "conf.txt", res: "data/v1/conf.txt"},
+		} {
+			res, err := kfs.EvalSymlinks(fsys, i.name)
+			assert.NoError(err)
+			assert.Equal(i.res, res)
+		}
+
+		_, err := kfs.EvalSymlinks(fsys, "loop/a")
+		assert.ErrorIs(err, kfs.ErrLinkLoop)
+		_, err = kfs.EvalSymlinks(fsys, "loop/b/child")
+		assert.ErrorIs(err, kfs.ErrLinkLoop)
+		_, err = kfs.EvalSymlinks(fsys, "missing")
+		assert.ErrorIs(err, fs.ErrNotExist)
+		_, err = kfs.EvalSymlinks(fsys, "conf.txt/child")
+		assert.ErrorIs(err, kfs.ErrNotDir)
+
+		_, err = fs.Stat(fsys, "loop/a")
+		assert.ErrorIs(err, kfs.ErrLinkLoop)
+		_, err = fsys.Open("loop/b")
+		assert.ErrorIs(err, kfs.ErrLinkLoop)
+	})
+}
+
+func Test_Symlink(t *testing.T) {
+	t.Parallel()
+
+	forEachWriteFS(t, func(t *testing.T, fsys kfs.FS) {
+		assert := require.New(t)
+
+		assert.NoError(kfs.WriteFile(fsys, "data/v1/conf.txt", []byte("conf"), 0o644))
+		assert.NoError(kfs.Symlink(fsys, "v1", "data/current"))
+		assert.NoError(kfs.Symlink(fsys, "../data/current/conf.txt", "etc/app.conf"))
+
+		target, err := kfs.ReadLink(fsys, "etc/app.conf")
+		assert.NoError(err)
+		assert.Equal("../data/current/conf.txt", target)
+		data, err := fs.ReadFile(fsys, "etc/app.conf")
+		assert.NoError(err)
+		assert.Equal([]byte("conf"), data)
+		info, err := kfs.Lstat(fsys, "data/current")
+		assert.NoError(err)
+		assert.Equal(fs.ModeSymlink, info.Mode().Type())
+
+		err = kfs.Symlink(fsys, "v1", "data/current")
+		assert.ErrorIs(err, fs.ErrExist)
+		var linkErr *os.LinkError
+		assert.ErrorAs(err, &linkErr)
+		assert.Equal("symlink", linkErr.Op)
+
+		assert.ErrorIs(kfs.Symlink(fsys, "/etc/passwd", "passwd"), kfs.ErrTargetOutsideFS)
+		assert.ErrorIs(kfs.Symlink(fsys, "../../outside", "data/escape"), kfs.ErrTargetOutsideFS)
+		_, err = kfs.Lstat(fsys, "data/escape")
+		assert.ErrorIs(err, fs.ErrNotExist)
+
+		err = kfs.Symlink(kfs.NewReadOnlyFS(fsys), "v1", "data/next")
+		assert.ErrorIs(err, kfs.ErrReadOnly)
+		assert.ErrorAs(err, &linkErr)
+		assert.Equal("v1", linkErr.Old)
+		assert.Equal("data/next", linkErr.New)
+		masked := kfs.NewMaskFS(fsys, func(p string) (bool, error) {
+			return p != "data/v1/conf.txt", nil
+		})
+		assert.ErrorIs(kfs.Symlink(masked, "v1/conf.txt", "data/conf.txt"), kfs.ErrFileMasked)
+		assert.NoError(kfs.Symlink(masked, "v1", "data/next"))
+	})
+}
+
+func Test_OSFSLinkFallback(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	assert.Equal("junction", kfs.LinkFallbackJunction.String())
+	assert.Equal("LinkFallback(7)", kfs.LinkFallback(7).String())
+
+	fsys := kfs.DirFS(t.TempDir(), kfs.OSFSLinkFallback(kfs.LinkFallbackCopy))
+	assert.NoError(kfs.WriteFile(fsys, "data/v1/conf.txt", []byte("conf"), 0o644))
+	sub, err := fs.Sub(fsys, "data")
+	assert.NoError(err)
+	assert.NoError(kfs.Symlink(sub, "v1", "current"))
+
+	data, err := fs.ReadFile(fsys, "data/current/conf.txt")
+	assert.NoError(err)
+	assert.Equal([]byte("conf"), data)
+	if runtime.GOOS != "windows" {
+		// the fallback is only used when the os refuses to create a symlink
+		target, err := kfs.ReadLink(fsys, "data/current")
+		assert.NoError(err)
+		assert.Equal("v1", target)
+	}
+}
+
+func Test_Chown(t *testing.T) {
+	t.Parallel()
+
+	t.Run("map", func(t *testing.T) {
+		t.Parallel()
+
+		assert := require.New(t)
+
+		fsys := kfstest.NewMapFS().
+			WithFile("srv/app.conf", []byte("conf"), 0o644).
+			WithSymlink("srv/current.conf", "app.conf")
+
+		assert.NoError(kfs.Chown(fsys, "srv/current.conf", 1000, 100))
+		info, err := fs.Stat(fsys, "srv/app.conf")
+		assert.NoError(err)
+		assert.Equal(&kfstest.Owner{UID: 1000, GID: 100}, info.Sys())
+		info, err = kfs.Lstat(fsys, "srv/current.conf")
+		assert.NoError(err)
+		assert.Nil(info.Sys())
+
+		assert.NoError(kfs.Lchown(fsys, "srv/current.conf", 0, 0))
+		info, err = kfs.Lstat(fsys, "srv/current.conf")
+		assert.NoError(err)
+		assert.Equal(&kfstest.Owner{UID: 0, GID: 0}, info.Sys())
+
+		sub, err := fs.Sub(fsys, "srv")
+		assert.NoError(err)
+		assert.NoError(kfs.Chown(sub, "app.conf", -1, 200))
+		assert.NoError(kfs.Chown(sub, ".", 1000, 1000))
+		info, err = fs.Stat(fsys, "srv/app.conf")
+		assert.NoError(err)
+		assert.Equal(&kfstest.Owner{UID: 1000, GID: 200}, info.Sys())
+		info, err = fs.Stat(fsys, "srv")
+		assert.NoError(err)
+		assert.Equal(&kfstest.Owner{UID: 1000, GID: 1000}, info.Sys())
+
+		// writes keep the owner and access time of a file
+		atime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		assert.NoError(kfs.Chtimes(fsys, "srv/app.conf", atime, atime))
+		assert.NoError(kfs.WriteFile(fsys, "srv/app.conf", []byte("new conf"), 0o644))
+		info, err = fs.Stat(fsys, "srv/app.conf")
+		assert.NoError(err)
+		assert.Equal(&kfstest.Owner{UID: 1000, GID: 200}, info.Sys())
+		assert.NoError(kfs.WriteFileIfMatch(fsys, "srv/app.conf", []byte("newer conf"), 0o644, kfs.FileToken(info)))
+		info, err = fs.Stat(fsys, "srv/app.conf")
+		assert.NoError(err)
+		assert.Equal(&kfstest.Owner{UID: 1000, GID: 200}, info.Sys())
+		got, err := fsys.ATime("srv/app.conf")
+		assert.NoError(err)
+		assert.True(atime.Equal(got))
+
+		assert.ErrorIs(kfs.Chown(fsys, "missing.conf", 0, 0), fs.ErrNotExist)
+		assert.ErrorIs(kfs.Chown(kfs.NewReadOnlyFS(fsys), "srv/app.conf", 0, 0), kfs.ErrReadOnly)
+		assert.ErrorIs(kfs.Lchown(kfs.NewWORMFS(fsys), "srv/app.conf", 0, 0), kfs.ErrWriteOnce)
+	})
+
+	t.Run("os", func(t *testing.T) {
+		t.Parallel()
+
+		if runtime.GOOS == "windows" {
+			t.Skip("changing owners is not supported on windows")
+		}
+
+		assert := require.New(t)
+
+		fsys := kfs.DirFS(t.TempDir())
+		assert.NoError(kfs.WriteFile(fsys, "app.conf", []byte("conf"), 0o644))
+		// changing the owner to the current user does not require privileges
+		assert.NoError(kfs.Chown(fsys, "app.conf", os.Getuid(), os.Getgid()))
+		assert.NoError(kfs.Lchown(fsys, "app.conf", -1, -1))
+		assert.ErrorIs(kfs.Chown(fsys, "missing.conf", -1, -1), fs.ErrNotExist)
+	})
+}
+
+func Test_SymlinkPolicyFS(t *testing.T) {
+	t.Parallel()
+
+	newFS := func() *kfstest.MapFS {
+		return kfstest.NewMapFS().
+			WithFile("data/a.txt", []byte("a"), 0o644).
+			WithSymlink("data/link.txt", "a.txt").
+			WithSymlink("linkdir", "data")
+	}
+
+	t.Run("follow", func(t *testing.T) {
+		t.Parallel()
+
+		assert := require.New(t)
+
+		fsys := kfs.Chain(newFS(), kfs.WithSymlinkPolicy(kfs.SymlinkFollow))
+		assert.NoError(kfstest.TestFileOpen(fsys, "data/link.txt", []byte("a")))
+		assert.NoError(kfstest.TestFileOpen(fsys, "linkdir/a.txt", []byte("a")))
+
+		entries, err := fs.ReadDir(fsys, ".")
+		assert.NoError(err)
+		assert.Len(entries, 2)
+		assert.Equal("linkdir", entries[1].Name())
+		assert.True(entries[1].IsDir())
+		link, ok := entries[1].(kfs.SymlinkDirEntry)
+		assert.True(ok)
+		assert.True(link.IsSymlink())
+		_, ok = entries[0].(kfs.SymlinkDirEntry)
+		assert.False(ok)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		t.Parallel()
+
+		assert := require.New(t)
+
+		fsys := kfs.NewSymlinkPolicyFS(newFS(), kfs.SymlinkReject)
+		assert.NoError(kfstest.TestFileOpen(fsys, "data/a.txt", []byte("a")))
+		_, err := fsys.Open("data/link.txt")
+		assert.ErrorIs(err, kfs.ErrSymlink)
+		assert.ErrorIs(err, fs.ErrPermission)
+		_, err = fs.ReadFile(fsys, "linkdir/a.txt")
+		assert.ErrorIs(err, kfs.ErrSymlink)
+		_, err = fs.Sub(fsys, "linkdir")
+		assert.ErrorIs(err, kfs.ErrSymlink)
+		assert.ErrorIs(kfs.WriteFile(fsys, "linkdir/b.txt", []byte("b"), 0o644), kfs.ErrSymlink)
+
+		info, err := kfs.Lstat(fsys, "data/link.txt")
+		assert.NoError(err)
+		assert.Equal(fs.ModeSymlink, info.Mode().Type())
+
+		entries, err := fs.ReadDir(fsys, "data")
+		assert.NoError(err)
+		assert.Len(entries, 1)
+		assert.Equal("a.txt", entries[0].Name())
+
+		matches, err := fs.Glob(fsys, "*/*.txt")
+		assert.NoError(err)
+		assert.Equal([]string{"data/a.txt"}, matches)
+
+		assert.NoError(kfs.Remove(fsys, "data/link.txt"))
+	})
+
+	t.Run("preserve", func(t *testing.T) {
+		t.Parallel()
+
+		assert := require.New(t)
+
+		fsys := kfs.NewSymlinkPolicyFS(newFS(), kfs.SymlinkPreserve)
+		assert.NoError(kfstest.TestFileOpen(fsys, "data/a.txt", []byte("a")))
+		b, err := fs.ReadFile(fsys, "data/link.txt")
+		assert.NoError(err)
+		assert.Equal([]byte("a.txt"), b)
+		f, err := fsys.Open("data/link.txt")
+		assert.NoError(err)
+		b, err = io.ReadAll(f)
+		assert.NoError(err)
+		assert.Equal([]byte("a.txt"), b)
+		assert.NoError(f.Close())
+
+		info, err := fs.Stat(fsys, "linkdir")
+		assert.NoError(err)
+		assert.Equal(fs.ModeSymlink, info.Mode().Type())
+		_, err = fs.ReadFile(fsys, "linkdir/a.txt")
+		assert.ErrorIs(err, kfs.ErrNotDir)
+		_, err = fs.ReadDir(fsys, "linkdir")
+		assert.ErrorIs(err, kfs.ErrNotDir)
+		_, err = kfs.OpenFile(fsys, "data/link.txt", os.O_WRONLY|os.O_TRUNC, 0)
+		assert.ErrorIs(err, kfs.ErrSymlink)
+		assert.ErrorIs(kfs.Chmod(fsys, "data/link.txt", 0o600), kfs.ErrSymlink)
+
+		entries, err := fs.ReadDir(fsys, "data")
+		assert.NoError(err)
+		assert.Len(entries, 2)
+		assert.Equal(fs.ModeSymlink, entries[1].Type())
+		link, ok := entries[1].(kfs.SymlinkDirEntry)
+		assert.True(ok)
+		assert.True(link.IsSymlink())
+	})
+}
+
+type (
+	noFullFilePathFS struct {
+		*kfstest.MapFS
+	}
+)
+
+func (f noFullFilePathFS) FullFilePath(name string) (string, error) {
+	return "", kfs.ErrNotImplemented
+}
+
+type (
+	// plainWriteFS hides all optional interfaces of an fs but [kfs.WriteFS]
+	plainWriteFS struct {
+		kfs.WriteFS
+	}
+)
+
+func Test_WriteExecutable(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	tempDir := t.TempDir()
+	fsys := kfs.DirFS(tempDir)
+	assert.NoError(kfs.WriteFile(fsys, "run.sh", []byte("old"), 0o644))
+
+	p, err := kfs.WriteExecutable(fsys, "run.sh", []byte("#!/bin/sh\n"))
+	assert.NoError(err)
+	assert.Equal(filepath.Join(tempDir, "run.sh"), p)
+	assert.NoError(kfstest.TestFileOpen(fsys, "run.sh", []byte("#!/bin/sh\n")))
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(p)
+		assert.NoError(err)
+		assert.NotZero(info.Mode().Perm() & 0o100)
+	}
+
+	mfs := noFullFilePathFS{MapFS: kfstest.NewMapFS()}
+	_, err = kfs.WriteExecutable(mfs, "bin/run.sh", []byte("#!/bin/sh\n"))
+	assert.ErrorIs(err, kfs.ErrNoOSPath)
+	assert.ErrorIs(err, kfs.ErrNotImplemented)
+	info, err := fs.Stat(mfs, "bin/run.sh")
+	assert.NoError(err)
+	assert.Equal(fs.FileMode(0o755), info.Mode().Perm())
+}
+
+func Test_Mkfifo(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	mfs := kfstest.NewMapFS()
+	assert.NoError(kfs.Mkfifo(mfs, "run/ctl", 0o600))
+	info, err := kfs.Lstat(mfs, "run/ctl")
+	assert.NoError(err)
+	assert.Equal(fs.ModeNamedPipe|0o600, info.Mode())
+	assert.ErrorIs(kfs.Mkfifo(mfs, "run/ctl", 0o600), fs.ErrExist)
+	assert.ErrorIs(kfs.Mkfifo(kfs.NewReadOnlyFS(mfs), "run/ctl2", 0o600), kfs.ErrReadOnly)
+
+	{
+		// opening the writer blocks until the reader is opened
+		written := make(chan error, 1)
+		go func() {
+			written <- func() error {
+				f, err := kfs.OpenFile(mfs, "run/ctl", os.O_WRONLY, 0)
+				if err != nil {
+					return err
+				}
+				if _, err := f.Write([]byte("hello, world")); err != nil {
+					return err
+				}
+				return f.Close()
+			}()
+		}()
+		f, err := mfs.Open("run/ctl")
+		assert.NoError(err)
+		b, err := io.ReadAll(f)
+		assert.NoError(err)
+		assert.Equal([]byte("hello, world"), b)
+		assert.NoError(<-written)
+		assert.NoError(f.Close())
+		assert.ErrorIs(f.Close(), fs.ErrClosed)
+	}
+	{
+		// a reader blocks until data is written
+		r := make(chan []byte, 1)
+		go func() {
+			f, err := mfs.Open("run/ctl")
+			if err != nil {
+				r <- nil
+				return
+			}
+			b := make([]byte, 5)
+			n, _ := f.Read(b)
+			_ = f.Close()
+			r <- b[:n]
+		}()
+		f, err := kfs.OpenFile(mfs, "run/ctl", os.O_WRONLY, 0)
+		assert.NoError(err)
+		select {
+		case <-r:
+			assert.Fail("Read did not block")
+		case <-time.After(10 * time.Millisecond):
+		}
+		_, err = f.Write([]byte("hello"))
+		assert.NoError(err)
+		assert.Equal([]byte("hello"), <-r)
+		// the reader has closed the pipe
+		_, err = f.Write([]byte("world"))
+		assert.ErrorIs(err, io.ErrClosedPipe)
+		assert.NoError(f.Close())
+	}
+	_, err = kfs.OpenFile(mfs, "run/ctl", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	assert.ErrorIs(err, fs.ErrExist)
+
+	fsys := kfs.DirFS(t.TempDir())
+	if err := kfs.Mkfifo(fsys, "run/ctl", 0o600); errors.Is(err, kfs.ErrNotImplemented) {
+		// named pipes are not supported on this platform
+		return
+	} else {
+		assert.NoError(err)
+	}
+	info, err = kfs.Lstat(fsys, "run/ctl")
+	assert.NoError(err)
+	assert.Equal(fs.ModeNamedPipe, info.Mode().Type())
+	assert.ErrorIs(kfs.Mkfifo(fsys, "run/ctl", 0o600), fs.ErrExist)
+}
+
+func Benchmark_WrapperStack(b *testing.B) {
+	fsys := kfstest.NewMapFS()
+	for i := range 16 {
+		fsys = fsys.WithFile(fmt.Sprintf("a/b/c/d/file%d.txt", i), []byte("data"), 0o644)
+	}
+	var stack fs.FS = kfs.Chain(fsys,
+		kfs.WithMask(func(p string) (bool, error) { return true, nil }),
+		kfs.WithProtect("a/b/c/d/*.lock"),
+		kfs.WithInspect(kfs.InspectorFunc(func(name string, r io.Reader) error { return nil })),
+		kfs.WithMask(func(p string) (bool, error) { return true, nil }),
+	)
+	for _, i := range []string{"a", "b", "c"} {
+		var err error
+		stack, err = fs.Sub(stack, i)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run("Stat", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := fs.Stat(stack, "d/file0.txt"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ReadDir", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := fs.ReadDir(stack, "d"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Remove", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if err := kfs.Remove(stack, "d/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func Benchmark_OSFS(b *testing.B) {
+	fsys := kfs.DirFS(b.TempDir())
+	if err := kfs.WriteFile(fsys, "a/b/c.txt", []byte("c"), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Stat", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := fs.Stat(fsys, "a/b/c.txt"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Lstat", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := kfs.Lstat(fsys, "a/b/c.txt"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("LstatNotExist", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := kfs.Lstat(fsys, "a/b/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Open", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			f, err := fsys.Open("a/b/c.txt")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Chtimes", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if err := kfs.Chtimes(fsys, "a/b/c.txt", time.Time{}, time.Time{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,328 @@
+// Package kfsbackup takes scheduled snapshots of an [fs.FS] as kfs images, and
+// prunes them with grandfather-father-son retention rules
+//
+// Each snapshot is a full [kfsimage] image in the root of the destination,
+// named by the UTC time it was taken, such as
+// "20261016T120000.000000000Z.kfsimg". As snapshots are not incremental, any
+// of them may be restored with [kfsimage.Restore] or pruned independently of
+// the others.
+package kfsbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+
+	"xorkevin.dev/kerrors"
+	"xorkevin.dev/kfs"
+	"xorkevin.dev/kfs/kfsimage"
+)
+
+const (
+	snapshotLayout = "20060102T150405.000000000Z"
+	snapshotExt    = ".kfsimg"
+)
+
+type (
+	// Snapshot is a snapshot in a destination fs
+	Snapshot struct {
+		Name string
+		Time time.Time
+	}
+)
+
+// SnapshotName returns the name of a snapshot taken at t
+func SnapshotName(t time.Time) string {
+	return t.UTC().Format(snapshotLayout) + snapshotExt
+}
+
+// List returns the snapshots in the root of fsys from oldest to newest
+//
+// Files that are not named as snapshots are ignored.
+func List(fsys fs.FS) ([]Snapshot, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, kerrors.WithMsg(err, "Failed to read snapshot dir")
+	}
+	var snapshots []Snapshot
+	for _, i := range entries {
+		if !i.Type().IsRegular() {
+			continue
+		}
+		base, ok := strings.CutSuffix(i.Name(), snapshotExt)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(snapshotLayout, base)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name: i.Name(),
+			Time: t,
+		})
+	}
+	slices.SortFunc(snapshots, func(a, b Snapshot) int {
+		return a.Time.Compare(b.Time)
+	})
+	return snapshots, nil
+}
+
+// Take writes a snapshot of src taken at t to the root of dst
+//
+// The image is written to a temp file that is synced and renamed into place,
+// so that a partial snapshot is never listed. Syncing is skipped if dst does
+// not support it.
+func Take(ctx context.Context, src fs.FS, dst fs.FS, t time.Time, opts ...kfsimage.WriterOpt) (_ Snapshot, retErr error) {
+	snapshot := Snapshot{
+		Name: SnapshotName(t),
+		Time: t.UTC(),
+	}
+	if err := ctx.Err(); err != nil {
+		return Snapshot{}, kerrors.WithMsg(err, "Snapshot canceled")
+	}
+	if _, err := fs.Stat(dst, snapshot.Name); err == nil {
+		return Snapshot{}, &fs.PathError{
+			Op:   "snapshot",
+			Path: snapshot.Name,
+			Err:  kerrors.WithMsg(fs.ErrExist, "Snapshot already exists"),
+		}
+	}
+	f, tmp, err := kfs.CreateTemp(dst, ".", ".snapshot-*")
+	if err != nil {
+		return Snapshot{}, kerrors.WithMsg(err, "Failed to create snapshot file")
+	}
+	committed := false
+	closed := false
+	defer func() {
+		if !closed {
+			if err := f.Close(); err != nil {
+				retErr = errors.Join(retErr, kerrors.WithMsg(err, "Failed to close snapshot file"))
+			}
+		}
+		if !committed {
+			if err := kfs.Remove(dst, tmp); err != nil {
+				retErr = errors.Join(retErr, kerrors.WithMsg(err, "Failed to remove partial snapshot"))
+			}
+		}
+	}()
+	w := kfsimage.NewWriter(f, opts...)
+	if err := w.AddFS(src); err != nil {
+		return Snapshot{}, kerrors.WithMsg(err, "Failed to write snapshot")
+	}
+	if err := w.Close(); err != nil {
+		return Snapshot{}, kerrors.WithMsg(err, "Failed to write snapshot")
+	}
+	if err := kfs.Sync(f); err != nil && !errors.Is(err, kfs.ErrNotImplemented) {
+		return Snapshot{}, kerrors.WithMsg(err, "Failed to sync snapshot file")
+	}
+	closed = true
+	if err := f.Close(); err != nil {
+		return Snapshot{}, kerrors.WithMsg(err, "Failed to close snapshot file")
+	}
+	if err := kfs.Rename(dst, tmp, snapshot.Name); err != nil {
+		return Snapshot{}, kerrors.WithMsg(err, "Failed to commit snapshot")
+	}
+	committed = true
+	if err := kfs.SyncDir(dst, "."); err != nil && !errors.Is(err, kfs.ErrNotImplemented) {
+		return Snapshot{}, kerrors.WithMsg(err, "Failed to sync snapshot dir")
+	}
+	return snapshot, nil
+}
+
+type (
+	// Retention is a grandfather-father-son retention policy
+	//
+	// For each period, the newest snapshot of each of the most recent periods
+	// with snapshots is kept, up to the count of that period. A snapshot is
+	// kept if any rule keeps it, and the newest snapshot is always kept. Weeks
+	// are ISO 8601 weeks, and all periods are in UTC.
+	Retention struct {
+		// Last is the number of most recent snapshots to keep
+		Last    int
+		Hourly  int
+		Daily   int
+		Weekly  int
+		Monthly int
+		Yearly  int
+	}
+)
+
+func (r Retention) validate() error {
+	if r.Last < 0 || r.Hourly < 0 || r.Daily < 0 || r.Weekly < 0 || r.Monthly < 0 || r.Yearly < 0 {
+		return kerrors.WithMsg(fs.ErrInvalid, "Retention counts may not be negative")
+	}
+	return nil
+}
+
+type (
+	retentionRule struct {
+		count  int
+		period func(t time.Time) string
+	}
+)
+
+// Expired returns the snapshots that are not kept by r
+//
+// Snapshots must be ordered from oldest to newest, as returned by [List].
+func Expired(snapshots []Snapshot, r Retention) ([]Snapshot, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	rules := []retentionRule{
+		{count: r.Last, period: func(t time.Time) string { return t.Format(snapshotLayout) }},
+		{count: r.Hourly, period: func(t time.Time) string { return t.Format("2006010215") }},
+		{count: r.Daily, period: func(t time.Time) string { return t.Format("20060102") }},
+		{count: r.Weekly, period: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+		{count: r.Monthly, period: func(t time.Time) string { return t.Format("200601") }},
+		{count: r.Yearly, period: func(t time.Time) string { return t.Format("2006") }},
+	}
+	keep := make([]bool, len(snapshots))
+	if len(snapshots) > 0 {
+		keep[len(snapshots)-1] = true
+	}
+	for _, rule := range rules {
+		seen := map[string]struct{}{}
+		for n := len(snapshots) - 1; n >= 0 && len(seen) < rule.count; n-- {
+			p := rule.period(snapshots[n].Time.UTC())
+			if _, ok := seen[p]; ok {
+				continue
+			}
+			seen[p] = struct{}{}
+			keep[n] = true
+		}
+	}
+	var expired []Snapshot
+	for n, i := range snapshots {
+		if !keep[n] {
+			expired = append(expired, i)
+		}
+	}
+	return expired, nil
+}
+
+// Prune removes the snapshots in the root of fsys that are not kept by r
+//
+// The returned report lists the removed snapshots from oldest to newest.
+func Prune(fsys fs.FS, r Retention) (*kfs.RemoveReport, error) {
+	report := &kfs.RemoveReport{}
+	snapshots, err := List(fsys)
+	if err != nil {
+		return report, err
+	}
+	expired, err := Expired(snapshots, r)
+	if err != nil {
+		return report, err
+	}
+	for _, i := range expired {
+		if err := kfs.Remove(fsys, i.Name); err != nil {
+			return report, kerrors.WithMsg(err, "Failed to remove snapshot")
+		}
+		report.Removed = append(report.Removed, i.Name)
+	}
+	return report, nil
+}
+
+type (
+	// Report reports a round of [Schedule]
+	Report struct {
+		// Snapshot is the snapshot taken, which is empty if it failed
+		Snapshot Snapshot
+		// Pruned is the names of the pruned snapshots
+		Pruned []string
+		// Err is the error of the round, if any
+		Err error
+	}
+
+	// ScheduleOpt is an option for [Schedule]
+	ScheduleOpt = func(o *scheduleOpts)
+
+	scheduleOpts struct {
+		reporter   func(r Report)
+		writerOpts []kfsimage.WriterOpt
+		now        func() time.Time
+	}
+)
+
+// ScheduleReporter returns a [ScheduleOpt] that calls f with the [Report] of
+// each round
+func ScheduleReporter(f func(r Report)) ScheduleOpt {
+	return func(o *scheduleOpts) {
+		o.reporter = f
+	}
+}
+
+// ScheduleWriterOpts returns a [ScheduleOpt] that writes snapshots with
+// [kfsimage.WriterOpt] options, such as [kfsimage.WriterCompress]
+func ScheduleWriterOpts(opts ...kfsimage.WriterOpt) ScheduleOpt {
+	return func(o *scheduleOpts) {
+		o.writerOpts = append(o.writerOpts, opts...)
+	}
+}
+
+// ScheduleClock returns a [ScheduleOpt] that sets the clock used to time
+// snapshots, which defaults to [time.Now]
+func ScheduleClock(now func() time.Time) ScheduleOpt {
+	return func(o *scheduleOpts) {
+		o.now = now
+	}
+}
+
+// Schedule takes a snapshot of src into the root of dst every cadence, and
+// prunes dst with retention after each snapshot
+//
+// The first snapshot is taken immediately. A failed round does not stop the
+// schedule, and its error is only reported by [ScheduleReporter]. Schedule
+// runs until ctx is canceled, and returns an error matching ctx.Err().
+func Schedule(ctx context.Context, src fs.FS, dst fs.FS, cadence time.Duration, retention Retention, opts ...ScheduleOpt) error {
+	o := scheduleOpts{
+		now: time.Now,
+	}
+	for _, i := range opts {
+		i(&o)
+	}
+	if cadence <= 0 {
+		return kerrors.WithMsg(fs.ErrInvalid, "Cadence must be positive")
+	}
+	if err := retention.validate(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(cadence)
+	defer ticker.Stop()
+	for {
+		report := runRound(ctx, src, dst, retention, o)
+		if err := ctx.Err(); err != nil {
+			return kerrors.WithMsg(err, "Schedule canceled")
+		}
+		if o.reporter != nil {
+			o.reporter(report)
+		}
+		select {
+		case <-ctx.Done():
+			return kerrors.WithMsg(ctx.Err(), "Schedule canceled")
+		case <-ticker.C:
+		}
+	}
+}
+
+func runRound(ctx context.Context, src fs.FS, dst fs.FS, retention Retention, o scheduleOpts) Report {
+	snapshot, err := Take(ctx, src, dst, o.now(), o.writerOpts...)
+	if err != nil {
+		return Report{
+			Err: err,
+		}
+	}
+	pruned, err := Prune(dst, retention)
+	return Report{
+		Snapshot: snapshot,
+		Pruned:   pruned.Removed,
+		Err:      err,
+	}
+}
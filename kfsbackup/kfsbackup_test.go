@@ -0,0 +1,184 @@
+package kfsbackup_test
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"xorkevin.dev/kfs"
+	"xorkevin.dev/kfs/kfsbackup"
+	"xorkevin.dev/kfs/kfsimage"
+	"xorkevin.dev/kfs/kfstest"
+)
+
+func Test_Expired(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	start := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	var snapshots []kfsbackup.Snapshot
+	// a snapshot every 6 hours for 60 days
+	for i := range 60 * 4 {
+		ts := start.Add(time.Duration(i) * 6 * time.Hour)
+		snapshots = append(snapshots, kfsbackup.Snapshot{
+			Name: kfsbackup.SnapshotName(ts),
+			Time: ts,
+		})
+	}
+
+	expired, err := kfsbackup.Expired(snapshots, kfsbackup.Retention{})
+	assert.NoError(err)
+	assert.Len(expired, len(snapshots)-1)
+
+	expired, err = kfsbackup.Expired(snapshots, kfsbackup.Retention{
+		Last:    2,
+		Daily:   7,
+		Monthly: 3,
+	})
+	assert.NoError(err)
+	kept := map[string]struct{}{}
+	for _, i := range snapshots {
+		kept[i.Name] = struct{}{}
+	}
+	for _, i := range expired {
+		delete(kept, i.Name)
+	}
+	// the last 2, the last of each of the 6 other most recent days, which
+	// include the last of february, and the last of january
+	assert.Len(kept, 2+6+1)
+	last := snapshots[len(snapshots)-1].Time
+	for _, i := range []time.Time{
+		last,
+		last.Add(-6 * time.Hour),
+		last.Add(-24 * time.Hour),
+		last.Add(-6 * 24 * time.Hour),
+		time.Date(2026, time.January, 31, 18, 0, 0, 0, time.UTC),
+	} {
+		assert.Contains(kept, kfsbackup.SnapshotName(i))
+	}
+
+	_, err = kfsbackup.Expired(snapshots, kfsbackup.Retention{Daily: -1})
+	assert.ErrorIs(err, fs.ErrInvalid)
+}
+
+func Test_TakeAndPrune(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		fsys func(t *testing.T) kfs.FS
+	}{
+		{
+			name: "os",
+			fsys: func(t *testing.T) kfs.FS {
+				return kfs.DirFS(t.TempDir())
+			},
+		},
+		{
+			name: "map",
+			fsys: func(t *testing.T) kfs.FS {
+				return kfstest.NewMapFS()
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert := require.New(t)
+
+			src := kfstest.NewMapFS().
+				WithFile("foo.txt", []byte("hello, world"), 0o644).
+				WithSymlink("bar/link.txt", "../foo.txt")
+			dst := tc.fsys(t)
+			assert.NoError(kfs.WriteFile(dst, "README", []byte("not a snapshot"), 0o644))
+
+			start := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)
+			for i := range 3 {
+				_, err := kfsbackup.Take(context.Background(), src, dst, start.Add(time.Duration(i)*time.Hour))
+				assert.NoError(err)
+			}
+			_, err := kfsbackup.Take(context.Background(), src, dst, start)
+			assert.ErrorIs(err, fs.ErrExist)
+
+			snapshots, err := kfsbackup.List(dst)
+			assert.NoError(err)
+			assert.Len(snapshots, 3)
+			assert.Equal("20261016T120000.000000000Z.kfsimg", snapshots[0].Name)
+			assert.True(start.Equal(snapshots[0].Time))
+
+			b, err := fs.ReadFile(dst, snapshots[2].Name)
+			assert.NoError(err)
+			r, err := kfsimage.NewReader(bytes.NewReader(b), int64(len(b)))
+			assert.NoError(err)
+			assert.NoError(kfstest.TestFS(r,
+				kfstest.TestFSEntry{
+					Name: "foo.txt",
+					Data: []byte("hello, world"),
+				},
+				kfstest.TestFSEntry{
+					Name:       "bar/link.txt",
+					LinkTarget: "../foo.txt",
+				},
+			))
+
+			report, err := kfsbackup.Prune(dst, kfsbackup.Retention{Last: 2})
+			assert.NoError(err)
+			assert.Equal([]string{snapshots[0].Name}, report.Removed)
+			entries, err := fs.ReadDir(dst, ".")
+			assert.NoError(err)
+			assert.Len(entries, 3)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err = kfsbackup.Take(ctx, src, dst, start.Add(-time.Hour))
+			assert.ErrorIs(err, context.Canceled)
+		})
+	}
+}
+
+func Test_Schedule(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	src := kfstest.NewMapFS().
+		WithFile("foo.txt", []byte("hello, world"), 0o644)
+	dst := kfs.DirFS(t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)
+	var reports []kfsbackup.Report
+	err := kfsbackup.Schedule(ctx, src, dst, time.Millisecond, kfsbackup.Retention{Last: 2},
+		kfsbackup.ScheduleClock(func() time.Time {
+			now = now.Add(time.Hour)
+			return now
+		}),
+		kfsbackup.ScheduleReporter(func(r kfsbackup.Report) {
+			reports = append(reports, r)
+			if len(reports) == 4 {
+				cancel()
+			}
+		}),
+	)
+	assert.ErrorIs(err, context.Canceled)
+	assert.Len(reports, 4)
+	for _, i := range reports {
+		assert.NoError(i.Err)
+	}
+	assert.Len(reports[1].Pruned, 0)
+	assert.Equal([]string{reports[0].Snapshot.Name}, reports[2].Pruned)
+
+	snapshots, err := kfsbackup.List(dst)
+	assert.NoError(err)
+	assert.Len(snapshots, 2)
+	assert.Equal(reports[3].Snapshot, snapshots[1])
+
+	err = kfsbackup.Schedule(ctx, src, dst, 0, kfsbackup.Retention{})
+	assert.ErrorIs(err, fs.ErrInvalid)
+}
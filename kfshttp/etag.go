@@ -0,0 +1,47 @@
+package kfshttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"strconv"
+)
+
+type (
+	// ETagFunc returns the ETag of a file, including its quotes and any W/
+	// prefix
+	ETagFunc = func(fsys fs.FS, name string, info fs.FileInfo) (string, error)
+)
+
+// ModTimeETag returns a weak ETag derived from the mod time and size of a
+// file
+//
+// It is the default [ETagFunc] of [NewListingHandler], and does not read the
+// file.
+func ModTimeETag(fsys fs.FS, name string, info fs.FileInfo) (string, error) {
+	return `W/"` + strconv.FormatInt(info.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(info.Size(), 16) + `"`, nil
+}
+
+// ContentETag returns a strong ETag of the sha256 digest of the contents of a
+// file
+//
+// It reads the entire file on every request, and is intended for small
+// files or for fs whose mod times are unreliable.
+func ContentETag(fsys fs.FS, name string, info fs.FileInfo) (_ string, retErr error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			retErr = errors.Join(retErr, err)
+		}
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`, nil
+}
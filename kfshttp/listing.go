@@ -0,0 +1,347 @@
+// Package kfshttp serves file systems over http
+package kfshttp
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"xorkevin.dev/kfs"
+)
+
+type (
+	// Listing is the data of a directory listing page
+	Listing struct {
+		// Path is the path of the directory, which is "." for the root
+		Path        string
+		Breadcrumbs []Breadcrumb
+		Columns     []Column
+		Entries     []Entry
+	}
+
+	// Breadcrumb is a link to the directory or one of its ancestors
+	Breadcrumb struct {
+		Name string
+		URL  string
+	}
+
+	// Column is a sortable column of a listing
+	Column struct {
+		Name string
+		// URL sorts the listing by the column, toggling the order if it is
+		// already sorted by it
+		URL    string
+		Active bool
+		Desc   bool
+	}
+
+	// Entry is a dir entry of a listing
+	Entry struct {
+		Name    string
+		URL     string
+		IsDir   bool
+		Size    int64
+		Mode    fs.FileMode
+		ModTime time.Time
+	}
+
+	// ListingOpt is an option for [NewListingHandler]
+	ListingOpt = func(o *listingOpts)
+
+	listingOpts struct {
+		tmpl *template.Template
+		etag ETagFunc
+	}
+)
+
+// ListingTemplate sets the template used to render directory listings
+//
+// The template is executed with a [Listing].
+func ListingTemplate(tmpl *template.Template) ListingOpt {
+	return func(o *listingOpts) {
+		o.tmpl = tmpl
+	}
+}
+
+// ListingETag sets the function used to compute the ETag of served files
+//
+// A nil etag disables ETags, leaving only Last-Modified for conditional
+// requests.
+func ListingETag(etag ETagFunc) ListingOpt {
+	return func(o *listingOpts) {
+		o.etag = etag
+	}
+}
+
+const (
+	sortName    = "name"
+	sortSize    = "size"
+	sortModTime = "modtime"
+)
+
+var defaultListingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Path}}</title>
+</head>
+<body>
+<nav>{{range $i, $b := .Breadcrumbs}}{{if $i}} / {{end}}<a href="{{$b.URL}}">{{$b.Name}}</a>{{end}}</nav>
+<table>
+<thead><tr>{{range .Columns}}<th><a href="{{.URL}}">{{.Name}}{{if .Active}}{{if .Desc}} &#9660;{{else}} &#9650;{{end}}{{end}}</a></th>{{end}}</tr></thead>
+<tbody>
+{{- range .Entries}}
+<tr><td><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+</body>
+</html>
+`))
+
+type (
+	listingHandler struct {
+		fsys fs.FS
+		tmpl *template.Template
+		etag ETagFunc
+	}
+)
+
+// NewListingHandler creates a read-only [http.Handler] that serves the files
+// of fsys and renders listings of its directories
+//
+// Listings may be sorted by name, size, or modtime with the sort query
+// parameter, in the order given by the order query parameter of asc or desc.
+// Directories are always listed before files. All links are relative, so the
+// handler may be mounted under a prefix with [http.StripPrefix].
+//
+// Files are served with [http.ServeContent], which handles range requests and
+// the conditional request headers If-Modified-Since, If-None-Match, and
+// If-Match. Files are given an ETag with [ModTimeETag] unless changed with
+// [ListingETag].
+//
+// Files may be hidden by wrapping fsys with [kfs.NewMaskFS], in which case
+// masked files are treated as if they do not exist.
+func NewListingHandler(fsys fs.FS, opts ...ListingOpt) http.Handler {
+	o := listingOpts{
+		tmpl: defaultListingTemplate,
+		etag: ModTimeETag,
+	}
+	for _, i := range opts {
+		i(&o)
+	}
+	return &listingHandler{
+		fsys: fsys,
+		tmpl: o.tmpl,
+		etag: o.etag,
+	}
+}
+
+func writeFSError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, kfs.ErrFileMasked), errors.Is(err, fs.ErrInvalid):
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (h *listingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	if !info.IsDir() {
+		h.serveFile(w, r, name, info)
+		return
+	}
+	if name != "." && !strings.HasSuffix(r.URL.Path, "/") {
+		// a relative redirect is correct even when mounted under a prefix
+		target := "./" + url.PathEscape(path.Base(name)) + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+	h.serveDir(w, r, name)
+}
+
+func (h *listingHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+	if h.etag != nil {
+		etag, err := h.etag(h.fsys, name, info)
+		if err != nil {
+			writeFSError(w, err)
+			return
+		}
+		w.Header().Set("ETag", etag)
+	}
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			writeFSError(w, err)
+			return
+		}
+		rs = bytes.NewReader(b)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), rs)
+}
+
+func breadcrumbs(name string) []Breadcrumb {
+	var parts []string
+	if name != "." {
+		parts = strings.Split(name, "/")
+	}
+	crumbs := make([]Breadcrumb, 0, len(parts)+1)
+	crumbs = append(crumbs, Breadcrumb{
+		Name: "/",
+		URL:  "./" + strings.Repeat("../", len(parts)),
+	})
+	for n, i := range parts {
+		crumbs = append(crumbs, Breadcrumb{
+			Name: i,
+			URL:  "./" + strings.Repeat("../", len(parts)-n-1),
+		})
+	}
+	return crumbs
+}
+
+func (h *listingHandler) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	dirEntries, err := fs.ReadDir(h.fsys, name)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	entries := make([]Entry, 0, len(dirEntries))
+	for _, i := range dirEntries {
+		info, err := i.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// the file was removed after the dir was read
+				continue
+			}
+			writeFSError(w, err)
+			return
+		}
+		// the leading ./ keeps names with a colon from being parsed as a scheme
+		u := "./" + url.PathEscape(i.Name())
+		if i.IsDir() {
+			u += "/"
+		}
+		entries = append(entries, Entry{
+			Name:    i.Name(),
+			URL:     u,
+			IsDir:   i.IsDir(),
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	query := r.URL.Query()
+	sortBy := query.Get("sort")
+	switch sortBy {
+	case sortName, sortSize, sortModTime:
+	default:
+		sortBy = sortName
+	}
+	desc := query.Get("order") == "desc"
+	sortEntries(entries, sortBy, desc)
+
+	columns := make([]Column, 0, 3)
+	for _, i := range []struct {
+		name string
+		key  string
+	}{
+		{name: "Name", key: sortName},
+		{name: "Size", key: sortSize},
+		{name: "Modified", key: sortModTime},
+	} {
+		active := i.key == sortBy
+		order := "asc"
+		if active && !desc {
+			order = "desc"
+		}
+		columns = append(columns, Column{
+			Name:   i.name,
+			URL:    "?" + url.Values{"sort": {i.key}, "order": {order}}.Encode(),
+			Active: active,
+			Desc:   active && desc,
+		})
+	}
+
+	var b bytes.Buffer
+	if err := h.tmpl.Execute(&b, Listing{
+		Path:        name,
+		Breadcrumbs: breadcrumbs(name),
+		Columns:     columns,
+		Entries:     entries,
+	}); err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(b.Bytes())
+}
+
+func sortEntries(entries []Entry, sortBy string, desc bool) {
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		c := 0
+		switch sortBy {
+		case sortSize:
+			c = cmp.Compare(a.Size, b.Size)
+		case sortModTime:
+			c = a.ModTime.Compare(b.ModTime)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
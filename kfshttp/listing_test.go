@@ -0,0 +1,162 @@
+package kfshttp
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"xorkevin.dev/kfs"
+	"xorkevin.dev/kfs/kfstest"
+)
+
+func Test_ListingHandler(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := kfstest.NewMapFS().
+		WithFile("a.txt", []byte("hello, world"), 0o644).
+		WithFile("b.txt", []byte("b"), 0o644).
+		WithFile("dir/c:d.txt", []byte("cd"), 0o644).
+		WithFile("dir/sub/e.txt", []byte("e"), 0o644).
+		WithFile(".secret", []byte("secret"), 0o600)
+	assert.NoError(kfs.Chtimes(fsys, "a.txt", now, now))
+	assert.NoError(kfs.Chtimes(fsys, "b.txt", now.Add(time.Hour), now.Add(time.Hour)))
+
+	tmpl := template.Must(template.New("test").Parse(`{{.Path}}|{{range .Breadcrumbs}}{{.Name}}={{.URL}},{{end}}|{{range .Columns}}{{.Name}}={{.URL}},{{end}}|{{range .Entries}}{{.Name}}={{.URL}},{{end}}`))
+	handler := NewListingHandler(kfs.NewMaskFS(fsys, func(p string) (bool, error) {
+		return p != ".secret", nil
+	}), ListingTemplate(tmpl))
+
+	get := func(target string) *http.Response {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w.Result()
+	}
+	body := func(res *http.Response) string {
+		b, err := io.ReadAll(res.Body)
+		assert.NoError(err)
+		return string(b)
+	}
+
+	res := get("/")
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal("text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equal(".|/=./,|Name=?order=desc&amp;sort=name,Size=?order=asc&amp;sort=size,Modified=?order=asc&amp;sort=modtime,|dir=./dir/,a.txt=./a.txt,b.txt=./b.txt,", body(res))
+
+	res = get("/?sort=size&order=desc")
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.True(strings.HasSuffix(body(res), "|dir=./dir/,a.txt=./a.txt,b.txt=./b.txt,"))
+	res = get("/?sort=modtime&order=desc")
+	assert.True(strings.HasSuffix(body(res), "|dir=./dir/,b.txt=./b.txt,a.txt=./a.txt,"))
+
+	res = get("/dir/sub/")
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.True(strings.HasPrefix(body(res), "dir/sub|/=./../../,dir=./../,sub=./,|"))
+
+	res = get("/dir/")
+	assert.True(strings.HasSuffix(body(res), "|sub=./sub/,c:d.txt=./c:d.txt,"))
+
+	res = get("/dir?sort=size")
+	assert.Equal(http.StatusMovedPermanently, res.StatusCode)
+	assert.Equal("./dir/?sort=size", res.Header.Get("Location"))
+
+	{
+		// redirects of dir names that are not valid url paths are escaped
+		handler := NewListingHandler(kfstest.NewMapFS().
+			WithDir("q?r").
+			WithDir("a:b").
+			WithDir("sub/with space"))
+		for _, tc := range []struct {
+			target   string
+			location string
+		}{
+			{target: "/q%3Fr", location: "./q%3Fr/"},
+			{target: "/a:b", location: "./a:b/"},
+			{target: "/sub/with%20space?sort=size", location: "./with%20space/?sort=size"},
+		} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			handler.ServeHTTP(w, req)
+			assert.Equal(http.StatusMovedPermanently, w.Code)
+			location := w.Header().Get("Location")
+			assert.Equal(tc.location, location)
+			u, err := req.URL.Parse(location)
+			assert.NoError(err)
+			assert.Equal(req.URL.Path+"/", u.Path)
+		}
+	}
+
+	res = get("/a.txt")
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal("hello, world", body(res))
+	assert.Equal(now.Format(http.TimeFormat), res.Header.Get("Last-Modified"))
+
+	assert.Equal(http.StatusNotFound, get("/.secret").StatusCode)
+	assert.Equal(http.StatusNotFound, get("/missing").StatusCode)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	NewListingHandler(fsys).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `<a href="./a.txt">a.txt</a>`)
+}
+
+func Test_ListingHandlerConditional(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := kfstest.NewMapFS().
+		WithFile("a.txt", []byte("hello, world"), 0o644)
+	assert.NoError(kfs.Chtimes(fsys, "a.txt", now, now))
+
+	serve := func(handler http.Handler, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	handler := NewListingHandler(fsys)
+	w := serve(handler, nil)
+	assert.Equal(http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.True(strings.HasPrefix(etag, `W/"`))
+
+	w = serve(handler, http.Header{"If-None-Match": {etag}})
+	assert.Equal(http.StatusNotModified, w.Code)
+	w = serve(handler, http.Header{"If-Modified-Since": {now.Format(http.TimeFormat)}})
+	assert.Equal(http.StatusNotModified, w.Code)
+	w = serve(handler, http.Header{"Range": {"bytes=0-4"}})
+	assert.Equal(http.StatusPartialContent, w.Code)
+	assert.Equal("hello", w.Body.String())
+
+	handler = NewListingHandler(fsys, ListingETag(ContentETag))
+	w = serve(handler, nil)
+	assert.Equal(`"09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b"`, w.Header().Get("ETag"))
+	w = serve(handler, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+	assert.Equal(http.StatusNotModified, w.Code)
+
+	assert.NoError(kfs.WriteFile(fsys, "a.txt", []byte("hello, there"), 0o644))
+	assert.NoError(kfs.Chtimes(fsys, "a.txt", now, now))
+	w = serve(handler, http.Header{"If-None-Match": {`"09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b"`}})
+	assert.Equal(http.StatusOK, w.Code)
+
+	handler = NewListingHandler(fsys, ListingETag(nil))
+	w = serve(handler, nil)
+	assert.Equal("", w.Header().Get("ETag"))
+}
@@ -0,0 +1,185 @@
+// Package kfsimage implements kfs images, a container format that serializes
+// the files of an [fs.FS] with their metadata and symlinks
+//
+// An image is written once by a [Writer] and read by a [Reader], which is a
+// read-only [fs.FS]. All integers are big-endian. An image is:
+//
+//   - an 8 byte header
+//   - the 32 byte [ID] of its parent image if it is incremental
+//   - the content blocks of its entries
+//   - the index of its entries
+//   - a 24 byte trailer
+//
+// The header is the 6 byte magic "kfsimg", a 1 byte format version, currently
+// 1, and a 1 byte flags, where bit 0 is set if the image is incremental. Other
+// bits are reserved and are 0.
+//
+// An incremental image is a snapshot that references a parent image, and only
+// stores the content of files that differ from the parent. Its index still
+// describes every file of the snapshot, so files absent from its index are
+// absent from the snapshot. The parent may itself be incremental, forming a
+// chain of snapshots.
+//
+// A content block is the contents of a regular file or the target of a
+// symlink, and is compressed with DEFLATE if its entry is flagged as
+// compressed. Directories have no content block.
+//
+// The index is a sequence of entries, each encoded as:
+//
+//   - 2 byte name length, followed by the name, a valid [fs.FS] path other
+//     than "."
+//   - 4 byte [fs.FileMode]
+//   - 8 byte mod time in nanoseconds since the unix epoch
+//   - 1 byte flags, where bit 0 is set if the content block is compressed,
+//     and bit 1 is set if the content is that of the entry of the same name in
+//     the parent image, in which case there is no content block, and the
+//     offset and stored length are 0. Other bits are reserved and are 0.
+//   - 8 byte offset of the content block from the start of the image
+//   - 8 byte stored length of the content block
+//   - 8 byte uncompressed length of the content
+//   - 4 byte CRC-32 (IEEE) of the uncompressed content
+//
+// Each entry follows the entry of its parent directory, and the root dir "."
+// is implicit.
+//
+// The trailer is the 8 byte offset of the index, the 8 byte length of the
+// index, the 4 byte CRC-32 (IEEE) of the index, and the 4 byte magic "kfse".
+//
+// The [ID] of an image is the SHA-256 digest of its header, the ID of its
+// parent if it is incremental, and its index.
+package kfsimage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io/fs"
+	"time"
+
+	"xorkevin.dev/kerrors"
+)
+
+var (
+	// ErrMalformed is returned when an image is malformed
+	ErrMalformed errMalformed
+	// ErrParent is returned when the parent of an incremental image is missing
+	// or is not the image it references
+	ErrParent errParent
+)
+
+type (
+	errMalformed struct{}
+	errParent    struct{}
+)
+
+func (e errMalformed) Error() string {
+	return "Malformed image"
+}
+
+func (e errParent) Error() string {
+	return "Invalid parent image"
+}
+
+type (
+	// ID identifies an image
+	ID [sha256.Size]byte
+)
+
+func (i ID) String() string {
+	return hex.EncodeToString(i[:])
+}
+
+const (
+	headerMagic   = "kfsimg"
+	trailerMagic  = "kfse"
+	formatVersion = 1
+	headerSize    = 8
+	trailerSize   = 24
+
+	// entryFixedSize is the size of an encoded index entry without its name
+	entryFixedSize = 2 + 4 + 8 + 1 + 8 + 8 + 8 + 4
+	maxNameLen     = 1<<16 - 1
+
+	headerFlagIncremental = 1
+
+	flagCompressed = 1
+	flagInParent   = 2
+)
+
+type (
+	entry struct {
+		name       string
+		mode       fs.FileMode
+		modTime    time.Time
+		compressed bool
+		inParent   bool
+		offset     int64
+		storedSize int64
+		size       int64
+		crc        uint32
+	}
+)
+
+func (e *entry) appendEncoded(b []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(e.name)))
+	b = append(b, e.name...)
+	b = binary.BigEndian.AppendUint32(b, uint32(e.mode))
+	b = binary.BigEndian.AppendUint64(b, uint64(e.modTime.UnixNano()))
+	var flags byte
+	if e.compressed {
+		flags |= flagCompressed
+	}
+	if e.inParent {
+		flags |= flagInParent
+	}
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint64(b, uint64(e.offset))
+	b = binary.BigEndian.AppendUint64(b, uint64(e.storedSize))
+	b = binary.BigEndian.AppendUint64(b, uint64(e.size))
+	b = binary.BigEndian.AppendUint32(b, e.crc)
+	return b
+}
+
+// decodeEntry decodes the first entry of b, and returns it with the rest of b
+func decodeEntry(b []byte) (*entry, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, kerrors.WithMsg(ErrMalformed, "Truncated index entry")
+	}
+	nameLen := int(binary.BigEndian.Uint16(b))
+	if len(b) < entryFixedSize+nameLen {
+		return nil, nil, kerrors.WithMsg(ErrMalformed, "Truncated index entry")
+	}
+	b = b[2:]
+	e := &entry{
+		name: string(b[:nameLen]),
+	}
+	b = b[nameLen:]
+	e.mode = fs.FileMode(binary.BigEndian.Uint32(b[0:4]))
+	e.modTime = time.Unix(0, int64(binary.BigEndian.Uint64(b[4:12])))
+	if b[12]&^(flagCompressed|flagInParent) != 0 {
+		return nil, nil, kerrors.WithMsg(ErrMalformed, "Unknown index entry flags")
+	}
+	e.compressed = b[12]&flagCompressed != 0
+	e.inParent = b[12]&flagInParent != 0
+	e.offset = int64(binary.BigEndian.Uint64(b[13:21]))
+	e.storedSize = int64(binary.BigEndian.Uint64(b[21:29]))
+	e.size = int64(binary.BigEndian.Uint64(b[29:37]))
+	e.crc = binary.BigEndian.Uint32(b[37:41])
+	return e, b[41:], nil
+}
+
+func checksum(b []byte) uint32 {
+	return crc32.ChecksumIEEE(b)
+}
+
+// imageID computes the [ID] of an image from its header, including the parent
+// ID, and its index
+func imageID(header, index []byte) ID {
+	h := sha256.New()
+	h.Write(header)
+	h.Write(index)
+	var id ID
+	h.Sum(id[:0])
+	return id
+}
@@ -0,0 +1,279 @@
+package kfsimage_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"xorkevin.dev/kfs"
+	"xorkevin.dev/kfs/kfsimage"
+	"xorkevin.dev/kfs/kfstest"
+)
+
+func Test_Image(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		Test string
+		Opts []kfsimage.WriterOpt
+	}{
+		{
+			Test: "uncompressed",
+		},
+		{
+			Test: "compressed",
+			Opts: []kfsimage.WriterOpt{kfsimage.WriterCompress(flate.BestCompression)},
+		},
+	} {
+		t.Run(tc.Test, func(t *testing.T) {
+			t.Parallel()
+
+			assert := require.New(t)
+
+			now := time.Unix(0, time.Now().UnixNano())
+			large := bytes.Repeat([]byte("compressible "), 256)
+			src := kfstest.NewMapFS().
+				WithFile("foo.txt", []byte("hello, world"), 0o644).
+				WithFile("bar/large.txt", large, 0o600).
+				WithFile("bar/empty.txt", nil, 0o644).
+				WithDir("bar/baz").
+				WithSymlink("link.txt", "bar/large.txt").
+				WithSymlink("bar/dirlink", "baz")
+			for _, v := range src.Fsys {
+				v.ModTime = now
+			}
+
+			var b bytes.Buffer
+			w := kfsimage.NewWriter(&b, tc.Opts...)
+			assert.NoError(w.AddFS(src))
+			assert.NoError(w.Close())
+			assert.ErrorIs(w.Close(), fs.ErrClosed)
+			assert.ErrorIs(w.AddDir("other", 0o755, now), fs.ErrClosed)
+
+			r, err := kfsimage.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+			assert.NoError(err)
+
+			assert.NoError(fstest.TestFS(r, "foo.txt", "bar/large.txt", "bar/empty.txt", "bar/baz", "link.txt"))
+			assert.NoError(kfstest.TestFS(r,
+				kfstest.TestFSEntry{
+					Name:    "foo.txt",
+					Data:    []byte("hello, world"),
+					Mode:    0o644,
+					ModTime: now,
+				},
+				kfstest.TestFSEntry{
+					Name: "bar/large.txt",
+					Data: large,
+					Mode: 0o600,
+				},
+				kfstest.TestFSEntry{
+					Name:  "bar/baz",
+					IsDir: true,
+				},
+				kfstest.TestFSEntry{
+					Name:       "link.txt",
+					LinkTarget: "bar/large.txt",
+				},
+				kfstest.TestFSEntry{
+					Name:       "bar/dirlink",
+					LinkTarget: "baz",
+				},
+			))
+
+			info, err := fs.Stat(r, "bar/dirlink")
+			assert.NoError(err)
+			assert.True(info.IsDir())
+		})
+	}
+}
+
+func Test_ImageWriterErrors(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	now := time.Now()
+	w := kfsimage.NewWriter(&bytes.Buffer{})
+	assert.NoError(w.AddDir("foo", 0o755, now))
+	assert.ErrorIs(w.AddDir("foo", 0o755, now), fs.ErrExist)
+	assert.ErrorIs(w.AddFile("bar/baz.txt", 0o644, now, bytes.NewReader(nil)), fs.ErrNotExist)
+	assert.ErrorIs(w.AddFile("../baz.txt", 0o644, now, bytes.NewReader(nil)), fs.ErrInvalid)
+	assert.ErrorIs(w.AddSymlink("foo/link", "../../outside", now), kfs.ErrTargetOutsideFS)
+
+	assert.Panics(func() {
+		kfsimage.NewWriter(&bytes.Buffer{}, kfsimage.WriterCompress(64))
+	})
+}
+
+func Test_ImageMalformed(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	now := time.Now()
+	var b bytes.Buffer
+	w := kfsimage.NewWriter(&b)
+	assert.NoError(w.AddFile("foo.txt", 0o644, now, bytes.NewReader([]byte("hello, world"))))
+	assert.NoError(w.AddSymlink("a", "b", now))
+	assert.NoError(w.AddSymlink("b", "a", now))
+	assert.NoError(w.Close())
+	img := b.Bytes()
+
+	r, err := kfsimage.NewReader(bytes.NewReader(img), int64(len(img)))
+	assert.NoError(err)
+	_, err = fs.ReadFile(r, "a")
+	assert.ErrorIs(err, kfs.ErrLinkLoop)
+
+	{
+		// corrupt the content of foo.txt
+		corrupt := bytes.Clone(img)
+		corrupt[8] ^= 0xff
+		r, err := kfsimage.NewReader(bytes.NewReader(corrupt), int64(len(corrupt)))
+		assert.NoError(err)
+		_, err = fs.ReadFile(r, "foo.txt")
+		assert.ErrorIs(err, kfsimage.ErrMalformed)
+	}
+	{
+		// corrupt the index
+		corrupt := bytes.Clone(img)
+		corrupt[len(corrupt)-30] ^= 0xff
+		_, err := kfsimage.NewReader(bytes.NewReader(corrupt), int64(len(corrupt)))
+		assert.ErrorIs(err, kfsimage.ErrMalformed)
+	}
+	{
+		_, err := kfsimage.NewReader(bytes.NewReader(img[:len(img)-1]), int64(len(img)-1))
+		assert.ErrorIs(err, kfsimage.ErrMalformed)
+	}
+}
+
+func writeImage(t *testing.T, fsys fs.FS, opts ...kfsimage.WriterOpt) *kfsimage.Reader {
+	t.Helper()
+
+	assert := require.New(t)
+
+	var b bytes.Buffer
+	w := kfsimage.NewWriter(&b, opts...)
+	assert.NoError(w.AddFS(fsys))
+	assert.NoError(w.Close())
+	r, err := kfsimage.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.NoError(err)
+	return r
+}
+
+func Test_ImageIncremental(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	src := kfstest.NewMapFS().
+		WithFile("foo.txt", []byte("hello, world"), 0o644).
+		WithFile("bar/same.txt", []byte("unchanged"), 0o644).
+		WithFile("bar/gone.txt", []byte("removed later"), 0o644).
+		WithSymlink("link.txt", "foo.txt")
+	base := writeImage(t, src)
+	_, ok := base.ParentID()
+	assert.False(ok)
+
+	assert.NoError(kfs.WriteFile(src, "foo.txt", []byte("goodbye, world"), 0o644))
+	assert.NoError(kfs.Remove(src, "bar/gone.txt"))
+	assert.NoError(kfs.WriteFile(src, "bar/new.txt", []byte("added"), 0o644))
+	inc1 := writeImage(t, src, kfsimage.WriterParent(base))
+	parentID, ok := inc1.ParentID()
+	assert.True(ok)
+	assert.Equal(base.ID(), parentID)
+
+	_, err := fs.ReadFile(inc1, "bar/same.txt")
+	assert.ErrorIs(err, kfsimage.ErrParent)
+	_, err = base.WithParent(base)
+	assert.ErrorIs(err, kfsimage.ErrParent)
+	inc1, err = inc1.WithParent(base)
+	assert.NoError(err)
+
+	assert.NoError(kfs.WriteFile(src, "bar/new.txt", []byte("changed"), 0o644))
+	inc2 := writeImage(t, src, kfsimage.WriterParent(inc1))
+	_, err = inc2.WithParent(base)
+	assert.ErrorIs(err, kfsimage.ErrParent)
+	inc2, err = inc2.WithParent(inc1)
+	assert.NoError(err)
+
+	assert.NoError(kfstest.TestFS(inc1,
+		kfstest.TestFSEntry{
+			Name: "foo.txt",
+			Data: []byte("goodbye, world"),
+		},
+		kfstest.TestFSEntry{
+			Name: "bar/same.txt",
+			Data: []byte("unchanged"),
+		},
+		kfstest.TestFSEntry{
+			Name: "bar/new.txt",
+			Data: []byte("added"),
+		},
+		kfstest.TestFSEntry{
+			Name:       "link.txt",
+			LinkTarget: "foo.txt",
+		},
+	))
+	_, err = fs.Stat(inc1, "bar/gone.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	for _, tc := range []struct {
+		Test string
+		Dst  kfs.FS
+	}{
+		{
+			Test: "os",
+			Dst:  kfs.DirFS(t.TempDir()),
+		},
+		{
+			Test: "map",
+			Dst:  kfstest.NewMapFS(),
+		},
+	} {
+		t.Run(tc.Test, func(t *testing.T) {
+			t.Parallel()
+
+			assert := require.New(t)
+
+			assert.NoError(kfs.WriteFile(tc.Dst, "other.txt", []byte("kept"), 0o644))
+			assert.NoError(kfsimage.Restore(tc.Dst, base))
+			// restoring a later image replaces links and files
+			assert.NoError(kfs.Remove(tc.Dst, "link.txt"))
+			assert.NoError(kfs.Symlink(tc.Dst, "other.txt", "link.txt"))
+			assert.NoError(kfsimage.Restore(tc.Dst, inc2))
+			// restoring the same image again keeps identical links
+			assert.NoError(kfsimage.Restore(tc.Dst, inc2))
+			assert.NoError(kfstest.TestFS(tc.Dst,
+				kfstest.TestFSEntry{
+					Name: "foo.txt",
+					Data: []byte("goodbye, world"),
+					Mode: 0o644,
+				},
+				kfstest.TestFSEntry{
+					Name: "bar/same.txt",
+					Data: []byte("unchanged"),
+				},
+				kfstest.TestFSEntry{
+					Name: "bar/new.txt",
+					Data: []byte("changed"),
+				},
+				kfstest.TestFSEntry{
+					Name:       "link.txt",
+					LinkTarget: "foo.txt",
+				},
+				kfstest.TestFSEntry{
+					Name: "other.txt",
+					Data: []byte("kept"),
+				},
+			))
+			// files removed after the base image are kept
+			entries, err := fs.ReadDir(tc.Dst, "bar")
+			assert.NoError(err)
+			assert.Len(entries, 3)
+		})
+	}
+}
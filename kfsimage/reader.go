@@ -0,0 +1,564 @@
+package kfsimage
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"xorkevin.dev/kerrors"
+	"xorkevin.dev/kfs"
+)
+
+const (
+	maxLinkDepth = 40
+)
+
+type (
+	// Reader is a read-only [fs.FS] of an image
+	Reader struct {
+		img *image
+		dir string
+	}
+
+	image struct {
+		r           io.ReaderAt
+		id          ID
+		incremental bool
+		parentID    ID
+		parent      *image
+		entries     map[string]*entry
+		children    map[string][]*entry
+	}
+)
+
+// NewReader reads the index of the image of size bytes in r
+//
+// The index and the bounds of every content block are validated. Content is
+// checked against its checksum as it is opened. Files of an incremental image
+// that are stored in its parent may only be read once the parent is given by
+// [Reader.WithParent].
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < headerSize+trailerSize {
+		return nil, kerrors.WithMsg(ErrMalformed, "Image is too small")
+	}
+	header := make([]byte, headerSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, kerrors.WithMsg(err, "Failed reading image header")
+	}
+	if string(header[:len(headerMagic)]) != headerMagic {
+		return nil, kerrors.WithMsg(ErrMalformed, "Invalid image header magic")
+	}
+	if v := header[len(headerMagic)]; v != formatVersion {
+		return nil, kerrors.WithMsg(ErrMalformed, fmt.Sprintf("Unsupported image version %d", v))
+	}
+	flags := header[len(headerMagic)+1]
+	if flags&^headerFlagIncremental != 0 {
+		return nil, kerrors.WithMsg(ErrMalformed, "Unknown image header flags")
+	}
+	img := &image{
+		r:           r,
+		incremental: flags&headerFlagIncremental != 0,
+		entries:     map[string]*entry{},
+		children:    map[string][]*entry{},
+	}
+	if img.incremental {
+		if size < headerSize+int64(len(img.parentID))+trailerSize {
+			return nil, kerrors.WithMsg(ErrMalformed, "Image is too small")
+		}
+		if _, err := r.ReadAt(img.parentID[:], headerSize); err != nil {
+			return nil, kerrors.WithMsg(err, "Failed reading image parent")
+		}
+		header = append(header, img.parentID[:]...)
+	}
+	var trailer [trailerSize]byte
+	if _, err := r.ReadAt(trailer[:], size-trailerSize); err != nil {
+		return nil, kerrors.WithMsg(err, "Failed reading image trailer")
+	}
+	if string(trailer[20:]) != trailerMagic {
+		return nil, kerrors.WithMsg(ErrMalformed, "Invalid image trailer magic")
+	}
+	dataStart := uint64(len(header))
+	indexOffset := binary.BigEndian.Uint64(trailer[0:8])
+	indexLen := binary.BigEndian.Uint64(trailer[8:16])
+	indexEnd := uint64(size - trailerSize)
+	if indexOffset < dataStart || indexOffset > indexEnd || indexLen != indexEnd-indexOffset {
+		return nil, kerrors.WithMsg(ErrMalformed, "Invalid index bounds")
+	}
+	index := make([]byte, indexLen)
+	if _, err := r.ReadAt(index, int64(indexOffset)); err != nil {
+		return nil, kerrors.WithMsg(err, "Failed reading image index")
+	}
+	if checksum(index) != binary.BigEndian.Uint32(trailer[16:20]) {
+		return nil, kerrors.WithMsg(ErrMalformed, "Index checksum mismatch")
+	}
+	img.id = imageID(header, index)
+	for len(index) > 0 {
+		e, rest, err := decodeEntry(index)
+		if err != nil {
+			return nil, err
+		}
+		index = rest
+		if err := img.checkEntry(e, dataStart, indexOffset); err != nil {
+			return nil, kerrors.WithMsg(err, fmt.Sprintf("Invalid index entry %s", e.name))
+		}
+		img.entries[e.name] = e
+		dir := path.Dir(e.name)
+		img.children[dir] = append(img.children[dir], e)
+	}
+	for _, v := range img.children {
+		slices.SortFunc(v, func(a, b *entry) int {
+			return strings.Compare(a.name, b.name)
+		})
+	}
+	return &Reader{
+		img: img,
+		dir: ".",
+	}, nil
+}
+
+func (img *image) checkEntry(e *entry, dataStart, indexOffset uint64) error {
+	if !fs.ValidPath(e.name) || e.name == "." {
+		return kerrors.WithMsg(ErrMalformed, "Invalid path")
+	}
+	if _, ok := img.entries[e.name]; ok {
+		return kerrors.WithMsg(ErrMalformed, "Duplicate entry")
+	}
+	if dir := path.Dir(e.name); dir != "." {
+		if p, ok := img.entries[dir]; !ok || !p.mode.IsDir() {
+			return kerrors.WithMsg(ErrMalformed, "Entry precedes its parent directory")
+		}
+	}
+	if e.storedSize < 0 || e.size < 0 {
+		return kerrors.WithMsg(ErrMalformed, "Invalid content size")
+	}
+	switch e.mode.Type() {
+	case fs.ModeDir:
+		if e.storedSize != 0 || e.size != 0 || e.inParent {
+			return kerrors.WithMsg(ErrMalformed, "Directory has content")
+		}
+		return nil
+	case fs.ModeSymlink, 0:
+	default:
+		return kerrors.WithMsg(ErrMalformed, fmt.Sprintf("Unsupported file type %s", e.mode.Type()))
+	}
+	if e.inParent {
+		if !img.incremental {
+			return kerrors.WithMsg(ErrMalformed, "Entry references the parent of an image without one")
+		}
+		if e.compressed || e.offset != 0 || e.storedSize != 0 {
+			return kerrors.WithMsg(ErrMalformed, "Entry in parent has a content block")
+		}
+		return nil
+	}
+	if uint64(e.offset) < dataStart || uint64(e.offset) > indexOffset || uint64(e.storedSize) > indexOffset-uint64(e.offset) {
+		return kerrors.WithMsg(ErrMalformed, "Invalid content bounds")
+	}
+	if !e.compressed && e.storedSize != e.size {
+		return kerrors.WithMsg(ErrMalformed, "Invalid content size")
+	}
+	return nil
+}
+
+// ID returns the [ID] of the image
+func (r *Reader) ID() ID {
+	return r.img.id
+}
+
+// ParentID returns the [ID] of the parent of the image, and whether the image
+// is incremental
+func (r *Reader) ParentID() (ID, bool) {
+	return r.img.parentID, r.img.incremental
+}
+
+// WithParent returns a [Reader] of the incremental image that reads the files
+// stored in parent from parent
+//
+// Parent must be the image referenced by [Reader.ParentID], and if it is
+// itself incremental, it must already have its own parent.
+func (r *Reader) WithParent(parent *Reader) (*Reader, error) {
+	if !r.img.incremental {
+		return nil, kerrors.WithMsg(ErrParent, "Image is not incremental")
+	}
+	if parent.img.id != r.img.parentID {
+		return nil, kerrors.WithMsg(ErrParent, fmt.Sprintf("Image parent %s does not match %s", r.img.parentID, parent.img.id))
+	}
+	img := *r.img
+	img.parent = parent.img
+	return &Reader{
+		img: &img,
+		dir: r.dir,
+	}, nil
+}
+
+// content reads, decompresses, and verifies the content of e
+func (img *image) content(e *entry) ([]byte, error) {
+	if e.inParent {
+		return img.parentContent(e)
+	}
+	var r io.Reader = io.NewSectionReader(img.r, e.offset, e.storedSize)
+	if e.compressed {
+		d := flate.NewReader(r)
+		defer func() {
+			_ = d.Close()
+		}()
+		r = d
+	}
+	b, err := io.ReadAll(io.LimitReader(r, e.size+1))
+	if err != nil {
+		if e.compressed && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, kerrors.WithKind(err, ErrMalformed, "Failed decompressing content")
+		}
+		return nil, kerrors.WithMsg(err, "Failed reading content")
+	}
+	if int64(len(b)) != e.size {
+		return nil, kerrors.WithMsg(ErrMalformed, "Content size mismatch")
+	}
+	if checksum(b) != e.crc {
+		return nil, kerrors.WithMsg(ErrMalformed, "Content checksum mismatch")
+	}
+	return b, nil
+}
+
+// parentContent reads the content of e from the parent image
+func (img *image) parentContent(e *entry) ([]byte, error) {
+	if img.parent == nil {
+		return nil, kerrors.WithMsg(ErrParent, fmt.Sprintf("Parent image %s is required", img.parentID))
+	}
+	pe := img.parent.entries[e.name]
+	if pe == nil || pe.mode.Type() != e.mode.Type() {
+		return nil, kerrors.WithMsg(ErrParent, "Parent image is missing the file")
+	}
+	b, err := img.parent.content(pe)
+	if err != nil {
+		return nil, kerrors.WithMsg(err, "Failed reading parent content")
+	}
+	if int64(len(b)) != e.size || checksum(b) != e.crc {
+		return nil, kerrors.WithMsg(ErrParent, "Parent content checksum mismatch")
+	}
+	return b, nil
+}
+
+// resolve returns the image path of p after resolving its symlinks
+func (img *image) resolve(p string, follow bool) (string, error) {
+	var rest []string
+	if p != "." {
+		rest = strings.Split(p, "/")
+	}
+	cur := "."
+	links := 0
+	for len(rest) > 0 {
+		next := rest[0]
+		if cur != "." {
+			next = cur + "/" + rest[0]
+		}
+		rest = rest[1:]
+		e := img.entries[next]
+		if e == nil {
+			return "", kerrors.WithMsg(fs.ErrNotExist, "File does not exist")
+		}
+		if len(rest) > 0 && e.mode.Type() == 0 {
+			return "", kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, "Path component is not a directory")
+		}
+		if e.mode.Type() != fs.ModeSymlink || (len(rest) == 0 && !follow) {
+			cur = next
+			continue
+		}
+		links++
+		if links > maxLinkDepth {
+			return "", kerrors.WithKind(fs.ErrInvalid, kfs.ErrLinkLoop, fmt.Sprintf("Too many links resolving %s", p))
+		}
+		b, err := img.content(e)
+		if err != nil {
+			return "", err
+		}
+		target := path.Join(path.Dir(next), string(b))
+		if path.IsAbs(string(b)) || !fs.ValidPath(target) {
+			return "", kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the image", string(b)))
+		}
+		// resolve the target from the root
+		if target != "." {
+			rest = append(strings.Split(target, "/"), rest...)
+		}
+		cur = "."
+	}
+	return cur, nil
+}
+
+func (r *Reader) lookup(op string, name string, follow bool) (string, *entry, error) {
+	if !fs.ValidPath(name) {
+		return "", nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	full := name
+	if r.dir != "." {
+		full = path.Join(r.dir, name)
+	}
+	p, err := r.img.resolve(full, follow)
+	if err != nil {
+		return "", nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  err,
+		}
+	}
+	if r.dir != "." && p != r.dir && !strings.HasPrefix(p, r.dir+"/") {
+		return "", nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(kfs.ErrTargetOutsideFS, "Link target is outside the sub fs"),
+		}
+	}
+	// the root dir has no entry
+	return p, r.img.entries[p], nil
+}
+
+func (r *Reader) Open(name string) (fs.File, error) {
+	p, e, err := r.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := newFileInfo(name, e)
+	if info.IsDir() {
+		return &dirFile{
+			info:    info,
+			entries: r.img.children[p],
+		}, nil
+	}
+	b, err := r.img.content(e)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return &file{
+		info: info,
+		r:    bytes.NewReader(b),
+	}, nil
+}
+
+func (r *Reader) Stat(name string) (fs.FileInfo, error) {
+	_, e, err := r.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(name, e), nil
+}
+
+func (r *Reader) Lstat(name string) (fs.FileInfo, error) {
+	_, e, err := r.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(name, e), nil
+}
+
+func (r *Reader) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, e, err := r.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil && !e.mode.IsDir() {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, "Not a directory"),
+		}
+	}
+	return dirEntries(r.img.children[p]), nil
+}
+
+func (r *Reader) ReadFile(name string) ([]byte, error) {
+	_, e, err := r.lookup("read", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil || e.mode.IsDir() {
+		return nil, &fs.PathError{
+			Op:   "read",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "Is a directory"),
+		}
+	}
+	b, err := r.img.content(e)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "read",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return b, nil
+}
+
+func (r *Reader) ReadLink(name string) (string, error) {
+	_, e, err := r.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e == nil || e.mode.Type() != fs.ModeSymlink {
+		return "", &fs.PathError{
+			Op:   "readlink",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Not a symlink"),
+		}
+	}
+	b, err := r.img.content(e)
+	if err != nil {
+		return "", &fs.PathError{
+			Op:   "readlink",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return string(b), nil
+}
+
+func (r *Reader) Sub(dir string) (fs.FS, error) {
+	p, e, err := r.lookup("sub", dir, true)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil && !e.mode.IsDir() {
+		return nil, &fs.PathError{
+			Op:   "sub",
+			Path: dir,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, "Not a directory"),
+		}
+	}
+	return &Reader{
+		img: r.img,
+		dir: p,
+	}, nil
+}
+
+type (
+	fileInfo struct {
+		name string
+		e    *entry
+	}
+)
+
+// newFileInfo returns the info of e, or of the root dir if e is nil
+func newFileInfo(name string, e *entry) *fileInfo {
+	return &fileInfo{
+		name: path.Base(name),
+		e:    e,
+	}
+}
+
+func (i *fileInfo) Name() string {
+	return i.name
+}
+
+func (i *fileInfo) Size() int64 {
+	if i.e == nil {
+		return 0
+	}
+	return i.e.size
+}
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.e == nil {
+		return fs.ModeDir | 0o555
+	}
+	return i.e.mode
+}
+
+func (i *fileInfo) ModTime() time.Time {
+	if i.e == nil {
+		return time.Time{}
+	}
+	return i.e.modTime
+}
+
+func (i *fileInfo) IsDir() bool {
+	return i.Mode().IsDir()
+}
+
+func (i *fileInfo) Sys() any {
+	return nil
+}
+
+func dirEntries(entries []*entry) []fs.DirEntry {
+	s := make([]fs.DirEntry, 0, len(entries))
+	for _, i := range entries {
+		s = append(s, fs.FileInfoToDirEntry(newFileInfo(i.name, i)))
+	}
+	return s
+}
+
+type (
+	file struct {
+		info *fileInfo
+		r    *bytes.Reader
+	}
+
+	dirFile struct {
+		info    *fileInfo
+		entries []*entry
+		offset  int
+	}
+)
+
+func (f *file) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	return f.r.ReadAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+func (f *dirFile) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "read",
+		Path: f.info.name,
+		Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "Is a directory"),
+	}
+}
+
+func (f *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := f.entries[f.offset:]
+	if n <= 0 {
+		f.offset = len(f.entries)
+		return dirEntries(rest), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(n, len(rest))]
+	f.offset += len(rest)
+	return dirEntries(rest), nil
+}
+
+func (f *dirFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *dirFile) Close() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package kfsimage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+
+	"xorkevin.dev/kerrors"
+	"xorkevin.dev/kfs"
+)
+
+type (
+	restoredFile struct {
+		name    string
+		mode    fs.FileMode
+		modTime time.Time
+	}
+)
+
+// Restore materializes the files of an image onto dst
+//
+// To restore a snapshot of an incremental chain, r must have its parents, as
+// given by [Reader.WithParent]. Existing regular files are overwritten, and
+// files of dst that are not in the image are kept. Existing symlinks with the
+// same target are kept, and other existing symlinks and regular files at the
+// path of a symlink are replaced, so that an image may be restored onto dst
+// again. Directories are created with [kfs.MkdirTemp] and [kfs.Rename], as
+// [kfs.WriteFS] may not create empty directories. Modes and mod times are
+// applied with [kfs.Chmod] and [kfs.Chtimes] if dst supports them, but not to
+// symlinks.
+func Restore(dst fs.FS, r *Reader) error {
+	var restored []restoredFile
+	if err := fs.WalkDir(r, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		info, err := r.Lstat(p)
+		if err != nil {
+			return err
+		}
+		switch info.Mode().Type() {
+		case fs.ModeDir:
+			if err := restoreDir(dst, p); err != nil {
+				return err
+			}
+		case fs.ModeSymlink:
+			target, err := r.ReadLink(p)
+			if err != nil {
+				return err
+			}
+			return restoreSymlink(dst, p, target)
+		default:
+			b, err := r.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if err := kfs.WriteFile(dst, p, b, info.Mode().Perm()); err != nil {
+				return kerrors.WithMsg(err, "Failed to restore file")
+			}
+		}
+		restored = append(restored, restoredFile{
+			name:    p,
+			mode:    info.Mode(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	}); err != nil {
+		return err
+	}
+	// children are restored after their parents, so apply metadata in
+	// reverse to avoid changing the mod times of restored dirs
+	for n := len(restored) - 1; n >= 0; n-- {
+		i := restored[n]
+		if err := kfs.Chmod(dst, i.name, i.mode.Perm()); err != nil && !errors.Is(err, kfs.ErrNotImplemented) {
+			return kerrors.WithMsg(err, "Failed to restore file mode")
+		}
+		if err := kfs.Chtimes(dst, i.name, i.modTime, i.modTime); err != nil && !errors.Is(err, kfs.ErrNotImplemented) {
+			return kerrors.WithMsg(err, "Failed to restore file mod time")
+		}
+	}
+	return nil
+}
+
+func restoreSymlink(dst fs.FS, name string, target string) error {
+	info, err := kfs.Lstat(dst, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return kerrors.WithMsg(err, "Failed to stat symlink")
+	}
+	if err == nil {
+		switch info.Mode().Type() {
+		case fs.ModeSymlink:
+			existing, err := kfs.ReadLink(dst, name)
+			if err != nil {
+				return kerrors.WithMsg(err, "Failed to read symlink")
+			}
+			if existing == target {
+				return nil
+			}
+		case 0:
+		default:
+			return &fs.PathError{
+				Op:   "restore",
+				Path: name,
+				Err:  kerrors.WithMsg(fs.ErrExist, "File exists and is not a symlink or regular file"),
+			}
+		}
+		if err := kfs.Remove(dst, name); err != nil {
+			return kerrors.WithMsg(err, "Failed to replace symlink")
+		}
+	}
+	if err := kfs.Symlink(dst, target, name); err != nil {
+		return kerrors.WithMsg(err, "Failed to restore symlink")
+	}
+	return nil
+}
+
+func restoreDir(dst fs.FS, name string) error {
+	info, err := fs.Stat(dst, name)
+	if err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{
+				Op:   "restore",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, "File exists and is not a directory"),
+			}
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return kerrors.WithMsg(err, "Failed to stat dir")
+	}
+	tmp, err := kfs.MkdirTemp(dst, path.Dir(name), fmt.Sprintf(".%s.restore-*", path.Base(name)))
+	if err != nil {
+		return kerrors.WithMsg(err, "Failed to create dir")
+	}
+	if err := kfs.Rename(dst, tmp, name); err != nil {
+		err = kerrors.WithMsg(err, "Failed to create dir")
+		if rmErr := kfs.Remove(dst, tmp); rmErr != nil {
+			err = errors.Join(err, kerrors.WithMsg(rmErr, "Failed to remove temp dir"))
+		}
+		return err
+	}
+	return nil
+}
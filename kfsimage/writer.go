@@ -0,0 +1,338 @@
+package kfsimage
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"xorkevin.dev/kerrors"
+	"xorkevin.dev/kfs"
+)
+
+type (
+	// WriterOpt is an option for [NewWriter]
+	WriterOpt = func(o *writerOpts)
+
+	writerOpts struct {
+		compress bool
+		level    int
+		parent   *Reader
+	}
+
+	// Writer writes an image
+	//
+	// Entries must be added after their parent directory. The image is
+	// complete once the Writer is closed.
+	Writer struct {
+		w       io.Writer
+		opts    writerOpts
+		header  []byte
+		offset  int64
+		entries []*entry
+		modes   map[string]fs.FileMode
+		closed  bool
+	}
+)
+
+// WriterCompress returns a [WriterOpt] that compresses content blocks with
+// DEFLATE at a [compress/flate] level
+//
+// A block is only stored compressed if that is smaller.
+func WriterCompress(level int) WriterOpt {
+	return func(o *writerOpts) {
+		o.compress = true
+		o.level = level
+	}
+}
+
+// WriterParent returns a [WriterOpt] that writes an incremental image of
+// parent
+//
+// The content of a file is not stored if it is the same as the file of the
+// same name and type in parent. If parent is itself incremental, it must
+// already have its own parent. Reading such files requires the parent, as
+// given to [Reader.WithParent].
+func WriterParent(parent *Reader) WriterOpt {
+	return func(o *writerOpts) {
+		o.parent = parent
+	}
+}
+
+// NewWriter creates a new [Writer] that writes an image to w
+//
+// NewWriter panics if the compression level is invalid.
+func NewWriter(w io.Writer, opts ...WriterOpt) *Writer {
+	o := writerOpts{
+		compress: false,
+		level:    flate.DefaultCompression,
+	}
+	for _, i := range opts {
+		i(&o)
+	}
+	if o.level < flate.HuffmanOnly || o.level > flate.BestCompression {
+		panic(fmt.Sprintf("kfsimage: invalid compression level %d", o.level))
+	}
+	header := make([]byte, 0, headerSize+len(ID{}))
+	header = append(header, headerMagic...)
+	header = append(header, formatVersion)
+	if o.parent != nil {
+		parentID := o.parent.ID()
+		header = append(header, headerFlagIncremental)
+		header = append(header, parentID[:]...)
+	} else {
+		header = append(header, 0)
+	}
+	return &Writer{
+		w:      w,
+		opts:   o,
+		header: header,
+		modes:  map[string]fs.FileMode{},
+	}
+}
+
+func (w *Writer) write(b []byte) error {
+	if w.offset == 0 {
+		if _, err := w.w.Write(w.header); err != nil {
+			return kerrors.WithMsg(err, "Failed writing image header")
+		}
+		w.offset = int64(len(w.header))
+	}
+	if _, err := w.w.Write(b); err != nil {
+		return kerrors.WithMsg(err, "Failed writing image")
+	}
+	w.offset += int64(len(b))
+	return nil
+}
+
+func (w *Writer) add(name string, mode fs.FileMode, modTime time.Time, data []byte) error {
+	if w.closed {
+		return &fs.PathError{
+			Op:   "add",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrClosed, "Writer is closed"),
+		}
+	}
+	if !fs.ValidPath(name) || name == "." || len(name) > maxNameLen {
+		return &fs.PathError{
+			Op:   "add",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	if _, ok := w.modes[name]; ok {
+		return &fs.PathError{
+			Op:   "add",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrExist, "File already added"),
+		}
+	}
+	if dir := path.Dir(name); dir != "." {
+		if m, ok := w.modes[dir]; !ok || !m.IsDir() {
+			return &fs.PathError{
+				Op:   "add",
+				Path: name,
+				Err:  kerrors.WithMsg(fs.ErrNotExist, fmt.Sprintf("Parent %s has not been added as a directory", dir)),
+			}
+		}
+	}
+	e := &entry{
+		name:    name,
+		mode:    mode,
+		modTime: modTime,
+		size:    int64(len(data)),
+		crc:     checksum(data),
+	}
+	if !mode.IsDir() {
+		inParent, err := w.inParent(name, mode, data)
+		if err != nil {
+			return &fs.PathError{
+				Op:   "add",
+				Path: name,
+				Err:  err,
+			}
+		}
+		if inParent {
+			e.inParent = true
+			w.entries = append(w.entries, e)
+			w.modes[name] = mode
+			return nil
+		}
+		block := data
+		if w.opts.compress && len(data) > 0 {
+			var b bytes.Buffer
+			// the level is checked by NewWriter
+			c, _ := flate.NewWriter(&b, w.opts.level)
+			if _, err := c.Write(data); err != nil {
+				return &fs.PathError{
+					Op:   "add",
+					Path: name,
+					Err:  kerrors.WithMsg(err, "Failed compressing file"),
+				}
+			}
+			if err := c.Close(); err != nil {
+				return &fs.PathError{
+					Op:   "add",
+					Path: name,
+					Err:  kerrors.WithMsg(err, "Failed compressing file"),
+				}
+			}
+			if b.Len() < len(data) {
+				block = b.Bytes()
+				e.compressed = true
+			}
+		}
+		if err := w.write(block); err != nil {
+			return &fs.PathError{
+				Op:   "add",
+				Path: name,
+				Err:  err,
+			}
+		}
+		e.offset = w.offset - int64(len(block))
+		e.storedSize = int64(len(block))
+	}
+	w.entries = append(w.entries, e)
+	w.modes[name] = mode
+	return nil
+}
+
+// inParent returns whether the content of a file is the same as that of the
+// file of the same name in the parent image
+func (w *Writer) inParent(name string, mode fs.FileMode, data []byte) (bool, error) {
+	if w.opts.parent == nil {
+		return false, nil
+	}
+	pe := w.opts.parent.img.entries[name]
+	if pe == nil || pe.mode.Type() != mode.Type() || pe.size != int64(len(data)) || pe.crc != checksum(data) {
+		return false, nil
+	}
+	b, err := w.opts.parent.img.content(pe)
+	if err != nil {
+		return false, kerrors.WithMsg(err, "Failed reading parent content")
+	}
+	return bytes.Equal(b, data), nil
+}
+
+// AddDir adds a directory
+//
+// Only the permission bits of mode are used.
+func (w *Writer) AddDir(name string, mode fs.FileMode, modTime time.Time) error {
+	return w.add(name, fs.ModeDir|mode.Perm(), modTime, nil)
+}
+
+// AddFile adds a regular file with the contents of r
+//
+// The type bits of mode are ignored.
+func (w *Writer) AddFile(name string, mode fs.FileMode, modTime time.Time, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return &fs.PathError{
+			Op:   "add",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed reading file"),
+		}
+	}
+	return w.add(name, mode&^fs.ModeType, modTime, data)
+}
+
+// AddSymlink adds a symlink to target
+//
+// Like [kfs.ReadLinkFS], target is relative to the directory of the link, and
+// must be a path inside the image.
+func (w *Writer) AddSymlink(name string, target string, modTime time.Time) error {
+	if target == "" || path.IsAbs(target) || !fs.ValidPath(path.Join(path.Dir(name), target)) {
+		return &fs.PathError{
+			Op:   "add",
+			Path: name,
+			Err:  kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the image", target)),
+		}
+	}
+	return w.add(name, fs.ModeSymlink|0o777, modTime, []byte(target))
+}
+
+// AddFS adds all files of fsys
+//
+// Symlinks are added as symlinks if fsys implements [kfs.LstatFS] and
+// [kfs.ReadLinkFS], and are otherwise followed. Files that are neither
+// regular files, directories, nor symlinks may not be added.
+func (w *Writer) AddFS(fsys fs.FS) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		info, err := kfs.Lstat(fsys, p)
+		if errors.Is(err, kfs.ErrNotImplemented) {
+			info, err = fs.Stat(fsys, p)
+		}
+		if err != nil {
+			return kerrors.WithMsg(err, "Failed to stat file")
+		}
+		switch info.Mode().Type() {
+		case fs.ModeDir:
+			return w.AddDir(p, info.Mode(), info.ModTime())
+		case fs.ModeSymlink:
+			target, err := kfs.ReadLink(fsys, p)
+			if err != nil {
+				return kerrors.WithMsg(err, "Failed to read link")
+			}
+			return w.AddSymlink(p, target, info.ModTime())
+		case 0:
+			return w.addFSFile(fsys, p, info)
+		default:
+			return &fs.PathError{
+				Op:   "add",
+				Path: p,
+				Err:  kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Unsupported file type %s", info.Mode().Type())),
+			}
+		}
+	})
+}
+
+func (w *Writer) addFSFile(fsys fs.FS, name string, info fs.FileInfo) (retErr error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return kerrors.WithMsg(err, "Failed to open file")
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			retErr = errors.Join(retErr, kerrors.WithMsg(err, "Failed to close file"))
+		}
+	}()
+	return w.AddFile(name, info.Mode(), info.ModTime(), f)
+}
+
+// Close writes the index and trailer of the image
+//
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return kerrors.WithMsg(fs.ErrClosed, "Writer is closed")
+	}
+	w.closed = true
+	size := 0
+	for _, i := range w.entries {
+		size += entryFixedSize + len(i.name)
+	}
+	index := make([]byte, 0, size)
+	for _, i := range w.entries {
+		index = i.appendEncoded(index)
+	}
+	if err := w.write(index); err != nil {
+		return err
+	}
+	trailer := make([]byte, 0, trailerSize)
+	trailer = binary.BigEndian.AppendUint64(trailer, uint64(w.offset-int64(len(index))))
+	trailer = binary.BigEndian.AppendUint64(trailer, uint64(len(index)))
+	trailer = binary.BigEndian.AppendUint32(trailer, checksum(index))
+	trailer = append(trailer, trailerMagic...)
+	return w.write(trailer)
+}
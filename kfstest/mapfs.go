@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"testing/fstest"
 	"time"
 
@@ -17,12 +18,130 @@ import (
 type (
 	// MapFS is an in-memory [kfs.FS]
 	MapFS struct {
-		Fsys fstest.MapFS
+		Fsys    fstest.MapFS
+		modTime time.Time
+		stats   *kfs.FSStats
+		// atimes are the access times of files, which [fstest.MapFile] does not
+		// have a field for
+		atimes map[*fstest.MapFile]time.Time
+		// pipes are the states of named pipes, which may be opened
+		// concurrently
+		pipesMu sync.Mutex
+		pipes   map[*fstest.MapFile]*mapPipe
+	}
+
+	// Owner is the simulated owner of a file in a [MapFS]
+	Owner struct {
+		UID int
+		GID int
 	}
 )
 
+// NewMapFS creates a new empty [MapFS]
+//
+// Entries added with the With methods share the creation time of the
+// [MapFS] as their mod time.
+func NewMapFS() *MapFS {
+	return &MapFS{
+		Fsys:    fstest.MapFS{},
+		modTime: time.Now(),
+	}
+}
+
+func (m *MapFS) fixtureModTime() time.Time {
+	if m.modTime.IsZero() {
+		m.modTime = time.Now()
+	}
+	return m.modTime
+}
+
+func (m *MapFS) withEntry(name string, f *fstest.MapFile) *MapFS {
+	if !fs.ValidPath(name) {
+		panic(fmt.Sprintf("kfstest: invalid fixture path %q", name))
+	}
+	if m.Fsys == nil {
+		m.Fsys = fstest.MapFS{}
+	}
+	f.ModTime = m.fixtureModTime()
+	m.Fsys[name] = f
+	return m
+}
+
+// WithFile adds a regular file and returns m
+//
+// WithFile panics if name is not a valid path.
+func (m *MapFS) WithFile(name string, data []byte, mode fs.FileMode) *MapFS {
+	return m.withEntry(name, &fstest.MapFile{
+		Data: data,
+		Mode: mode.Perm(),
+	})
+}
+
+// WithDir adds a directory with mode 0o755 and returns m
+//
+// WithDir panics if name is not a valid path.
+func (m *MapFS) WithDir(name string) *MapFS {
+	return m.withEntry(name, &fstest.MapFile{
+		Mode: fs.ModeDir | 0o755,
+	})
+}
+
+// WithSymlink adds a symlink at name to target and returns m
+//
+// Like [kfs.ReadLinkFS], target is relative to the directory of the link.
+// WithSymlink panics if name is not a valid path.
+func (m *MapFS) WithSymlink(name string, target string) *MapFS {
+	return m.withEntry(name, &fstest.MapFile{
+		Data: []byte(target),
+		Mode: fs.ModeSymlink | 0o777,
+	})
+}
+
+// WithSymlinkLoop adds a cycle of symlinks in which each of names links to the
+// next, and the last links to the first, and returns m
+//
+// A single name is added as a link to itself. Resolving any of names fails
+// with [kfs.ErrLinkLoop]. WithSymlinkLoop panics if a name is not a valid
+// path.
+func (m *MapFS) WithSymlinkLoop(names ...string) *MapFS {
+	for n, i := range names {
+		m.WithSymlink(i, relLinkTarget(i, names[(n+1)%len(names)]))
+	}
+	return m
+}
+
+// WithStatfs sets the stats returned by [MapFS.Statfs] and returns m
+func (m *MapFS) WithStatfs(stats kfs.FSStats) *MapFS {
+	m.stats = &stats
+	return m
+}
+
+// relLinkTarget returns the valid path target relative to the directory of
+// the link at name
+func relLinkTarget(name, target string) string {
+	var dir []string
+	if d := path.Dir(name); d != "." {
+		dir = strings.Split(d, "/")
+	}
+	rest := strings.Split(target, "/")
+	n := 0
+	for n < len(dir) && n < len(rest) && dir[n] == rest[n] {
+		n++
+	}
+	rel := path.Join(strings.Repeat("../", len(dir)-n), strings.Join(rest[n:], "/"))
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
 const (
 	rwFlagMask = os.O_RDONLY | os.O_WRONLY | os.O_RDWR
+	// maxLinkDepth is the max number of symlinks followed in resolving a path
+	maxLinkDepth = 40
+	// maxTempAttempts is the max number of names tried for a temporary file,
+	// as for [kfs.CreateTemp]
+	maxTempAttempts = 10000
 )
 
 func isReadWrite(flag int) (bool, bool) {
@@ -38,19 +157,119 @@ func isReadWrite(flag int) (bool, bool) {
 	}
 }
 
+// resolve returns the valid path p with symlinks resolved in all but the last
+// path component, and also in the last if follow is true
+//
+// Unlike [fstest.MapFS], which recurses without bound on a cycle of symlinks,
+// resolve fails with [kfs.ErrLinkLoop] after following maxLinkDepth links.
+func (m *MapFS) resolve(p string, follow bool) (string, error) {
+	var rest []string
+	if p != "." {
+		rest = strings.Split(p, "/")
+	}
+	cur := "."
+	links := 0
+	for len(rest) > 0 {
+		next := rest[0]
+		if cur != "." {
+			next = cur + "/" + rest[0]
+		}
+		rest = rest[1:]
+		f := m.Fsys[next]
+		if f == nil || f.Mode.Type() != fs.ModeSymlink || (len(rest) == 0 && !follow) {
+			cur = next
+			continue
+		}
+		links++
+		if links > maxLinkDepth {
+			return "", kerrors.WithKind(fs.ErrInvalid, kfs.ErrLinkLoop, fmt.Sprintf("Too many links resolving %s", p))
+		}
+		target := string(f.Data)
+		if path.IsAbs(target) {
+			return "", kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is absolute", target))
+		}
+		target = path.Join(path.Dir(next), target)
+		if !fs.ValidPath(target) {
+			return "", kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the FS", target))
+		}
+		// resolve the target from the root
+		if target != "." {
+			rest = append(strings.Split(target, "/"), rest...)
+		}
+		cur = "."
+	}
+	return cur, nil
+}
+
+// checkLinks returns an error if name is invalid or its symlinks may not be
+// resolved
+func (m *MapFS) checkLinks(op string, name string, follow bool) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	if _, err := m.resolve(name, follow); err != nil {
+		return &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
 func (m *MapFS) Open(name string) (fs.File, error) {
+	if err := m.checkLinks("open", name, true); err != nil {
+		return nil, err
+	}
+	if f := m.namedPipe(name); f != nil {
+		return m.openPipe(name, f, false), nil
+	}
 	return m.Fsys.Open(name)
 }
 
+// namedPipe returns the named pipe at the valid path name, or nil if it is not
+// a named pipe
+func (m *MapFS) namedPipe(name string) *fstest.MapFile {
+	p, err := m.resolve(name, true)
+	if err != nil {
+		return nil
+	}
+	f := m.Fsys[p]
+	if f == nil || f.Mode.Type() != fs.ModeNamedPipe {
+		return nil
+	}
+	return f
+}
+
 func (m *MapFS) Stat(name string) (fs.FileInfo, error) {
+	if err := m.checkLinks("stat", name, true); err != nil {
+		return nil, err
+	}
 	return fs.Stat(m.Fsys, name)
 }
 
 func (m *MapFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := m.checkLinks("readdir", name, true); err != nil {
+		return nil, err
+	}
+	if info, err := fs.Stat(m.Fsys, name); err == nil && !info.IsDir() {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, "File is not a directory"),
+		}
+	}
 	return fs.ReadDir(m.Fsys, name)
 }
 
 func (m *MapFS) ReadFile(name string) ([]byte, error) {
+	if err := m.checkLinks("readfile", name, true); err != nil {
+		return nil, err
+	}
 	return fs.ReadFile(m.Fsys, name)
 }
 
@@ -82,12 +301,8 @@ func (m *MapFS) FullFilePath(name string) (string, error) {
 }
 
 func (m *MapFS) OpenFile(name string, flag int, mode fs.FileMode) (kfs.File, error) {
-	if !fs.ValidPath(name) {
-		return nil, &fs.PathError{
-			Op:   "openfile",
-			Path: name,
-			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
-		}
+	if err := m.checkLinks("openfile", name, true); err != nil {
+		return nil, err
 	}
 
 	isRead, isWrite := isReadWrite(flag)
@@ -125,7 +340,54 @@ func (m *MapFS) OpenFile(name string, flag int, mode fs.FileMode) (kfs.File, err
 		}
 	}
 
-	f := m.Fsys[name]
+	if f := m.namedPipe(name); f != nil {
+		if flag&os.O_EXCL != 0 {
+			return nil, &fs.PathError{
+				Op:   "openfile",
+				Path: name,
+				Err:  kerrors.WithMsg(fs.ErrExist, "File already exists"),
+			}
+		}
+		return m.openPipe(name, f, isWrite), nil
+	}
+
+	// files are opened at the target of a symlink, except that excl fails on
+	// an existing symlink, as on the os
+	p, err := m.resolve(name, flag&os.O_EXCL == 0)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "openfile",
+			Path: name,
+			Err:  err,
+		}
+	}
+
+	if info, err := fs.Stat(m.Fsys, p); err == nil && info.IsDir() {
+		if flag&os.O_EXCL != 0 {
+			return nil, &fs.PathError{
+				Op:   "openfile",
+				Path: name,
+				Err:  kerrors.WithMsg(fs.ErrExist, "File already exists"),
+			}
+		}
+		if isWrite || flag&(os.O_TRUNC|os.O_APPEND) != 0 {
+			return nil, &fs.PathError{
+				Op:   "openfile",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "File is a directory"),
+			}
+		}
+		d, err := m.Fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &mapDir{
+			ReadDirFile: d.(fs.ReadDirFile),
+			path:        name,
+		}, nil
+	}
+
+	f := m.Fsys[p]
 	if f == nil {
 		if flag&os.O_CREATE == 0 {
 			return nil, &fs.PathError{
@@ -134,6 +396,15 @@ func (m *MapFS) OpenFile(name string, flag int, mode fs.FileMode) (kfs.File, err
 				Err:  kerrors.WithMsg(fs.ErrNotExist, "File does not exist"),
 			}
 		}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if info, err := fs.Stat(m.Fsys, dir); err == nil && !info.IsDir() {
+				return nil, &fs.PathError{
+					Op:   "openfile",
+					Path: name,
+					Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, fmt.Sprintf("Parent %s is not a directory", dir)),
+				}
+			}
+		}
 
 		f = &fstest.MapFile{
 			Data:    nil,
@@ -179,9 +450,7 @@ func (m *MapFS) OpenFile(name string, flag int, mode fs.FileMode) (kfs.File, err
 	var b *bytes.Buffer
 	if isWrite {
 		b = &bytes.Buffer{}
-		if end {
-			b.Write(f.Data)
-		}
+		b.Write(f.Data)
 	}
 
 	return &mapFile{
@@ -189,10 +458,11 @@ func (m *MapFS) OpenFile(name string, flag int, mode fs.FileMode) (kfs.File, err
 			name: path.Base(name),
 			f:    f,
 		},
-		path: name,
-		r:    r,
-		b:    b,
-		fsys: m,
+		path:   p,
+		r:      r,
+		b:      b,
+		append: end,
+		fsys:   m,
 	}, nil
 }
 
@@ -205,7 +475,16 @@ func (m *MapFS) Lstat(name string) (fs.FileInfo, error) {
 		}
 	}
 
-	// fs.MapFS does not follow symlinks
+	// look up the file directly, since fstest.MapFS may follow symlinks
+	if f, ok := m.Fsys[name]; ok {
+		return &mapFileInfo{
+			name: path.Base(name),
+			f:    f,
+		}, nil
+	}
+	if err := m.checkLinks("lstat", name, false); err != nil {
+		return nil, err
+	}
 	return fs.Stat(m.Fsys, name)
 }
 
@@ -218,25 +497,31 @@ func (m *MapFS) ReadLink(name string) (string, error) {
 		}
 	}
 
-	if f, ok := m.Fsys[name]; ok {
-		if f.Mode.Type()&fs.ModeSymlink != 0 {
-			target := string(f.Data)
-			if path.IsAbs(target) {
-				return "", &fs.PathError{
-					Op:   "readlink",
-					Path: name,
-					Err:  kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is absolute", target)),
-				}
+	f, ok := m.Fsys[name]
+	if !ok {
+		return "", &fs.PathError{
+			Op:   "readlink",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrNotExist, "File does not exist"),
+		}
+	}
+	if f.Mode.Type()&fs.ModeSymlink != 0 {
+		target := string(f.Data)
+		if path.IsAbs(target) {
+			return "", &fs.PathError{
+				Op:   "readlink",
+				Path: name,
+				Err:  kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is absolute", target)),
 			}
-			if !fs.ValidPath(path.Join(path.Dir(name), target)) {
-				return "", &fs.PathError{
-					Op:   "readlink",
-					Path: name,
-					Err:  kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the FS", target)),
-				}
+		}
+		if !fs.ValidPath(path.Join(path.Dir(name), target)) {
+			return "", &fs.PathError{
+				Op:   "readlink",
+				Path: name,
+				Err:  kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the FS", target)),
 			}
-			return target, nil
 		}
+		return target, nil
 	}
 
 	return "", &fs.PathError{
@@ -246,6 +531,62 @@ func (m *MapFS) ReadLink(name string) (string, error) {
 	}
 }
 
+// Symlink implements [kfs.MakeSymlinkFS]
+func (m *MapFS) Symlink(oldname, newname string) error {
+	if !fs.ValidPath(newname) || oldname == "" {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	if path.IsAbs(oldname) {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is absolute", oldname)),
+		}
+	}
+	if !fs.ValidPath(path.Join(path.Dir(newname), oldname)) {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the FS", oldname)),
+		}
+	}
+
+	if _, err := m.Lstat(newname); err == nil {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrExist, "File already exists"),
+		}
+	}
+	for dir := path.Dir(newname); dir != "."; dir = path.Dir(dir) {
+		if info, err := m.Stat(dir); err == nil && !info.IsDir() {
+			return &os.LinkError{
+				Op:  "symlink",
+				Old: oldname,
+				New: newname,
+				Err: kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, fmt.Sprintf("Parent %s is not a directory", dir)),
+			}
+		}
+	}
+	if m.Fsys == nil {
+		m.Fsys = fstest.MapFS{}
+	}
+	m.Fsys[newname] = &fstest.MapFile{
+		Data:    []byte(oldname),
+		Mode:    fs.ModeSymlink | 0o777,
+		ModTime: time.Now(),
+	}
+	return nil
+}
+
 func (m *MapFS) Remove(name string) error {
 	if !fs.ValidPath(name) {
 		return &fs.PathError{
@@ -287,29 +628,509 @@ func (m *MapFS) RemoveAll(name string) error {
 	return nil
 }
 
+// Rename implements [kfs.RenameFS]
+//
+// Renaming a dir moves all of its descendants.
+func (m *MapFS) Rename(oldname, newname string) error {
+	if !fs.ValidPath(oldname) || !fs.ValidPath(newname) {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	if oldname == "." || newname == "." {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrInvalid, "May not rename the root dir"),
+		}
+	}
+
+	info, err := m.Lstat(oldname)
+	if err != nil {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrNotExist, "File does not exist"),
+		}
+	}
+	if oldname == newname {
+		return nil
+	}
+	if info.IsDir() && strings.HasPrefix(newname, oldname+"/") {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrInvalid, "May not move a dir into itself"),
+		}
+	}
+	if target, err := m.Lstat(newname); err == nil {
+		if target.IsDir() {
+			if !info.IsDir() {
+				return &os.LinkError{
+					Op:  "rename",
+					Old: oldname,
+					New: newname,
+					Err: kerrors.WithKind(fs.ErrExist, kfs.ErrIsDir, "Target is a directory"),
+				}
+			}
+			for k := range m.Fsys {
+				if strings.HasPrefix(k, newname+"/") {
+					return &os.LinkError{
+						Op:  "rename",
+						Old: oldname,
+						New: newname,
+						Err: kerrors.WithKind(fs.ErrExist, kfs.ErrDirNotEmpty, "Directory is not empty"),
+					}
+				}
+			}
+		} else if info.IsDir() {
+			return &os.LinkError{
+				Op:  "rename",
+				Old: oldname,
+				New: newname,
+				Err: kerrors.WithKind(fs.ErrExist, kfs.ErrNotDir, "Target is not a directory"),
+			}
+		}
+	}
+
+	moved := fstest.MapFS{}
+	for k, v := range m.Fsys {
+		if k == oldname {
+			moved[newname] = v
+		} else if rest, ok := strings.CutPrefix(k, oldname+"/"); ok {
+			moved[newname+"/"+rest] = v
+		}
+	}
+	delete(m.Fsys, oldname)
+	delete(m.Fsys, newname)
+	for k := range m.Fsys {
+		if strings.HasPrefix(k, oldname+"/") {
+			delete(m.Fsys, k)
+		}
+	}
+	for k, v := range moved {
+		m.Fsys[k] = v
+	}
+	return nil
+}
+
+// Chtimes implements [kfs.ChtimesFS]
+//
+// The access time is returned by [MapFS.ATime]. A zero time leaves that time
+// unchanged.
 func (m *MapFS) Chtimes(name string, atime, mtime time.Time) error {
+	f, err := m.entry("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	m.chtimes(f, atime, mtime)
+	return nil
+}
+
+// Lchtimes implements [kfs.LchtimesFS]
+//
+// Times are set as with [MapFS.Chtimes].
+func (m *MapFS) Lchtimes(name string, atime, mtime time.Time) error {
+	f, err := m.entry("lchtimes", name, false)
+	if err != nil {
+		return err
+	}
+	m.chtimes(f, atime, mtime)
+	return nil
+}
+
+func (m *MapFS) chtimes(f *fstest.MapFile, atime, mtime time.Time) {
+	if !atime.IsZero() {
+		if m.atimes == nil {
+			m.atimes = map[*fstest.MapFile]time.Time{}
+		}
+		m.atimes[f] = atime
+	}
+	if !mtime.IsZero() {
+		f.ModTime = mtime
+	}
+}
+
+// ATime returns the access time of a file without following symlinks
+//
+// The access time is that set by [MapFS.Chtimes] or [MapFS.Lchtimes], and is
+// otherwise the mod time of the file, as reads do not change it.
+func (m *MapFS) ATime(name string) (time.Time, error) {
+	f, err := m.entry("atime", name, false)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if t, ok := m.atimes[f]; ok {
+		return t, nil
+	}
+	return f.ModTime, nil
+}
+
+// entry returns the stored file at name, adding an entry for an implicit dir
+// of [fstest.MapFS] so that its metadata may be changed
+//
+// Symlinks in all but the last path component are followed, and also in the
+// last if follow is true.
+func (m *MapFS) entry(op string, name string, follow bool) (*fstest.MapFile, error) {
 	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	p, err := m.resolve(name, follow)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  err,
+		}
+	}
+	if f := m.Fsys[p]; f != nil {
+		return f, nil
+	}
+	if info, err := fs.Stat(m.Fsys, p); err == nil && info.IsDir() {
+		f := &fstest.MapFile{
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}
+		if m.Fsys == nil {
+			m.Fsys = fstest.MapFS{}
+		}
+		m.Fsys[p] = f
+		return f, nil
+	}
+	return nil, &fs.PathError{
+		Op:   op,
+		Path: name,
+		Err:  kerrors.WithMsg(fs.ErrNotExist, "File does not exist"),
+	}
+}
+
+// Chmod implements [kfs.ChmodFS]
+//
+// Only the permission bits of mode are used.
+func (m *MapFS) Chmod(name string, mode fs.FileMode) error {
+	f, err := m.entry("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	f.Mode = f.Mode&^fs.ModePerm | mode.Perm()
+	return nil
+}
+
+// resizeData returns a copy of data truncated or zero extended to size
+func resizeData(data []byte, size int64) []byte {
+	b := make([]byte, size)
+	copy(b, data)
+	return b
+}
+
+// Truncate implements [kfs.TruncateFS]
+func (m *MapFS) Truncate(name string, size int64) error {
+	f, err := m.entry("truncate", name, true)
+	if err != nil {
+		return err
+	}
+	if f.Mode.IsDir() {
 		return &fs.PathError{
-			Op:   "chtimes",
+			Op:   "truncate",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "File is a directory"),
+		}
+	}
+	if size < 0 {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Negative size"),
+		}
+	}
+	f.Data = resizeData(f.Data, size)
+	f.ModTime = time.Now()
+	return nil
+}
+
+func chownFile(f *fstest.MapFile, uid, gid int) {
+	owner := Owner{}
+	if o, ok := f.Sys.(*Owner); ok {
+		owner = *o
+	}
+	if uid != -1 {
+		owner.UID = uid
+	}
+	if gid != -1 {
+		owner.GID = gid
+	}
+	f.Sys = &owner
+}
+
+// Chown implements [kfs.ChownFS]
+//
+// The owner is stored as an [*Owner] in the Sys field of the file, which is
+// returned by the Sys method of its [fs.FileInfo].
+func (m *MapFS) Chown(name string, uid, gid int) error {
+	f, err := m.entry("chown", name, true)
+	if err != nil {
+		return err
+	}
+	chownFile(f, uid, gid)
+	return nil
+}
+
+// Lchown implements [kfs.LchownFS]
+//
+// The owner is stored as with [MapFS.Chown].
+func (m *MapFS) Lchown(name string, uid, gid int) error {
+	f, err := m.entry("lchown", name, false)
+	if err != nil {
+		return err
+	}
+	chownFile(f, uid, gid)
+	return nil
+}
+
+// Mkfifo implements [kfs.MkfifoFS]
+//
+// The pipe is simulated in memory. As for a real named pipe, opening one end
+// blocks until the other end is opened, reads block until data is written,
+// and reads return [io.EOF] once all writers have closed the pipe. Writes
+// fail with [io.ErrClosedPipe] once all readers have closed the pipe, but are
+// otherwise buffered without bound and do not block. A pipe may not be opened
+// for both reading and writing.
+func (m *MapFS) Mkfifo(name string, mode fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "mkfifo",
 			Path: name,
 			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
 		}
 	}
 
-	f := m.Fsys[name]
-	if f == nil {
+	if _, ok := m.Fsys[name]; ok {
 		return &fs.PathError{
-			Op:   "chtimes",
+			Op:   "mkfifo",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrExist, "File already exists"),
+		}
+	}
+	if m.Fsys == nil {
+		m.Fsys = fstest.MapFS{}
+	}
+	m.Fsys[name] = &fstest.MapFile{
+		Mode:    fs.ModeNamedPipe | mode.Perm(),
+		ModTime: time.Now(),
+	}
+	return nil
+}
+
+// WriteFileIfMatch implements [kfs.ConditionalWriteFS]
+func (m *MapFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "writefileifmatch",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+
+	f, ok := m.Fsys[name]
+	if token == "" {
+		if ok {
+			return &fs.PathError{
+				Op:   "writefileifmatch",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrExist, kfs.ErrPreconditionFailed, "File already exists"),
+			}
+		}
+		if m.Fsys == nil {
+			m.Fsys = fstest.MapFS{}
+		}
+		m.Fsys[name] = &fstest.MapFile{
+			Data:    bytes.Clone(data),
+			Mode:    perm.Perm(),
+			ModTime: time.Now(),
+		}
+		return nil
+	}
+	if !ok {
+		return &fs.PathError{
+			Op:   "writefileifmatch",
 			Path: name,
 			Err:  kerrors.WithMsg(fs.ErrNotExist, "File does not exist"),
 		}
 	}
-	if mtime != (time.Time{}) {
-		f.ModTime = mtime
+	if !f.Mode.IsRegular() {
+		return &fs.PathError{
+			Op:   "writefileifmatch",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "File is not a regular file"),
+		}
+	}
+	if kfs.FileToken(&mapFileInfo{name: path.Base(name), f: f}) != token {
+		return &fs.PathError{
+			Op:   "writefileifmatch",
+			Path: name,
+			Err:  kerrors.WithKind(nil, kfs.ErrPreconditionFailed, "File token does not match"),
+		}
+	}
+	m.replaceFile(name, &fstest.MapFile{
+		Data:    bytes.Clone(data),
+		Mode:    f.Mode,
+		ModTime: time.Now(),
+	})
+	return nil
+}
+
+// replaceFile stores file at name, keeping the owner and access time of the
+// file it replaces
+func (m *MapFS) replaceFile(name string, file *fstest.MapFile) {
+	if prev := m.Fsys[name]; prev != nil {
+		file.Sys = prev.Sys
+		if t, ok := m.atimes[prev]; ok {
+			delete(m.atimes, prev)
+			m.atimes[file] = t
+		}
+	}
+	if m.Fsys == nil {
+		m.Fsys = fstest.MapFS{}
+	}
+	m.Fsys[name] = file
+}
+
+// checkTempDir returns an error if a temporary file may not be created in dir
+func (m *MapFS) checkTempDir(op string, dir string) error {
+	if !fs.ValidPath(dir) {
+		return &fs.PathError{
+			Op:   op,
+			Path: dir,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	for p := dir; p != "."; p = path.Dir(p) {
+		if info, err := m.Stat(p); err == nil && !info.IsDir() {
+			return &fs.PathError{
+				Op:   op,
+				Path: dir,
+				Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, fmt.Sprintf("Parent %s is not a directory", p)),
+			}
+		}
+	}
+	return nil
+}
+
+// MkdirTemp implements [kfs.TempFS]
+func (m *MapFS) MkdirTemp(dir, pattern string) (string, error) {
+	if err := m.checkTempDir("mkdirtemp", dir); err != nil {
+		return "", err
+	}
+	for range maxTempAttempts {
+		name, err := kfs.TempName(pattern)
+		if err != nil {
+			return "", &fs.PathError{
+				Op:   "mkdirtemp",
+				Path: dir,
+				Err:  err,
+			}
+		}
+		name = path.Join(dir, name)
+		if _, err := m.Lstat(name); err == nil {
+			continue
+		}
+		if m.Fsys == nil {
+			m.Fsys = fstest.MapFS{}
+		}
+		m.Fsys[name] = &fstest.MapFile{
+			Mode:    fs.ModeDir | 0o700,
+			ModTime: time.Now(),
+		}
+		return name, nil
+	}
+	return "", &fs.PathError{
+		Op:   "mkdirtemp",
+		Path: dir,
+		Err:  kerrors.WithMsg(fs.ErrExist, "Failed to find an unused temp name"),
+	}
+}
+
+// CreateTemp implements [kfs.TempFS]
+//
+// As with [MapFS.OpenFile], the file is only opened for writing.
+func (m *MapFS) CreateTemp(dir, pattern string) (kfs.File, string, error) {
+	if err := m.checkTempDir("createtemp", dir); err != nil {
+		return nil, "", err
+	}
+	for range maxTempAttempts {
+		name, err := kfs.TempName(pattern)
+		if err != nil {
+			return nil, "", &fs.PathError{
+				Op:   "createtemp",
+				Path: dir,
+				Err:  err,
+			}
+		}
+		name = path.Join(dir, name)
+		if _, err := m.Lstat(name); err == nil {
+			continue
+		}
+		f, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return nil, "", err
+		}
+		return f, name, nil
+	}
+	return nil, "", &fs.PathError{
+		Op:   "createtemp",
+		Path: dir,
+		Err:  kerrors.WithMsg(fs.ErrExist, "Failed to find an unused temp name"),
+	}
+}
+
+// SyncDir implements [kfs.SyncFS]
+//
+// A [MapFS] is only in memory, so SyncDir only checks that name is a
+// directory.
+func (m *MapFS) SyncDir(name string) error {
+	info, err := m.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{
+			Op:   "syncdir",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, "Not a directory"),
+		}
 	}
 	return nil
 }
 
+// Statfs implements [kfs.StatfsFS]
+//
+// It returns the fake stats set by [MapFS.WithStatfs], and otherwise returns
+// an error.
+func (m *MapFS) Statfs(name string) (*kfs.FSStats, error) {
+	if _, err := m.Stat(name); err != nil {
+		return nil, err
+	}
+	if m.stats == nil {
+		return nil, &fs.PathError{
+			Op:   "statfs",
+			Path: name,
+			Err:  kerrors.WithMsg(kfs.ErrNotImplemented, "File system stats are not set"),
+		}
+	}
+	stats := *m.stats
+	return &stats, nil
+}
+
 type (
 	subdirFS struct {
 		m    *MapFS
@@ -318,19 +1139,61 @@ type (
 	}
 )
 
+// join joins the dir of the sub fs with a valid name
+//
+// Unlike [path.Join], it does not clean the result, since both are already
+// valid paths, and so avoids allocating on every operation.
+func (f *subdirFS) join(name string) string {
+	if f.dir == "." {
+		return name
+	}
+	if name == "." {
+		return f.dir
+	}
+	return f.dir + "/" + name
+}
+
+// checkLinks returns an error if the symlinks of a valid name may not be
+// resolved, leaving invalid names to be rejected by fsys
+func (f *subdirFS) checkLinks(op string, name string) error {
+	if !fs.ValidPath(name) {
+		return nil
+	}
+	if _, err := f.m.resolve(f.join(name), true); err != nil {
+		return &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
 func (f *subdirFS) Open(name string) (fs.File, error) {
+	if err := f.checkLinks("open", name); err != nil {
+		return nil, err
+	}
 	return f.fsys.Open(name)
 }
 
 func (f *subdirFS) Stat(name string) (fs.FileInfo, error) {
+	if err := f.checkLinks("stat", name); err != nil {
+		return nil, err
+	}
 	return fs.Stat(f.fsys, name)
 }
 
 func (f *subdirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := f.checkLinks("readdir", name); err != nil {
+		return nil, err
+	}
 	return fs.ReadDir(f.fsys, name)
 }
 
 func (f *subdirFS) ReadFile(name string) ([]byte, error) {
+	if err := f.checkLinks("readfile", name); err != nil {
+		return nil, err
+	}
 	return fs.ReadFile(f.fsys, name)
 }
 
@@ -346,7 +1209,7 @@ func (f *subdirFS) Sub(dir string) (fs.FS, error) {
 	return &subdirFS{
 		m:    f.m,
 		fsys: fsys,
-		dir:  path.Join(f.dir, dir),
+		dir:  f.join(dir),
 	}, nil
 }
 
@@ -358,7 +1221,7 @@ func (f *subdirFS) OpenFile(name string, flag int, mode fs.FileMode) (kfs.File,
 			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
 		}
 	}
-	return f.m.OpenFile(path.Join(f.dir, name), flag, mode)
+	return f.m.OpenFile(f.join(name), flag, mode)
 }
 
 func (f *subdirFS) Lstat(name string) (fs.FileInfo, error) {
@@ -369,7 +1232,7 @@ func (f *subdirFS) Lstat(name string) (fs.FileInfo, error) {
 			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
 		}
 	}
-	return f.m.Lstat(path.Join(f.dir, name))
+	return f.m.Lstat(f.join(name))
 }
 
 func (f *subdirFS) ReadLink(name string) (string, error) {
@@ -380,7 +1243,27 @@ func (f *subdirFS) ReadLink(name string) (string, error) {
 			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
 		}
 	}
-	return f.m.ReadLink(path.Join(f.dir, name))
+	return f.m.ReadLink(f.join(name))
+}
+
+func (f *subdirFS) Symlink(oldname, newname string) error {
+	if !fs.ValidPath(newname) {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	if !fs.ValidPath(path.Join(path.Dir(newname), oldname)) {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the FS", oldname)),
+		}
+	}
+	return f.m.Symlink(oldname, f.join(newname))
 }
 
 func (f *subdirFS) Remove(name string) error {
@@ -391,18 +1274,63 @@ func (f *subdirFS) Remove(name string) error {
 			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
 		}
 	}
-	return f.m.Remove(path.Join(f.dir, name))
+	return f.m.Remove(f.join(name))
 }
 
 func (f *subdirFS) RemoveAll(name string) error {
 	if !fs.ValidPath(name) {
 		return &fs.PathError{
-			Op:   "remove",
+			Op:   "removeall",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.RemoveAll(f.join(name))
+}
+
+func (f *subdirFS) Rename(oldname, newname string) error {
+	if !fs.ValidPath(oldname) || !fs.ValidPath(newname) {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.Rename(f.join(oldname), f.join(newname))
+}
+
+func (f *subdirFS) Truncate(name string, size int64) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "truncate",
 			Path: name,
 			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
 		}
 	}
-	return f.m.RemoveAll(path.Join(f.dir, name))
+	return f.m.Truncate(f.join(name), size)
+}
+
+func (f *subdirFS) Chown(name string, uid, gid int) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "chown",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.Chown(f.join(name), uid, gid)
+}
+
+func (f *subdirFS) Lchown(name string, uid, gid int) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "lchown",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.Lchown(f.join(name), uid, gid)
 }
 
 func (f *subdirFS) Chtimes(name string, atime, mtime time.Time) error {
@@ -413,16 +1341,114 @@ func (f *subdirFS) Chtimes(name string, atime, mtime time.Time) error {
 			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
 		}
 	}
-	return f.m.Chtimes(path.Join(f.dir, name), atime, mtime)
+	return f.m.Chtimes(f.join(name), atime, mtime)
+}
+
+func (f *subdirFS) Lchtimes(name string, atime, mtime time.Time) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "lchtimes",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.Lchtimes(f.join(name), atime, mtime)
+}
+
+func (f *subdirFS) Chmod(name string, mode fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "chmod",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.Chmod(f.join(name), mode)
+}
+
+func (f *subdirFS) Mkfifo(name string, mode fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "mkfifo",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.Mkfifo(f.join(name), mode)
+}
+
+func (f *subdirFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "writefileifmatch",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.WriteFileIfMatch(f.join(name), data, perm, token)
+}
+
+func (f *subdirFS) MkdirTemp(dir, pattern string) (string, error) {
+	if !fs.ValidPath(dir) {
+		return "", &fs.PathError{
+			Op:   "mkdirtemp",
+			Path: dir,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	name, err := f.m.MkdirTemp(f.join(dir), pattern)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, path.Base(name)), nil
+}
+
+func (f *subdirFS) CreateTemp(dir, pattern string) (kfs.File, string, error) {
+	if !fs.ValidPath(dir) {
+		return nil, "", &fs.PathError{
+			Op:   "createtemp",
+			Path: dir,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	file, name, err := f.m.CreateTemp(f.join(dir), pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, path.Join(dir, path.Base(name)), nil
+}
+
+func (f *subdirFS) SyncDir(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   "syncdir",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.SyncDir(f.join(name))
+}
+
+func (f *subdirFS) Statfs(name string) (*kfs.FSStats, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "statfs",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return f.m.Statfs(f.join(name))
 }
 
 type (
 	mapFile struct {
-		info mapFileInfo
-		path string
-		r    *bytes.Reader
-		b    *bytes.Buffer
-		fsys *MapFS
+		info   mapFileInfo
+		path   string
+		r      *bytes.Reader
+		b      *bytes.Buffer
+		wpos   int64
+		append bool
+		fsys   *MapFS
 	}
 
 	mapFileInfo struct {
@@ -448,48 +1474,140 @@ func (f *mapFile) assertReader() error {
 
 func (f *mapFile) Read(p []byte) (int, error) {
 	if err := f.assertReader(); err != nil {
-		return 0, nil
+		return 0, err
 	}
 	return f.r.Read(p)
 }
 
 func (f *mapFile) Seek(offset int64, whence int) (int64, error) {
 	if err := f.assertReader(); err != nil {
-		return 0, nil
+		return 0, err
 	}
 	return f.r.Seek(offset, whence)
 }
 
 func (f *mapFile) ReadAt(b []byte, offset int64) (int, error) {
 	if err := f.assertReader(); err != nil {
-		return 0, nil
+		return 0, err
 	}
 	return f.r.ReadAt(b, offset)
 }
 
-func (f *mapFile) Write(p []byte) (int, error) {
+func (f *mapFile) assertWriter(op string) error {
 	if f.b == nil {
-		return 0, &fs.PathError{
-			Op:   "write",
+		return &fs.PathError{
+			Op:   op,
 			Path: f.path,
 			Err:  kerrors.WithMsg(fs.ErrInvalid, "File not open for writing"),
 		}
 	}
-	return f.b.Write(p)
+	return nil
+}
+
+// writeAt writes p at offset, zero filling any gap past the end of the file
+func (f *mapFile) writeAt(p []byte, offset int64) int {
+	if end := offset + int64(len(p)); end > int64(f.b.Len()) {
+		f.b.Write(make([]byte, end-int64(f.b.Len())))
+	}
+	return copy(f.b.Bytes()[offset:], p)
+}
+
+func (f *mapFile) Write(p []byte) (int, error) {
+	if err := f.assertWriter("write"); err != nil {
+		return 0, err
+	}
+	if f.append {
+		f.wpos = int64(f.b.Len())
+	}
+	n := f.writeAt(p, f.wpos)
+	f.wpos += int64(n)
+	return n, nil
+}
+
+func (f *mapFile) WriteAt(p []byte, offset int64) (int, error) {
+	if err := f.assertWriter("writeat"); err != nil {
+		return 0, err
+	}
+	if f.append {
+		return 0, &fs.PathError{
+			Op:   "writeat",
+			Path: f.path,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "May not write at offset when appending"),
+		}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{
+			Op:   "writeat",
+			Path: f.path,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Negative offset"),
+		}
+	}
+	return f.writeAt(p, offset), nil
+}
+
+// Truncate implements [kfs.TruncatableFile]
+func (f *mapFile) Truncate(size int64) error {
+	if err := f.assertWriter("truncate"); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: f.path,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Negative size"),
+		}
+	}
+	if size <= int64(f.b.Len()) {
+		f.b.Truncate(int(size))
+	} else {
+		f.b.Write(make([]byte, size-int64(f.b.Len())))
+	}
+	return nil
+}
+
+// Sync implements [kfs.SyncFile] by storing the written contents of the file
+// in the [MapFS]
+func (f *mapFile) Sync() error {
+	if err := f.assertWriter("sync"); err != nil {
+		return err
+	}
+	f.store()
+	return nil
+}
+
+// store stores the written contents of the file
+func (f *mapFile) store() {
+	f.fsys.replaceFile(f.path, &fstest.MapFile{
+		Data:    bytes.Clone(f.b.Bytes()),
+		Mode:    f.info.f.Mode,
+		ModTime: time.Now(),
+	})
 }
 
 func (f *mapFile) Close() error {
 	if f.b != nil {
-		f.fsys.Fsys[f.path] = &fstest.MapFile{
-			Data:    f.b.Bytes(),
-			Mode:    f.info.f.Mode,
-			ModTime: time.Now(),
-		}
+		f.store()
 		f.b = nil
 	}
 	return nil
 }
 
+type (
+	// mapDir is a directory opened with [MapFS.OpenFile]
+	mapDir struct {
+		fs.ReadDirFile
+		path string
+	}
+)
+
+func (d *mapDir) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "write",
+		Path: d.path,
+		Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "File is a directory"),
+	}
+}
+
 func (i *mapFileInfo) Name() string {
 	return i.name
 }
@@ -1,9 +1,13 @@
 package kfstest
 
 import (
+	"bytes"
 	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -54,6 +58,12 @@ func Test_MapFS(t *testing.T) {
 	assert.NoError(fstest.TestFS(fsys, fileNames...))
 
 	assert.NoError(TestFS(fsys, testFiles...))
+	RunFS(t, fsys, testFiles...)
+	assert.NoError(TestReadDirFile(fsys, "."))
+	assert.NoError(TestDirOpenFile(fsys, "bar"))
+	assert.NoError(TestFSErrors(fsys, TestFSErrorsOpts{
+		Existing: "foo.txt",
+	}))
 
 	assert.NoError(TestFileWrite(fsys, "other/other.txt", []byte("other")))
 	subFsys, err := fs.Sub(fsys, "other")
@@ -68,6 +78,31 @@ func Test_MapFS(t *testing.T) {
 		ModTime: now,
 	}
 
+	fsys.Fsys["emptydir"] = &fstest.MapFile{
+		Mode:    fs.ModeDir | 0o755,
+		ModTime: now,
+	}
+
+	assert.NoError(TestFS(fsys,
+		TestFSEntry{
+			Name:    "foo.txt",
+			Data:    []byte("hello, world"),
+			Mode:    filemode,
+			ModTime: now,
+		},
+		TestFSEntry{
+			Name:    "emptydir",
+			IsDir:   true,
+			Mode:    fs.ModeDir | 0o755,
+			ModTime: now,
+		},
+		TestFSEntry{
+			Name:       "other/link.txt",
+			Mode:       0o777 | fs.ModeSymlink,
+			LinkTarget: "subother/subother.txt",
+		},
+	))
+
 	{
 		// test lstat
 		info, err := kfs.Lstat(subFsys, "link.txt")
@@ -78,6 +113,24 @@ func Test_MapFS(t *testing.T) {
 		assert.Equal("subother/subother.txt", target)
 	}
 
+	{
+		// test positional writes, truncation, and exclusive creation
+		assert.NoError(TestFileWrite(subFsys, "writeat.txt", []byte("hello, world")))
+		assert.NoError(TestFileWriteAt(subFsys, "writeat.txt", 7, []byte("there")))
+		assert.NoError(TestFileWriteAt(subFsys, "writeat.txt", 16, []byte("past end")))
+		assert.NoError(TestFileTruncate(subFsys, "writeat.txt"))
+		assert.NoError(TestFileCreateExcl(subFsys, "excl.txt", []byte("excl")))
+		assert.NoError(kfs.Remove(subFsys, "writeat.txt"))
+		assert.NoError(kfs.Remove(subFsys, "excl.txt"))
+	}
+
+	{
+		// test dir errors
+		assert.ErrorIs(kfs.WriteFile(subFsys, "subother/subother.txt/child.txt", []byte("child"), 0o644), kfs.ErrNotDir)
+		_, err := kfs.OpenFile(subFsys, "subother", os.O_WRONLY, 0)
+		assert.ErrorIs(err, kfs.ErrIsDir)
+	}
+
 	{
 		// test chtimes
 		info, err := fs.Stat(subFsys, "subother/subother.txt")
@@ -112,3 +165,209 @@ func Test_MapFS(t *testing.T) {
 		_, ok = f.(io.ReaderAt)
 	}
 }
+
+func Test_MapFSBuilder(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := NewMapFS().
+		WithFile("a/b.txt", []byte("hello, world"), 0o644).
+		WithDir("c").
+		WithSymlink("d", "a/b.txt")
+
+	info, err := fs.Stat(fsys, "a/b.txt")
+	assert.NoError(err)
+	modTime := info.ModTime()
+
+	assert.NoError(TestFS(fsys,
+		TestFSEntry{
+			Name:    "a/b.txt",
+			Data:    []byte("hello, world"),
+			Mode:    0o644,
+			ModTime: modTime,
+		},
+		TestFSEntry{
+			Name:    "c",
+			IsDir:   true,
+			Mode:    fs.ModeDir | 0o755,
+			ModTime: modTime,
+		},
+		TestFSEntry{
+			Name:       "d",
+			Mode:       fs.ModeSymlink | 0o777,
+			ModTime:    modTime,
+			LinkTarget: "a/b.txt",
+		},
+	))
+
+	assert.Panics(func() {
+		fsys.WithFile("../escape.txt", nil, 0o644)
+	})
+}
+
+func Test_MapFSSymlinkLoop(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := NewMapFS().
+		WithFile("a/b.txt", []byte("hello, world"), 0o644).
+		WithSymlinkLoop("a/b/x", "a/y", "z").
+		WithSymlinkLoop("self")
+
+	for _, i := range []struct {
+		name   string
+		target string
+	}{
+		{name: "a/b/x", target: "../y"},
+		{name: "a/y", target: "../z"},
+		{name: "z", target: "a/b/x"},
+		{name: "self", target: "self"},
+	} {
+		target, err := fsys.ReadLink(i.name)
+		assert.NoError(err)
+		assert.Equal(i.target, target)
+
+		_, err = fsys.Lstat(i.name)
+		assert.NoError(err)
+		_, err = fsys.Stat(i.name)
+		assert.ErrorIs(err, kfs.ErrLinkLoop)
+		_, err = fsys.Open(i.name)
+		assert.ErrorIs(err, kfs.ErrLinkLoop)
+		_, err = fsys.ReadFile(i.name)
+		assert.ErrorIs(err, kfs.ErrLinkLoop)
+		_, err = fsys.OpenFile(i.name, os.O_WRONLY|os.O_CREATE, 0o644)
+		assert.ErrorIs(err, kfs.ErrLinkLoop)
+		assert.ErrorIs(fsys.Chmod(i.name, 0o644), kfs.ErrLinkLoop)
+	}
+
+	_, err := fsys.Lstat("z/child")
+	assert.ErrorIs(err, kfs.ErrLinkLoop)
+	_, err = fsys.ReadDir("self")
+	assert.ErrorIs(err, kfs.ErrLinkLoop)
+
+	sub, err := fs.Sub(fsys, "a")
+	assert.NoError(err)
+	_, err = fs.Stat(sub, "y")
+	assert.ErrorIs(err, kfs.ErrLinkLoop)
+	data, err := fs.ReadFile(sub, "b.txt")
+	assert.NoError(err)
+	assert.Equal([]byte("hello, world"), data)
+}
+
+func Test_MapFSWriteThroughLinks(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := NewMapFS().
+		WithFile("a", []byte("orig"), 0o644).
+		WithSymlink("l", "a").
+		WithDir("d").
+		WithSymlink("dl", "d")
+
+	f, err := fsys.OpenFile("l", os.O_WRONLY|os.O_TRUNC, 0)
+	assert.NoError(err)
+	_, err = f.Write([]byte("new"))
+	assert.NoError(err)
+	assert.NoError(f.Close())
+	data, err := fsys.ReadFile("a")
+	assert.NoError(err)
+	assert.Equal([]byte("new"), data)
+	target, err := fsys.ReadLink("l")
+	assert.NoError(err)
+	assert.Equal("a", target)
+
+	f, err = fsys.OpenFile("dl/x", os.O_WRONLY|os.O_CREATE, 0o644)
+	assert.NoError(err)
+	_, err = f.Write([]byte("x"))
+	assert.NoError(err)
+	assert.NoError(f.Close())
+	data, err = fsys.ReadFile("d/x")
+	assert.NoError(err)
+	assert.Equal([]byte("x"), data)
+	_, ok := fsys.Fsys["dl/x"]
+	assert.False(ok)
+
+	_, err = fsys.OpenFile("l", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	assert.ErrorIs(err, fs.ErrExist)
+}
+
+func Test_MapFSFromDir(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	tempDir := t.TempDir()
+	srcFS := kfs.DirFS(tempDir)
+	assert.NoError(TestFileWrite(srcFS, "foo.txt", []byte("hello, world")))
+	assert.NoError(TestFileWrite(srcFS, "bar/foobar.txt", []byte("foo bar")))
+	assert.NoError(TestFileWrite(srcFS, ".git/hidden.txt", []byte("hidden")))
+	assert.NoError(os.Symlink("bar/foobar.txt", filepath.Join(tempDir, "link.txt")))
+
+	fsys, err := MapFSFromDir(tempDir, func(p string) (bool, error) {
+		return p != ".git", nil
+	})
+	assert.NoError(err)
+
+	assert.NoError(TestFS(fsys,
+		TestFSEntry{
+			Name: "foo.txt",
+			Data: []byte("hello, world"),
+		},
+		TestFSEntry{
+			Name: "bar/foobar.txt",
+			Data: []byte("foo bar"),
+		},
+		TestFSEntry{
+			Name:       "link.txt",
+			LinkTarget: "bar/foobar.txt",
+		},
+	))
+	_, err = fs.Stat(fsys, ".git/hidden.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+}
+
+func Test_WriteMapFSSource(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	m := NewMapFS().
+		WithFile("a/b.txt", []byte("hello\n"), 0o644).
+		WithFile("run.sh", []byte("#!/bin/sh\n"), 0o755).
+		WithFile("empty.txt", nil, 0o600).
+		WithFile("large.bin", bytes.Repeat([]byte{'x'}, mapFSSourceChunkSize+1), 0o644).
+		WithDir("a").
+		WithDir("emptydir").
+		WithSymlink("link", "a/b.txt")
+
+	var b bytes.Buffer
+	assert.NoError(WriteMapFSSource(&b, m, "fixtures", "testFS"))
+	large := strconv.Quote(strings.Repeat("x", mapFSSourceChunkSize))
+	assert.Equal(`// Code generated by kfstest.WriteMapFSSource. DO NOT EDIT.
+
+package fixtures
+
+import (
+	"xorkevin.dev/kfs/kfstest"
+)
+
+// testFS returns a new [kfstest.MapFS] of generated files
+func testFS() *kfstest.MapFS {
+	return kfstest.NewMapFS().
+		WithDir("a").
+		WithFile("a/b.txt", []byte("hello\n"), 0o644).
+		WithFile("empty.txt", []byte(""), 0o600).
+		WithDir("emptydir").
+		WithFile("large.bin", []byte(`+large+`+
+			"x"), 0o644).
+		WithSymlink("link", "a/b.txt").
+		WithFile("run.sh", []byte("#!/bin/sh\n"), 0o755)
+}
+`, b.String())
+
+	assert.Error(WriteMapFSSource(&b, m, "not a package", "testFS"))
+	assert.Error(WriteMapFSSource(&b, m, "fixtures", "test-fs"))
+}
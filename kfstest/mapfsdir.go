@@ -0,0 +1,87 @@
+package kfstest
+
+import (
+	"fmt"
+	"io/fs"
+	"testing/fstest"
+
+	"xorkevin.dev/kerrors"
+	"xorkevin.dev/kfs"
+)
+
+const (
+	// MapFSFromDirMaxSize is the max total size of file data loaded by
+	// [MapFSFromDir]
+	MapFSFromDirMaxSize = 64 * 1024 * 1024
+)
+
+// MapFSFromDir loads an on-disk directory into a new [MapFS]
+//
+// Files, directories, and symlinks are loaded with their modes and mod times.
+// Other file types are skipped. Paths relative to dir for which filter returns
+// false are skipped, and if a skipped path is a directory, its children are
+// skipped as well. A nil filter loads all paths. MapFSFromDir returns an error
+// if the total size of loaded file data exceeds [MapFSFromDirMaxSize].
+func MapFSFromDir(dir string, filter kfs.FileFilter) (*MapFS, error) {
+	src := kfs.DirFS(dir)
+	m := NewMapFS()
+	var size int64
+	if err := fs.WalkDir(src, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		if filter != nil {
+			if ok, err := filter(p); err != nil {
+				return kerrors.WithMsg(err, fmt.Sprintf("Failed filtering file %s", p))
+			} else if !ok {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+		}
+		info, err := kfs.Lstat(src, p)
+		if err != nil {
+			return err
+		}
+		mode := info.Mode()
+		switch mode.Type() {
+		case fs.ModeDir:
+			m.Fsys[p] = &fstest.MapFile{
+				Mode:    mode,
+				ModTime: info.ModTime(),
+			}
+		case fs.ModeSymlink:
+			target, err := kfs.ReadLink(src, p)
+			if err != nil {
+				return err
+			}
+			m.Fsys[p] = &fstest.MapFile{
+				Data:    []byte(target),
+				Mode:    mode,
+				ModTime: info.ModTime(),
+			}
+		case 0:
+			size += info.Size()
+			if size > MapFSFromDirMaxSize {
+				return kerrors.WithMsg(nil, fmt.Sprintf("Dir %s exceeds max size of %d bytes", dir, MapFSFromDirMaxSize))
+			}
+			data, err := fs.ReadFile(src, p)
+			if err != nil {
+				return err
+			}
+			m.Fsys[p] = &fstest.MapFile{
+				Data:    data,
+				Mode:    mode,
+				ModTime: info.ModTime(),
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, kerrors.WithMsg(err, fmt.Sprintf("Failed loading dir %s", dir))
+	}
+	return m, nil
+}
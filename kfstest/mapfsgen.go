@@ -0,0 +1,92 @@
+package kfstest
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+
+	"xorkevin.dev/kerrors"
+)
+
+const (
+	// mapFSSourceChunkSize is the max number of bytes of file data in each
+	// string literal of generated source
+	mapFSSourceChunkSize = 4096
+)
+
+// WriteMapFSSource writes Go source for package pkg with a function fn that
+// returns a copy of m built with [NewMapFS]
+//
+// This allows a fixture loaded with [MapFSFromDir] to be generated ahead of
+// time, as an alternative to embed.FS that keeps modes and symlinks. File data
+// is written as string literals of at most 4 KiB each, which the compiler
+// handles far faster than byte slice literals. Directories are written with
+// [MapFS.WithDir], so their modes are not kept, and other file types are
+// skipped. Mod times are not kept.
+//
+// The generated source imports kfstest, which imports package testing, so it
+// should be written to a _test.go file.
+func WriteMapFSSource(w io.Writer, m *MapFS, pkg string, fn string) error {
+	if !token.IsIdentifier(pkg) {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Invalid package name %q", pkg))
+	}
+	if !token.IsIdentifier(fn) {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Invalid function name %q", fn))
+	}
+
+	names := make([]string, 0, len(m.Fsys))
+	for k := range m.Fsys {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by kfstest.WriteMapFSSource. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "import (\n\t\"xorkevin.dev/kfs/kfstest\"\n)\n\n")
+	fmt.Fprintf(&b, "// %s returns a new [kfstest.MapFS] of generated files\n", fn)
+	fmt.Fprintf(&b, "func %s() *kfstest.MapFS {\n", fn)
+	fmt.Fprintf(&b, "\treturn kfstest.NewMapFS()")
+	for _, i := range names {
+		f := m.Fsys[i]
+		switch f.Mode.Type() {
+		case fs.ModeDir:
+			fmt.Fprintf(&b, ".\n\t\tWithDir(%s)", strconv.Quote(i))
+		case fs.ModeSymlink:
+			fmt.Fprintf(&b, ".\n\t\tWithSymlink(%s, %s)", strconv.Quote(i), strconv.Quote(string(f.Data)))
+		case 0:
+			fmt.Fprintf(&b, ".\n\t\tWithFile(%s, []byte(", strconv.Quote(i))
+			writeChunkedLiteral(&b, f.Data)
+			fmt.Fprintf(&b, "), 0o%o)", f.Mode.Perm())
+		}
+	}
+	fmt.Fprintf(&b, "\n}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return kerrors.WithMsg(err, "Failed to format generated source")
+	}
+	if _, err := w.Write(src); err != nil {
+		return kerrors.WithMsg(err, "Failed to write generated source")
+	}
+	return nil
+}
+
+// writeChunkedLiteral writes data as a sum of string literals
+func writeChunkedLiteral(b *bytes.Buffer, data []byte) {
+	if len(data) == 0 {
+		b.WriteString(`""`)
+		return
+	}
+	for n := 0; n < len(data); n += mapFSSourceChunkSize {
+		if n > 0 {
+			b.WriteString(" +\n\t\t\t")
+		}
+		b.WriteString(strconv.Quote(string(data[n:min(n+mapFSSourceChunkSize, len(data))])))
+	}
+}
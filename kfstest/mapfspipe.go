@@ -0,0 +1,185 @@
+package kfstest
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"testing/fstest"
+
+	"xorkevin.dev/kerrors"
+)
+
+type (
+	// mapPipe is the state of a named pipe in a [MapFS]
+	mapPipe struct {
+		mu      sync.Mutex
+		cond    *sync.Cond
+		buf     bytes.Buffer
+		readers int
+		writers int
+		// readerOpens and writerOpens count the opens of each end, so that an
+		// open waiting on the other end proceeds even if the other end is
+		// closed again before the waiting open is woken
+		readerOpens int
+		writerOpens int
+	}
+
+	// pipeFile is an end of a named pipe opened in a [MapFS]
+	pipeFile struct {
+		info   mapFileInfo
+		path   string
+		pipe   *mapPipe
+		write  bool
+		closed bool
+	}
+)
+
+// pipe returns the state of the named pipe f
+func (m *MapFS) pipe(f *fstest.MapFile) *mapPipe {
+	m.pipesMu.Lock()
+	defer m.pipesMu.Unlock()
+	if m.pipes == nil {
+		m.pipes = map[*fstest.MapFile]*mapPipe{}
+	}
+	p := m.pipes[f]
+	if p == nil {
+		p = &mapPipe{}
+		p.cond = sync.NewCond(&p.mu)
+		m.pipes[f] = p
+	}
+	return p
+}
+
+// openPipe opens an end of the named pipe f at name
+//
+// Like a real named pipe, opening an end blocks until the other end is
+// opened.
+func (m *MapFS) openPipe(name string, f *fstest.MapFile, write bool) *pipeFile {
+	p := m.pipe(f)
+	p.open(write)
+	return &pipeFile{
+		info: mapFileInfo{
+			name: path.Base(name),
+			f:    f,
+		},
+		path:  name,
+		pipe:  p,
+		write: write,
+	}
+}
+
+func (p *mapPipe) open(write bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if write {
+		p.writers++
+		p.writerOpens++
+		p.cond.Broadcast()
+		opens := p.readerOpens
+		for p.readers == 0 && p.readerOpens == opens {
+			p.cond.Wait()
+		}
+	} else {
+		p.readers++
+		p.readerOpens++
+		p.cond.Broadcast()
+		opens := p.writerOpens
+		for p.writers == 0 && p.writerOpens == opens {
+			p.cond.Wait()
+		}
+	}
+}
+
+func (f *pipeFile) Stat() (fs.FileInfo, error) {
+	return &f.info, nil
+}
+
+func (f *pipeFile) checkOpen(op string, write bool) error {
+	if f.closed {
+		return &fs.PathError{
+			Op:   op,
+			Path: f.path,
+			Err:  kerrors.WithMsg(fs.ErrClosed, "File is closed"),
+		}
+	}
+	if f.write != write {
+		msg := "File not open for reading"
+		if write {
+			msg = "File not open for writing"
+		}
+		return &fs.PathError{
+			Op:   op,
+			Path: f.path,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, msg),
+		}
+	}
+	return nil
+}
+
+// Read reads from the pipe, blocking until data is written, and returns
+// [io.EOF] once all writers have closed the pipe
+func (f *pipeFile) Read(p []byte) (int, error) {
+	f.pipe.mu.Lock()
+	defer f.pipe.mu.Unlock()
+	if err := f.checkOpen("read", false); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for f.pipe.buf.Len() == 0 && f.pipe.writers > 0 {
+		f.pipe.cond.Wait()
+	}
+	if f.pipe.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return f.pipe.buf.Read(p)
+}
+
+// Write writes to the pipe, and fails with [io.ErrClosedPipe] if all readers
+// have closed the pipe
+//
+// Writes are buffered without bound, so Write does not block.
+func (f *pipeFile) Write(p []byte) (int, error) {
+	f.pipe.mu.Lock()
+	defer f.pipe.mu.Unlock()
+	if err := f.checkOpen("write", true); err != nil {
+		return 0, err
+	}
+	if f.pipe.readers == 0 {
+		return 0, &fs.PathError{
+			Op:   "write",
+			Path: f.path,
+			Err:  kerrors.WithMsg(io.ErrClosedPipe, "Pipe has no readers"),
+		}
+	}
+	n, _ := f.pipe.buf.Write(p)
+	f.pipe.cond.Broadcast()
+	return n, nil
+}
+
+func (f *pipeFile) Close() error {
+	f.pipe.mu.Lock()
+	defer f.pipe.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{
+			Op:   "close",
+			Path: f.path,
+			Err:  kerrors.WithMsg(fs.ErrClosed, "File is closed"),
+		}
+	}
+	f.closed = true
+	if f.write {
+		f.pipe.writers--
+	} else {
+		f.pipe.readers--
+		if f.pipe.readers == 0 {
+			// data written with no readers is lost, as for a real pipe
+			f.pipe.buf.Reset()
+		}
+	}
+	f.pipe.cond.Broadcast()
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 	"path"
 	"slices"
 	"strings"
+	"testing"
+	"time"
 
 	"xorkevin.dev/kerrors"
 	"xorkevin.dev/kfs"
@@ -43,12 +45,147 @@ func TestFileOpen(fsys fs.FS, name string, data []byte) (retErr error) {
 	return nil
 }
 
+// readDirFileNames reads all dir entry names of an opened directory with
+// ReadDir(n)
+func readDirFileNames(fsys fs.FS, name string, n int) (_ []string, retErr error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, kerrors.WithMsg(err, fmt.Sprintf("Failed to open dir %s", name))
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			retErr = errors.Join(retErr, kerrors.WithMsg(err, fmt.Sprintf("Failed closing dir %s", name)))
+		}
+	}()
+	d, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return nil, kerrors.WithMsg(nil, fmt.Sprintf("Dir %s does not implement fs.ReadDirFile", name))
+	}
+	var names []string
+	for {
+		entries, err := d.ReadDir(n)
+		if n > 0 && len(entries) > n {
+			return nil, kerrors.WithMsg(nil, fmt.Sprintf("ReadDir(%d) of %s returned %d entries", n, name, len(entries)))
+		}
+		for _, i := range entries {
+			names = append(names, i.Name())
+		}
+		if n <= 0 {
+			if err != nil {
+				return nil, kerrors.WithMsg(err, fmt.Sprintf("Failed to readdir %s", name))
+			}
+			return names, nil
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return names, nil
+			}
+			return nil, kerrors.WithMsg(err, fmt.Sprintf("Failed to readdir %s", name))
+		}
+		if len(entries) == 0 {
+			return nil, kerrors.WithMsg(nil, fmt.Sprintf("ReadDir(%d) of %s returned no entries and no error", n, name))
+		}
+	}
+}
+
+// TestReadDirFile tests reading an opened directory with [fs.ReadDirFile]
+//
+// ReadDir(n) for n > 0 must return at most n entries at a time and end with
+// [io.EOF], and ReadDir(-1) must return all entries with a nil error. Both
+// must return the same entries as [fs.ReadDir].
+func TestReadDirFile(fsys fs.FS, name string) error {
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to readdir %s", name))
+	}
+	expected := make([]string, 0, len(entries))
+	for _, i := range entries {
+		expected = append(expected, i.Name())
+	}
+	slices.Sort(expected)
+	for _, n := range []int{-1, 1, 2} {
+		names, err := readDirFileNames(fsys, name, n)
+		if err != nil {
+			return err
+		}
+		slices.Sort(names)
+		if !slices.Equal(expected, names) {
+			return kerrors.WithMsg(nil, fmt.Sprintf("ReadDir(%d) entries of %s do not match: %v, expected %v", n, name, names, expected))
+		}
+	}
+	return nil
+}
+
+// TestDirOpenFile tests opening a directory with [kfs.OpenFile]
+//
+// Opening a directory with O_RDONLY must return an [fs.ReadDirFile], and
+// opening it for writing must fail with [kfs.ErrIsDir], or with
+// [fs.ErrPermission] if the fs is read-only.
+func TestDirOpenFile(fsys fs.FS, name string) error {
+	if err := func() (retErr error) {
+		f, err := kfs.OpenFile(fsys, name, os.O_RDONLY, 0)
+		if err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed to open dir %s", name))
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				retErr = errors.Join(retErr, kerrors.WithMsg(err, fmt.Sprintf("Failed closing dir %s", name)))
+			}
+		}()
+		info, err := f.Stat()
+		if err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed to stat dir %s", name))
+		}
+		if !info.IsDir() {
+			return kerrors.WithMsg(nil, fmt.Sprintf("Fileinfo for %s is not a dir", name))
+		}
+		d, ok := f.(fs.ReadDirFile)
+		if !ok {
+			return kerrors.WithMsg(nil, fmt.Sprintf("Dir %s does not implement fs.ReadDirFile", name))
+		}
+		if _, err := d.ReadDir(-1); err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed to readdir %s", name))
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+	f, err := kfs.OpenFile(fsys, name, os.O_WRONLY, 0)
+	if err == nil {
+		return errors.Join(
+			kerrors.WithMsg(nil, fmt.Sprintf("Opened dir %s for writing", name)),
+			f.Close(),
+		)
+	}
+	if !errors.Is(err, kfs.ErrIsDir) && !errors.Is(err, fs.ErrPermission) {
+		return kerrors.WithMsg(err, fmt.Sprintf("Expected opening dir %s for writing to fail with %v", name, kfs.ErrIsDir))
+	}
+	return nil
+}
+
 type (
-	// TestFSFile specifies files to test by [TestFS]
-	TestFSFile struct {
+	// TestFSEntry specifies entries to test by [TestFS]
+	//
+	// By default an entry is a regular file with content Data. Mode and
+	// ModTime are only checked when they are non-zero.
+	TestFSEntry struct {
 		Name string
 		Data []byte
+		// Mode is the expected file mode
+		Mode fs.FileMode
+		// ModTime is the expected mod time
+		ModTime time.Time
+		// IsDir is whether the entry is a directory, in which case Data is
+		// ignored
+		IsDir bool
+		// LinkTarget is the expected target if the entry is a symlink, in which
+		// case Data is ignored and Mode and ModTime are checked against the link
+		// itself
+		LinkTarget string
 	}
+
+	// TestFSFile is an alias of [TestFSEntry]
+	TestFSFile = TestFSEntry
 )
 
 // TestFS tests fs operations:
@@ -59,114 +196,282 @@ type (
 //   - ReadDir
 //   - Glob
 //   - Sub
+//   - Lstat
+//   - ReadLink
 func TestFS(fsys fs.FS, files ...TestFSFile) error {
-	filesByDir := map[string][]TestFSFile{}
-	readDirRes := map[string][]fs.DirEntry{}
-	globbedAncestors := map[string]struct{}{}
-
+	c := newFSChecker(fsys)
 	for _, i := range files {
-		// check file open
-		if err := TestFileOpen(fsys, i.Name, i.Data); err != nil {
-			return err
+		for _, j := range fsFileChecks {
+			if err := j.check(c, i); err != nil {
+				return err
+			}
 		}
+	}
 
-		// check stat
-		info, err := fs.Stat(fsys, i.Name)
+	// test subdir
+	filesByDir := groupFilesByDir(files)
+	for _, i := range sortedDirs(filesByDir) {
+		subfsys, err := fs.Sub(fsys, i)
 		if err != nil {
-			return kerrors.WithMsg(err, fmt.Sprintf("Failed to stat %s", i.Name))
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed subdir %s", i))
 		}
-		if info.Name() != path.Base(i.Name) {
-			return kerrors.WithMsg(nil, fmt.Sprintf("Fileinfo name for %s does not match %s", i.Name, info.Name()))
+		if err := TestFS(subfsys, filesByDir[i]...); err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed TestFS in subdir %s", i))
 		}
+	}
+	return nil
+}
 
-		// check content of read file
-		content, err := fs.ReadFile(fsys, i.Name)
-		if err != nil {
-			return kerrors.WithMsg(err, fmt.Sprintf("Failed to readfile %s", i.Name))
-		}
-		if !bytes.Equal(i.Data, content) {
-			return kerrors.WithMsg(nil, fmt.Sprintf("Data for %s does not match", i.Name))
-		}
+// RunFS runs the checks of [TestFS] as subtests of t
+//
+// Unlike [TestFS], RunFS does not stop at the first failure. Each check for
+// each file is run as its own subtest, and subdirectories are tested in
+// subtests named by their directory.
+func RunFS(t *testing.T, fsys fs.FS, files ...TestFSFile) {
+	t.Helper()
 
-		// get directory, directory child, and rest if there exists one
-		dir, rest, hasDir := strings.Cut(i.Name, "/")
-		var child string
-		if hasDir {
-			child, _, _ = strings.Cut(rest, "/")
-			// for files with a directory, group them by directory for subdir testing
-			filesByDir[dir] = append(filesByDir[dir], TestFSFile{
-				Name: rest,
-				Data: i.Data,
-			})
-		} else {
-			dir = "."
-			child = i.Name
-		}
-
-		// get readdir if not already obtained
-		entries, isCached := readDirRes[dir]
-		if !isCached {
-			var err error
-			entries, err = fs.ReadDir(fsys, dir)
+	c := newFSChecker(fsys)
+	for _, i := range files {
+		t.Run(i.Name, func(t *testing.T) {
+			for _, j := range fsFileChecks {
+				t.Run(j.name, func(t *testing.T) {
+					if err := j.check(c, i); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+		})
+	}
+
+	filesByDir := groupFilesByDir(files)
+	for _, i := range sortedDirs(filesByDir) {
+		t.Run("Sub/"+i, func(t *testing.T) {
+			subfsys, err := fs.Sub(fsys, i)
 			if err != nil {
-				return kerrors.WithMsg(err, fmt.Sprintf("Failed to readdir %s for %s", dir, i.Name))
+				t.Fatal(kerrors.WithMsg(err, fmt.Sprintf("Failed subdir %s", i)))
 			}
-			readDirRes[dir] = entries
+			RunFS(t, subfsys, filesByDir[i]...)
+		})
+	}
+}
+
+type (
+	fsChecker struct {
+		fsys             fs.FS
+		readDirRes       map[string][]fs.DirEntry
+		globbedAncestors map[string]struct{}
+	}
+
+	fsFileCheck struct {
+		name  string
+		check func(c *fsChecker, f TestFSFile) error
+	}
+)
+
+var fsFileChecks = []fsFileCheck{
+	{name: "Open", check: (*fsChecker).checkOpen},
+	{name: "Stat", check: (*fsChecker).checkStat},
+	{name: "ReadFile", check: (*fsChecker).checkReadFile},
+	{name: "ReadDir", check: (*fsChecker).checkReadDir},
+	{name: "Glob", check: (*fsChecker).checkGlob},
+	{name: "Link", check: (*fsChecker).checkLink},
+}
+
+func newFSChecker(fsys fs.FS) *fsChecker {
+	return &fsChecker{
+		fsys:             fsys,
+		readDirRes:       map[string][]fs.DirEntry{},
+		globbedAncestors: map[string]struct{}{},
+	}
+}
+
+// checkOpen checks file open
+func (c *fsChecker) checkOpen(f TestFSFile) (retErr error) {
+	if f.LinkTarget != "" {
+		return nil
+	}
+	if !f.IsDir {
+		return TestFileOpen(c.fsys, f.Name, f.Data)
+	}
+	d, err := c.fsys.Open(f.Name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to open dir %s", f.Name))
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			retErr = errors.Join(retErr, kerrors.WithMsg(err, fmt.Sprintf("Failed closing dir %s", f.Name)))
 		}
+	}()
+	info, err := d.Stat()
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to stat dir %s", f.Name))
+	}
+	if !info.IsDir() {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Fileinfo for %s is not a dir", f.Name))
+	}
+	return TestReadDirFile(c.fsys, f.Name)
+}
 
-		// check readdir output for directory child
-		hasEntry := false
-		for _, j := range entries {
-			if j.Name() == child {
-				hasEntry = true
-				break
-			}
+// checkInfo checks file info against the expected metadata of an entry
+func checkInfo(f TestFSFile, info fs.FileInfo) error {
+	if info.Name() != path.Base(f.Name) {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Fileinfo name for %s does not match %s", f.Name, info.Name()))
+	}
+	if f.LinkTarget != "" {
+		if info.Mode().Type()&fs.ModeSymlink == 0 {
+			return kerrors.WithMsg(nil, fmt.Sprintf("Fileinfo for %s is not a symlink", f.Name))
 		}
-		if !hasEntry {
-			return kerrors.WithMsg(nil, fmt.Sprintf("Missing dir entry %s in %s for %s", child, dir, i.Name))
+	} else if info.IsDir() != f.IsDir {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Fileinfo is dir %t for %s does not match %t", info.IsDir(), f.Name, f.IsDir))
+	}
+	if f.Mode != 0 && info.Mode() != f.Mode {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Fileinfo mode %s for %s does not match %s", info.Mode(), f.Name, f.Mode))
+	}
+	if !f.ModTime.IsZero() && !info.ModTime().Equal(f.ModTime) {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Fileinfo modtime %s for %s does not match %s", info.ModTime(), f.Name, f.ModTime))
+	}
+	return nil
+}
+
+// checkStat checks stat
+func (c *fsChecker) checkStat(f TestFSFile) error {
+	if f.LinkTarget != "" {
+		// stat follows links, which is checked by checkLink instead
+		return nil
+	}
+	info, err := fs.Stat(c.fsys, f.Name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to stat %s", f.Name))
+	}
+	return checkInfo(f, info)
+}
+
+// checkReadFile checks content of read file
+func (c *fsChecker) checkReadFile(f TestFSFile) error {
+	if f.IsDir || f.LinkTarget != "" {
+		return nil
+	}
+	content, err := fs.ReadFile(c.fsys, f.Name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to readfile %s", f.Name))
+	}
+	if !bytes.Equal(f.Data, content) {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Data for %s does not match", f.Name))
+	}
+	return nil
+}
+
+// checkReadDir checks readdir output for the directory child of a file
+func (c *fsChecker) checkReadDir(f TestFSFile) error {
+	// get directory and directory child
+	dir, rest, hasDir := strings.Cut(f.Name, "/")
+	var child string
+	isEntry := true
+	if hasDir {
+		child, _, isEntry = strings.Cut(rest, "/")
+		isEntry = !isEntry
+	} else {
+		dir = "."
+		child = f.Name
+	}
+
+	// get readdir if not already obtained
+	entries, isCached := c.readDirRes[dir]
+	if !isCached {
+		var err error
+		entries, err = fs.ReadDir(c.fsys, dir)
+		if err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed to readdir %s for %s", dir, f.Name))
 		}
+		c.readDirRes[dir] = entries
+	}
 
-		// check glob pattern
-		ancestors, base := path.Split(i.Name)
-		if _, ok := globbedAncestors[ancestors]; !ok {
-			globbedAncestors[ancestors] = struct{}{}
-			ext := path.Ext(base)
-			if ext != "" {
-				pattern := path.Join(ancestors, "*"+ext)
-				entries, err := fs.Glob(fsys, pattern)
-				if err != nil {
-					return kerrors.WithMsg(err, fmt.Sprintf("Failed to glob %s for %s", pattern, i.Name))
-				}
-				hasEntry := false
-				for _, j := range entries {
-					if j == i.Name {
-						hasEntry = true
-						break
-					}
-				}
-				if !hasEntry {
-					return kerrors.WithMsg(nil, fmt.Sprintf("Missing glob entry %s in %s", i.Name, pattern))
+	for _, j := range entries {
+		if j.Name() != child {
+			continue
+		}
+		if isEntry {
+			if f.LinkTarget != "" {
+				if j.Type()&fs.ModeSymlink == 0 {
+					return kerrors.WithMsg(nil, fmt.Sprintf("Dir entry %s in %s is not a symlink", child, dir))
 				}
+			} else if j.IsDir() != f.IsDir {
+				return kerrors.WithMsg(nil, fmt.Sprintf("Dir entry is dir %t for %s in %s does not match %t", j.IsDir(), child, dir, f.IsDir))
 			}
 		}
+		return nil
 	}
+	return kerrors.WithMsg(nil, fmt.Sprintf("Missing dir entry %s in %s for %s", child, dir, f.Name))
+}
 
-	// test subdir
+// checkGlob checks glob pattern
+func (c *fsChecker) checkGlob(f TestFSFile) error {
+	ancestors, base := path.Split(f.Name)
+	if _, ok := c.globbedAncestors[ancestors]; ok {
+		return nil
+	}
+	c.globbedAncestors[ancestors] = struct{}{}
+	ext := path.Ext(base)
+	if ext == "" {
+		return nil
+	}
+	pattern := path.Join(ancestors, "*"+ext)
+	entries, err := fs.Glob(c.fsys, pattern)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to glob %s for %s", pattern, f.Name))
+	}
+	for _, j := range entries {
+		if j == f.Name {
+			return nil
+		}
+	}
+	return kerrors.WithMsg(nil, fmt.Sprintf("Missing glob entry %s in %s", f.Name, pattern))
+}
+
+// checkLink checks lstat and readlink of a symlink
+func (c *fsChecker) checkLink(f TestFSFile) error {
+	if f.LinkTarget == "" {
+		return nil
+	}
+	info, err := kfs.Lstat(c.fsys, f.Name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to lstat %s", f.Name))
+	}
+	if err := checkInfo(f, info); err != nil {
+		return err
+	}
+	target, err := kfs.ReadLink(c.fsys, f.Name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to readlink %s", f.Name))
+	}
+	if target != f.LinkTarget {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Link target %s for %s does not match %s", target, f.Name, f.LinkTarget))
+	}
+	return nil
+}
+
+// groupFilesByDir groups files with a directory by their top level directory
+// for subdir testing
+func groupFilesByDir(files []TestFSFile) map[string][]TestFSFile {
+	filesByDir := map[string][]TestFSFile{}
+	for _, i := range files {
+		dir, rest, hasDir := strings.Cut(i.Name, "/")
+		if !hasDir {
+			continue
+		}
+		i.Name = rest
+		filesByDir[dir] = append(filesByDir[dir], i)
+	}
+	return filesByDir
+}
+
+func sortedDirs(filesByDir map[string][]TestFSFile) []string {
 	dirs := make([]string, 0, len(filesByDir))
 	for i := range filesByDir {
 		dirs = append(dirs, i)
 	}
 	slices.Sort(dirs)
-	for _, i := range dirs {
-		subfsys, err := fs.Sub(fsys, i)
-		if err != nil {
-			return kerrors.WithMsg(err, fmt.Sprintf("Failed subdir %s", i))
-		}
-		if err := TestFS(subfsys, filesByDir[i]...); err != nil {
-			return kerrors.WithMsg(err, fmt.Sprintf("Failed TestFS in subdir %s", i))
-		}
-	}
-	return nil
+	return dirs
 }
 
 // TestFileWrite tests writing a file with [kfs.OpenFile]
@@ -269,3 +574,296 @@ func TestFileAppend(fsys fs.FS, name string, data []byte) error {
 	}
 	return nil
 }
+
+// TestFileWriteAt tests writing to a file at an offset with [io.WriterAt]
+//
+// The file must already exist. Writing past the end of the file is expected
+// to zero fill the gap.
+func TestFileWriteAt(fsys fs.FS, name string, offset int64, data []byte) error {
+	orig, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to read file %s", name))
+	}
+	if err := func() (retErr error) {
+		f, err := kfs.OpenFile(fsys, name, os.O_WRONLY, 0)
+		if err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed to open file %s for writing", name))
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				retErr = errors.Join(retErr, err)
+			}
+		}()
+		w, ok := f.(io.WriterAt)
+		if !ok {
+			return kerrors.WithMsg(nil, fmt.Sprintf("File %s does not implement io.WriterAt", name))
+		}
+		if _, err := w.WriteAt(data, offset); err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed to write file %s at %d", name, offset))
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+	expected := orig
+	if end := offset + int64(len(data)); end > int64(len(expected)) {
+		expected = append(expected, make([]byte, end-int64(len(expected)))...)
+	}
+	copy(expected[offset:], data)
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to read file %s", name))
+	}
+	if !bytes.Equal(expected, content) {
+		return kerrors.WithMsg(nil, fmt.Sprintf("File data does not match for %s", name))
+	}
+	return nil
+}
+
+// TestFileTruncate tests truncating a file with O_TRUNC using [kfs.OpenFile]
+//
+// The file must already exist.
+func TestFileTruncate(fsys fs.FS, name string) error {
+	if _, err := fs.Stat(fsys, name); err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to stat file %s", name))
+	}
+	if err := func() (retErr error) {
+		f, err := kfs.OpenFile(fsys, name, os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed to open file %s for truncating", name))
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				retErr = errors.Join(retErr, err)
+			}
+		}()
+		return nil
+	}(); err != nil {
+		return err
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to stat file %s", name))
+	}
+	if info.Size() != 0 {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Fileinfo size is not zero after truncating %s", name))
+	}
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return kerrors.WithMsg(err, fmt.Sprintf("Failed to read file %s", name))
+	}
+	if len(content) != 0 {
+		return kerrors.WithMsg(nil, fmt.Sprintf("File data is not empty after truncating %s", name))
+	}
+	return nil
+}
+
+// TestFileCreateExcl tests exclusively creating a file with O_EXCL using
+// [kfs.OpenFile]
+//
+// The file must not already exist. A second exclusive create is expected to
+// fail with [fs.ErrExist].
+func TestFileCreateExcl(fsys fs.FS, name string, data []byte) error {
+	if err := func() (retErr error) {
+		f, err := kfs.OpenFile(fsys, name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed to exclusively create file %s", name))
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				retErr = errors.Join(retErr, err)
+			}
+		}()
+		if _, err := f.Write(data); err != nil {
+			return kerrors.WithMsg(err, fmt.Sprintf("Failed to write file %s", name))
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+	f, err := kfs.OpenFile(fsys, name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err == nil {
+		return errors.Join(
+			kerrors.WithMsg(nil, fmt.Sprintf("Exclusively created existing file %s", name)),
+			f.Close(),
+		)
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		return kerrors.WithMsg(err, fmt.Sprintf("Expected exclusive create of existing file %s to fail with %v", name, fs.ErrExist))
+	}
+	if err := TestFileOpen(fsys, name, data); err != nil {
+		return err
+	}
+	return nil
+}
+
+type (
+	// TestFSErrorsOpts configures [TestFSErrors]
+	TestFSErrorsOpts struct {
+		// Existing is the name of an existing regular file used to test that
+		// ReadDir of a file fails with [kfs.ErrNotDir], and that O_EXCL fails
+		// with [fs.ErrExist]. If empty, both checks are skipped.
+		Existing string
+		// ReadOnly is whether the fs is expected to reject all writes with
+		// [fs.ErrPermission]
+		ReadOnly bool
+	}
+)
+
+const (
+	testMissingName = "kfstest-dne/dne.txt"
+)
+
+var testInvalidNames = []string{
+	"",
+	"/abs.txt",
+	"../escape.txt",
+	"kfstest/../dne.txt",
+	"kfstest//dne.txt",
+	"kfstest/",
+}
+
+// checkPathError checks that err is a [*fs.PathError] for name that matches
+// target
+func checkPathError(err error, op string, name string, target error) error {
+	if err == nil {
+		return kerrors.WithMsg(nil, fmt.Sprintf("Expected %s of %q to fail with %v", op, name, target))
+	}
+	if !errors.Is(err, target) {
+		return kerrors.WithMsg(err, fmt.Sprintf("Expected %s of %q to fail with %v", op, name, target))
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		return kerrors.WithMsg(err, fmt.Sprintf("Expected %s of %q to return a path error", op, name))
+	}
+	if pathErr.Op == "" {
+		return kerrors.WithMsg(err, fmt.Sprintf("Path error op for %s of %q is unset", op, name))
+	}
+	if pathErr.Path != name {
+		return kerrors.WithMsg(err, fmt.Sprintf("Path error path %q for %s does not match %q", pathErr.Path, op, name))
+	}
+	return nil
+}
+
+// isNotImplemented returns whether err is due to fsys not implementing an
+// optional operation
+func isNotImplemented(err error) bool {
+	return errors.Is(err, kfs.ErrNotImplemented)
+}
+
+// TestFSErrors tests fs error behavior:
+//
+//   - [fs.ErrNotExist] for missing files
+//   - [fs.ErrInvalid] for invalid paths
+//   - [fs.ErrExist] for O_EXCL on existing files
+//   - [kfs.ErrNotDir] for reading an existing file as a directory
+//   - [fs.ErrPermission] for writes to read-only fs
+//
+// Each error is checked to be a [*fs.PathError] with its Op set and its Path
+// set to the name of the file. Optional operations that fsys does not
+// implement are skipped.
+func TestFSErrors(fsys fs.FS, opts TestFSErrorsOpts) error {
+	type readOp struct {
+		name string
+		fn   func(name string) error
+	}
+	readOps := []readOp{
+		{name: "open", fn: func(name string) error {
+			f, err := fsys.Open(name)
+			if err == nil {
+				return f.Close()
+			}
+			return err
+		}},
+		{name: "stat", fn: func(name string) error {
+			_, err := fs.Stat(fsys, name)
+			return err
+		}},
+		{name: "readfile", fn: func(name string) error {
+			_, err := fs.ReadFile(fsys, name)
+			return err
+		}},
+		{name: "readdir", fn: func(name string) error {
+			_, err := fs.ReadDir(fsys, name)
+			return err
+		}},
+		{name: "lstat", fn: func(name string) error {
+			_, err := kfs.Lstat(fsys, name)
+			return err
+		}},
+		{name: "readlink", fn: func(name string) error {
+			_, err := kfs.ReadLink(fsys, name)
+			return err
+		}},
+	}
+
+	for _, i := range readOps {
+		if err := i.fn(testMissingName); !isNotImplemented(err) {
+			if err := checkPathError(err, i.name, testMissingName, fs.ErrNotExist); err != nil {
+				return err
+			}
+		}
+		for _, j := range testInvalidNames {
+			if err := i.fn(j); !isNotImplemented(err) {
+				if err := checkPathError(err, i.name, j, fs.ErrInvalid); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, i := range testInvalidNames {
+		if _, err := kfs.OpenFile(fsys, i, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644); !isNotImplemented(err) {
+			if err := checkPathError(err, "openfile", i, fs.ErrInvalid); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.ReadOnly {
+		if _, err := kfs.OpenFile(fsys, testMissingName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644); !isNotImplemented(err) {
+			if err := checkPathError(err, "openfile", testMissingName, fs.ErrPermission); err != nil {
+				return err
+			}
+		}
+		if err := kfs.Remove(fsys, testMissingName); !isNotImplemented(err) {
+			if err := checkPathError(err, "remove", testMissingName, fs.ErrPermission); err != nil {
+				return err
+			}
+		}
+		if err := kfs.RemoveAll(fsys, testMissingName); !isNotImplemented(err) {
+			if err := checkPathError(err, "removeall", testMissingName, fs.ErrPermission); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, err := kfs.OpenFile(fsys, testMissingName, os.O_RDONLY, 0); !isNotImplemented(err) {
+		if err := checkPathError(err, "openfile", testMissingName, fs.ErrNotExist); err != nil {
+			return err
+		}
+	}
+	if err := kfs.Remove(fsys, testMissingName); !isNotImplemented(err) {
+		if err := checkPathError(err, "remove", testMissingName, fs.ErrNotExist); err != nil {
+			return err
+		}
+	}
+	if opts.Existing != "" {
+		_, err := fs.ReadDir(fsys, opts.Existing)
+		if err := checkPathError(err, "readdir", opts.Existing, kfs.ErrNotDir); err != nil {
+			return err
+		}
+		if f, err := kfs.OpenFile(fsys, opts.Existing, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644); !isNotImplemented(err) {
+			if err == nil {
+				if err := f.Close(); err != nil {
+					return kerrors.WithMsg(err, fmt.Sprintf("Failed closing file %s", opts.Existing))
+				}
+			}
+			if err := checkPathError(err, "openfile", opts.Existing, fs.ErrExist); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
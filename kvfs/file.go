@@ -0,0 +1,314 @@
+package kvfs
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"xorkevin.dev/kerrors"
+	"xorkevin.dev/kfs"
+)
+
+type (
+	// file is a regular file opened from an [FS]
+	file struct {
+		fsys   *FS
+		name   string
+		p      string
+		node   *node
+		offset int64
+		read   bool
+		write  bool
+		append bool
+		dirty  bool
+		closed bool
+	}
+
+	fileInfo struct {
+		name string
+		node *node
+	}
+)
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return &fileInfo{
+		name: path.Base(f.name),
+		node: f.node,
+	}, nil
+}
+
+func (f *file) assertOpen(op string) error {
+	if f.closed {
+		return &fs.PathError{
+			Op:   op,
+			Path: f.name,
+			Err:  fs.ErrClosed,
+		}
+	}
+	return nil
+}
+
+func (f *file) assertReader(op string) error {
+	if err := f.assertOpen(op); err != nil {
+		return err
+	}
+	if !f.read {
+		return &fs.PathError{
+			Op:   op,
+			Path: f.name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "File not open for reading"),
+		}
+	}
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if err := f.assertReader("read"); err != nil {
+		return 0, err
+	}
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err != nil && n > 0 {
+		// Read returns io.EOF only when no bytes are read
+		err = nil
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, offset int64) (int, error) {
+	if err := f.assertReader("readat"); err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{
+			Op:   "readat",
+			Path: f.name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Negative offset"),
+		}
+	}
+	if offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if err := f.assertOpen("seek"); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	default:
+		return 0, &fs.PathError{
+			Op:   "seek",
+			Path: f.name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid whence"),
+		}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{
+			Op:   "seek",
+			Path: f.name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Negative offset"),
+		}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) assertWriter(op string) error {
+	if err := f.assertOpen(op); err != nil {
+		return err
+	}
+	if !f.write {
+		return &fs.PathError{
+			Op:   op,
+			Path: f.name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "File not open for writing"),
+		}
+	}
+	return nil
+}
+
+// writeAt writes p at offset, zero filling any gap past the end of the file
+func (f *file) writeAt(p []byte, offset int64) int {
+	if end := offset + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	f.dirty = true
+	return copy(f.node.data[offset:], p)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if err := f.assertWriter("write"); err != nil {
+		return 0, err
+	}
+	if f.append {
+		f.offset = int64(len(f.node.data))
+	}
+	n := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *file) WriteAt(p []byte, offset int64) (int, error) {
+	if err := f.assertWriter("writeat"); err != nil {
+		return 0, err
+	}
+	if f.append {
+		return 0, &fs.PathError{
+			Op:   "writeat",
+			Path: f.name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "May not write at offset when appending"),
+		}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{
+			Op:   "writeat",
+			Path: f.name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Negative offset"),
+		}
+	}
+	return f.writeAt(p, offset), nil
+}
+
+// Truncate implements [kfs.TruncatableFile]
+func (f *file) Truncate(size int64) error {
+	if err := f.assertWriter("truncate"); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: f.name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Negative size"),
+		}
+	}
+	if size <= int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size]
+	} else {
+		f.node.data = append(f.node.data, make([]byte, size-int64(len(f.node.data)))...)
+	}
+	f.dirty = true
+	return nil
+}
+
+// store stores the contents of the file if it was written
+func (f *file) store(op string) error {
+	if !f.dirty {
+		return nil
+	}
+	f.node.modTime = time.Now()
+	if err := f.fsys.putNode(f.p, f.node); err != nil {
+		return &fs.PathError{
+			Op:   op,
+			Path: f.name,
+			Err:  err,
+		}
+	}
+	f.dirty = false
+	return nil
+}
+
+// Sync implements [kfs.SyncFile] by storing the contents of the file if it
+// was written
+func (f *file) Sync() error {
+	if err := f.assertOpen("sync"); err != nil {
+		return err
+	}
+	return f.store("sync")
+}
+
+// Close stores the contents of the file if it was written
+func (f *file) Close() error {
+	if err := f.assertOpen("close"); err != nil {
+		return err
+	}
+	f.closed = true
+	return f.store("close")
+}
+
+type (
+	// dirFile is a directory opened from an [FS]
+	dirFile struct {
+		name    string
+		node    *node
+		entries []fs.DirEntry
+	}
+)
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return &fileInfo{
+		name: path.Base(d.name),
+		node: d.node,
+	}, nil
+}
+
+func (d *dirFile) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "read",
+		Path: d.name,
+		Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "File is a directory"),
+	}
+}
+
+func (d *dirFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "write",
+		Path: d.name,
+		Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "File is a directory"),
+	}
+}
+
+// ReadDir implements [fs.ReadDirFile]
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		res := d.entries
+		d.entries = nil
+		return res, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	k := min(n, len(d.entries))
+	res := d.entries[:k:k]
+	d.entries = d.entries[k:]
+	return res, nil
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+func (i *fileInfo) Name() string {
+	return i.name
+}
+
+func (i *fileInfo) Size() int64 {
+	return int64(len(i.node.data))
+}
+
+func (i *fileInfo) Mode() fs.FileMode {
+	return i.node.mode
+}
+
+func (i *fileInfo) ModTime() time.Time {
+	return i.node.modTime
+}
+
+func (i *fileInfo) IsDir() bool {
+	return i.node.mode.IsDir()
+}
+
+func (i *fileInfo) Sys() any {
+	return nil
+}
@@ -0,0 +1,1124 @@
+// Package kvfs implements a [kfs.FS] backed by an ordered key value store
+//
+// Each file is stored as one value. Its key is the dir of the file and its
+// name joined by a NUL byte, with the root dir being ".", so that the
+// children of a dir are listed with a single prefix scan. The root dir itself
+// is not stored. Each value is encoded as:
+//
+//   - 1 byte format version, currently 1
+//   - 4 byte big-endian [fs.FileMode]
+//   - 8 byte big-endian mod time in nanoseconds since the unix epoch
+//   - the contents of a regular file or the target of a symlink
+package kvfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"xorkevin.dev/kerrors"
+	"xorkevin.dev/kfs"
+)
+
+const (
+	// maxLinkDepth is the max number of symlinks followed in resolving a path
+	maxLinkDepth = 40
+	// maxTempAttempts is the max number of names tried for a temporary file,
+	// as for [kfs.CreateTemp]
+	maxTempAttempts = 10000
+)
+
+type (
+	// FS is a [kfs.FS] backed by a [Store]
+	FS struct {
+		store Store
+		dir   string
+	}
+)
+
+// New creates a new [FS] backed by store
+func New(store Store) *FS {
+	return &FS{
+		store: store,
+		dir:   ".",
+	}
+}
+
+// checkName validates name and returns its path in the store
+func (f *FS) checkName(op string, name string) (string, error) {
+	if !fs.ValidPath(name) || strings.Contains(name, keySep) {
+		return "", &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return path.Join(f.dir, name), nil
+}
+
+func (f *FS) getNode(p string) (*node, error) {
+	if p == "." {
+		return &node{
+			mode: fs.ModeDir | 0o755,
+		}, nil
+	}
+	b, err := f.store.Get(nodeKey(p))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, kerrors.WithKind(err, fs.ErrNotExist, "File does not exist")
+		}
+		return nil, kerrors.WithMsg(err, "Failed to get file")
+	}
+	return decodeNode(b)
+}
+
+func (f *FS) putNode(p string, n *node) error {
+	if err := f.store.Put(nodeKey(p), n.encode()); err != nil {
+		return kerrors.WithMsg(err, "Failed to put file")
+	}
+	return nil
+}
+
+// swapNode stores n at store path p only if its node is still old, or if old
+// is nil, only if p does not exist
+//
+// The swap is only atomic if the store is a [CASStore].
+func (f *FS) swapNode(p string, old, n *node) (bool, error) {
+	s, ok := f.store.(CASStore)
+	if !ok {
+		return true, f.putNode(p, n)
+	}
+	var oldValue []byte
+	if old != nil {
+		oldValue = old.encode()
+	}
+	swapped, err := s.CompareAndSwap(nodeKey(p), oldValue, n.encode())
+	if err != nil {
+		return false, kerrors.WithMsg(err, "Failed to swap file")
+	}
+	return swapped, nil
+}
+
+// resolve returns the store path and node of p, following symlinks in all
+// but the last path component, and also in the last if follow is true
+func (f *FS) resolve(p string, follow bool) (string, *node, error) {
+	var rest []string
+	if p != "." {
+		rest = strings.Split(p, "/")
+	}
+	cur := "."
+	curNode, err := f.getNode(cur)
+	if err != nil {
+		return "", nil, err
+	}
+	links := 0
+	for len(rest) > 0 {
+		if !curNode.mode.IsDir() {
+			return "", nil, kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, fmt.Sprintf("Parent %s is not a directory", cur))
+		}
+		next := path.Join(cur, rest[0])
+		n, err := f.getNode(next)
+		if err != nil {
+			return "", nil, err
+		}
+		rest = rest[1:]
+		if n.mode.Type() != fs.ModeSymlink || (len(rest) == 0 && !follow) {
+			cur = next
+			curNode = n
+			continue
+		}
+		links++
+		if links > maxLinkDepth {
+			return "", nil, kerrors.WithKind(fs.ErrInvalid, kfs.ErrLinkLoop, fmt.Sprintf("Too many links resolving %s", p))
+		}
+		target := string(n.data)
+		if path.IsAbs(target) {
+			return "", nil, kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is absolute", target))
+		}
+		target = path.Join(cur, target)
+		if !fs.ValidPath(target) {
+			return "", nil, kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the FS", target))
+		}
+		// resolve the target from the root
+		if target != "." {
+			rest = append(strings.Split(target, "/"), rest...)
+		}
+		cur = "."
+		curNode, err = f.getNode(cur)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return cur, curNode, nil
+}
+
+func (f *FS) lookup(op string, name string, follow bool) (string, *node, error) {
+	p, err := f.checkName(op, name)
+	if err != nil {
+		return "", nil, err
+	}
+	p, n, err := f.resolve(p, follow)
+	if err != nil {
+		return "", nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  err,
+		}
+	}
+	return p, n, nil
+}
+
+// mkdirAll creates the dir at store path p and any missing parents, and
+// returns its resolved store path
+func (f *FS) mkdirAll(p string) (string, error) {
+	if p == "." {
+		return p, nil
+	}
+	resolved, n, err := f.resolve(p, true)
+	if err == nil {
+		if !n.mode.IsDir() {
+			return "", kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, fmt.Sprintf("Parent %s is not a directory", p))
+		}
+		return resolved, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if _, _, err := f.resolve(p, false); err == nil {
+		return "", kerrors.WithMsg(fs.ErrNotExist, fmt.Sprintf("Target of link %s does not exist", p))
+	}
+	parent, err := f.mkdirAll(path.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	resolved = path.Join(parent, path.Base(p))
+	if err := f.putNode(resolved, &node{
+		mode:    fs.ModeDir | 0o755,
+		modTime: time.Now(),
+	}); err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+func (f *FS) readDir(p string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	prefix := childPrefix(p)
+	if err := f.store.Scan(prefix, func(key string, value []byte) error {
+		n, err := decodeNode(value)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(&fileInfo{
+			name: strings.TrimPrefix(key, prefix),
+			node: n,
+		}))
+		return nil
+	}); err != nil {
+		return nil, kerrors.WithMsg(err, "Failed to scan dir")
+	}
+	return entries, nil
+}
+
+func (f *FS) hasChildren(p string) (bool, error) {
+	found := false
+	errFound := errors.New("found")
+	if err := f.store.Scan(childPrefix(p), func(key string, value []byte) error {
+		found = true
+		return errFound
+	}); err != nil && !errors.Is(err, errFound) {
+		return false, kerrors.WithMsg(err, "Failed to scan dir")
+	}
+	return found, nil
+}
+
+// Open implements [fs.FS]
+func (f *FS) Open(name string) (fs.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Stat implements [fs.StatFS]
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	_, n, err := f.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{
+		name: path.Base(name),
+		node: n,
+	}, nil
+}
+
+// ReadDir implements [fs.ReadDirFS]
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, n, err := f.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, "File is not a directory"),
+		}
+	}
+	entries, err := f.readDir(p)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return entries, nil
+}
+
+// ReadFile implements [fs.ReadFileFS]
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	_, n, err := f.lookup("readfile", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return nil, &fs.PathError{
+			Op:   "readfile",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "File is a directory"),
+		}
+	}
+	return n.data, nil
+}
+
+// Glob implements [fs.GlobFS]
+func (f *FS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(struct{ fs.ReadDirFS }{f}, pattern)
+}
+
+// Sub implements [fs.SubFS]
+func (f *FS) Sub(dir string) (fs.FS, error) {
+	p, err := f.checkName("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	return &FS{
+		store: f.store,
+		dir:   p,
+	}, nil
+}
+
+// FullFilePath implements [kfs.FullFilePathFS]
+//
+// Files in the store have no os path, so it always returns an error.
+func (f *FS) FullFilePath(name string) (string, error) {
+	return "", &fs.PathError{
+		Op:   "fullfilepath",
+		Path: name,
+		Err:  kerrors.WithMsg(kfs.ErrNotImplemented, "Files in a key value store have no os path"),
+	}
+}
+
+// Lstat implements [kfs.LstatFS]
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	_, n, err := f.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{
+		name: path.Base(name),
+		node: n,
+	}, nil
+}
+
+// ReadLink implements [kfs.ReadLinkFS]
+func (f *FS) ReadLink(name string) (string, error) {
+	_, n, err := f.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode.Type() != fs.ModeSymlink {
+		return "", &fs.PathError{
+			Op:   "readlink",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "File is not a link"),
+		}
+	}
+	return string(n.data), nil
+}
+
+// Symlink implements [kfs.MakeSymlinkFS]
+//
+// It will create any directories in the path of newname with 0o755.
+func (f *FS) Symlink(oldname, newname string) error {
+	if oldname == "" {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	if path.IsAbs(oldname) {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is absolute", oldname)),
+		}
+	}
+	if _, _, err := f.lookup("symlink", newname, false); err == nil {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrExist, "File already exists"),
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	sp, _ := f.checkName("symlink", newname)
+	if !fs.ValidPath(path.Join(path.Dir(sp), oldname)) {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(kfs.ErrTargetOutsideFS, fmt.Sprintf("Target %s is outside the FS", oldname)),
+		}
+	}
+	parent, err := f.mkdirAll(path.Dir(sp))
+	if err != nil {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: err,
+		}
+	}
+	if err := f.putNode(path.Join(parent, path.Base(sp)), &node{
+		mode:    fs.ModeSymlink | 0o777,
+		modTime: time.Now(),
+		data:    []byte(oldname),
+	}); err != nil {
+		return &os.LinkError{
+			Op:  "symlink",
+			Old: oldname,
+			New: newname,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// OpenFile implements [kfs.WriteFS]
+//
+// When O_CREATE is set, it will create any directories in the path of the
+// file with 0o755. Writes are buffered in memory and stored when the file is
+// closed.
+func (f *FS) OpenFile(name string, flag int, mode fs.FileMode) (kfs.File, error) {
+	p, n, err := f.lookup("openfile", name, true)
+	if err != nil {
+		if flag&os.O_CREATE == 0 || !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		n = nil
+	}
+	isRead, isWrite := isReadWrite(flag)
+	if n != nil {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &fs.PathError{
+				Op:   "openfile",
+				Path: name,
+				Err:  kerrors.WithMsg(fs.ErrExist, "File already exists"),
+			}
+		}
+		if n.mode.IsDir() {
+			if isWrite {
+				return nil, &fs.PathError{
+					Op:   "openfile",
+					Path: name,
+					Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "File is a directory"),
+				}
+			}
+			entries, err := f.readDir(p)
+			if err != nil {
+				return nil, &fs.PathError{
+					Op:   "openfile",
+					Path: name,
+					Err:  err,
+				}
+			}
+			return &dirFile{
+				name:    name,
+				node:    n,
+				entries: entries,
+			}, nil
+		}
+		fi := &file{
+			fsys:   f,
+			name:   name,
+			p:      p,
+			node:   n,
+			read:   isRead,
+			write:  isWrite,
+			append: flag&os.O_APPEND != 0,
+		}
+		if isWrite && flag&os.O_TRUNC != 0 {
+			fi.node.data = nil
+			fi.dirty = true
+		}
+		return fi, nil
+	}
+
+	if _, _, err := f.lookup("openfile", name, false); err == nil {
+		return nil, &fs.PathError{
+			Op:   "openfile",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrNotExist, "Target of link does not exist"),
+		}
+	}
+	sp, _ := f.checkName("openfile", name)
+	parent, err := f.mkdirAll(path.Dir(sp))
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "openfile",
+			Path: name,
+			Err:  err,
+		}
+	}
+	p = path.Join(parent, path.Base(sp))
+	n = &node{
+		mode:    mode.Perm(),
+		modTime: time.Now(),
+	}
+	if err := f.putNode(p, n); err != nil {
+		return nil, &fs.PathError{
+			Op:   "openfile",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return &file{
+		fsys:   f,
+		name:   name,
+		p:      p,
+		node:   n,
+		read:   isRead,
+		write:  isWrite,
+		append: flag&os.O_APPEND != 0,
+	}, nil
+}
+
+// Remove implements [kfs.RemoveFS]
+func (f *FS) Remove(name string) error {
+	p, n, err := f.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if p == "." {
+		return &fs.PathError{
+			Op:   "remove",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "May not remove the root dir"),
+		}
+	}
+	if n.mode.IsDir() {
+		if ok, err := f.hasChildren(p); err != nil {
+			return &fs.PathError{
+				Op:   "remove",
+				Path: name,
+				Err:  err,
+			}
+		} else if ok {
+			return &fs.PathError{
+				Op:   "remove",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrDirNotEmpty, "Directory is not empty"),
+			}
+		}
+	}
+	if err := f.store.Delete(nodeKey(p)); err != nil {
+		return &fs.PathError{
+			Op:   "remove",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to delete file"),
+		}
+	}
+	return nil
+}
+
+func (f *FS) removeAll(p string, n *node) error {
+	if n.mode.IsDir() {
+		var children []string
+		var childNodes []*node
+		prefix := childPrefix(p)
+		if err := f.store.Scan(prefix, func(key string, value []byte) error {
+			c, err := decodeNode(value)
+			if err != nil {
+				return err
+			}
+			children = append(children, path.Join(p, strings.TrimPrefix(key, prefix)))
+			childNodes = append(childNodes, c)
+			return nil
+		}); err != nil {
+			return kerrors.WithMsg(err, "Failed to scan dir")
+		}
+		for i, c := range children {
+			if err := f.removeAll(c, childNodes[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if p == "." {
+		return nil
+	}
+	if err := f.store.Delete(nodeKey(p)); err != nil {
+		return kerrors.WithMsg(err, "Failed to delete file")
+	}
+	return nil
+}
+
+// RemoveAll implements [kfs.RemoveAllFS]
+//
+// Removing the root dir removes all of its children.
+func (f *FS) RemoveAll(name string) error {
+	p, n, err := f.lookup("removeall", name, false)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := f.removeAll(p, n); err != nil {
+		return &fs.PathError{
+			Op:   "removeall",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// moveAll moves the file at store path p and all of its children to store
+// path np
+func (f *FS) moveAll(p, np string, n *node) error {
+	if err := f.putNode(np, n); err != nil {
+		return err
+	}
+	if n.mode.IsDir() {
+		var children []string
+		var childNodes []*node
+		prefix := childPrefix(p)
+		if err := f.store.Scan(prefix, func(key string, value []byte) error {
+			c, err := decodeNode(value)
+			if err != nil {
+				return err
+			}
+			children = append(children, strings.TrimPrefix(key, prefix))
+			childNodes = append(childNodes, c)
+			return nil
+		}); err != nil {
+			return kerrors.WithMsg(err, "Failed to scan dir")
+		}
+		for i, c := range children {
+			if err := f.moveAll(path.Join(p, c), path.Join(np, c), childNodes[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := f.store.Delete(nodeKey(p)); err != nil {
+		return kerrors.WithMsg(err, "Failed to delete file")
+	}
+	return nil
+}
+
+// Rename implements [kfs.RenameFS]
+//
+// Each file is moved with a separate put and delete, so renaming is not
+// atomic, and renaming a dir moves each of its descendants in turn.
+func (f *FS) Rename(oldname, newname string) error {
+	p, n, err := f.lookup("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+	sp, err := f.checkName("rename", newname)
+	if err != nil {
+		return err
+	}
+	if p == "." || sp == "." {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrInvalid, "May not rename the root dir"),
+		}
+	}
+	parent, err := f.mkdirAll(path.Dir(sp))
+	if err != nil {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: err,
+		}
+	}
+	np := path.Join(parent, path.Base(sp))
+	if np == p {
+		return nil
+	}
+	if n.mode.IsDir() && strings.HasPrefix(np, p+"/") {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: kerrors.WithMsg(fs.ErrInvalid, "May not move a dir into itself"),
+		}
+	}
+	if target, err := f.getNode(np); err == nil {
+		if target.mode.IsDir() {
+			if !n.mode.IsDir() {
+				return &os.LinkError{
+					Op:  "rename",
+					Old: oldname,
+					New: newname,
+					Err: kerrors.WithKind(fs.ErrExist, kfs.ErrIsDir, "Target is a directory"),
+				}
+			}
+			if ok, err := f.hasChildren(np); err != nil {
+				return &os.LinkError{
+					Op:  "rename",
+					Old: oldname,
+					New: newname,
+					Err: err,
+				}
+			} else if ok {
+				return &os.LinkError{
+					Op:  "rename",
+					Old: oldname,
+					New: newname,
+					Err: kerrors.WithKind(fs.ErrExist, kfs.ErrDirNotEmpty, "Directory is not empty"),
+				}
+			}
+		} else if n.mode.IsDir() {
+			return &os.LinkError{
+				Op:  "rename",
+				Old: oldname,
+				New: newname,
+				Err: kerrors.WithKind(fs.ErrExist, kfs.ErrNotDir, "Target is not a directory"),
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: err,
+		}
+	}
+	if err := f.moveAll(p, np, n); err != nil {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldname,
+			New: newname,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// Truncate implements [kfs.TruncateFS]
+func (f *FS) Truncate(name string, size int64) error {
+	p, n, err := f.lookup("truncate", name, true)
+	if err != nil {
+		return err
+	}
+	if n.mode.IsDir() {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrIsDir, "File is a directory"),
+		}
+	}
+	if size < 0 {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Negative size"),
+		}
+	}
+	data := make([]byte, size)
+	copy(data, n.data)
+	n.data = data
+	n.modTime = time.Now()
+	if err := f.putNode(p, n); err != nil {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// Chtimes implements [kfs.ChtimesFS]
+//
+// Access times are not stored, so atime is ignored. A zero mtime leaves the
+// mod time unchanged.
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return f.chtimes("chtimes", name, true, mtime)
+}
+
+// Lchtimes implements [kfs.LchtimesFS]
+//
+// Access times are not stored, so atime is ignored. A zero mtime leaves the
+// mod time unchanged.
+func (f *FS) Lchtimes(name string, atime, mtime time.Time) error {
+	return f.chtimes("lchtimes", name, false, mtime)
+}
+
+func (f *FS) chtimes(op string, name string, follow bool, mtime time.Time) error {
+	p, n, err := f.lookup(op, name, follow)
+	if err != nil {
+		return err
+	}
+	if p == "." || mtime.IsZero() {
+		return nil
+	}
+	n.modTime = mtime
+	if err := f.putNode(p, n); err != nil {
+		return &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// Chmod implements [kfs.ChmodFS]
+//
+// Only the permission bits of mode are used. The mode of the root dir may not
+// be changed.
+func (f *FS) Chmod(name string, mode fs.FileMode) error {
+	p, n, err := f.lookup("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	if p == "." {
+		return &fs.PathError{
+			Op:   "chmod",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "May not change the mode of the root dir"),
+		}
+	}
+	n.mode = n.mode&^fs.ModePerm | mode.Perm()
+	if err := f.putNode(p, n); err != nil {
+		return &fs.PathError{
+			Op:   "chmod",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// Chown implements [kfs.ChownFS]
+//
+// Owners are not stored, so Chown always fails with [kfs.ErrNotImplemented].
+func (f *FS) Chown(name string, uid, gid int) error {
+	if _, _, err := f.lookup("chown", name, true); err != nil {
+		return err
+	}
+	return &fs.PathError{
+		Op:   "chown",
+		Path: name,
+		Err:  kerrors.WithMsg(kfs.ErrNotImplemented, "Owners are not stored"),
+	}
+}
+
+// Lchown implements [kfs.LchownFS]
+//
+// Owners are not stored, so Lchown always fails with [kfs.ErrNotImplemented].
+func (f *FS) Lchown(name string, uid, gid int) error {
+	if _, _, err := f.lookup("lchown", name, false); err != nil {
+		return err
+	}
+	return &fs.PathError{
+		Op:   "lchown",
+		Path: name,
+		Err:  kerrors.WithMsg(kfs.ErrNotImplemented, "Owners are not stored"),
+	}
+}
+
+// Mkfifo implements [kfs.MkfifoFS]
+//
+// The pipe is recorded with [fs.ModeNamedPipe], but as the store may be
+// shared beyond this process, it is not simulated, and reads and writes do
+// not block as they would on a real pipe. Use
+// [xorkevin.dev/kfs/kfstest.MapFS] to test code that coordinates over named
+// pipes.
+func (f *FS) Mkfifo(name string, mode fs.FileMode) error {
+	if _, _, err := f.lookup("mkfifo", name, false); err == nil {
+		return &fs.PathError{
+			Op:   "mkfifo",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrExist, "File already exists"),
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	sp, _ := f.checkName("mkfifo", name)
+	parent, err := f.mkdirAll(path.Dir(sp))
+	if err != nil {
+		return &fs.PathError{
+			Op:   "mkfifo",
+			Path: name,
+			Err:  err,
+		}
+	}
+	if err := f.putNode(path.Join(parent, path.Base(sp)), &node{
+		mode:    fs.ModeNamedPipe | mode.Perm(),
+		modTime: time.Now(),
+	}); err != nil {
+		return &fs.PathError{
+			Op:   "mkfifo",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// WriteFileIfMatch implements [kfs.ConditionalWriteFS]
+//
+// The write is atomic if the store is a [CASStore], and otherwise the check
+// and the write are not atomic with respect to other writers.
+func (f *FS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	if token == "" {
+		if _, _, err := f.lookup("writefileifmatch", name, false); err == nil {
+			return &fs.PathError{
+				Op:   "writefileifmatch",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrExist, kfs.ErrPreconditionFailed, "File already exists"),
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		sp, _ := f.checkName("writefileifmatch", name)
+		parent, err := f.mkdirAll(path.Dir(sp))
+		if err != nil {
+			return &fs.PathError{
+				Op:   "writefileifmatch",
+				Path: name,
+				Err:  err,
+			}
+		}
+		if ok, err := f.swapNode(path.Join(parent, path.Base(sp)), nil, &node{
+			mode:    perm.Perm(),
+			modTime: time.Now(),
+			data:    data,
+		}); err != nil {
+			return &fs.PathError{
+				Op:   "writefileifmatch",
+				Path: name,
+				Err:  err,
+			}
+		} else if !ok {
+			return &fs.PathError{
+				Op:   "writefileifmatch",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrExist, kfs.ErrPreconditionFailed, "File already exists"),
+			}
+		}
+		return nil
+	}
+
+	p, n, err := f.lookup("writefileifmatch", name, true)
+	if err != nil {
+		return err
+	}
+	if !n.mode.IsRegular() {
+		return &fs.PathError{
+			Op:   "writefileifmatch",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "File is not a regular file"),
+		}
+	}
+	if kfs.FileToken(&fileInfo{name: path.Base(name), node: n}) != token {
+		return &fs.PathError{
+			Op:   "writefileifmatch",
+			Path: name,
+			Err:  kerrors.WithKind(nil, kfs.ErrPreconditionFailed, "File token does not match"),
+		}
+	}
+	if ok, err := f.swapNode(p, n, &node{
+		mode:    n.mode,
+		modTime: time.Now(),
+		data:    data,
+	}); err != nil {
+		return &fs.PathError{
+			Op:   "writefileifmatch",
+			Path: name,
+			Err:  err,
+		}
+	} else if !ok {
+		return &fs.PathError{
+			Op:   "writefileifmatch",
+			Path: name,
+			Err:  kerrors.WithKind(nil, kfs.ErrPreconditionFailed, "File changed during write"),
+		}
+	}
+	return nil
+}
+
+// MkdirTemp implements [kfs.TempFS]
+//
+// It will create dir and any directories in its path with 0o755. The dir is
+// only created atomically if the store is a [CASStore].
+func (f *FS) MkdirTemp(dir, pattern string) (string, error) {
+	sp, err := f.checkName("mkdirtemp", dir)
+	if err != nil {
+		return "", err
+	}
+	parent, err := f.mkdirAll(sp)
+	if err != nil {
+		return "", &fs.PathError{
+			Op:   "mkdirtemp",
+			Path: dir,
+			Err:  err,
+		}
+	}
+	for range maxTempAttempts {
+		name, err := kfs.TempName(pattern)
+		if err != nil {
+			return "", &fs.PathError{
+				Op:   "mkdirtemp",
+				Path: dir,
+				Err:  err,
+			}
+		}
+		np := path.Join(parent, name)
+		if _, err := f.getNode(np); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", &fs.PathError{
+				Op:   "mkdirtemp",
+				Path: dir,
+				Err:  err,
+			}
+		}
+		swapped, err := f.swapNode(np, nil, &node{
+			mode:    fs.ModeDir | 0o700,
+			modTime: time.Now(),
+		})
+		if err != nil {
+			return "", &fs.PathError{
+				Op:   "mkdirtemp",
+				Path: dir,
+				Err:  err,
+			}
+		}
+		if swapped {
+			return path.Join(dir, name), nil
+		}
+	}
+	return "", &fs.PathError{
+		Op:   "mkdirtemp",
+		Path: dir,
+		Err:  kerrors.WithMsg(fs.ErrExist, "Failed to find an unused temp name"),
+	}
+}
+
+// CreateTemp implements [kfs.TempFS]
+//
+// The file is opened for reading and writing as with [FS.OpenFile].
+func (f *FS) CreateTemp(dir, pattern string) (kfs.File, string, error) {
+	if _, err := f.checkName("createtemp", dir); err != nil {
+		return nil, "", err
+	}
+	for range maxTempAttempts {
+		name, err := kfs.TempName(pattern)
+		if err != nil {
+			return nil, "", &fs.PathError{
+				Op:   "createtemp",
+				Path: dir,
+				Err:  err,
+			}
+		}
+		name = path.Join(dir, name)
+		file, err := f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				continue
+			}
+			return nil, "", err
+		}
+		return file, name, nil
+	}
+	return nil, "", &fs.PathError{
+		Op:   "createtemp",
+		Path: dir,
+		Err:  kerrors.WithMsg(fs.ErrExist, "Failed to find an unused temp name"),
+	}
+}
+
+// SyncDir implements [kfs.SyncFS]
+//
+// Entries are stored in the [Store] as they are changed, and are as durable as
+// the store, so SyncDir only checks that name is a directory.
+func (f *FS) SyncDir(name string) error {
+	_, n, err := f.lookup("syncdir", name, true)
+	if err != nil {
+		return err
+	}
+	if !n.mode.IsDir() {
+		return &fs.PathError{
+			Op:   "syncdir",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, kfs.ErrNotDir, "Not a directory"),
+		}
+	}
+	return nil
+}
+
+// Statfs implements [kfs.StatfsFS]
+//
+// The stats are those of the [Store] if it is a [StatfsStore], and otherwise
+// Statfs returns an error.
+func (f *FS) Statfs(name string) (*kfs.FSStats, error) {
+	if _, _, err := f.lookup("statfs", name, true); err != nil {
+		return nil, err
+	}
+	s, ok := f.store.(StatfsStore)
+	if !ok {
+		return nil, &fs.PathError{
+			Op:   "statfs",
+			Path: name,
+			Err:  kerrors.WithMsg(kfs.ErrNotImplemented, "Store does not report stats"),
+		}
+	}
+	stats, err := s.Statfs()
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "statfs",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to stat store"),
+		}
+	}
+	return stats, nil
+}
+
+func isReadWrite(flag int) (bool, bool) {
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		return true, false
+	case os.O_WRONLY:
+		return false, true
+	case os.O_RDWR:
+		return true, true
+	default:
+		return false, false
+	}
+}
@@ -0,0 +1,421 @@
+package kvfs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"xorkevin.dev/kfs"
+	"xorkevin.dev/kfs/kfstest"
+)
+
+func Test_FS(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	store := NewMemStore()
+	fsys := New(store)
+
+	{
+		var _ kfs.FS = fsys
+	}
+
+	testFiles := []kfstest.TestFSFile{
+		{
+			Name: "foo.txt",
+			Data: []byte("hello, world"),
+			Mode: 0o644,
+		},
+		{
+			Name: "bar/foobar.txt",
+			Data: []byte("foo bar"),
+			Mode: 0o644,
+		},
+	}
+	for _, i := range testFiles {
+		assert.NoError(kfstest.TestFileWrite(fsys, i.Name, i.Data))
+	}
+	assert.NoError(fsys.putNode("bar/link.txt", &node{
+		mode:    fs.ModeSymlink | 0o777,
+		modTime: time.Now(),
+		data:    []byte("foobar.txt"),
+	}))
+	assert.NoError(fsys.putNode("dirlink", &node{
+		mode:    fs.ModeSymlink | 0o777,
+		modTime: time.Now(),
+		data:    []byte("bar"),
+	}))
+
+	assert.NoError(fstest.TestFS(fsys, "foo.txt", "bar/foobar.txt", "bar/link.txt"))
+	testFiles = append(testFiles, kfstest.TestFSFile{
+		Name:       "bar/link.txt",
+		LinkTarget: "foobar.txt",
+	})
+	kfstest.RunFS(t, fsys, testFiles...)
+	assert.NoError(kfstest.TestReadDirFile(fsys, "."))
+	assert.NoError(kfstest.TestDirOpenFile(fsys, "bar"))
+	assert.NoError(kfstest.TestFSErrors(fsys, kfstest.TestFSErrorsOpts{
+		Existing: "foo.txt",
+	}))
+
+	// files persist in the store
+	assert.NoError(kfstest.TestFileOpen(New(store), "dirlink/foobar.txt", []byte("foo bar")))
+
+	assert.NoError(kfstest.TestFileAppend(fsys, "foo.txt", []byte(" again")))
+	assert.NoError(kfstest.TestFileWriteAt(fsys, "foo.txt", 7, []byte("there")))
+	assert.NoError(kfstest.TestFileWriteAt(fsys, "foo.txt", 32, []byte("past end")))
+	assert.NoError(kfstest.TestFileTruncate(fsys, "foo.txt"))
+	assert.NoError(kfstest.TestFileCreateExcl(fsys, "excl/excl.txt", []byte("excl")))
+
+	sub, err := fs.Sub(fsys, "dirlink")
+	assert.NoError(err)
+	assert.NoError(kfstest.TestFileWrite(sub, "sub/sub.txt", []byte("sub")))
+	assert.NoError(kfstest.TestFileOpen(fsys, "bar/sub/sub.txt", []byte("sub")))
+
+	assert.NoError(kfs.Mkfifo(fsys, "run/ctl", 0o600))
+	info, err := kfs.Lstat(fsys, "run/ctl")
+	assert.NoError(err)
+	assert.Equal(fs.ModeNamedPipe|0o600, info.Mode())
+
+	assert.NoError(fsys.putNode("dangling", &node{
+		mode:    fs.ModeSymlink | 0o777,
+		modTime: time.Now(),
+		data:    []byte("dne/dne.txt"),
+	}))
+	assert.NoError(fsys.putNode("escape", &node{
+		mode:    fs.ModeSymlink | 0o777,
+		modTime: time.Now(),
+		data:    []byte("../outside"),
+	}))
+	assert.NoError(fsys.putNode("loop", &node{
+		mode:    fs.ModeSymlink | 0o777,
+		modTime: time.Now(),
+		data:    []byte("loop"),
+	}))
+	_, err = fs.Stat(fsys, "dangling")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.ErrorIs(kfs.WriteFile(fsys, "dangling", []byte("dangling"), 0o644), fs.ErrNotExist)
+	_, err = fs.Stat(fsys, "escape")
+	assert.ErrorIs(err, kfs.ErrTargetOutsideFS)
+	_, err = fs.Stat(fsys, "loop")
+	assert.ErrorIs(err, fs.ErrInvalid)
+	assert.ErrorIs(err, kfs.ErrLinkLoop)
+	_, err = kfs.EvalSymlinks(fsys, "loop")
+	assert.ErrorIs(err, kfs.ErrLinkLoop)
+	_, err = fs.Stat(fsys, "foo.txt/child")
+	assert.ErrorIs(err, kfs.ErrNotDir)
+
+	assert.ErrorIs(kfs.Remove(fsys, "bar"), kfs.ErrDirNotEmpty)
+	assert.NoError(kfs.Remove(fsys, "dirlink"))
+	assert.NoError(kfstest.TestFileOpen(fsys, "bar/foobar.txt", []byte("foo bar")))
+	assert.NoError(kfs.RemoveAll(fsys, "bar"))
+	_, err = fs.Stat(fsys, "bar/sub/sub.txt")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.NoError(kfs.RemoveAll(fsys, "bar"))
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(kfs.Chtimes(fsys, "foo.txt", time.Time{}, mtime))
+	info, err = fs.Stat(New(store), "foo.txt")
+	assert.NoError(err)
+	assert.True(info.ModTime().Equal(mtime))
+
+	_, err = kfs.FullFilePath(fsys, "foo.txt")
+	assert.ErrorIs(err, kfs.ErrNotImplemented)
+
+	assert.NoError(kfs.RemoveAll(fsys, "."))
+	entries, err := fs.ReadDir(fsys, ".")
+	assert.NoError(err)
+	assert.Empty(entries)
+	assert.Empty(store.kv)
+}
+
+func Test_WriteFileIfMatch(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	store := NewMemStore()
+	fsys := New(store)
+
+	assert.NoError(fsys.WriteFileIfMatch("state/a.json", []byte("1"), 0o600, ""))
+	assert.ErrorIs(fsys.WriteFileIfMatch("state/a.json", []byte("2"), 0o600, ""), kfs.ErrPreconditionFailed)
+
+	info, err := fsys.Stat("state/a.json")
+	assert.NoError(err)
+	token := kfs.FileToken(info)
+
+	assert.NoError(fsys.WriteFileIfMatch("state/a.json", []byte("3"), 0o644, token))
+	b, err := fsys.ReadFile("state/a.json")
+	assert.NoError(err)
+	assert.Equal([]byte("3"), b)
+	info, err = fsys.Stat("state/a.json")
+	assert.NoError(err)
+	assert.Equal(fs.FileMode(0o600), info.Mode().Perm())
+	assert.ErrorIs(fsys.WriteFileIfMatch("state/a.json", []byte("4"), 0o600, token), kfs.ErrPreconditionFailed)
+}
+
+func Test_Rename(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := New(NewMemStore())
+
+	assert.NoError(kfs.WriteFile(fsys, "data/.a.txt.tmp", []byte("new"), 0o644))
+	assert.NoError(kfs.WriteFile(fsys, "data/a.txt", []byte("old"), 0o644))
+	assert.NoError(fsys.Rename("data/.a.txt.tmp", "data/a.txt"))
+	b, err := fsys.ReadFile("data/a.txt")
+	assert.NoError(err)
+	assert.Equal([]byte("new"), b)
+	_, err = fsys.Stat("data/.a.txt.tmp")
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	assert.NoError(kfs.WriteFile(fsys, "data/sub/b.txt", []byte("b"), 0o644))
+	assert.NoError(fsys.Rename("data", "moved/data"))
+	b, err = fsys.ReadFile("moved/data/sub/b.txt")
+	assert.NoError(err)
+	assert.Equal([]byte("b"), b)
+	_, err = fsys.Stat("data")
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	assert.ErrorIs(fsys.Rename("moved", "moved/data/inner"), fs.ErrInvalid)
+	assert.ErrorIs(fsys.Rename("moved/data/a.txt", "moved/data/sub"), kfs.ErrIsDir)
+	assert.ErrorIs(fsys.Rename("missing.txt", "other.txt"), fs.ErrNotExist)
+}
+
+func Test_Chmod(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := New(NewMemStore())
+
+	assert.NoError(kfs.WriteFile(fsys, "bin/run.sh", []byte("#!/bin/sh\n"), 0o644))
+	assert.NoError(fsys.Chmod("bin/run.sh", fs.ModeSetuid|0o755))
+	info, err := fsys.Stat("bin/run.sh")
+	assert.NoError(err)
+	assert.Equal(fs.FileMode(0o755), info.Mode())
+
+	assert.NoError(fsys.Chmod("bin", 0o700))
+	info, err = fsys.Stat("bin")
+	assert.NoError(err)
+	assert.Equal(fs.ModeDir|0o700, info.Mode())
+
+	assert.ErrorIs(fsys.Chmod(".", 0o700), fs.ErrInvalid)
+	assert.ErrorIs(fsys.Chmod("missing.sh", 0o700), fs.ErrNotExist)
+
+	assert.ErrorIs(fsys.Chown("bin/run.sh", 0, 0), kfs.ErrNotImplemented)
+	assert.ErrorIs(fsys.Lchown("missing.sh", 0, 0), fs.ErrNotExist)
+}
+
+func Test_Symlink(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := New(NewMemStore())
+
+	assert.NoError(kfs.WriteFile(fsys, "data/v1/conf.txt", []byte("conf"), 0o644))
+	assert.NoError(fsys.Symlink("v1", "data/current"))
+	assert.NoError(fsys.Symlink("../data/current/conf.txt", "etc/app.conf"))
+	data, err := fsys.ReadFile("etc/app.conf")
+	assert.NoError(err)
+	assert.Equal([]byte("conf"), data)
+	target, err := fsys.ReadLink("etc/app.conf")
+	assert.NoError(err)
+	assert.Equal("../data/current/conf.txt", target)
+
+	assert.ErrorIs(fsys.Symlink("v1", "data/current"), fs.ErrExist)
+	assert.ErrorIs(fsys.Symlink("/etc/passwd", "passwd"), kfs.ErrTargetOutsideFS)
+	assert.ErrorIs(fsys.Symlink("../../outside", "data/escape"), kfs.ErrTargetOutsideFS)
+}
+
+func Test_Truncate(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := New(NewMemStore())
+
+	assert.NoError(kfs.WriteFile(fsys, "log/app.log", []byte("hello world"), 0o644))
+	assert.NoError(fsys.Truncate("log/app.log", 5))
+	data, err := fsys.ReadFile("log/app.log")
+	assert.NoError(err)
+	assert.Equal([]byte("hello"), data)
+
+	f, err := fsys.OpenFile("log/app.log", os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(err)
+	assert.NoError(f.(kfs.TruncatableFile).Truncate(7))
+	_, err = f.Write([]byte("!"))
+	assert.NoError(err)
+	assert.NoError(f.Close())
+	data, err = fsys.ReadFile("log/app.log")
+	assert.NoError(err)
+	assert.Equal([]byte("hello\x00\x00!"), data)
+
+	assert.ErrorIs(fsys.Truncate("log", 0), kfs.ErrIsDir)
+	assert.ErrorIs(fsys.Truncate("log/app.log", -1), fs.ErrInvalid)
+	assert.ErrorIs(fsys.Truncate("missing.log", 0), fs.ErrNotExist)
+}
+
+func Test_Lchtimes(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := New(NewMemStore())
+
+	assert.NoError(kfs.WriteFile(fsys, "target.txt", []byte("hello"), 0o644))
+	assert.NoError(fsys.Symlink("target.txt", "link.txt"))
+	targetInfo, err := fsys.Stat("target.txt")
+	assert.NoError(err)
+
+	mtime := time.Unix(0, time.Date(2021, time.February, 3, 4, 5, 6, 0, time.UTC).UnixNano())
+	assert.NoError(fsys.Lchtimes("link.txt", time.Time{}, mtime))
+	info, err := fsys.Lstat("link.txt")
+	assert.NoError(err)
+	assert.True(mtime.Equal(info.ModTime()))
+	info, err = fsys.Stat("target.txt")
+	assert.NoError(err)
+	assert.True(targetInfo.ModTime().Equal(info.ModTime()))
+
+	assert.ErrorIs(fsys.Lchtimes("missing", time.Time{}, mtime), fs.ErrNotExist)
+}
+
+func Test_TempFS(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := New(NewMemStore())
+
+	dir, err := fsys.MkdirTemp("tmp", "build-*")
+	assert.NoError(err)
+	info, err := fsys.Stat(dir)
+	assert.NoError(err)
+	assert.Equal(fs.ModeDir|0o700, info.Mode())
+
+	f, name, err := fsys.CreateTemp(dir, "*.txt")
+	assert.NoError(err)
+	assert.Equal(dir, path.Dir(name))
+	_, err = f.Write([]byte("output"))
+	assert.NoError(err)
+	assert.NoError(f.Close())
+	data, err := fsys.ReadFile(name)
+	assert.NoError(err)
+	assert.Equal([]byte("output"), data)
+
+	_, err = fsys.MkdirTemp(".", "a/*")
+	assert.ErrorIs(err, fs.ErrInvalid)
+	_, _, err = fsys.CreateTemp(name, "*")
+	assert.ErrorIs(err, kfs.ErrNotDir)
+
+	{
+		// a store that never swaps does not loop forever
+		fsys := New(&noSwapStore{MemStore: NewMemStore()})
+		_, err := fsys.MkdirTemp(".", "build-*")
+		assert.ErrorIs(err, fs.ErrExist)
+	}
+}
+
+type (
+	// noSwapStore is a [CASStore] whose swaps always fail
+	noSwapStore struct {
+		*MemStore
+	}
+)
+
+func (s *noSwapStore) CompareAndSwap(key string, old, value []byte) (bool, error) {
+	return false, nil
+}
+
+func Test_Sync(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := New(NewMemStore())
+
+	f, err := fsys.OpenFile("data/state.json", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	assert.NoError(err)
+	_, err = f.Write([]byte("{}"))
+	assert.NoError(err)
+	assert.NoError(kfs.Sync(f))
+	data, err := fsys.ReadFile("data/state.json")
+	assert.NoError(err)
+	assert.Equal([]byte("{}"), data)
+	assert.NoError(f.Close())
+	assert.ErrorIs(kfs.Sync(f), fs.ErrClosed)
+
+	assert.NoError(fsys.SyncDir("data"))
+	assert.NoError(fsys.SyncDir("."))
+	assert.ErrorIs(fsys.SyncDir("data/state.json"), kfs.ErrNotDir)
+	assert.ErrorIs(fsys.SyncDir("missing"), fs.ErrNotExist)
+}
+
+type (
+	statfsStore struct {
+		*MemStore
+		stats kfs.FSStats
+	}
+)
+
+func (s *statfsStore) Statfs() (*kfs.FSStats, error) {
+	stats := s.stats
+	return &stats, nil
+}
+
+func Test_Statfs(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	fsys := New(NewMemStore())
+	assert.NoError(kfs.WriteFile(fsys, "cache/entry", []byte("hello"), 0o644))
+	_, err := fsys.Statfs("cache/entry")
+	assert.ErrorIs(err, kfs.ErrNotImplemented)
+
+	want := kfs.FSStats{
+		TotalBytes: 1 << 30,
+		FreeBytes:  1 << 20,
+		AvailBytes: 1 << 20,
+	}
+	fsys = New(&statfsStore{
+		MemStore: NewMemStore(),
+		stats:    want,
+	})
+	assert.NoError(kfs.WriteFile(fsys, "cache/entry", []byte("hello"), 0o644))
+	stats, err := fsys.Statfs("cache/entry")
+	assert.NoError(err)
+	assert.Equal(want, *stats)
+	_, err = fsys.Statfs("missing")
+	assert.ErrorIs(err, fs.ErrNotExist)
+}
+
+func Test_MemStoreCompareAndSwap(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	store := NewMemStore()
+	ok, err := store.CompareAndSwap("a", nil, []byte("1"))
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = store.CompareAndSwap("a", nil, []byte("2"))
+	assert.NoError(err)
+	assert.False(ok)
+	ok, err = store.CompareAndSwap("a", []byte("0"), []byte("2"))
+	assert.NoError(err)
+	assert.False(ok)
+	ok, err = store.CompareAndSwap("a", []byte("1"), []byte("2"))
+	assert.NoError(err)
+	assert.True(ok)
+	v, err := store.Get("a")
+	assert.NoError(err)
+	assert.Equal([]byte("2"), v)
+}
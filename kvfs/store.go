@@ -0,0 +1,197 @@
+package kvfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"xorkevin.dev/kerrors"
+	"xorkevin.dev/kfs"
+)
+
+// ErrKeyNotFound is returned when a key is not in a [Store]
+var ErrKeyNotFound errKeyNotFound
+
+type (
+	errKeyNotFound struct{}
+)
+
+func (e errKeyNotFound) Error() string {
+	return "Key not found"
+}
+
+type (
+	// Store is an ordered key value store
+	//
+	// Implementations must be safe for concurrent use.
+	Store interface {
+		// Get returns the value of a key, or an error matching [ErrKeyNotFound]
+		Get(key string) ([]byte, error)
+		// Put sets the value of a key
+		Put(key string, value []byte) error
+		// Delete deletes a key, and does nothing if the key does not exist
+		Delete(key string) error
+		// Scan calls fn for each key with prefix in ascending byte order, and
+		// stops at the first error returned by fn
+		Scan(prefix string, fn func(key string, value []byte) error) error
+	}
+)
+
+type (
+	// CASStore is a [Store] that may atomically compare and swap values
+	CASStore interface {
+		Store
+		// CompareAndSwap sets the value of a key only if its value is old, or if
+		// old is nil, only if the key does not exist. It returns whether the
+		// value was set.
+		CompareAndSwap(key string, old, value []byte) (bool, error)
+	}
+
+	// StatfsStore is a [Store] that may report its capacity and usage
+	StatfsStore interface {
+		Store
+		// Statfs returns the stats of the store
+		Statfs() (*kfs.FSStats, error)
+	}
+)
+
+type (
+	// MemStore is an in-memory [Store]
+	MemStore struct {
+		mu sync.RWMutex
+		kv map[string][]byte
+	}
+)
+
+// NewMemStore creates a new empty [MemStore]
+func NewMemStore() *MemStore {
+	return &MemStore{
+		kv: map[string][]byte{},
+	}
+}
+
+// Get implements [Store]
+func (s *MemStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.kv[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return slices.Clone(v), nil
+}
+
+// Put implements [Store]
+func (s *MemStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kv[key] = slices.Clone(value)
+	return nil
+}
+
+// Delete implements [Store]
+func (s *MemStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.kv, key)
+	return nil
+}
+
+// CompareAndSwap implements [CASStore]
+func (s *MemStore) CompareAndSwap(key string, old, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.kv[key]
+	if old == nil {
+		if ok {
+			return false, nil
+		}
+	} else if !ok || !bytes.Equal(v, old) {
+		return false, nil
+	}
+	s.kv[key] = slices.Clone(value)
+	return true, nil
+}
+
+// Scan implements [Store]
+//
+// fn is called on a snapshot of the matching keys, so it may modify s.
+func (s *MemStore) Scan(prefix string, fn func(key string, value []byte) error) error {
+	type kv struct {
+		k string
+		v []byte
+	}
+	var matches []kv
+	func() {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		for k, v := range s.kv {
+			if strings.HasPrefix(k, prefix) {
+				matches = append(matches, kv{k: k, v: slices.Clone(v)})
+			}
+		}
+	}()
+	slices.SortFunc(matches, func(a, b kv) int {
+		return strings.Compare(a.k, b.k)
+	})
+	for _, i := range matches {
+		if err := fn(i.k, i.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	nodeVersion    = 1
+	nodeHeaderSize = 13
+	// keySep separates the dir of a file from its name in a key, and may not
+	// appear in file names
+	keySep = "\x00"
+)
+
+type (
+	// node is a file stored as a value
+	node struct {
+		mode    fs.FileMode
+		modTime time.Time
+		// data is the contents of a regular file or the target of a symlink
+		data []byte
+	}
+)
+
+// nodeKey returns the key of a file, which is its dir and name joined by
+// keySep so that the children of a dir share a prefix
+func nodeKey(p string) string {
+	return path.Dir(p) + keySep + path.Base(p)
+}
+
+// childPrefix returns the key prefix of the children of a dir
+func childPrefix(dir string) string {
+	return dir + keySep
+}
+
+func (n *node) encode() []byte {
+	b := make([]byte, nodeHeaderSize+len(n.data))
+	b[0] = nodeVersion
+	binary.BigEndian.PutUint32(b[1:5], uint32(n.mode))
+	binary.BigEndian.PutUint64(b[5:13], uint64(n.modTime.UnixNano()))
+	copy(b[nodeHeaderSize:], n.data)
+	return b
+}
+
+func decodeNode(b []byte) (*node, error) {
+	if len(b) < nodeHeaderSize || b[0] != nodeVersion {
+		return nil, kerrors.WithMsg(fs.ErrInvalid, "Malformed file value")
+	}
+	return &node{
+		mode:    fs.FileMode(binary.BigEndian.Uint32(b[1:5])),
+		modTime: time.Unix(0, int64(binary.BigEndian.Uint64(b[5:13]))),
+		data:    b[nodeHeaderSize:],
+	}, nil
+}
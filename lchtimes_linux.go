@@ -0,0 +1,45 @@
+//go:build linux
+
+package kfs
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	atFDCWD           = -0x64
+	atSymlinkNofollow = 0x100
+	// utimeOmit is the nanoseconds of a timespec that is left unchanged by
+	// utimensat
+	utimeOmit = 1<<30 - 2
+)
+
+func utimeTimespec(t time.Time) syscall.Timespec {
+	if t.IsZero() {
+		return syscall.Timespec{Nsec: utimeOmit}
+	}
+	return syscall.NsecToTimespec(t.UnixNano())
+}
+
+func lchtimes(name string, atime, mtime time.Time) error {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return wrapOSErr(err, "Invalid path")
+	}
+	ts := [2]syscall.Timespec{utimeTimespec(atime), utimeTimespec(mtime)}
+	dirfd := atFDCWD
+	if _, _, errno := syscall.Syscall6(
+		syscall.SYS_UTIMENSAT,
+		uintptr(dirfd),
+		uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&ts[0])),
+		atSymlinkNofollow,
+		0,
+		0,
+	); errno != 0 {
+		return wrapOSErr(errno, "Failed to change link time metadata")
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package kfs
+
+import (
+	"time"
+
+	"xorkevin.dev/kerrors"
+)
+
+func lchtimes(name string, atime, mtime time.Time) error {
+	return kerrors.WithMsg(ErrNotImplemented, "Changing link time metadata is not supported on this platform")
+}
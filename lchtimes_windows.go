@@ -0,0 +1,46 @@
+//go:build windows
+
+package kfs
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+func filetime(t time.Time) *syscall.Filetime {
+	if t.IsZero() {
+		return nil
+	}
+	ft := syscall.NsecToFiletime(t.UnixNano())
+	return &ft
+}
+
+func lchtimes(name string, atime, mtime time.Time) (retErr error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return wrapOSErr(err, "Invalid path")
+	}
+	h, err := syscall.CreateFile(
+		p,
+		syscall.FILE_WRITE_ATTRIBUTES,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil,
+		syscall.OPEN_EXISTING,
+		syscall.FILE_FLAG_OPEN_REPARSE_POINT|syscall.FILE_FLAG_BACKUP_SEMANTICS,
+		0,
+	)
+	if err != nil {
+		return wrapOSErr(err, "Failed to open link")
+	}
+	defer func() {
+		if err := syscall.CloseHandle(h); err != nil {
+			retErr = errors.Join(retErr, wrapOSErr(err, "Failed to close link"))
+		}
+	}()
+	// a nil time is left unchanged
+	if err := syscall.SetFileTime(h, nil, filetime(atime), filetime(mtime)); err != nil {
+		return wrapOSErr(err, "Failed to change link time metadata")
+	}
+	return nil
+}
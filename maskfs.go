@@ -1,6 +1,8 @@
 package kfs
 
 import (
+	"errors"
+	"io"
 	"io/fs"
 	"path"
 	"time"
@@ -38,7 +40,7 @@ func (f *maskFS) checkFile(op string, name string) error {
 			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
 		}
 	}
-	ok, err := f.filter(path.Join(f.dir, name))
+	ok, err := f.filter(joinValidPath(f.dir, name))
 	if err != nil {
 		return &fs.PathError{
 			Op:   op,
@@ -56,11 +58,59 @@ func (f *maskFS) checkFile(op string, name string) error {
 	return nil
 }
 
+func (f *maskFS) filterEntries(op string, name string, entries []fs.DirEntry) ([]fs.DirEntry, error) {
+	paths := joinEntryPaths(joinValidPath(f.dir, name), entries)
+	res := make([]fs.DirEntry, 0, len(entries))
+	for n, i := range entries {
+		if ok, err := f.filter(paths[n]); err != nil {
+			return nil, &fs.PathError{
+				Op:   op,
+				Path: name,
+				Err:  kerrors.WithMsg(err, "Failed filtering dir entry"),
+			}
+		} else if !ok {
+			continue
+		}
+		res = append(res, i)
+	}
+	return res, nil
+}
+
+// maskDir wraps file with a [maskDirFile] if it is a directory, and otherwise
+// returns nil
+func (f *maskFS) maskDir(name string, file fs.File) (*maskDirFile, error) {
+	dir, ok := file.(fs.ReadDirFile)
+	if !ok {
+		return nil, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+	if !info.IsDir() {
+		return nil, nil
+	}
+	return &maskDirFile{
+		ReadDirFile: dir,
+		fsys:        f,
+		name:        name,
+	}, nil
+}
+
 func (f *maskFS) Open(name string) (fs.File, error) {
 	if err := f.checkFile("open", name); err != nil {
 		return nil, err
 	}
-	return f.fsys.Open(name)
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if d, err := f.maskDir(name, file); err != nil {
+		return nil, err
+	} else if d != nil {
+		return d, nil
+	}
+	return file, nil
 }
 
 func (f *maskFS) Stat(name string) (fs.FileInfo, error) {
@@ -78,21 +128,7 @@ func (f *maskFS) ReadDir(name string) ([]fs.DirEntry, error) {
 	if err != nil {
 		return nil, err
 	}
-	basePath := path.Join(f.dir, name)
-	res := make([]fs.DirEntry, 0, len(entries))
-	for _, i := range entries {
-		if ok, err := f.filter(path.Join(basePath, i.Name())); err != nil {
-			return nil, &fs.PathError{
-				Op:   "readdir",
-				Path: name,
-				Err:  kerrors.WithMsg(err, "Failed filtering dir entry"),
-			}
-		} else if !ok {
-			continue
-		}
-		res = append(res, i)
-	}
-	return res, nil
+	return f.filterEntries("readdir", name, entries)
 }
 
 func (f *maskFS) ReadFile(name string) ([]byte, error) {
@@ -116,7 +152,7 @@ func (f *maskFS) Sub(dir string) (fs.FS, error) {
 	}
 	return &maskFS{
 		fsys:   fsys,
-		dir:    path.Join(f.dir, dir),
+		dir:    joinValidPath(f.dir, dir),
 		filter: f.filter,
 	}, nil
 }
@@ -142,11 +178,33 @@ func (f *maskFS) ReadLink(name string) (string, error) {
 	return ReadLink(f.fsys, name)
 }
 
+func (f *maskFS) Symlink(oldname, newname string) error {
+	if err := f.checkFile("symlink", newname); err != nil {
+		return err
+	}
+	if target := path.Join(path.Dir(newname), oldname); fs.ValidPath(target) {
+		// a link must not expose a masked file
+		if err := f.checkFile("symlink", target); err != nil {
+			return err
+		}
+	}
+	return Symlink(f.fsys, oldname, newname)
+}
+
 func (f *maskFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
 	if err := f.checkFile("openfile", name); err != nil {
 		return nil, err
 	}
-	return OpenFile(f.fsys, name, flag, mode)
+	file, err := OpenFile(f.fsys, name, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	if d, err := f.maskDir(name, file); err != nil {
+		return nil, err
+	} else if d != nil {
+		return d, nil
+	}
+	return file, nil
 }
 
 func (f *maskFS) Remove(name string) error {
@@ -163,6 +221,23 @@ func (f *maskFS) RemoveAll(name string) error {
 	return RemoveAll(f.fsys, name)
 }
 
+func (f *maskFS) Rename(oldname, newname string) error {
+	if err := f.checkFile("rename", oldname); err != nil {
+		return err
+	}
+	if err := f.checkFile("rename", newname); err != nil {
+		return err
+	}
+	return Rename(f.fsys, oldname, newname)
+}
+
+func (f *maskFS) Truncate(name string, size int64) error {
+	if err := f.checkFile("truncate", name); err != nil {
+		return err
+	}
+	return Truncate(f.fsys, name, size)
+}
+
 func (f *maskFS) Chtimes(name string, atime, mtime time.Time) error {
 	if err := f.checkFile("chtimes", name); err != nil {
 		return err
@@ -170,6 +245,147 @@ func (f *maskFS) Chtimes(name string, atime, mtime time.Time) error {
 	return Chtimes(f.fsys, name, atime, mtime)
 }
 
+func (f *maskFS) Lchtimes(name string, atime, mtime time.Time) error {
+	if err := f.checkFile("lchtimes", name); err != nil {
+		return err
+	}
+	return Lchtimes(f.fsys, name, atime, mtime)
+}
+
+func (f *maskFS) Chmod(name string, mode fs.FileMode) error {
+	if err := f.checkFile("chmod", name); err != nil {
+		return err
+	}
+	return Chmod(f.fsys, name, mode)
+}
+
+func (f *maskFS) Chown(name string, uid, gid int) error {
+	if err := f.checkFile("chown", name); err != nil {
+		return err
+	}
+	return Chown(f.fsys, name, uid, gid)
+}
+
+func (f *maskFS) Lchown(name string, uid, gid int) error {
+	if err := f.checkFile("lchown", name); err != nil {
+		return err
+	}
+	return Lchown(f.fsys, name, uid, gid)
+}
+
+func (f *maskFS) Mkfifo(name string, mode fs.FileMode) error {
+	if err := f.checkFile("mkfifo", name); err != nil {
+		return err
+	}
+	return Mkfifo(f.fsys, name, mode)
+}
+
+func (f *maskFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	if err := f.checkFile("writefileifmatch", name); err != nil {
+		return err
+	}
+	return WriteFileIfMatch(f.fsys, name, data, perm, token)
+}
+
+// MkdirTemp implements [TempFS]
+//
+// A created dir that is masked by its random name is removed.
+func (f *maskFS) MkdirTemp(dir, pattern string) (string, error) {
+	if err := f.checkFile("mkdirtemp", dir); err != nil {
+		return "", err
+	}
+	name, err := MkdirTemp(f.fsys, dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	if err := f.checkFile("mkdirtemp", name); err != nil {
+		return "", errors.Join(err, RemoveAll(f.fsys, name))
+	}
+	return name, nil
+}
+
+// CreateTemp implements [TempFS]
+//
+// Names are generated until one is not masked.
+func (f *maskFS) CreateTemp(dir, pattern string) (File, string, error) {
+	if err := f.checkFile("createtemp", dir); err != nil {
+		return nil, "", err
+	}
+	return createTemp(f, dir, pattern)
+}
+
+func (f *maskFS) SyncDir(name string) error {
+	if err := f.checkFile("syncdir", name); err != nil {
+		return err
+	}
+	return SyncDir(f.fsys, name)
+}
+
+func (f *maskFS) Statfs(name string) (*FSStats, error) {
+	if err := f.checkFile("statfs", name); err != nil {
+		return nil, err
+	}
+	return Statfs(f.fsys, name)
+}
+
+type (
+	// maskDirFile is a directory file that masks its dir entries
+	maskDirFile struct {
+		fs.ReadDirFile
+		fsys    *maskFS
+		name    string
+		pending []fs.DirEntry
+		eof     bool
+	}
+)
+
+func (d *maskDirFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "write",
+		Path: d.name,
+		Err:  kerrors.WithKind(fs.ErrInvalid, ErrIsDir, "File is a directory"),
+	}
+}
+
+// ReadDir implements [fs.ReadDirFile]
+func (d *maskDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries, err := d.ReadDirFile.ReadDir(-1)
+		if err != nil {
+			return nil, err
+		}
+		entries, err = d.fsys.filterEntries("readdir", d.name, entries)
+		if err != nil {
+			return nil, err
+		}
+		res := append(d.pending, entries...)
+		d.pending = nil
+		d.eof = true
+		return res, nil
+	}
+	for len(d.pending) < n && !d.eof {
+		entries, err := d.ReadDirFile.ReadDir(n)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			d.eof = true
+		}
+		entries, err = d.fsys.filterEntries("readdir", d.name, entries)
+		if err != nil {
+			return nil, err
+		}
+		d.pending = append(d.pending, entries...)
+	}
+	if len(d.pending) == 0 {
+		return nil, io.EOF
+	}
+	k := min(n, len(d.pending))
+	res := d.pending[:k:k]
+	d.pending = d.pending[k:]
+	return res, nil
+}
+
 // NewMaskFS creates a new [FS] that masks an fs based on a filter
 func NewMaskFS(fsys fs.FS, filter FileFilter) FS {
 	return &maskFS{
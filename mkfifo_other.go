@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+
+package kfs
+
+import (
+	"io/fs"
+
+	"xorkevin.dev/kerrors"
+)
+
+func mkfifo(name string, mode fs.FileMode) error {
+	return kerrors.WithMsg(ErrNotImplemented, "Named pipes are not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package kfs
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func mkfifo(name string, mode fs.FileMode) error {
+	if err := syscall.Mkfifo(name, uint32(mode.Perm())); err != nil {
+		return wrapOSErr(err, "Failed to create named pipe")
+	}
+	return nil
+}
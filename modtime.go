@@ -0,0 +1,22 @@
+package kfs
+
+import (
+	"time"
+)
+
+// SameModTime returns whether two mod times are the same up to granularity
+//
+// Backends store mod times with different precision, such as 2s for FAT and
+// zip, and 1s for many object stores, and may round rather than truncate. Mod
+// times are considered the same if they differ by less than granularity. If
+// granularity is not positive, the mod times must be equal.
+func SameModTime(a, b time.Time, granularity time.Duration) bool {
+	if granularity <= 0 {
+		return a.Equal(b)
+	}
+	d := a.Sub(b)
+	if d < 0 {
+		d = -d
+	}
+	return d < granularity
+}
@@ -0,0 +1,139 @@
+package kfs
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+
+	"xorkevin.dev/kerrors"
+)
+
+var (
+	// ErrIsDir is returned when a file is unexpectedly a directory
+	ErrIsDir errIsDir
+	// ErrNotDir is returned when a file is unexpectedly not a directory
+	ErrNotDir errNotDir
+	// ErrNoSpace is returned when there is no space left on the device
+	ErrNoSpace errNoSpace
+	// ErrTooManyOpenFiles is returned when the process has too many open files
+	ErrTooManyOpenFiles errTooManyOpenFiles
+	// ErrNameTooLong is returned when a file name is too long
+	ErrNameTooLong errNameTooLong
+	// ErrCrossDevice is returned when an operation may not cross devices
+	ErrCrossDevice errCrossDevice
+	// ErrDirNotEmpty is returned when a directory is unexpectedly not empty
+	ErrDirNotEmpty errDirNotEmpty
+	// ErrLinkLoop is returned when resolving a path follows too many symlinks,
+	// as for a cycle of symlinks
+	ErrLinkLoop errLinkLoop
+)
+
+type (
+	errIsDir            struct{}
+	errNotDir           struct{}
+	errNoSpace          struct{}
+	errTooManyOpenFiles struct{}
+	errNameTooLong      struct{}
+	errCrossDevice      struct{}
+	errDirNotEmpty      struct{}
+	errLinkLoop         struct{}
+)
+
+func (e errIsDir) Error() string {
+	return "Is a directory"
+}
+
+func (e errNotDir) Error() string {
+	return "Not a directory"
+}
+
+func (e errNoSpace) Error() string {
+	return "No space left on device"
+}
+
+func (e errTooManyOpenFiles) Error() string {
+	return "Too many open files"
+}
+
+func (e errNameTooLong) Error() string {
+	return "File name too long"
+}
+
+func (e errCrossDevice) Error() string {
+	return "Cross-device operation"
+}
+
+func (e errDirNotEmpty) Error() string {
+	return "Directory not empty"
+}
+
+func (e errLinkLoop) Error() string {
+	return "Too many levels of symbolic links"
+}
+
+// osErrKind returns the kfs error kind of a platform error, or nil if there
+// is none
+func osErrKind(err error) error {
+	switch {
+	case errors.Is(err, syscall.EISDIR):
+		return ErrIsDir
+	case errors.Is(err, syscall.ENOTDIR):
+		return ErrNotDir
+	case errors.Is(err, syscall.EMFILE):
+		return ErrTooManyOpenFiles
+	case errors.Is(err, syscall.ENAMETOOLONG):
+		return ErrNameTooLong
+	}
+	if kind := errnoErrKind(err); kind != nil {
+		return kind
+	}
+	return platformErrKind(err)
+}
+
+// wrapOSErr wraps a platform error with a message and its kfs error kind
+//
+// Errors matching [fs.ErrNotExist] are returned unwrapped. They are the
+// expected result of probing for files, where callers only check them with
+// [errors.Is], and wrapping them would add a cost to every probe for no
+// benefit.
+func wrapOSErr(err error, msg string) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if kind := osErrKind(err); kind != nil {
+		return kerrors.WithKind(err, kind, msg)
+	}
+	return kerrors.WithMsg(err, msg)
+}
+
+// NormalizeError adds the kfs error kind of a platform error to err
+//
+// Platform errors such as EISDIR, ENOTDIR, ENOSPC, EMFILE, ENAMETOOLONG, EXDEV,
+// EROFS, ENOTEMPTY, and ELOOP, and their windows equivalents, are mapped onto
+// [ErrIsDir], [ErrNotDir], [ErrNoSpace], [ErrTooManyOpenFiles],
+// [ErrNameTooLong], [ErrCrossDevice], [ErrReadOnly], [ErrDirNotEmpty], and
+// [ErrLinkLoop] respectively, so that they may be checked with [errors.Is]
+// portably. If err is a [*fs.PathError], the returned error is a
+// [*fs.PathError] with the same op and path. Errors without a known kind are
+// returned unchanged.
+func NormalizeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr != err {
+		if kind := osErrKind(err); kind != nil {
+			return kerrors.WithKind(err, kind, kind.Error())
+		}
+		return err
+	}
+	kind := osErrKind(pathErr.Err)
+	if kind == nil {
+		return err
+	}
+	return &fs.PathError{
+		Op:   pathErr.Op,
+		Path: pathErr.Path,
+		Err:  kerrors.WithKind(pathErr.Err, kind, kind.Error()),
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !plan9
+
+package kfs
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errnoErrKind returns the kfs error kind of an errno that is not defined on
+// every platform, or nil if there is none
+func errnoErrKind(err error) error {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return ErrNoSpace
+	case errors.Is(err, syscall.EXDEV):
+		return ErrCrossDevice
+	case errors.Is(err, syscall.EROFS):
+		return ErrReadOnly
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return ErrDirNotEmpty
+	case errors.Is(err, syscall.ELOOP):
+		return ErrLinkLoop
+	default:
+		return nil
+	}
+}
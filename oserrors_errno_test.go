@@ -0,0 +1,33 @@
+//go:build !plan9
+
+package kfs_test
+
+import (
+	"io/fs"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"xorkevin.dev/kfs"
+)
+
+func Test_NormalizeErrno(t *testing.T) {
+	t.Parallel()
+
+	assert := require.New(t)
+
+	{
+		err := kfs.NormalizeError(&fs.PathError{Op: "open", Path: "foo.txt", Err: syscall.ENOSPC})
+		assert.ErrorIs(err, kfs.ErrNoSpace)
+		assert.ErrorIs(err, syscall.ENOSPC)
+		var pathErr *fs.PathError
+		assert.ErrorAs(err, &pathErr)
+		assert.Equal("open", pathErr.Op)
+		assert.Equal("foo.txt", pathErr.Path)
+	}
+
+	assert.ErrorIs(kfs.NormalizeError(syscall.EXDEV), kfs.ErrCrossDevice)
+	assert.ErrorIs(kfs.NormalizeError(syscall.EROFS), kfs.ErrReadOnly)
+	assert.ErrorIs(kfs.NormalizeError(syscall.ENOTEMPTY), kfs.ErrDirNotEmpty)
+	assert.ErrorIs(kfs.NormalizeError(syscall.ELOOP), kfs.ErrLinkLoop)
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package kfs
+
+func platformErrKind(err error) error {
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build plan9
+
+package kfs
+
+func errnoErrKind(err error) error {
+	return nil
+}
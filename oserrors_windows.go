@@ -0,0 +1,44 @@
+//go:build windows
+
+package kfs
+
+import (
+	"errors"
+	"syscall"
+)
+
+// windows system error codes without syscall equivalents
+const (
+	errorTooManyOpenFiles   syscall.Errno = 4
+	errorNotSameDevice      syscall.Errno = 17
+	errorWriteProtect       syscall.Errno = 19
+	errorHandleDiskFull     syscall.Errno = 39
+	errorDiskFull           syscall.Errno = 112
+	errorDirNotEmpty        syscall.Errno = 145
+	errorFilenameExcedRange syscall.Errno = 206
+	errorDirectory          syscall.Errno = 267
+	errorCantResolveFile    syscall.Errno = 1921
+)
+
+func platformErrKind(err error) error {
+	switch {
+	case errors.Is(err, errorDirectory):
+		return ErrNotDir
+	case errors.Is(err, errorDiskFull), errors.Is(err, errorHandleDiskFull):
+		return ErrNoSpace
+	case errors.Is(err, errorTooManyOpenFiles):
+		return ErrTooManyOpenFiles
+	case errors.Is(err, errorFilenameExcedRange):
+		return ErrNameTooLong
+	case errors.Is(err, errorNotSameDevice):
+		return ErrCrossDevice
+	case errors.Is(err, errorWriteProtect):
+		return ErrReadOnly
+	case errors.Is(err, errorDirNotEmpty):
+		return ErrDirNotEmpty
+	case errors.Is(err, errorCantResolveFile):
+		return ErrLinkLoop
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,174 @@
+package kfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"xorkevin.dev/kerrors"
+)
+
+type (
+	// LinkFallback is how an os backed [FS] creates a symlink when the os does
+	// not permit the process to create symlinks, as for a non-admin user on
+	// windows
+	LinkFallback int
+
+	// OSFSOpt is an option for [New] and [DirFS]
+	OSFSOpt = func(o *osFSOpts)
+
+	osFSOpts struct {
+		linkFallback LinkFallback
+	}
+)
+
+const (
+	// LinkFallbackError returns the error of the os
+	LinkFallbackError LinkFallback = iota
+	// LinkFallbackJunction creates a directory junction for a link to a
+	// directory, and otherwise returns the error of the os
+	LinkFallbackJunction
+	// LinkFallbackCopy creates a copy of the target in place of the link,
+	// which does not reflect later changes to the target
+	LinkFallbackCopy
+)
+
+func (f LinkFallback) String() string {
+	switch f {
+	case LinkFallbackError:
+		return "error"
+	case LinkFallbackJunction:
+		return "junction"
+	case LinkFallbackCopy:
+		return "copy"
+	default:
+		return fmt.Sprintf("LinkFallback(%d)", int(f))
+	}
+}
+
+// OSFSLinkFallback returns an [OSFSOpt] that sets how symlinks are created
+// when the os does not permit it
+//
+// The default is [LinkFallbackError]. Symlinks are only ever denied in this
+// way on windows, where creating them requires a privilege or developer mode.
+func OSFSLinkFallback(f LinkFallback) OSFSOpt {
+	return func(o *osFSOpts) {
+		o.linkFallback = f
+	}
+}
+
+// symlinkFallback creates the link at the os path link to the os path target
+// with the fallback after the os refused to create a symlink with err
+func symlinkFallback(fallback LinkFallback, target, link string, err error) error {
+	if fallback == LinkFallbackError || !isLinkPrivilegeErr(err) {
+		return wrapOSErr(err, "Failed to create symlink")
+	}
+	info, statErr := os.Stat(target)
+	if statErr != nil {
+		return errors.Join(
+			wrapOSErr(err, "Failed to create symlink"),
+			wrapOSErr(statErr, "Failed to stat link target for fallback"),
+		)
+	}
+	switch fallback {
+	case LinkFallbackJunction:
+		if !info.IsDir() {
+			return kerrors.WithMsg(err, "Failed to create symlink, and junctions may only link to directories")
+		}
+		if err := mkJunction(target, link); err != nil {
+			return kerrors.WithMsg(err, "Failed to create junction")
+		}
+		return nil
+	case LinkFallbackCopy:
+		if err := copyLinkTarget(target, link); err != nil {
+			return kerrors.WithMsg(err, "Failed to copy link target")
+		}
+		return nil
+	default:
+		return wrapOSErr(err, "Failed to create symlink")
+	}
+}
+
+// copyLinkTarget copies the file or directory tree at the os path src to dst
+//
+// Symlinks within a copied tree are followed if they link to files, and are
+// rejected if they link to directories, which may form cycles.
+func copyLinkTarget(src, dst string) (retErr error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return wrapOSErr(err, "Failed to stat file")
+	}
+	if !info.IsDir() {
+		return copyOSFile(src, dst, info.Mode())
+	}
+	if err := os.Mkdir(dst, info.Mode().Perm()); err != nil {
+		return wrapOSErr(err, "Failed to mkdir")
+	}
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			retErr = errors.Join(retErr, wrapOSErr(err, "Failed to clean up partial copy"))
+		}
+	}()
+	return filepath.WalkDir(src, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return wrapOSErr(err, "Failed to walk dir")
+		}
+		if p == src {
+			return nil
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return kerrors.WithMsg(err, "Failed to compute relative path")
+		}
+		out := filepath.Join(dst, rel)
+		info, err := os.Stat(p)
+		if err != nil {
+			return wrapOSErr(err, "Failed to stat file")
+		}
+		if entry.IsDir() {
+			if err := os.Mkdir(out, info.Mode().Perm()); err != nil {
+				return wrapOSErr(err, "Failed to mkdir")
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("May not copy link %s to a directory", rel))
+		}
+		return copyOSFile(p, out, info.Mode())
+	})
+}
+
+// copyOSFile copies the regular file at the os path src to dst
+func copyOSFile(src, dst string, mode fs.FileMode) (retErr error) {
+	if !mode.IsRegular() {
+		return kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("May not copy irregular file %s", src))
+	}
+	r, err := os.Open(src)
+	if err != nil {
+		return wrapOSErr(err, "Failed to open file")
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			retErr = errors.Join(retErr, wrapOSErr(err, "Failed to close file"))
+		}
+	}()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return wrapOSErr(err, "Failed to create file")
+	}
+	defer func() {
+		if err := w.Close(); err != nil {
+			retErr = errors.Join(retErr, wrapOSErr(err, "Failed to close file"))
+		}
+	}()
+	if _, err := io.Copy(w, r); err != nil {
+		return wrapOSErr(err, "Failed to copy file")
+	}
+	return nil
+}
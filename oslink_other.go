@@ -0,0 +1,15 @@
+//go:build !windows
+
+package kfs
+
+import (
+	"xorkevin.dev/kerrors"
+)
+
+func isLinkPrivilegeErr(err error) bool {
+	return false
+}
+
+func mkJunction(target, link string) error {
+	return kerrors.WithMsg(ErrNotImplemented, "Junctions are not supported on this platform")
+}
@@ -0,0 +1,101 @@
+//go:build windows
+
+package kfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unicode/utf16"
+
+	"xorkevin.dev/kerrors"
+)
+
+const (
+	errorPrivilegeNotHeld syscall.Errno = 1314
+
+	fsctlSetReparsePoint   = 0x000900a4
+	ioReparseTagMountPoint = 0xa0000003
+)
+
+func isLinkPrivilegeErr(err error) bool {
+	return errors.Is(err, errorPrivilegeNotHeld)
+}
+
+// mountPointReparseData returns the reparse data buffer of a junction to the
+// absolute os path target
+func mountPointReparseData(target string) []byte {
+	subst := utf16.Encode([]rune(`\??\` + target))
+	printName := utf16.Encode([]rune(target))
+	// each name is followed by a NUL that is not counted in its length
+	pathLen := (len(subst) + 1 + len(printName) + 1) * 2
+	b := make([]byte, 0, 16+pathLen)
+	b = binary.LittleEndian.AppendUint32(b, ioReparseTagMountPoint)
+	b = binary.LittleEndian.AppendUint16(b, uint16(8+pathLen))
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(subst)*2))
+	b = binary.LittleEndian.AppendUint16(b, uint16((len(subst)+1)*2))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(printName)*2))
+	for _, i := range subst {
+		b = binary.LittleEndian.AppendUint16(b, i)
+	}
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	for _, i := range printName {
+		b = binary.LittleEndian.AppendUint16(b, i)
+	}
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	return b
+}
+
+// mkJunction creates a directory junction at the os path link to the
+// directory at the os path target
+//
+// Unlike symlinks, junctions may be created without a privilege, but their
+// targets are absolute, so they break if the tree is moved.
+func mkJunction(target, link string) (retErr error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return kerrors.WithMsg(err, "Failed to resolve junction target")
+	}
+	if err := os.Mkdir(link, 0o777); err != nil {
+		return wrapOSErr(err, "Failed to mkdir")
+	}
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		if err := os.Remove(link); err != nil {
+			retErr = errors.Join(retErr, wrapOSErr(err, "Failed to remove junction dir"))
+		}
+	}()
+	p, err := syscall.UTF16PtrFromString(link)
+	if err != nil {
+		return kerrors.WithMsg(err, "Invalid junction path")
+	}
+	h, err := syscall.CreateFile(
+		p,
+		syscall.GENERIC_WRITE,
+		0,
+		nil,
+		syscall.OPEN_EXISTING,
+		syscall.FILE_FLAG_OPEN_REPARSE_POINT|syscall.FILE_FLAG_BACKUP_SEMANTICS,
+		0,
+	)
+	if err != nil {
+		return wrapOSErr(err, "Failed to open junction dir")
+	}
+	defer func() {
+		if err := syscall.CloseHandle(h); err != nil {
+			retErr = errors.Join(retErr, wrapOSErr(err, "Failed to close junction dir"))
+		}
+	}()
+	data := mountPointReparseData(target)
+	var n uint32
+	if err := syscall.DeviceIoControl(h, fsctlSetReparsePoint, &data[0], uint32(len(data)), nil, 0, &n, nil); err != nil {
+		return wrapOSErr(err, "Failed to set junction reparse point")
+	}
+	return nil
+}
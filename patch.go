@@ -0,0 +1,490 @@
+package kfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"xorkevin.dev/kerrors"
+)
+
+var (
+	// ErrPatchConflict is returned when a patch does not apply to a file
+	ErrPatchConflict errPatchConflict
+)
+
+type (
+	errPatchConflict struct{}
+)
+
+func (e errPatchConflict) Error() string {
+	return "Patch conflict"
+}
+
+type (
+	// PatchReport reports the files changed by [ApplyPatch]
+	PatchReport struct {
+		// Created is the names of created files
+		Created []string
+		// Modified is the names of modified files
+		Modified []string
+		// Removed is the names of removed files
+		Removed []string
+	}
+
+	// ApplyPatchOpt is an option for [ApplyPatch]
+	ApplyPatchOpt = func(o *applyPatchOpts)
+
+	applyPatchOpts struct {
+		dryRun bool
+		fuzz   int
+		strip  int
+	}
+)
+
+// ApplyPatchDryRun makes [ApplyPatch] check that a patch applies and report
+// the files it would change without writing them
+func ApplyPatchDryRun() ApplyPatchOpt {
+	return func(o *applyPatchOpts) {
+		o.dryRun = true
+	}
+}
+
+// ApplyPatchFuzz sets the max number of leading and trailing context lines of
+// a hunk that [ApplyPatch] may ignore when the full context does not match
+//
+// The default is 0, which requires all context lines to match.
+func ApplyPatchFuzz(n int) ApplyPatchOpt {
+	return func(o *applyPatchOpts) {
+		o.fuzz = n
+	}
+}
+
+// ApplyPatchStrip sets the number of leading path components that
+// [ApplyPatch] strips from file names in the patch
+//
+// The default is 1, which strips the a/ and b/ prefixes of diffs generated by
+// git.
+func ApplyPatchStrip(n int) ApplyPatchOpt {
+	return func(o *applyPatchOpts) {
+		o.strip = n
+	}
+}
+
+// ApplyPatch applies a unified diff to the files of fsys
+//
+// A hunk may apply at an offset from the line numbers in its header, in which
+// case the nearest matching offset is used. File names of /dev/null create or
+// remove files. Renaming files is not supported. Modified files keep their
+// mode, and created files are given mode 0o644.
+//
+// ApplyPatch checks that every hunk applies before writing any file, and
+// returns an error matching [ErrPatchConflict] if one does not. A write may
+// still fail partway, which leaves earlier files patched.
+func ApplyPatch(fsys fs.FS, diff []byte, opts ...ApplyPatchOpt) (*PatchReport, error) {
+	o := applyPatchOpts{
+		strip: 1,
+	}
+	for _, i := range opts {
+		i(&o)
+	}
+
+	files, err := parsePatch(string(diff), o.strip)
+	if err != nil {
+		return nil, err
+	}
+
+	type patchResult struct {
+		name    string
+		data    []byte
+		mode    fs.FileMode
+		created bool
+		removed bool
+	}
+	results := make([]*patchResult, 0, len(files))
+	byName := map[string]*patchResult{}
+	for _, i := range files {
+		name := i.newName
+		if name == "" {
+			name = i.oldName
+		}
+		res := byName[name]
+		if res == nil {
+			res = &patchResult{
+				name: name,
+			}
+			if i.oldName == "" {
+				if _, err := lstatOrStat(fsys, name); err == nil {
+					return nil, &fs.PathError{
+						Op:   "applypatch",
+						Path: name,
+						Err:  kerrors.WithKind(fs.ErrExist, ErrPatchConflict, "File to create already exists"),
+					}
+				} else if !errors.Is(err, fs.ErrNotExist) {
+					return nil, &fs.PathError{
+						Op:   "applypatch",
+						Path: name,
+						Err:  kerrors.WithMsg(err, "Failed to stat file"),
+					}
+				}
+				res.created = true
+				res.mode = 0o644
+			} else {
+				info, err := fs.Stat(fsys, name)
+				if err != nil {
+					return nil, &fs.PathError{
+						Op:   "applypatch",
+						Path: name,
+						Err:  kerrors.WithMsg(err, "Failed to stat file"),
+					}
+				}
+				data, err := fs.ReadFile(fsys, name)
+				if err != nil {
+					return nil, &fs.PathError{
+						Op:   "applypatch",
+						Path: name,
+						Err:  kerrors.WithMsg(err, "Failed to read file"),
+					}
+				}
+				res.data = data
+				res.mode = info.Mode().Perm()
+			}
+			byName[name] = res
+			results = append(results, res)
+		}
+		if res.removed {
+			return nil, &fs.PathError{
+				Op:   "applypatch",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrNotExist, ErrPatchConflict, "File was removed by an earlier patch"),
+			}
+		}
+		lines, err := applyHunks(name, splitPatchLines(string(res.data)), i.hunks, o.fuzz)
+		if err != nil {
+			return nil, err
+		}
+		res.data = []byte(strings.Join(lines, ""))
+		if i.newName == "" {
+			if len(res.data) != 0 {
+				return nil, &fs.PathError{
+					Op:   "applypatch",
+					Path: name,
+					Err:  kerrors.WithKind(fs.ErrInvalid, ErrPatchConflict, "File to remove is not empty after patching"),
+				}
+			}
+			res.removed = true
+		}
+	}
+
+	report := &PatchReport{}
+	for _, i := range results {
+		switch {
+		case i.created && i.removed:
+			continue
+		case i.created:
+			report.Created = append(report.Created, i.name)
+		case i.removed:
+			report.Removed = append(report.Removed, i.name)
+		default:
+			report.Modified = append(report.Modified, i.name)
+		}
+	}
+	if o.dryRun {
+		return report, nil
+	}
+	for _, i := range results {
+		switch {
+		case i.created && i.removed:
+			continue
+		case i.removed:
+			if err := Remove(fsys, i.name); err != nil {
+				return nil, &fs.PathError{
+					Op:   "applypatch",
+					Path: i.name,
+					Err:  kerrors.WithMsg(err, "Failed to remove file"),
+				}
+			}
+		default:
+			if err := WriteFile(fsys, i.name, i.data, i.mode); err != nil {
+				return nil, &fs.PathError{
+					Op:   "applypatch",
+					Path: i.name,
+					Err:  kerrors.WithMsg(err, "Failed to write file"),
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+type (
+	patchFile struct {
+		oldName string
+		newName string
+		hunks   []patchHunk
+	}
+
+	patchHunk struct {
+		oldStart int
+		oldLines int
+		// old and new are the lines of the hunk before and after, each including
+		// its line ending
+		old []string
+		new []string
+		// lead and trail are the number of leading and trailing context lines
+		lead  int
+		trail int
+	}
+)
+
+// splitPatchLines splits s into lines, each including its line ending
+func splitPatchLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func parsePatchName(line string, strip int) (string, error) {
+	name, _, _ := strings.Cut(line, "\t")
+	name = strings.TrimSpace(name)
+	if name == "/dev/null" {
+		return "", nil
+	}
+	for range strip {
+		_, rest, ok := strings.Cut(name, "/")
+		if !ok {
+			return "", &fs.PathError{
+				Op:   "applypatch",
+				Path: name,
+				Err:  kerrors.WithMsg(fs.ErrInvalid, "Too few path components to strip"),
+			}
+		}
+		name = rest
+	}
+	name = path.Clean(name)
+	if !fs.ValidPath(name) || name == "." {
+		return "", &fs.PathError{
+			Op:   "applypatch",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	return name, nil
+}
+
+// parseHunkRange parses a range of a hunk header of the form start[,count]
+func parseHunkRange(s string) (int, int, error) {
+	start, count, ok := strings.Cut(s, ",")
+	a, err := strconv.Atoi(start)
+	if err != nil || a < 0 {
+		return 0, 0, kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Invalid hunk range %q", s))
+	}
+	if !ok {
+		return a, 1, nil
+	}
+	b, err := strconv.Atoi(count)
+	if err != nil || b < 0 {
+		return 0, 0, kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Invalid hunk range %q", s))
+	}
+	return a, b, nil
+}
+
+func parsePatch(diff string, strip int) ([]patchFile, error) {
+	lines := splitPatchLines(diff)
+	var files []patchFile
+	for n := 0; n < len(lines); n++ {
+		if !strings.HasPrefix(lines[n], "--- ") {
+			// other lines, such as git extended headers, are ignored
+			continue
+		}
+		if n+1 >= len(lines) || !strings.HasPrefix(lines[n+1], "+++ ") {
+			return nil, kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Missing new file name on line %d", n+2))
+		}
+		oldName, err := parsePatchName(strings.TrimPrefix(lines[n], "--- "), strip)
+		if err != nil {
+			return nil, err
+		}
+		newName, err := parsePatchName(strings.TrimPrefix(lines[n+1], "+++ "), strip)
+		if err != nil {
+			return nil, err
+		}
+		if oldName == "" && newName == "" {
+			return nil, kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Missing file name on line %d", n+1))
+		}
+		if oldName != "" && newName != "" && oldName != newName {
+			return nil, &fs.PathError{
+				Op:   "applypatch",
+				Path: oldName,
+				Err:  kerrors.WithMsg(ErrNotImplemented, "Renaming files is not supported"),
+			}
+		}
+		file := patchFile{
+			oldName: oldName,
+			newName: newName,
+		}
+		n += 2
+		for n < len(lines) && strings.HasPrefix(lines[n], "@@ ") {
+			hunk, next, err := parsePatchHunk(lines, n)
+			if err != nil {
+				return nil, err
+			}
+			file.hunks = append(file.hunks, hunk)
+			n = next
+		}
+		n--
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, kerrors.WithMsg(fs.ErrInvalid, "Patch has no files")
+	}
+	return files, nil
+}
+
+// parsePatchHunk parses the hunk starting at lines[n] and returns the index
+// of the line after it
+func parsePatchHunk(lines []string, n int) (patchHunk, int, error) {
+	header := strings.TrimPrefix(lines[n], "@@ ")
+	header, _, ok := strings.Cut(header, " @@")
+	oldRange, newRange, ok2 := strings.Cut(header, " ")
+	if !ok || !ok2 || !strings.HasPrefix(oldRange, "-") || !strings.HasPrefix(newRange, "+") {
+		return patchHunk{}, 0, kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Invalid hunk header on line %d", n+1))
+	}
+	oldStart, oldLines, err := parseHunkRange(oldRange[1:])
+	if err != nil {
+		return patchHunk{}, 0, err
+	}
+	_, newLines, err := parseHunkRange(newRange[1:])
+	if err != nil {
+		return patchHunk{}, 0, err
+	}
+	hunk := patchHunk{
+		oldStart: oldStart,
+		oldLines: oldLines,
+	}
+	var kinds []byte
+	oldLeft, newLeft := oldLines, newLines
+	n++
+	for ; n < len(lines) && (oldLeft > 0 || newLeft > 0 || strings.HasPrefix(lines[n], `\`)); n++ {
+		line := lines[n]
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		kind := line[0]
+		if line == "\n" {
+			// some tools strip the trailing space of empty context lines
+			kind, line = ' ', " \n"
+		}
+		switch kind {
+		case ' ':
+			hunk.old = append(hunk.old, line[1:])
+			hunk.new = append(hunk.new, line[1:])
+			oldLeft--
+			newLeft--
+		case '-':
+			hunk.old = append(hunk.old, line[1:])
+			oldLeft--
+		case '+':
+			hunk.new = append(hunk.new, line[1:])
+			newLeft--
+		case '\\':
+			// no newline at end of file applies to the previous line
+			if len(kinds) == 0 {
+				return patchHunk{}, 0, kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Unexpected line %d", n+1))
+			}
+			prev := kinds[len(kinds)-1]
+			if prev != '+' {
+				hunk.old[len(hunk.old)-1] = strings.TrimSuffix(hunk.old[len(hunk.old)-1], "\n")
+			}
+			if prev != '-' {
+				hunk.new[len(hunk.new)-1] = strings.TrimSuffix(hunk.new[len(hunk.new)-1], "\n")
+			}
+			continue
+		default:
+			return patchHunk{}, 0, kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Unexpected line %d", n+1))
+		}
+		if oldLeft < 0 || newLeft < 0 {
+			return patchHunk{}, 0, kerrors.WithMsg(fs.ErrInvalid, fmt.Sprintf("Hunk longer than its header on line %d", n+1))
+		}
+		kinds = append(kinds, kind)
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return patchHunk{}, 0, kerrors.WithMsg(fs.ErrInvalid, "Patch ends in the middle of a hunk")
+	}
+	for _, i := range kinds {
+		if i != ' ' {
+			break
+		}
+		hunk.lead++
+	}
+	if hunk.lead < len(kinds) {
+		for i := len(kinds) - 1; i >= 0 && kinds[i] == ' '; i-- {
+			hunk.trail++
+		}
+	}
+	return hunk, n, nil
+}
+
+func applyHunks(name string, lines []string, hunks []patchHunk, fuzz int) ([]string, error) {
+	out := make([]string, 0, len(lines))
+	pos := 0
+	delta := 0
+	for n, i := range hunks {
+		start, lead, trail, ok := findHunk(lines, pos, i, delta, fuzz)
+		if !ok {
+			return nil, &fs.PathError{
+				Op:   "applypatch",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrInvalid, ErrPatchConflict, fmt.Sprintf("Hunk %d does not apply", n+1)),
+			}
+		}
+		old := i.old[lead : len(i.old)-trail]
+		out = append(out, lines[pos:start]...)
+		out = append(out, i.new[lead:len(i.new)-trail]...)
+		pos = start + len(old)
+		delta = start - hunkLine(i) - lead
+	}
+	out = append(out, lines[pos:]...)
+	return out, nil
+}
+
+// hunkLine returns the index of the first line of a hunk in the old file
+func hunkLine(h patchHunk) int {
+	if h.oldLines == 0 {
+		// a hunk without old lines starts after the line in its header
+		return h.oldStart
+	}
+	return h.oldStart - 1
+}
+
+// findHunk finds the nearest position at or after pos where a hunk applies,
+// ignoring up to fuzz leading and trailing context lines, and returns the
+// position and the number of ignored leading and trailing lines
+func findHunk(lines []string, pos int, h patchHunk, delta int, fuzz int) (int, int, int, bool) {
+	for f := 0; f <= fuzz; f++ {
+		lead, trail := min(f, h.lead), min(f, h.trail)
+		if f > 0 && lead < f && trail < f {
+			// no more context may be ignored
+			break
+		}
+		old := h.old[lead : len(h.old)-trail]
+		expected := hunkLine(h) + lead + delta
+		last := len(lines) - len(old)
+		for d := 0; expected-d >= pos || expected+d <= last; d++ {
+			for _, i := range []int{expected - d, expected + d} {
+				if i < pos || i > last {
+					continue
+				}
+				if slices.Equal(lines[i:i+len(old)], old) {
+					return i, lead, trail, true
+				}
+			}
+		}
+	}
+	return 0, 0, 0, false
+}
@@ -0,0 +1,233 @@
+package kfs
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"xorkevin.dev/kerrors"
+)
+
+// ErrProtected is returned when removing a protected file
+var ErrProtected errProtected
+
+type (
+	errProtected struct{}
+)
+
+func (e errProtected) Error() string {
+	return "File is protected"
+}
+
+type (
+	protectFS struct {
+		fsys     fs.FS
+		dir      string
+		patterns [][]string
+	}
+)
+
+// matchesProtected returns whether name or an ancestor of it matches a
+// protected pattern, or, if children is true, whether it has a descendant that
+// may match one
+func (f *protectFS) matchesProtected(name string, children bool) bool {
+	p := joinValidPath(f.dir, name)
+	if p == "." {
+		p = ""
+	}
+	for _, pattern := range f.patterns {
+		// components are matched in place to avoid splitting the path
+		rest := p
+		n := 0
+		matched := true
+		for n < len(pattern) && rest != "" {
+			var part string
+			part, rest, _ = strings.Cut(rest, "/")
+			if ok, _ := path.Match(pattern[n], part); !ok {
+				matched = false
+				break
+			}
+			n++
+		}
+		if !matched || (!children && n < len(pattern)) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (f *protectFS) checkRemove(op string, name string, children bool) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	if f.matchesProtected(name, children) {
+		return &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrPermission, ErrProtected, "File is protected"),
+		}
+	}
+	return nil
+}
+
+func (f *protectFS) Open(name string) (fs.File, error) {
+	return f.fsys.Open(name)
+}
+
+func (f *protectFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.fsys, name)
+}
+
+func (f *protectFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(f.fsys, name)
+}
+
+func (f *protectFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(f.fsys, name)
+}
+
+func (f *protectFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(f.fsys, pattern)
+}
+
+func (f *protectFS) Sub(dir string) (fs.FS, error) {
+	fsys, err := fs.Sub(f.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &protectFS{
+		fsys:     fsys,
+		dir:      joinValidPath(f.dir, dir),
+		patterns: f.patterns,
+	}, nil
+}
+
+func (f *protectFS) FullFilePath(name string) (string, error) {
+	return FullFilePath(f.fsys, name)
+}
+
+func (f *protectFS) Lstat(name string) (fs.FileInfo, error) {
+	return Lstat(f.fsys, name)
+}
+
+func (f *protectFS) ReadLink(name string) (string, error) {
+	return ReadLink(f.fsys, name)
+}
+
+func (f *protectFS) Symlink(oldname, newname string) error {
+	return Symlink(f.fsys, oldname, newname)
+}
+
+func (f *protectFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	return OpenFile(f.fsys, name, flag, mode)
+}
+
+func (f *protectFS) Remove(name string) error {
+	if err := f.checkRemove("remove", name, false); err != nil {
+		return err
+	}
+	return Remove(f.fsys, name)
+}
+
+// RemoveAll implements [RemoveAllFS]
+//
+// RemoveAll fails if name or any file that may be a descendant of it is
+// protected.
+func (f *protectFS) RemoveAll(name string) error {
+	if err := f.checkRemove("removeall", name, true); err != nil {
+		return err
+	}
+	return RemoveAll(f.fsys, name)
+}
+
+// Rename implements [RenameFS]
+//
+// Rename fails if oldname or any file that may be a descendant of it is
+// protected, or if newname is protected.
+func (f *protectFS) Rename(oldname, newname string) error {
+	if err := f.checkRemove("rename", oldname, true); err != nil {
+		return err
+	}
+	if err := f.checkRemove("rename", newname, false); err != nil {
+		return err
+	}
+	return Rename(f.fsys, oldname, newname)
+}
+
+func (f *protectFS) Truncate(name string, size int64) error {
+	return Truncate(f.fsys, name, size)
+}
+
+func (f *protectFS) Chtimes(name string, atime, mtime time.Time) error {
+	return Chtimes(f.fsys, name, atime, mtime)
+}
+
+func (f *protectFS) Lchtimes(name string, atime, mtime time.Time) error {
+	return Lchtimes(f.fsys, name, atime, mtime)
+}
+
+func (f *protectFS) Chmod(name string, mode fs.FileMode) error {
+	return Chmod(f.fsys, name, mode)
+}
+
+func (f *protectFS) Chown(name string, uid, gid int) error {
+	return Chown(f.fsys, name, uid, gid)
+}
+
+func (f *protectFS) Lchown(name string, uid, gid int) error {
+	return Lchown(f.fsys, name, uid, gid)
+}
+
+func (f *protectFS) Mkfifo(name string, mode fs.FileMode) error {
+	return Mkfifo(f.fsys, name, mode)
+}
+
+func (f *protectFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	return WriteFileIfMatch(f.fsys, name, data, perm, token)
+}
+
+func (f *protectFS) MkdirTemp(dir, pattern string) (string, error) {
+	return MkdirTemp(f.fsys, dir, pattern)
+}
+
+func (f *protectFS) CreateTemp(dir, pattern string) (File, string, error) {
+	return CreateTemp(f.fsys, dir, pattern)
+}
+
+func (f *protectFS) SyncDir(name string) error {
+	return SyncDir(f.fsys, name)
+}
+
+func (f *protectFS) Statfs(name string) (*FSStats, error) {
+	return Statfs(f.fsys, name)
+}
+
+// NewProtectFS creates a new [FS] that refuses to remove protected files
+//
+// A file is protected if its path matches any of patterns with [path.Match],
+// and the contents of a protected directory are also protected. Removing a
+// protected file, or removing a directory with [RemoveAll] that may contain
+// one, fails with [ErrProtected], as does renaming one or renaming over one
+// with [Rename]. Patterns are matched against paths relative to the root of
+// the returned fs, including in sub fs. It panics if a pattern is malformed.
+func NewProtectFS(fsys fs.FS, patterns ...string) FS {
+	parts := make([][]string, 0, len(patterns))
+	for _, i := range patterns {
+		if _, err := path.Match(i, ""); err != nil {
+			panic(fmt.Sprintf("kfs: invalid protected pattern %q", i))
+		}
+		parts = append(parts, strings.Split(path.Clean(i), "/"))
+	}
+	return &protectFS{
+		fsys:     fsys,
+		dir:      "",
+		patterns: parts,
+	}
+}
@@ -0,0 +1,104 @@
+package kfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+
+	"xorkevin.dev/kerrors"
+)
+
+type (
+	// PruneOpt is an option for [PruneEmptyDirs]
+	PruneOpt = func(o *pruneOpts)
+
+	pruneOpts struct {
+		dryRun bool
+	}
+)
+
+// PruneDryRun makes [PruneEmptyDirs] report the directories it would remove
+// without removing them
+func PruneDryRun() PruneOpt {
+	return func(o *pruneOpts) {
+		o.dryRun = true
+	}
+}
+
+// PruneEmptyDirs removes empty directories under root bottom-up
+//
+// A directory is removed if it is empty, or if all of its entries are
+// directories that are removed. Root itself is never removed. Directories
+// are listed and removed through fsys, so entries hidden by a wrapper such as
+// [NewMaskFS] are not counted, and a directory that is only empty due to a
+// wrapper is left in place when its removal fails with [ErrDirNotEmpty].
+// Such directories are still reported with [PruneDryRun], since nothing is
+// removed. Symbolic links are never followed. The returned report lists the
+// directories removed, or that would be removed with [PruneDryRun], children
+// before their parents. If ctx is canceled, PruneEmptyDirs stops and returns
+// an error matching ctx.Err().
+func PruneEmptyDirs(ctx context.Context, fsys fs.FS, root string, opts ...PruneOpt) (*RemoveReport, error) {
+	o := pruneOpts{}
+	for _, i := range opts {
+		i(&o)
+	}
+	report := &RemoveReport{}
+	if _, err := pruneEmptyDirs(ctx, fsys, root, o, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// pruneEmptyDirs prunes the children of dir and returns whether dir is empty
+// after pruning
+func pruneEmptyDirs(ctx context.Context, fsys fs.FS, dir string, o pruneOpts, report *RemoveReport) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, &fs.PathError{
+			Op:   "prune",
+			Path: dir,
+			Err:  kerrors.WithMsg(err, "Prune canceled"),
+		}
+	}
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return false, &fs.PathError{
+			Op:   "prune",
+			Path: dir,
+			Err:  kerrors.WithMsg(err, "Failed to read dir"),
+		}
+	}
+	remaining := 0
+	for _, i := range entries {
+		if !i.IsDir() {
+			remaining++
+			continue
+		}
+		child := path.Join(dir, i.Name())
+		empty, err := pruneEmptyDirs(ctx, fsys, child, o, report)
+		if err != nil {
+			return false, err
+		}
+		if !empty {
+			remaining++
+			continue
+		}
+		if !o.dryRun {
+			// a dir that no longer exists, such as an implicit parent dir
+			// of an in-memory fs, has already been removed
+			if err := Remove(fsys, child); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				if errors.Is(err, ErrDirNotEmpty) {
+					remaining++
+					continue
+				}
+				return false, &fs.PathError{
+					Op:   "prune",
+					Path: child,
+					Err:  kerrors.WithMsg(err, "Failed to remove dir"),
+				}
+			}
+		}
+		report.Removed = append(report.Removed, child)
+	}
+	return remaining == 0, nil
+}
@@ -2,6 +2,7 @@ package kfs
 
 import (
 	"io/fs"
+	"os"
 	"time"
 
 	"xorkevin.dev/kerrors"
@@ -64,36 +65,107 @@ func (f *readOnlyFS) ReadLink(name string) (string, error) {
 	return ReadLink(f.fsys, name)
 }
 
-func (f *readOnlyFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
-	return nil, &fs.PathError{
-		Op:   "openfile",
-		Path: name,
-		Err:  kerrors.WithKind(fs.ErrInvalid, ErrReadOnly, "Read-only fs does not support writing"),
+func (f *readOnlyFS) Symlink(oldname, newname string) error {
+	return &os.LinkError{
+		Op:  "symlink",
+		Old: oldname,
+		New: newname,
+		Err: f.writeErr(newname),
 	}
 }
 
-func (f *readOnlyFS) Remove(name string) error {
+func (f *readOnlyFS) checkWrite(op string, name string) error {
 	return &fs.PathError{
-		Op:   "remove",
+		Op:   op,
 		Path: name,
-		Err:  kerrors.WithKind(fs.ErrInvalid, ErrReadOnly, "Read-only fs does not support writing"),
+		Err:  f.writeErr(name),
+	}
+}
+
+// writeErr returns the error for writing to names
+func (f *readOnlyFS) writeErr(names ...string) error {
+	for _, i := range names {
+		if !fs.ValidPath(i) {
+			return kerrors.WithMsg(fs.ErrInvalid, "Invalid path")
+		}
 	}
+	return kerrors.WithKind(fs.ErrPermission, ErrReadOnly, "Read-only fs does not support writing")
+}
+
+// OpenFile implements [WriteFS]
+//
+// Only files opened for reading without O_CREATE, O_TRUNC, or O_APPEND are
+// allowed.
+func (f *readOnlyFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return OpenFile(f.fsys, name, flag, mode)
+	}
+	return nil, f.checkWrite("openfile", name)
+}
+
+func (f *readOnlyFS) Remove(name string) error {
+	return f.checkWrite("remove", name)
 }
 
 func (f *readOnlyFS) RemoveAll(name string) error {
-	return &fs.PathError{
-		Op:   "removeall",
-		Path: name,
-		Err:  kerrors.WithKind(fs.ErrInvalid, ErrReadOnly, "Read-only fs does not support writing"),
+	return f.checkWrite("removeall", name)
+}
+
+func (f *readOnlyFS) Rename(oldname, newname string) error {
+	return &os.LinkError{
+		Op:  "rename",
+		Old: oldname,
+		New: newname,
+		Err: f.writeErr(oldname, newname),
 	}
 }
 
+func (f *readOnlyFS) Truncate(name string, size int64) error {
+	return f.checkWrite("truncate", name)
+}
+
 func (f *readOnlyFS) Chtimes(name string, atime, mtime time.Time) error {
-	return &fs.PathError{
-		Op:   "atime",
-		Path: name,
-		Err:  kerrors.WithKind(fs.ErrInvalid, ErrReadOnly, "Read-only fs does not support writing"),
-	}
+	return f.checkWrite("chtimes", name)
+}
+
+func (f *readOnlyFS) Lchtimes(name string, atime, mtime time.Time) error {
+	return f.checkWrite("lchtimes", name)
+}
+
+func (f *readOnlyFS) Chmod(name string, mode fs.FileMode) error {
+	return f.checkWrite("chmod", name)
+}
+
+func (f *readOnlyFS) Chown(name string, uid, gid int) error {
+	return f.checkWrite("chown", name)
+}
+
+func (f *readOnlyFS) Lchown(name string, uid, gid int) error {
+	return f.checkWrite("lchown", name)
+}
+
+func (f *readOnlyFS) Mkfifo(name string, mode fs.FileMode) error {
+	return f.checkWrite("mkfifo", name)
+}
+
+func (f *readOnlyFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	return f.checkWrite("writefileifmatch", name)
+}
+
+func (f *readOnlyFS) MkdirTemp(dir, pattern string) (string, error) {
+	return "", f.checkWrite("mkdirtemp", dir)
+}
+
+func (f *readOnlyFS) CreateTemp(dir, pattern string) (File, string, error) {
+	return nil, "", f.checkWrite("createtemp", dir)
+}
+
+func (f *readOnlyFS) SyncDir(name string) error {
+	return SyncDir(f.fsys, name)
+}
+
+func (f *readOnlyFS) Statfs(name string) (*FSStats, error) {
+	return Statfs(f.fsys, name)
 }
 
 // NewReadOnlyFS creates a new [FS] that is read-only
@@ -0,0 +1,474 @@
+package kfs
+
+import (
+	"cmp"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"xorkevin.dev/kerrors"
+)
+
+type (
+	// Redactor redacts file paths in errors
+	Redactor interface {
+		// Redact returns the replacement of a file path
+		Redact(p string) string
+	}
+
+	// RedactorFunc is a function that implements [Redactor]
+	RedactorFunc func(p string) string
+)
+
+// Redact implements [Redactor]
+func (f RedactorFunc) Redact(p string) string {
+	return f(p)
+}
+
+const (
+	redactedPath = "[redacted]"
+)
+
+// RelativeRedactor returns a [Redactor] that makes paths within dir relative
+// to it, and replaces all other absolute paths with [redacted]
+//
+// dir is typically the path returned by [FullFilePath] for ".".
+func RelativeRedactor(dir string) Redactor {
+	dir = strings.TrimSuffix(filepath.ToSlash(dir), "/")
+	return RedactorFunc(func(p string) string {
+		q := filepath.ToSlash(p)
+		if dir != "" {
+			if q == dir {
+				return "."
+			}
+			if rest, ok := strings.CutPrefix(q, dir+"/"); ok {
+				return rest
+			}
+		}
+		if strings.HasPrefix(q, "/") || filepath.IsAbs(p) {
+			return redactedPath
+		}
+		return p
+	})
+}
+
+type (
+	// redactedError is an error with a redacted message that still matches the
+	// errors it redacts with [errors.Is]
+	//
+	// It intentionally does not implement Unwrap so that the unredacted paths
+	// of the errors it wraps may not be extracted with [errors.As].
+	redactedError struct {
+		msg string
+		err error
+	}
+)
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Is(target error) bool {
+	return errors.Is(e.err, target)
+}
+
+// collectErrPaths appends the paths of all [*fs.PathError] and
+// [*os.LinkError] in the tree of err
+func collectErrPaths(paths []string, err error) []string {
+	for err != nil {
+		switch e := err.(type) {
+		case *fs.PathError:
+			if e.Path != "" {
+				paths = append(paths, e.Path)
+			}
+		case *os.LinkError:
+			if e.Old != "" {
+				paths = append(paths, e.Old)
+			}
+			if e.New != "" {
+				paths = append(paths, e.New)
+			}
+		}
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		case interface{ Unwrap() []error }:
+			for _, i := range e.Unwrap() {
+				paths = collectErrPaths(paths, i)
+			}
+			return paths
+		default:
+			return paths
+		}
+	}
+	return paths
+}
+
+// redactErr replaces every file path in the message of err with its
+// redaction
+//
+// If err is a [*fs.PathError] or [*os.LinkError], the returned error is of
+// the same type with the same op and redacted paths.
+func redactErr(err error, redactor Redactor) error {
+	if err == nil {
+		return nil
+	}
+	inner := err
+	// the paths of the outermost error are redacted separately, and are not
+	// in the message of the error it wraps
+	switch e := err.(type) {
+	case *fs.PathError:
+		inner = e.Err
+	case *os.LinkError:
+		inner = e.Err
+	}
+	paths := collectErrPaths(nil, inner)
+	slices.SortFunc(paths, func(a, b string) int {
+		// replace longer paths first so that paths that contain others are
+		// replaced whole
+		if c := cmp.Compare(len(b), len(a)); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	paths = slices.Compact(paths)
+	replacements := make([]string, 0, 2*len(paths))
+	for _, i := range paths {
+		replacements = append(replacements, i, redactor.Redact(i))
+	}
+	redacted := &redactedError{
+		msg: strings.NewReplacer(replacements...).Replace(inner.Error()),
+		err: inner,
+	}
+	switch e := err.(type) {
+	case *fs.PathError:
+		return &fs.PathError{
+			Op:   e.Op,
+			Path: redactor.Redact(e.Path),
+			Err:  redacted,
+		}
+	case *os.LinkError:
+		return &os.LinkError{
+			Op:  e.Op,
+			Old: redactor.Redact(e.Old),
+			New: redactor.Redact(e.New),
+			Err: redacted,
+		}
+	default:
+		return redacted
+	}
+}
+
+type (
+	redactFS struct {
+		fsys     fs.FS
+		redactor Redactor
+	}
+)
+
+func (f *redactFS) Open(name string) (fs.File, error) {
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, redactErr(err, f.redactor)
+	}
+	return newRedactFile(file, name, f.redactor), nil
+}
+
+func (f *redactFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fs.Stat(f.fsys, name)
+	if err != nil {
+		return nil, redactErr(err, f.redactor)
+	}
+	return info, nil
+}
+
+func (f *redactFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, redactErr(err, f.redactor)
+	}
+	return entries, nil
+}
+
+func (f *redactFS) ReadFile(name string) ([]byte, error) {
+	data, err := fs.ReadFile(f.fsys, name)
+	if err != nil {
+		return nil, redactErr(err, f.redactor)
+	}
+	return data, nil
+}
+
+func (f *redactFS) Glob(pattern string) ([]string, error) {
+	matches, err := fs.Glob(f.fsys, pattern)
+	if err != nil {
+		return nil, redactErr(err, f.redactor)
+	}
+	return matches, nil
+}
+
+func (f *redactFS) Sub(dir string) (fs.FS, error) {
+	fsys, err := fs.Sub(f.fsys, dir)
+	if err != nil {
+		return nil, redactErr(err, f.redactor)
+	}
+	return NewRedactFS(fsys, f.redactor), nil
+}
+
+func (f *redactFS) FullFilePath(name string) (string, error) {
+	p, err := FullFilePath(f.fsys, name)
+	if err != nil {
+		return "", redactErr(err, f.redactor)
+	}
+	return p, nil
+}
+
+func (f *redactFS) Lstat(name string) (fs.FileInfo, error) {
+	info, err := Lstat(f.fsys, name)
+	if err != nil {
+		return nil, redactErr(err, f.redactor)
+	}
+	return info, nil
+}
+
+func (f *redactFS) ReadLink(name string) (string, error) {
+	target, err := ReadLink(f.fsys, name)
+	if err != nil {
+		return "", redactErr(err, f.redactor)
+	}
+	return target, nil
+}
+
+func (f *redactFS) Symlink(oldname, newname string) error {
+	return redactErr(Symlink(f.fsys, oldname, newname), f.redactor)
+}
+
+func (f *redactFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	file, err := OpenFile(f.fsys, name, flag, mode)
+	if err != nil {
+		return nil, redactErr(err, f.redactor)
+	}
+	return newRedactFile(file, name, f.redactor), nil
+}
+
+func (f *redactFS) Remove(name string) error {
+	return redactErr(Remove(f.fsys, name), f.redactor)
+}
+
+func (f *redactFS) RemoveAll(name string) error {
+	return redactErr(RemoveAll(f.fsys, name), f.redactor)
+}
+
+func (f *redactFS) Rename(oldname, newname string) error {
+	return redactErr(Rename(f.fsys, oldname, newname), f.redactor)
+}
+
+func (f *redactFS) Truncate(name string, size int64) error {
+	return redactErr(Truncate(f.fsys, name, size), f.redactor)
+}
+
+func (f *redactFS) Chtimes(name string, atime, mtime time.Time) error {
+	return redactErr(Chtimes(f.fsys, name, atime, mtime), f.redactor)
+}
+
+func (f *redactFS) Lchtimes(name string, atime, mtime time.Time) error {
+	return redactErr(Lchtimes(f.fsys, name, atime, mtime), f.redactor)
+}
+
+func (f *redactFS) Chmod(name string, mode fs.FileMode) error {
+	return redactErr(Chmod(f.fsys, name, mode), f.redactor)
+}
+
+func (f *redactFS) Chown(name string, uid, gid int) error {
+	return redactErr(Chown(f.fsys, name, uid, gid), f.redactor)
+}
+
+func (f *redactFS) Lchown(name string, uid, gid int) error {
+	return redactErr(Lchown(f.fsys, name, uid, gid), f.redactor)
+}
+
+func (f *redactFS) Mkfifo(name string, mode fs.FileMode) error {
+	return redactErr(Mkfifo(f.fsys, name, mode), f.redactor)
+}
+
+func (f *redactFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	return redactErr(WriteFileIfMatch(f.fsys, name, data, perm, token), f.redactor)
+}
+
+func (f *redactFS) MkdirTemp(dir, pattern string) (string, error) {
+	name, err := MkdirTemp(f.fsys, dir, pattern)
+	return name, redactErr(err, f.redactor)
+}
+
+func (f *redactFS) CreateTemp(dir, pattern string) (File, string, error) {
+	file, name, err := CreateTemp(f.fsys, dir, pattern)
+	if err != nil {
+		return nil, "", redactErr(err, f.redactor)
+	}
+	return newRedactFile(file, name, f.redactor), name, nil
+}
+
+func (f *redactFS) SyncDir(name string) error {
+	return redactErr(SyncDir(f.fsys, name), f.redactor)
+}
+
+func (f *redactFS) Statfs(name string) (*FSStats, error) {
+	stats, err := Statfs(f.fsys, name)
+	if err != nil {
+		return nil, redactErr(err, f.redactor)
+	}
+	return stats, nil
+}
+
+// NewRedactFS creates a new [FS] that redacts file paths in the errors it
+// returns
+//
+// Errors from the underlying fs, such as those from the os that contain
+// absolute paths, have the path of every [*fs.PathError] they wrap replaced
+// with its redaction in their messages. Returned errors still match the
+// errors they wrap with [errors.Is], but do not unwrap to them, so that
+// unredacted paths may not be recovered with [errors.As]. The returned
+// [*fs.PathError] values themselves have redacted paths.
+//
+// If redactor is nil, paths are made relative to the root of fsys as given by
+// [FullFilePath], with [RelativeRedactor]. Errors from the methods of opened
+// files are redacted as well, and methods of the optional interfaces of files
+// that the underlying file does not implement fail with [ErrNotImplemented].
+func NewRedactFS(fsys fs.FS, redactor Redactor) FS {
+	if redactor == nil {
+		dir, err := FullFilePath(fsys, ".")
+		if err != nil {
+			dir = ""
+		}
+		redactor = RelativeRedactor(dir)
+	}
+	return &redactFS{
+		fsys:     fsys,
+		redactor: redactor,
+	}
+}
+
+type (
+	// redactFile is an opened file that redacts file paths in the errors it
+	// returns
+	redactFile struct {
+		file     fs.File
+		name     string
+		redactor Redactor
+	}
+
+	// redactDirFile is an opened directory that redacts file paths in the
+	// errors it returns
+	redactDirFile struct {
+		*redactFile
+		dir fs.ReadDirFile
+	}
+)
+
+func newRedactFile(file fs.File, name string, redactor Redactor) File {
+	f := &redactFile{
+		file:     file,
+		name:     name,
+		redactor: redactor,
+	}
+	if d, ok := file.(fs.ReadDirFile); ok {
+		return &redactDirFile{
+			redactFile: f,
+			dir:        d,
+		}
+	}
+	return f
+}
+
+// redact redacts err, except for [io.EOF], which callers compare by identity
+func (f *redactFile) redact(err error) error {
+	if err == io.EOF {
+		return err
+	}
+	return redactErr(err, f.redactor)
+}
+
+func (f *redactFile) notImplemented(op string) error {
+	return &fs.PathError{
+		Op:   op,
+		Path: f.name,
+		Err:  kerrors.WithMsg(ErrNotImplemented, "File does not support the operation"),
+	}
+}
+
+func (f *redactFile) Stat() (fs.FileInfo, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return nil, f.redact(err)
+	}
+	return info, nil
+}
+
+func (f *redactFile) Read(p []byte) (int, error) {
+	n, err := f.file.Read(p)
+	return n, f.redact(err)
+}
+
+func (f *redactFile) Write(p []byte) (int, error) {
+	w, ok := f.file.(io.Writer)
+	if !ok {
+		return 0, f.notImplemented("write")
+	}
+	n, err := w.Write(p)
+	return n, f.redact(err)
+}
+
+func (f *redactFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := f.file.(io.Seeker)
+	if !ok {
+		return 0, f.notImplemented("seek")
+	}
+	n, err := s.Seek(offset, whence)
+	return n, f.redact(err)
+}
+
+func (f *redactFile) ReadAt(p []byte, offset int64) (int, error) {
+	r, ok := f.file.(io.ReaderAt)
+	if !ok {
+		return 0, f.notImplemented("readat")
+	}
+	n, err := r.ReadAt(p, offset)
+	return n, f.redact(err)
+}
+
+func (f *redactFile) WriteAt(p []byte, offset int64) (int, error) {
+	w, ok := f.file.(io.WriterAt)
+	if !ok {
+		return 0, f.notImplemented("writeat")
+	}
+	n, err := w.WriteAt(p, offset)
+	return n, f.redact(err)
+}
+
+// Truncate implements [TruncatableFile]
+func (f *redactFile) Truncate(size int64) error {
+	t, ok := f.file.(interface{ Truncate(size int64) error })
+	if !ok {
+		return f.notImplemented("truncate")
+	}
+	return f.redact(t.Truncate(size))
+}
+
+// Sync implements [SyncFile]
+func (f *redactFile) Sync() error {
+	return f.redact(Sync(f.file))
+}
+
+func (f *redactFile) Close() error {
+	return f.redact(f.file.Close())
+}
+
+// ReadDir implements [fs.ReadDirFile]
+func (d *redactDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := d.dir.ReadDir(n)
+	return entries, d.redact(err)
+}
@@ -0,0 +1,194 @@
+package kfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"sync"
+
+	"xorkevin.dev/kerrors"
+)
+
+type (
+	// RemoveReport reports the files removed by [RemoveAllContext] and
+	// [PruneEmptyDirs]
+	RemoveReport struct {
+		// Removed is the names of removed files in the order they were removed
+		Removed []string
+	}
+
+	// RemoveAllOpt is an option for [RemoveAllContext]
+	RemoveAllOpt = func(o *removeAllOpts)
+
+	removeAllOpts struct {
+		workers int
+	}
+)
+
+// RemoveAllWorkers sets the max number of files that [RemoveAllContext] may
+// remove concurrently
+//
+// Values less than 2 remove files sequentially, which is the default.
+func RemoveAllWorkers(n int) RemoveAllOpt {
+	return func(o *removeAllOpts) {
+		o.workers = n
+	}
+}
+
+// lstatOrStat returns the FileInfo of the named file without following
+// symbolic links if fsys supports it
+func lstatOrStat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	info, err := Lstat(fsys, name)
+	if err != nil && errors.Is(err, ErrNotImplemented) {
+		return fs.Stat(fsys, name)
+	}
+	return info, err
+}
+
+// RemoveAllContext removes a file and all children, checking for
+// cancellation before each removal
+//
+// Unlike [RemoveAll], RemoveAllContext walks the tree and removes files
+// individually with [Remove], children before their parents. Symbolic links
+// are removed and never followed. Like [RemoveAll], it returns nil if name
+// does not exist. If ctx is canceled, RemoveAllContext stops and returns an
+// error matching ctx.Err(). The returned report lists the files removed
+// before any error.
+//
+// With [RemoveAllWorkers], files are removed concurrently, and the first
+// error stops all workers.
+func RemoveAllContext(ctx context.Context, fsys fs.FS, name string, opts ...RemoveAllOpt) (*RemoveReport, error) {
+	o := removeAllOpts{}
+	for _, i := range opts {
+		i(&o)
+	}
+
+	report := &RemoveReport{}
+	info, err := lstatOrStat(fsys, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return report, nil
+		}
+		return report, &fs.PathError{
+			Op:   "removeall",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to stat file"),
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s := &removeAllState{
+		ctx:    ctx,
+		cancel: cancel,
+		fsys:   fsys,
+		report: report,
+	}
+	if o.workers > 1 {
+		// the calling goroutine is also a worker
+		s.sem = make(chan struct{}, o.workers-1)
+	}
+	s.remove(name, info.IsDir())
+	if s.err != nil {
+		return report, s.err
+	}
+	return report, nil
+}
+
+type (
+	removeAllState struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+		fsys   fs.FS
+		sem    chan struct{}
+		mu     sync.Mutex
+		report *RemoveReport
+		err    error
+	}
+)
+
+func (s *removeAllState) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+		s.cancel()
+	}
+}
+
+func (s *removeAllState) removed(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report.Removed = append(s.report.Removed, name)
+}
+
+// remove removes a file and its children, and returns whether it succeeded
+func (s *removeAllState) remove(name string, isDir bool) bool {
+	if isDir {
+		entries, err := fs.ReadDir(s.fsys, name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.fail(&fs.PathError{
+				Op:   "removeall",
+				Path: name,
+				Err:  kerrors.WithMsg(err, "Failed to read dir"),
+			})
+			return false
+		}
+		ok := true
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, i := range entries {
+			child := path.Join(name, i.Name())
+			select {
+			case s.sem <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() {
+						<-s.sem
+					}()
+					if !s.remove(child, i.IsDir()) {
+						mu.Lock()
+						ok = false
+						mu.Unlock()
+					}
+				}()
+			default:
+				// a nil sem or one without free workers removes inline
+				if !s.remove(child, i.IsDir()) {
+					mu.Lock()
+					ok = false
+					mu.Unlock()
+				}
+			}
+		}
+		wg.Wait()
+		if !ok {
+			return false
+		}
+	}
+	if err := s.ctx.Err(); err != nil {
+		s.fail(&fs.PathError{
+			Op:   "removeall",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Remove canceled"),
+		})
+		return false
+	}
+	if err := Remove(s.fsys, name); err != nil {
+		// directories may be implicit in some fs and removed along with their
+		// last child
+		if errors.Is(err, fs.ErrNotExist) {
+			return true
+		}
+		s.fail(&fs.PathError{
+			Op:   "removeall",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to remove file"),
+		})
+		return false
+	}
+	s.removed(name)
+	return true
+}
@@ -0,0 +1,486 @@
+package kfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"xorkevin.dev/kerrors"
+)
+
+type (
+	// ShardFSOpt is an option for [NewShardFS]
+	ShardFSOpt = func(o *shardFSOpts)
+
+	shardFSOpts struct {
+		levels int
+		width  int
+	}
+)
+
+// ShardFSLevels sets the number of levels of shard directories
+//
+// The default is 2.
+func ShardFSLevels(n int) ShardFSOpt {
+	return func(o *shardFSOpts) {
+		o.levels = n
+	}
+}
+
+// ShardFSWidth sets the number of hex digits in the name of each shard
+// directory
+//
+// The default is 2, which gives 256 shards per level.
+func ShardFSWidth(n int) ShardFSOpt {
+	return func(o *shardFSOpts) {
+		o.width = n
+	}
+}
+
+type (
+	shardFS struct {
+		fsys   fs.FS
+		levels int
+		width  int
+	}
+)
+
+// shardPath returns the path of a file in the underlying fs
+func (f *shardFS) shardPath(op string, name string) (string, error) {
+	if name == "." {
+		return name, nil
+	}
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	if strings.Contains(name, "/") {
+		return "", &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrNotExist, "Sharded fs has no subdirectories"),
+		}
+	}
+	sum := sha256.Sum256([]byte(name))
+	digest := hex.EncodeToString(sum[:])
+	var b strings.Builder
+	for i := range f.levels {
+		b.WriteString(digest[i*f.width : (i+1)*f.width])
+		b.WriteByte('/')
+	}
+	b.WriteString(name)
+	return b.String(), nil
+}
+
+// readShards reads the files of all shards under dir at a level
+func (f *shardFS) readShards(dir string, level int) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	if level == f.levels {
+		return entries, nil
+	}
+	var res []fs.DirEntry
+	for _, i := range entries {
+		if !i.IsDir() {
+			continue
+		}
+		p := i.Name()
+		if dir != "." {
+			p = dir + "/" + p
+		}
+		children, err := f.readShards(p, level+1)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, children...)
+	}
+	return res, nil
+}
+
+func (f *shardFS) Open(name string) (fs.File, error) {
+	p, err := f.shardPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if p != "." {
+		return f.fsys.Open(p)
+	}
+	file, err := f.fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := f.ReadDir(".")
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &shardDirFile{
+		File:    file,
+		entries: entries,
+	}, nil
+}
+
+func (f *shardFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.shardPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(f.fsys, p)
+}
+
+// ReadDir implements [fs.ReadDirFS]
+//
+// Only the root may be read, and its entries are the files of all shards,
+// sorted by name.
+func (f *shardFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := f.shardPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if p != "." {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, ErrNotDir, "File is not a directory"),
+		}
+	}
+	entries, err := f.readShards(".", 0)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to read shards"),
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+func (f *shardFS) ReadFile(name string) ([]byte, error) {
+	p, err := f.shardPath("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(f.fsys, p)
+}
+
+func (f *shardFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(struct{ fs.ReadDirFS }{f}, pattern)
+}
+
+func (f *shardFS) Sub(dir string) (fs.FS, error) {
+	p, err := f.shardPath("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	if p != "." {
+		return nil, &fs.PathError{
+			Op:   "sub",
+			Path: dir,
+			Err:  kerrors.WithKind(fs.ErrInvalid, ErrNotDir, "File is not a directory"),
+		}
+	}
+	return f, nil
+}
+
+func (f *shardFS) FullFilePath(name string) (string, error) {
+	p, err := f.shardPath("fullfilepath", name)
+	if err != nil {
+		return "", err
+	}
+	return FullFilePath(f.fsys, p)
+}
+
+func (f *shardFS) Lstat(name string) (fs.FileInfo, error) {
+	p, err := f.shardPath("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return Lstat(f.fsys, p)
+}
+
+// ReadLink implements [ReadLinkFS]
+//
+// Targets written by [shardFS.Symlink] are mapped back to the names of their
+// files. Other targets are returned as is.
+func (f *shardFS) ReadLink(name string) (string, error) {
+	p, err := f.shardPath("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	target, err := ReadLink(f.fsys, p)
+	if err != nil {
+		return "", err
+	}
+	return f.flatLinkTarget(target), nil
+}
+
+// linkTarget returns the target of a link in the underlying fs to the file
+// at the sharded path p, relative to the shard dir of the link
+func (f *shardFS) linkTarget(p string) string {
+	return strings.Repeat("../", f.levels) + p
+}
+
+// flatLinkTarget returns the name of the file of a target returned by
+// linkTarget, or target if it is not one
+func (f *shardFS) flatLinkTarget(target string) string {
+	rest, ok := strings.CutPrefix(target, strings.Repeat("../", f.levels))
+	if !ok {
+		return target
+	}
+	name := path.Base(rest)
+	if p, err := f.shardPath("readlink", name); err != nil || p != rest {
+		return target
+	}
+	return name
+}
+
+// Symlink implements [MakeSymlinkFS]
+//
+// oldname is rewritten to the sharded path of its file, so that the link
+// resolves in the underlying fs, and is mapped back to oldname by
+// [shardFS.ReadLink].
+func (f *shardFS) Symlink(oldname, newname string) error {
+	p, err := f.shardPath("symlink", newname)
+	if err != nil {
+		return err
+	}
+	target, err := f.shardPath("symlink", oldname)
+	if err != nil {
+		return err
+	}
+	return Symlink(f.fsys, f.linkTarget(target), p)
+}
+
+func (f *shardFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	p, err := f.shardPath("openfile", name)
+	if err != nil {
+		return nil, err
+	}
+	return OpenFile(f.fsys, p, flag, mode)
+}
+
+// Remove implements [RemoveFS]
+//
+// Shard directories are left in place when their last file is removed.
+func (f *shardFS) Remove(name string) error {
+	p, err := f.shardPath("remove", name)
+	if err != nil {
+		return err
+	}
+	return Remove(f.fsys, p)
+}
+
+func (f *shardFS) RemoveAll(name string) error {
+	p, err := f.shardPath("removeall", name)
+	if err != nil {
+		return err
+	}
+	return RemoveAll(f.fsys, p)
+}
+
+func (f *shardFS) Rename(oldname, newname string) error {
+	oldp, err := f.shardPath("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newp, err := f.shardPath("rename", newname)
+	if err != nil {
+		return err
+	}
+	return Rename(f.fsys, oldp, newp)
+}
+
+func (f *shardFS) Truncate(name string, size int64) error {
+	p, err := f.shardPath("truncate", name)
+	if err != nil {
+		return err
+	}
+	return Truncate(f.fsys, p, size)
+}
+
+func (f *shardFS) Chtimes(name string, atime, mtime time.Time) error {
+	p, err := f.shardPath("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return Chtimes(f.fsys, p, atime, mtime)
+}
+
+func (f *shardFS) Lchtimes(name string, atime, mtime time.Time) error {
+	p, err := f.shardPath("lchtimes", name)
+	if err != nil {
+		return err
+	}
+	return Lchtimes(f.fsys, p, atime, mtime)
+}
+
+func (f *shardFS) Chmod(name string, mode fs.FileMode) error {
+	p, err := f.shardPath("chmod", name)
+	if err != nil {
+		return err
+	}
+	return Chmod(f.fsys, p, mode)
+}
+
+func (f *shardFS) Chown(name string, uid, gid int) error {
+	p, err := f.shardPath("chown", name)
+	if err != nil {
+		return err
+	}
+	return Chown(f.fsys, p, uid, gid)
+}
+
+func (f *shardFS) Lchown(name string, uid, gid int) error {
+	p, err := f.shardPath("lchown", name)
+	if err != nil {
+		return err
+	}
+	return Lchown(f.fsys, p, uid, gid)
+}
+
+func (f *shardFS) Mkfifo(name string, mode fs.FileMode) error {
+	p, err := f.shardPath("mkfifo", name)
+	if err != nil {
+		return err
+	}
+	return Mkfifo(f.fsys, p, mode)
+}
+
+func (f *shardFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	p, err := f.shardPath("writefileifmatch", name)
+	if err != nil {
+		return err
+	}
+	return WriteFileIfMatch(f.fsys, p, data, perm, token)
+}
+
+// MkdirTemp implements [TempFS]
+//
+// A sharded fs has no subdirectories, so MkdirTemp always fails.
+func (f *shardFS) MkdirTemp(dir, pattern string) (string, error) {
+	return "", &fs.PathError{
+		Op:   "mkdirtemp",
+		Path: dir,
+		Err:  kerrors.WithMsg(ErrNotImplemented, "Sharded fs has no subdirectories"),
+	}
+}
+
+// CreateTemp implements [TempFS]
+//
+// dir must be ".", as a sharded fs has no subdirectories.
+func (f *shardFS) CreateTemp(dir, pattern string) (File, string, error) {
+	if dir != "." {
+		return nil, "", &fs.PathError{
+			Op:   "createtemp",
+			Path: dir,
+			Err:  kerrors.WithMsg(fs.ErrNotExist, "Sharded fs has no subdirectories"),
+		}
+	}
+	return createTemp(f, dir, pattern)
+}
+
+// SyncDir implements [SyncFS]
+//
+// name must be ".", as a sharded fs has no subdirectories. The root and every
+// shard directory are synced, as files are stored in the shard directories.
+func (f *shardFS) SyncDir(name string) error {
+	if name != "." {
+		return &fs.PathError{
+			Op:   "syncdir",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrNotExist, "Sharded fs has no subdirectories"),
+		}
+	}
+	return fs.WalkDir(f.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := SyncDir(f.fsys, p); err != nil {
+			return err
+		}
+		if p != "." && strings.Count(p, "/")+1 >= f.levels {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+func (f *shardFS) Statfs(name string) (*FSStats, error) {
+	p, err := f.shardPath("statfs", name)
+	if err != nil {
+		return nil, err
+	}
+	return Statfs(f.fsys, p)
+}
+
+// NewShardFS creates a new [FS] that presents a flat directory of files
+// stored in hashed shard directories of fsys
+//
+// A file is stored under shard directories named by the leading hex digits of
+// the sha256 digest of its name, e.g. with the default options, a file named
+// abc is stored at ba/78/abc. This avoids the per-directory entry limits of
+// some backends for directories with millions of files. The returned fs has
+// no subdirectories, and listing its root reads every shard. Symlink targets
+// are rewritten to the sharded paths of their files in fsys, and are read back
+// as the names of their files.
+//
+// NewShardFS panics if the levels or width are less than 1, or if they use
+// more digits than a sha256 digest has.
+func NewShardFS(fsys fs.FS, opts ...ShardFSOpt) FS {
+	o := shardFSOpts{
+		levels: 2,
+		width:  2,
+	}
+	for _, i := range opts {
+		i(&o)
+	}
+	if o.levels < 1 || o.width < 1 || o.levels*o.width > 2*sha256.Size {
+		panic(fmt.Sprintf("kfs: invalid shard levels %d and width %d", o.levels, o.width))
+	}
+	return &shardFS{
+		fsys:   fsys,
+		levels: o.levels,
+		width:  o.width,
+	}
+}
+
+type (
+	// shardDirFile is the root dir of a [shardFS]
+	shardDirFile struct {
+		fs.File
+		entries []fs.DirEntry
+	}
+)
+
+// ReadDir implements [fs.ReadDirFile]
+func (d *shardDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		res := d.entries
+		d.entries = nil
+		return res, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	k := min(n, len(d.entries))
+	res := d.entries[:k:k]
+	d.entries = d.entries[k:]
+	return res, nil
+}
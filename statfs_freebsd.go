@@ -0,0 +1,13 @@
+package kfs
+
+import (
+	"syscall"
+)
+
+// statfsAvail returns the available blocks and free inodes of s
+//
+// These are signed on freebsd, and are negative when usage exceeds the space
+// reserved for the superuser, so they are clamped to 0.
+func statfsAvail(s *syscall.Statfs_t) (uint64, uint64) {
+	return uint64(max(s.Bavail, 0)), uint64(max(s.Ffree, 0))
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package kfs
+
+import (
+	"xorkevin.dev/kerrors"
+)
+
+func statfs(name string) (*FSStats, error) {
+	return nil, kerrors.WithMsg(ErrNotImplemented, "File system stats are not supported on this platform")
+}
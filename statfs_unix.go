@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd
+
+package kfs
+
+import (
+	"syscall"
+)
+
+func statfs(name string) (*FSStats, error) {
+	var s syscall.Statfs_t
+	if err := syscall.Statfs(name, &s); err != nil {
+		return nil, wrapOSErr(err, "Failed to stat file system")
+	}
+	bsize := uint64(s.Bsize)
+	bavail, ffree := statfsAvail(&s)
+	return &FSStats{
+		TotalBytes:  uint64(s.Blocks) * bsize,
+		FreeBytes:   uint64(s.Bfree) * bsize,
+		AvailBytes:  bavail * bsize,
+		TotalInodes: uint64(s.Files),
+		FreeInodes:  ffree,
+	}, nil
+}
@@ -0,0 +1,12 @@
+//go:build linux || darwin
+
+package kfs
+
+import (
+	"syscall"
+)
+
+// statfsAvail returns the available blocks and free inodes of s
+func statfsAvail(s *syscall.Statfs_t) (uint64, uint64) {
+	return uint64(s.Bavail), uint64(s.Ffree)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package kfs
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+func statfs(name string) (*FSStats, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, wrapOSErr(err, "Invalid path")
+	}
+	var avail, total, free uint64
+	if r, _, err := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&avail)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&free)),
+	); r == 0 {
+		return nil, wrapOSErr(err, "Failed to stat file system")
+	}
+	return &FSStats{
+		TotalBytes: total,
+		FreeBytes:  free,
+		AvailBytes: avail,
+	}, nil
+}
@@ -0,0 +1,598 @@
+package kfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"xorkevin.dev/kerrors"
+)
+
+// ErrSymlink is returned when a path contains a symlink rejected by a
+// [SymlinkPolicy]
+var ErrSymlink errSymlink
+
+type (
+	errSymlink struct{}
+)
+
+func (e errSymlink) Error() string {
+	return "File is a symlink"
+}
+
+type (
+	// SymlinkPolicy is how [NewSymlinkPolicyFS] handles symlinks
+	SymlinkPolicy int
+
+	// SymlinkDirEntry is a dir entry of a symlink returned by an fs created
+	// with [NewSymlinkPolicyFS]
+	SymlinkDirEntry interface {
+		fs.DirEntry
+		// IsSymlink returns true
+		IsSymlink() bool
+	}
+)
+
+const (
+	// SymlinkFollow follows symlinks as the os does
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkReject fails operations on paths that would follow a symlink
+	SymlinkReject
+	// SymlinkPreserve treats symlinks as files whose contents are their
+	// targets
+	SymlinkPreserve
+)
+
+func (p SymlinkPolicy) String() string {
+	switch p {
+	case SymlinkFollow:
+		return "follow"
+	case SymlinkReject:
+		return "reject"
+	case SymlinkPreserve:
+		return "preserve"
+	default:
+		return fmt.Sprintf("SymlinkPolicy(%d)", int(p))
+	}
+}
+
+type (
+	symlinkPolicyFS struct {
+		fsys   fs.FS
+		policy SymlinkPolicy
+	}
+)
+
+// checkLinks checks the symlinks in name against the policy, and returns
+// whether the last path component is a symlink that is preserved
+//
+// Symlinks in the last path component are only checked if follow is true.
+func (f *symlinkPolicyFS) checkLinks(op string, name string, follow bool) (bool, error) {
+	if f.policy == SymlinkFollow {
+		return false, nil
+	}
+	if !fs.ValidPath(name) {
+		return false, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	if name == "." {
+		return false, nil
+	}
+	end := len(name)
+	if !follow {
+		end = max(strings.LastIndexByte(name, '/'), 0)
+	}
+	for i := 0; i < end; {
+		next := strings.IndexByte(name[i:], '/')
+		if next < 0 {
+			next = end
+		} else {
+			next += i
+		}
+		p := name[:next]
+		i = next + 1
+		info, err := Lstat(f.fsys, p)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// missing files are reported by the operation itself
+				return false, nil
+			}
+			return false, &fs.PathError{
+				Op:   op,
+				Path: name,
+				Err:  kerrors.WithMsg(err, "Failed to check for symlinks"),
+			}
+		}
+		if info.Mode().Type() != fs.ModeSymlink {
+			continue
+		}
+		if f.policy == SymlinkReject {
+			return false, &fs.PathError{
+				Op:   op,
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrPermission, ErrSymlink, fmt.Sprintf("Symlink %s is rejected", p)),
+			}
+		}
+		if next < len(name) {
+			return false, &fs.PathError{
+				Op:   op,
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrInvalid, ErrNotDir, fmt.Sprintf("Symlink %s is not a directory", p)),
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// check checks the symlinks in name against the policy for an operation that
+// may not treat a symlink as a file
+func (f *symlinkPolicyFS) check(op string, name string, follow bool) error {
+	isLink, err := f.checkLinks(op, name, follow)
+	if err != nil {
+		return err
+	}
+	if isLink {
+		return &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, ErrSymlink, "Preserved symlink may not be followed"),
+		}
+	}
+	return nil
+}
+
+// annotate filters and annotates the symlinks of the entries of dir name
+func (f *symlinkPolicyFS) annotate(name string, entries []fs.DirEntry) []fs.DirEntry {
+	res := make([]fs.DirEntry, 0, len(entries))
+	for _, i := range entries {
+		if i.Type() != fs.ModeSymlink {
+			res = append(res, i)
+			continue
+		}
+		switch f.policy {
+		case SymlinkReject:
+			continue
+		case SymlinkFollow:
+			// the type of a followed link is that of its target, if it exists
+			info, err := fs.Stat(f.fsys, joinValidPath(name, i.Name()))
+			if err != nil {
+				info = nil
+			}
+			res = append(res, &symlinkDirEntry{
+				DirEntry: i,
+				target:   info,
+			})
+		default:
+			res = append(res, &symlinkDirEntry{
+				DirEntry: i,
+			})
+		}
+	}
+	return res
+}
+
+// openLink opens a preserved symlink as a file
+func (f *symlinkPolicyFS) openLink(name string) (*symlinkFile, error) {
+	info, err := Lstat(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	target, err := ReadLink(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return &symlinkFile{
+		name: name,
+		info: info,
+		r:    bytes.NewReader([]byte(target)),
+	}, nil
+}
+
+// wrapDir wraps file with a [symlinkDirFile] if it is a directory, and
+// otherwise returns nil
+func (f *symlinkPolicyFS) wrapDir(name string, file fs.File) (*symlinkDirFile, error) {
+	dir, ok := file.(fs.ReadDirFile)
+	if !ok {
+		return nil, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+	if !info.IsDir() {
+		return nil, nil
+	}
+	return &symlinkDirFile{
+		ReadDirFile: dir,
+		fsys:        f,
+		name:        name,
+	}, nil
+}
+
+func (f *symlinkPolicyFS) Open(name string) (fs.File, error) {
+	isLink, err := f.checkLinks("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if isLink {
+		return f.openLink(name)
+	}
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if d, err := f.wrapDir(name, file); err != nil {
+		return nil, err
+	} else if d != nil {
+		return d, nil
+	}
+	return file, nil
+}
+
+func (f *symlinkPolicyFS) Stat(name string) (fs.FileInfo, error) {
+	isLink, err := f.checkLinks("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if isLink {
+		return Lstat(f.fsys, name)
+	}
+	return fs.Stat(f.fsys, name)
+}
+
+func (f *symlinkPolicyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	isLink, err := f.checkLinks("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if isLink {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrInvalid, ErrNotDir, "Preserved symlink is not a directory"),
+		}
+	}
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.annotate(name, entries), nil
+}
+
+func (f *symlinkPolicyFS) ReadFile(name string) ([]byte, error) {
+	isLink, err := f.checkLinks("readfile", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if isLink {
+		target, err := ReadLink(f.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(target), nil
+	}
+	return fs.ReadFile(f.fsys, name)
+}
+
+func (f *symlinkPolicyFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(struct{ fs.ReadDirFS }{f}, pattern)
+}
+
+func (f *symlinkPolicyFS) Sub(dir string) (fs.FS, error) {
+	if err := f.check("sub", dir, true); err != nil {
+		return nil, err
+	}
+	fsys, err := fs.Sub(f.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewSymlinkPolicyFS(fsys, f.policy), nil
+}
+
+func (f *symlinkPolicyFS) FullFilePath(name string) (string, error) {
+	return FullFilePath(f.fsys, name)
+}
+
+func (f *symlinkPolicyFS) Lstat(name string) (fs.FileInfo, error) {
+	if err := f.check("lstat", name, false); err != nil {
+		return nil, err
+	}
+	return Lstat(f.fsys, name)
+}
+
+func (f *symlinkPolicyFS) ReadLink(name string) (string, error) {
+	if err := f.check("readlink", name, false); err != nil {
+		return "", err
+	}
+	return ReadLink(f.fsys, name)
+}
+
+func (f *symlinkPolicyFS) Symlink(oldname, newname string) error {
+	if err := f.check("symlink", newname, false); err != nil {
+		return err
+	}
+	return Symlink(f.fsys, oldname, newname)
+}
+
+// OpenFile implements [WriteFS]
+//
+// A preserved symlink may only be opened for reading.
+func (f *symlinkPolicyFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	isLink, err := f.checkLinks("openfile", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if isLink {
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+			return nil, &fs.PathError{
+				Op:   "openfile",
+				Path: name,
+				Err:  kerrors.WithKind(fs.ErrInvalid, ErrSymlink, "Preserved symlink may not be written"),
+			}
+		}
+		return f.openLink(name)
+	}
+	file, err := OpenFile(f.fsys, name, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	if d, err := f.wrapDir(name, file); err != nil {
+		return nil, err
+	} else if d != nil {
+		return d, nil
+	}
+	return file, nil
+}
+
+func (f *symlinkPolicyFS) Remove(name string) error {
+	if err := f.check("remove", name, false); err != nil {
+		return err
+	}
+	return Remove(f.fsys, name)
+}
+
+func (f *symlinkPolicyFS) RemoveAll(name string) error {
+	if err := f.check("removeall", name, false); err != nil {
+		return err
+	}
+	return RemoveAll(f.fsys, name)
+}
+
+func (f *symlinkPolicyFS) Rename(oldname, newname string) error {
+	if err := f.check("rename", oldname, false); err != nil {
+		return err
+	}
+	if err := f.check("rename", newname, false); err != nil {
+		return err
+	}
+	return Rename(f.fsys, oldname, newname)
+}
+
+func (f *symlinkPolicyFS) Truncate(name string, size int64) error {
+	if err := f.check("truncate", name, true); err != nil {
+		return err
+	}
+	return Truncate(f.fsys, name, size)
+}
+
+func (f *symlinkPolicyFS) Chtimes(name string, atime, mtime time.Time) error {
+	if err := f.check("chtimes", name, true); err != nil {
+		return err
+	}
+	return Chtimes(f.fsys, name, atime, mtime)
+}
+
+func (f *symlinkPolicyFS) Lchtimes(name string, atime, mtime time.Time) error {
+	if err := f.check("lchtimes", name, false); err != nil {
+		return err
+	}
+	return Lchtimes(f.fsys, name, atime, mtime)
+}
+
+func (f *symlinkPolicyFS) Chmod(name string, mode fs.FileMode) error {
+	if err := f.check("chmod", name, true); err != nil {
+		return err
+	}
+	return Chmod(f.fsys, name, mode)
+}
+
+func (f *symlinkPolicyFS) Chown(name string, uid, gid int) error {
+	if err := f.check("chown", name, true); err != nil {
+		return err
+	}
+	return Chown(f.fsys, name, uid, gid)
+}
+
+func (f *symlinkPolicyFS) Lchown(name string, uid, gid int) error {
+	if err := f.check("lchown", name, false); err != nil {
+		return err
+	}
+	return Lchown(f.fsys, name, uid, gid)
+}
+
+func (f *symlinkPolicyFS) Mkfifo(name string, mode fs.FileMode) error {
+	if err := f.check("mkfifo", name, false); err != nil {
+		return err
+	}
+	return Mkfifo(f.fsys, name, mode)
+}
+
+func (f *symlinkPolicyFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	if err := f.check("writefileifmatch", name, true); err != nil {
+		return err
+	}
+	return WriteFileIfMatch(f.fsys, name, data, perm, token)
+}
+
+func (f *symlinkPolicyFS) MkdirTemp(dir, pattern string) (string, error) {
+	if err := f.check("mkdirtemp", dir, true); err != nil {
+		return "", err
+	}
+	return MkdirTemp(f.fsys, dir, pattern)
+}
+
+func (f *symlinkPolicyFS) CreateTemp(dir, pattern string) (File, string, error) {
+	if err := f.check("createtemp", dir, true); err != nil {
+		return nil, "", err
+	}
+	return CreateTemp(f.fsys, dir, pattern)
+}
+
+func (f *symlinkPolicyFS) SyncDir(name string) error {
+	if err := f.check("syncdir", name, true); err != nil {
+		return err
+	}
+	return SyncDir(f.fsys, name)
+}
+
+func (f *symlinkPolicyFS) Statfs(name string) (*FSStats, error) {
+	if err := f.check("statfs", name, true); err != nil {
+		return nil, err
+	}
+	return Statfs(f.fsys, name)
+}
+
+// NewSymlinkPolicyFS creates a new [FS] that handles symlinks according to a
+// [SymlinkPolicy]
+//
+// With [SymlinkFollow], symlinks are followed as the os does. With
+// [SymlinkReject], operations on paths that would follow a symlink fail with
+// [ErrSymlink], and symlinks are omitted from dir entries. With
+// [SymlinkPreserve], a symlink is treated as a file: Open, Stat, and ReadFile
+// return the link itself with its target as its contents, and paths through a
+// symlink fail with [ErrNotDir]. Operations that do not follow the last path
+// component, such as Lstat, Remove, and Rename, still check its parents.
+//
+// Dir entries of symlinks that are not omitted implement [SymlinkDirEntry].
+// With [SymlinkFollow], their type is that of their target if it exists, so
+// that it agrees with Stat. Checking for symlinks costs an Lstat of every
+// path component, except with [SymlinkFollow], which costs nothing beyond
+// dir entry annotations.
+func NewSymlinkPolicyFS(fsys fs.FS, policy SymlinkPolicy) FS {
+	return &symlinkPolicyFS{
+		fsys:   fsys,
+		policy: policy,
+	}
+}
+
+type (
+	// symlinkDirEntry is an annotated dir entry of a symlink
+	symlinkDirEntry struct {
+		fs.DirEntry
+		target fs.FileInfo
+	}
+)
+
+func (e *symlinkDirEntry) IsDir() bool {
+	if e.target != nil {
+		return e.target.IsDir()
+	}
+	return e.DirEntry.IsDir()
+}
+
+func (e *symlinkDirEntry) Type() fs.FileMode {
+	if e.target != nil {
+		return e.target.Mode().Type()
+	}
+	return e.DirEntry.Type()
+}
+
+func (e *symlinkDirEntry) Info() (fs.FileInfo, error) {
+	if e.target != nil {
+		return e.target, nil
+	}
+	return e.DirEntry.Info()
+}
+
+// IsSymlink implements [SymlinkDirEntry]
+func (e *symlinkDirEntry) IsSymlink() bool {
+	return true
+}
+
+type (
+	// symlinkFile is a preserved symlink opened as a file
+	symlinkFile struct {
+		name string
+		info fs.FileInfo
+		r    *bytes.Reader
+	}
+)
+
+func (f *symlinkFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *symlinkFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *symlinkFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "write",
+		Path: f.name,
+		Err:  kerrors.WithKind(fs.ErrInvalid, ErrSymlink, "Preserved symlink may not be written"),
+	}
+}
+
+func (f *symlinkFile) Close() error {
+	return nil
+}
+
+type (
+	// symlinkDirFile is a directory file that annotates its dir entries
+	symlinkDirFile struct {
+		fs.ReadDirFile
+		fsys    *symlinkPolicyFS
+		name    string
+		pending []fs.DirEntry
+		eof     bool
+	}
+)
+
+func (d *symlinkDirFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "write",
+		Path: d.name,
+		Err:  kerrors.WithKind(fs.ErrInvalid, ErrIsDir, "File is a directory"),
+	}
+}
+
+// ReadDir implements [fs.ReadDirFile]
+func (d *symlinkDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries, err := d.ReadDirFile.ReadDir(-1)
+		if err != nil {
+			return nil, err
+		}
+		res := append(d.pending, d.fsys.annotate(d.name, entries)...)
+		d.pending = nil
+		d.eof = true
+		return res, nil
+	}
+	for len(d.pending) < n && !d.eof {
+		entries, err := d.ReadDirFile.ReadDir(n)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			d.eof = true
+		}
+		d.pending = append(d.pending, d.fsys.annotate(d.name, entries)...)
+	}
+	if len(d.pending) == 0 {
+		return nil, io.EOF
+	}
+	k := min(n, len(d.pending))
+	res := d.pending[:k:k]
+	d.pending = d.pending[k:]
+	return res, nil
+}
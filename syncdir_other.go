@@ -0,0 +1,36 @@
+//go:build !windows
+
+package kfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"xorkevin.dev/kerrors"
+)
+
+// syncOSDir commits the entries of the directory at the os path name to
+// stable storage
+func syncOSDir(name string) (retErr error) {
+	d, err := os.Open(name)
+	if err != nil {
+		return wrapOSErr(err, "Failed to open dir")
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			retErr = errors.Join(retErr, wrapOSErr(err, "Failed to close dir"))
+		}
+	}()
+	info, err := d.Stat()
+	if err != nil {
+		return wrapOSErr(err, "Failed to stat dir")
+	}
+	if !info.IsDir() {
+		return kerrors.WithKind(fs.ErrInvalid, ErrNotDir, "Not a directory")
+	}
+	if err := d.Sync(); err != nil {
+		return wrapOSErr(err, "Failed to sync dir")
+	}
+	return nil
+}
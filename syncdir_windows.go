@@ -0,0 +1,26 @@
+//go:build windows
+
+package kfs
+
+import (
+	"io/fs"
+	"os"
+
+	"xorkevin.dev/kerrors"
+)
+
+// syncOSDir commits the entries of the directory at the os path name to
+// stable storage
+//
+// Windows does not allow flushing a directory handle, and NTFS commits
+// directory entries through its journal, so only the directory is checked.
+func syncOSDir(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return wrapOSErr(err, "Failed to stat dir")
+	}
+	if !info.IsDir() {
+		return kerrors.WithKind(fs.ErrInvalid, ErrNotDir, "Not a directory")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package kfs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+	"sync"
+
+	"xorkevin.dev/kerrors"
+)
+
+// ErrUnknownScheme is returned when a url scheme has no registered opener
+var ErrUnknownScheme errUnknownScheme
+
+type (
+	errUnknownScheme struct{}
+)
+
+func (e errUnknownScheme) Error() string {
+	return "Unknown url scheme"
+}
+
+type (
+	// URLOpener opens an [FS] from a url
+	URLOpener = func(ctx context.Context, u *url.URL) (FS, error)
+
+	schemeRegistry struct {
+		mu      sync.RWMutex
+		openers map[string]URLOpener
+	}
+)
+
+var defaultSchemeRegistry = &schemeRegistry{
+	openers: map[string]URLOpener{
+		"file": openFileURL,
+	},
+}
+
+func (r *schemeRegistry) register(scheme string, opener URLOpener) {
+	if opener == nil {
+		panic("kfs: nil url opener for scheme " + scheme)
+	}
+	// url.Parse lowercases schemes
+	scheme = strings.ToLower(scheme)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.openers[scheme]; ok {
+		panic("kfs: url scheme already registered " + scheme)
+	}
+	r.openers[scheme] = opener
+}
+
+func (r *schemeRegistry) get(scheme string) (URLOpener, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	opener, ok := r.openers[scheme]
+	return opener, ok
+}
+
+// RegisterScheme registers an opener for a url scheme used by [OpenURL]
+//
+// RegisterScheme panics if the opener is nil or if the scheme is already
+// registered. Schemes are case-insensitive, so "S3" and "s3" are the same
+// scheme. The "file" scheme is registered by default.
+func RegisterScheme(scheme string, opener URLOpener) {
+	defaultSchemeRegistry.register(scheme, opener)
+}
+
+// OpenURL opens an [FS] based on the scheme of a url
+//
+// Strings without a scheme, including windows paths with a drive letter, are
+// treated as local directories and opened with [DirFS].
+func OpenURL(ctx context.Context, rawURL string) (FS, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// not a url, or a windows drive letter
+		return DirFS(rawURL), nil
+	}
+	opener, ok := defaultSchemeRegistry.get(u.Scheme)
+	if !ok {
+		return nil, kerrors.WithKind(nil, ErrUnknownScheme, fmt.Sprintf("No opener registered for scheme %s", u.Scheme))
+	}
+	fsys, err := opener(ctx, u)
+	if err != nil {
+		return nil, kerrors.WithMsg(err, fmt.Sprintf("Failed to open url with scheme %s", u.Scheme))
+	}
+	return fsys, nil
+}
+
+func openFileURL(ctx context.Context, u *url.URL) (FS, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, kerrors.WithKind(nil, fs.ErrInvalid, fmt.Sprintf("File url host %s is not local", u.Host))
+	}
+	if u.Path == "" {
+		return nil, kerrors.WithKind(nil, fs.ErrInvalid, "File url has no path")
+	}
+	return DirFS(u.Path), nil
+}
@@ -0,0 +1,434 @@
+package kfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"xorkevin.dev/kerrors"
+)
+
+// ErrIntegrity is returned when the contents of a file do not match its
+// expected digest
+var ErrIntegrity errIntegrity
+
+type (
+	errIntegrity struct{}
+)
+
+func (e errIntegrity) Error() string {
+	return "File integrity check failed"
+}
+
+type (
+	// Manifest maps file names to the multihash digests of their contents
+	Manifest map[string][]byte
+)
+
+// NewManifest computes a [Manifest] of every regular file in fsys with a hash
+// algorithm
+//
+// Symbolic links are not followed.
+func NewManifest(fsys fs.FS, alg HashAlg) (Manifest, error) {
+	m := Manifest{}
+	if err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		digest, err := hashFile(fsys, p, alg.New(), "newmanifest")
+		if err != nil {
+			return err
+		}
+		m[p] = alg.Multihash(digest)
+		return nil
+	}); err != nil {
+		return nil, kerrors.WithMsg(err, "Failed to compute manifest")
+	}
+	return m, nil
+}
+
+func hashFile(fsys fs.FS, name string, h hash.Hash, op string) (_ []byte, retErr error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to open file"),
+		}
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			retErr = errors.Join(retErr, &fs.PathError{
+				Op:   op,
+				Path: name,
+				Err:  kerrors.WithMsg(err, "Failed closing file"),
+			})
+		}
+	}()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to read file"),
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+type (
+	// DigestFS is a file system that may open files by the digests of their
+	// contents
+	DigestFS interface {
+		fs.FS
+		// OpenByDigest opens a file whose contents have a multihash digest
+		OpenByDigest(mh []byte) (fs.File, error)
+	}
+)
+
+// OpenByDigest opens a file whose contents have a multihash digest
+//
+// If fsys does not implement [DigestFS], then OpenByDigest returns an error.
+func OpenByDigest(fsys fs.FS, mh []byte) (fs.File, error) {
+	f, ok := fsys.(DigestFS)
+	if !ok {
+		return nil, &fs.PathError{
+			Op:   "openbydigest",
+			Path: hex.EncodeToString(mh),
+			Err:  kerrors.WithMsg(ErrNotImplemented, "Failed to open file by digest"),
+		}
+	}
+	return f.OpenByDigest(mh)
+}
+
+type (
+	verifiedFS struct {
+		fsys     fs.FS
+		dir      string
+		manifest Manifest
+		// digests maps multihash digests to the sorted names of the files in
+		// manifest with them
+		digests map[string][]string
+	}
+)
+
+// expectedDigest returns the hash and expected digest of a file
+func (f *verifiedFS) expectedDigest(op string, name string) (hash.Hash, []byte, error) {
+	mh, ok := f.manifest[path.Join(f.dir, name)]
+	if !ok {
+		return nil, nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithKind(fs.ErrPermission, ErrIntegrity, "File is not in manifest"),
+		}
+	}
+	alg, digest, err := ParseMultihash(mh)
+	if err == nil && len(digest) == 0 {
+		err = kerrors.WithKind(nil, fs.ErrInvalid, "Empty digest")
+	}
+	if err != nil {
+		return nil, nil, &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  kerrors.WithKind(err, ErrIntegrity, "Invalid manifest digest"),
+		}
+	}
+	return alg.New(), digest, nil
+}
+
+func (f *verifiedFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  kerrors.WithMsg(fs.ErrInvalid, "Invalid path"),
+		}
+	}
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  kerrors.WithMsg(err, "Failed to stat file"),
+		}
+	}
+	if info.IsDir() {
+		return file, nil
+	}
+	h, digest, err := f.expectedDigest("open", name)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &verifiedFile{
+		file:   file,
+		name:   name,
+		h:      h,
+		digest: digest,
+	}, nil
+}
+
+// OpenByDigest implements [DigestFS]
+//
+// It opens the file in the manifest whose multihash digest is identical to
+// mh, choosing the first by name if there are many, and verifies it as with
+// Open.
+func (f *verifiedFS) OpenByDigest(mh []byte) (fs.File, error) {
+	for _, i := range f.digests[string(mh)] {
+		name := i
+		if f.dir != "" {
+			rest, ok := strings.CutPrefix(i, f.dir+"/")
+			if !ok {
+				continue
+			}
+			name = rest
+		}
+		return f.Open(name)
+	}
+	return nil, &fs.PathError{
+		Op:   "openbydigest",
+		Path: hex.EncodeToString(mh),
+		Err:  kerrors.WithMsg(fs.ErrNotExist, "No file in manifest with digest"),
+	}
+}
+
+func (f *verifiedFS) ReadFile(name string) (_ []byte, retErr error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			retErr = errors.Join(retErr, err)
+		}
+	}()
+	return io.ReadAll(file)
+}
+
+func (f *verifiedFS) Sub(dir string) (fs.FS, error) {
+	fsys, err := fs.Sub(f.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedFS{
+		fsys:     fsys,
+		dir:      path.Join(f.dir, dir),
+		manifest: f.manifest,
+		digests:  f.digests,
+	}, nil
+}
+
+func (f *verifiedFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.fsys, name)
+}
+
+func (f *verifiedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(f.fsys, name)
+}
+
+func (f *verifiedFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(f.fsys, pattern)
+}
+
+func (f *verifiedFS) FullFilePath(name string) (string, error) {
+	return FullFilePath(f.fsys, name)
+}
+
+func (f *verifiedFS) Lstat(name string) (fs.FileInfo, error) {
+	return Lstat(f.fsys, name)
+}
+
+func (f *verifiedFS) ReadLink(name string) (string, error) {
+	return ReadLink(f.fsys, name)
+}
+
+func (f *verifiedFS) Symlink(oldname, newname string) error {
+	return (&readOnlyFS{fsys: f.fsys}).Symlink(oldname, newname)
+}
+
+func (f *verifiedFS) checkWrite(op string, name string) error {
+	return (&readOnlyFS{fsys: f.fsys}).checkWrite(op, name)
+}
+
+// OpenFile implements [WriteFS]
+//
+// Only files opened for reading without O_CREATE, O_TRUNC, or O_APPEND are
+// allowed, and they are verified as with Open.
+func (f *verifiedFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, f.checkWrite("openfile", name)
+	}
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if file, ok := file.(File); ok {
+		return file, nil
+	}
+	return &readOnlyFile{
+		File: file,
+		name: name,
+	}, nil
+}
+
+func (f *verifiedFS) Remove(name string) error {
+	return f.checkWrite("remove", name)
+}
+
+func (f *verifiedFS) RemoveAll(name string) error {
+	return f.checkWrite("removeall", name)
+}
+
+func (f *verifiedFS) Rename(oldname, newname string) error {
+	return (&readOnlyFS{fsys: f.fsys}).Rename(oldname, newname)
+}
+
+func (f *verifiedFS) Truncate(name string, size int64) error {
+	return f.checkWrite("truncate", name)
+}
+
+func (f *verifiedFS) Chtimes(name string, atime, mtime time.Time) error {
+	return f.checkWrite("chtimes", name)
+}
+
+func (f *verifiedFS) Lchtimes(name string, atime, mtime time.Time) error {
+	return f.checkWrite("lchtimes", name)
+}
+
+func (f *verifiedFS) Chmod(name string, mode fs.FileMode) error {
+	return f.checkWrite("chmod", name)
+}
+
+func (f *verifiedFS) Chown(name string, uid, gid int) error {
+	return f.checkWrite("chown", name)
+}
+
+func (f *verifiedFS) Lchown(name string, uid, gid int) error {
+	return f.checkWrite("lchown", name)
+}
+
+func (f *verifiedFS) Mkfifo(name string, mode fs.FileMode) error {
+	return f.checkWrite("mkfifo", name)
+}
+
+func (f *verifiedFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	return f.checkWrite("writefileifmatch", name)
+}
+
+func (f *verifiedFS) MkdirTemp(dir, pattern string) (string, error) {
+	return "", f.checkWrite("mkdirtemp", dir)
+}
+
+func (f *verifiedFS) CreateTemp(dir, pattern string) (File, string, error) {
+	return nil, "", f.checkWrite("createtemp", dir)
+}
+
+func (f *verifiedFS) SyncDir(name string) error {
+	return SyncDir(f.fsys, name)
+}
+
+func (f *verifiedFS) Statfs(name string) (*FSStats, error) {
+	return Statfs(f.fsys, name)
+}
+
+// NewVerifiedFS creates a new read-only [FS] that verifies the contents of
+// files against a [Manifest] as they are read
+//
+// Opening a regular file that is not in the manifest fails with
+// [ErrIntegrity]. Files are hashed as they are read, and reaching the end of
+// a file whose contents do not match its digest fails with [ErrIntegrity]
+// instead of [io.EOF]. As such, the contents of a file should not be trusted
+// until it has been read to the end. [fs.ReadFile] reads the whole file, and
+// so returns only verified contents. Files opened from the returned fs do not
+// support seeking. Manifest names are relative to the root of the returned
+// fs, including in sub fs.
+//
+// Files may also be opened by the digest in the manifest of their contents
+// with [OpenByDigest], regardless of their names.
+func NewVerifiedFS(fsys fs.FS, manifest Manifest) FS {
+	digests := map[string][]string{}
+	for k, v := range manifest {
+		digests[string(v)] = append(digests[string(v)], k)
+	}
+	for _, v := range digests {
+		slices.Sort(v)
+	}
+	return &verifiedFS{
+		fsys:     fsys,
+		dir:      "",
+		manifest: manifest,
+		digests:  digests,
+	}
+}
+
+type (
+	// verifiedFile is a file that verifies its contents on reaching the end
+	verifiedFile struct {
+		file     fs.File
+		name     string
+		h        hash.Hash
+		digest   []byte
+		verified bool
+		err      error
+	}
+)
+
+func (f *verifiedFile) Stat() (fs.FileInfo, error) {
+	return f.file.Stat()
+}
+
+func (f *verifiedFile) Read(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	n, err := f.file.Read(p)
+	f.h.Write(p[:n])
+	if errors.Is(err, io.EOF) && !f.verified {
+		// multihash digests may be truncated
+		if !bytes.Equal(f.h.Sum(nil)[:len(f.digest)], f.digest) {
+			f.err = &fs.PathError{
+				Op:   "read",
+				Path: f.name,
+				Err:  kerrors.WithKind(nil, ErrIntegrity, "File does not match manifest digest"),
+			}
+			return n, f.err
+		}
+		f.verified = true
+	}
+	return n, err
+}
+
+func (f *verifiedFile) Close() error {
+	return f.file.Close()
+}
+
+type (
+	// readOnlyFile is a read-only [File]
+	readOnlyFile struct {
+		fs.File
+		name string
+	}
+)
+
+func (f *readOnlyFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "write",
+		Path: f.name,
+		Err:  kerrors.WithKind(fs.ErrPermission, ErrReadOnly, "File not open for writing"),
+	}
+}
@@ -0,0 +1,198 @@
+package kfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"xorkevin.dev/kerrors"
+)
+
+// ErrWriteOnce is returned when modifying or removing a file that may only be
+// written once
+var ErrWriteOnce errWriteOnce
+
+type (
+	errWriteOnce struct{}
+)
+
+func (e errWriteOnce) Error() string {
+	return "File may only be written once"
+}
+
+type (
+	wormFS struct {
+		fsys fs.FS
+	}
+)
+
+func (f *wormFS) Open(name string) (fs.File, error) {
+	return f.fsys.Open(name)
+}
+
+func (f *wormFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.fsys, name)
+}
+
+func (f *wormFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(f.fsys, name)
+}
+
+func (f *wormFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(f.fsys, name)
+}
+
+func (f *wormFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(f.fsys, pattern)
+}
+
+func (f *wormFS) Sub(dir string) (fs.FS, error) {
+	fsys, err := fs.Sub(f.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewWORMFS(fsys), nil
+}
+
+func (f *wormFS) FullFilePath(name string) (string, error) {
+	return FullFilePath(f.fsys, name)
+}
+
+func (f *wormFS) Lstat(name string) (fs.FileInfo, error) {
+	return Lstat(f.fsys, name)
+}
+
+func (f *wormFS) ReadLink(name string) (string, error) {
+	return ReadLink(f.fsys, name)
+}
+
+func (f *wormFS) Symlink(oldname, newname string) error {
+	return Symlink(f.fsys, oldname, newname)
+}
+
+func (f *wormFS) checkWrite(op string, name string) error {
+	return &fs.PathError{
+		Op:   op,
+		Path: name,
+		Err:  f.writeErr(name),
+	}
+}
+
+// writeErr returns the error for modifying names
+func (f *wormFS) writeErr(names ...string) error {
+	for _, i := range names {
+		if !fs.ValidPath(i) {
+			return kerrors.WithMsg(fs.ErrInvalid, "Invalid path")
+		}
+	}
+	return kerrors.WithKind(fs.ErrPermission, ErrWriteOnce, "Write-once fs does not support modifying existing files")
+}
+
+// OpenFile implements [WriteFS]
+//
+// Files opened for writing must be created with O_CREATE, and O_EXCL is
+// always added so that existing files are never opened for writing.
+func (f *wormFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return OpenFile(f.fsys, name, flag, mode)
+	}
+	if flag&os.O_CREATE == 0 {
+		return nil, f.checkWrite("openfile", name)
+	}
+	file, err := OpenFile(f.fsys, name, flag|os.O_EXCL, mode)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil, &fs.PathError{
+				Op:   "openfile",
+				Path: name,
+				Err:  kerrors.WithKind(err, ErrWriteOnce, "File already exists"),
+			}
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+func (f *wormFS) Remove(name string) error {
+	return f.checkWrite("remove", name)
+}
+
+func (f *wormFS) RemoveAll(name string) error {
+	return f.checkWrite("removeall", name)
+}
+
+func (f *wormFS) Rename(oldname, newname string) error {
+	return &os.LinkError{
+		Op:  "rename",
+		Old: oldname,
+		New: newname,
+		Err: f.writeErr(oldname, newname),
+	}
+}
+
+func (f *wormFS) Truncate(name string, size int64) error {
+	return f.checkWrite("truncate", name)
+}
+
+func (f *wormFS) Chtimes(name string, atime, mtime time.Time) error {
+	return f.checkWrite("chtimes", name)
+}
+
+func (f *wormFS) Lchtimes(name string, atime, mtime time.Time) error {
+	return f.checkWrite("lchtimes", name)
+}
+
+func (f *wormFS) Chmod(name string, mode fs.FileMode) error {
+	return f.checkWrite("chmod", name)
+}
+
+func (f *wormFS) Chown(name string, uid, gid int) error {
+	return f.checkWrite("chown", name)
+}
+
+func (f *wormFS) Lchown(name string, uid, gid int) error {
+	return f.checkWrite("lchown", name)
+}
+
+func (f *wormFS) Mkfifo(name string, mode fs.FileMode) error {
+	return Mkfifo(f.fsys, name, mode)
+}
+
+// WriteFileIfMatch implements [ConditionalWriteFS]
+//
+// Only an empty token, which creates a new file, is allowed.
+func (f *wormFS) WriteFileIfMatch(name string, data []byte, perm fs.FileMode, token string) error {
+	if token != "" {
+		return f.checkWrite("writefileifmatch", name)
+	}
+	return WriteFileIfMatch(f.fsys, name, data, perm, token)
+}
+
+func (f *wormFS) MkdirTemp(dir, pattern string) (string, error) {
+	return MkdirTemp(f.fsys, dir, pattern)
+}
+
+func (f *wormFS) CreateTemp(dir, pattern string) (File, string, error) {
+	return CreateTemp(f.fsys, dir, pattern)
+}
+
+func (f *wormFS) SyncDir(name string) error {
+	return SyncDir(f.fsys, name)
+}
+
+func (f *wormFS) Statfs(name string) (*FSStats, error) {
+	return Statfs(f.fsys, name)
+}
+
+// NewWORMFS creates a new write-once read-many [FS]
+//
+// New files may be created and written, but existing files may not be opened
+// for writing, truncated, removed, renamed, or have their times, modes, or
+// owners changed, and attempting to do so fails with [ErrWriteOnce]. A file may
+// be written through the handle that created it until it is closed.
+func NewWORMFS(fsys fs.FS) FS {
+	return &wormFS{
+		fsys: fsys,
+	}
+}